@@ -57,6 +57,67 @@ const (
 	// PodSet is admitted using TopologyAwareScheduling, and all Pods created
 	// from the Job's PodTemplate also have the label.
 	TASLabel = "kueue.x-k8s.io/tas"
+
+	// NodeNVLinkGroupsAnnotation is an annotation set on a Node to indicate
+	// how its GPUs are grouped into NVLink domains, as a comma-separated list
+	// of the number of GPUs in each group (e.g. "4,4"). Pods requesting
+	// multiple GPUs are only assigned to the Node if all their GPUs fit
+	// within a single group.
+	NodeNVLinkGroupsAnnotation = "kueue.x-k8s.io/nvlink-groups"
+
+	// NodeUnhealthyGPUsAnnotation is an annotation set on a Node to indicate
+	// the number of its GPUs which are unhealthy. The unhealthy GPUs are not
+	// counted towards the capacity of the Node by Topology Aware Scheduling,
+	// even if they are included in the Node's allocatable resources.
+	NodeUnhealthyGPUsAnnotation = "kueue.x-k8s.io/unhealthy-gpus"
+
+	// ResourceFlavorCompactionThresholdAnnotation is an annotation set on a
+	// ResourceFlavor using Topology Aware Scheduling to indicate the average
+	// fragmentation of its lowest level topology domains, as a number between
+	// 0 and 1, above which the workloads are packed tightly into the domains
+	// with the least free capacity, rather than into the domains with the
+	// most free capacity, to compact the flavor. The fragmentation of a
+	// partially used domain is the fraction of its capacity which is free.
+	ResourceFlavorCompactionThresholdAnnotation = "kueue.x-k8s.io/tas-compaction-threshold"
+
+	// ResourceFlavorIncludeUnschedulableNodesAnnotation is an annotation set
+	// on a ResourceFlavor using Topology Aware Scheduling to indicate, when
+	// "true", that the capacity of its cordoned and NotReady nodes is offered
+	// to the workloads. By default, such nodes are excluded.
+	ResourceFlavorIncludeUnschedulableNodesAnnotation = "kueue.x-k8s.io/tas-include-unschedulable-nodes"
+
+	// ResourceFlavorReservationFractionAnnotation is an annotation set on a
+	// ResourceFlavor using Topology Aware Scheduling to indicate the fraction
+	// of the capacity of each of its nodes, as a number in [0, 1), which is
+	// left unassigned as the headroom for the workloads scaling within the
+	// topology domain.
+	ResourceFlavorReservationFractionAnnotation = "kueue.x-k8s.io/tas-reservation-fraction"
+
+	// ResourceFlavorSubHostLevelAnnotation is an annotation set on a
+	// ResourceFlavor using Topology Aware Scheduling to indicate the lowest
+	// level of its Topology, for example nvidia.com/gpu.clique, whose domains
+	// are the NVLink groups of the node, based on the
+	// NodeNVLinkGroupsAnnotation, rather than the nodes.
+	ResourceFlavorSubHostLevelAnnotation = "kueue.x-k8s.io/tas-sub-host-level"
+
+	// ResourceFlavorNodeSelectorAnnotation is an annotation set on a
+	// ResourceFlavor using Topology Aware Scheduling to restrict its nodes to
+	// the ones matching the label selector, in addition to the nodeLabels,
+	// for example "zone in (zone-a,zone-b)".
+	ResourceFlavorNodeSelectorAnnotation = "kueue.x-k8s.io/tas-node-selector"
+
+	// ResourceFlavorNodeAnnotationSelectorAnnotation is an annotation set on
+	// a ResourceFlavor using Topology Aware Scheduling to restrict its nodes
+	// to the ones with all the given annotations, as a comma-separated list
+	// of key=value pairs.
+	ResourceFlavorNodeAnnotationSelectorAnnotation = "kueue.x-k8s.io/tas-node-annotation-selector"
+
+	// ResourceFlavorNormalizeLevelValuesAnnotation is an annotation set on a
+	// ResourceFlavor using Topology Aware Scheduling to indicate, when "true",
+	// that the topology level values of its nodes are trimmed and lowercased,
+	// so that the values differing only in the casing or the whitespace
+	// identify the same topology domain.
+	ResourceFlavorNormalizeLevelValuesAnnotation = "kueue.x-k8s.io/tas-normalize-level-values"
 )
 
 // TopologySpec defines the desired state of Topology
@@ -85,6 +146,17 @@ type TopologyLevel struct {
 	// +kubebuilder:validation:MaxLength=316
 	// +kubebuilder:validation:Pattern=`^([a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*/)?(([A-Za-z0-9][-A-Za-z0-9_.]*)?[A-Za-z0-9])$`
 	NodeLabel string `json:"nodeLabel"`
+
+	// fromAnnotation indicates that the values of the level are read from
+	// the node annotation named by nodeLabel, rather than from the node
+	// label. It allows to declare a synthetic level, such as a chassis
+	// grouping the hosts within a rack, which some vendors expose only as a
+	// node annotation. The level is used to pack the pods, but it is not
+	// injected into the node selector of the pods, so it needs to be followed
+	// by a level identifying the nodes, such as kubernetes.io/hostname.
+	//
+	// +optional
+	FromAnnotation bool `json:"fromAnnotation,omitempty"`
 }
 
 // TopologyStatus defines the observed state of Topology
@@ -88,12 +88,66 @@ type PodSetTopologyRequest struct {
 
 	// preferred indicates the topology level preferred by the PodSet, as
 	// indicated by the `kueue.x-k8s.io/podset-preferred-topology` PodSet
-	// annotation.
+	// annotation. Along with required, a level below the required one makes
+	// the PodSet pack at the preferred level within the required domain. A level
+	// above the required one is invalid.
 	//
 	// +optional
 	Preferred *string `json:"preferred,omitempty"`
+
+	// requiredFallback lists the broader topology levels tried, in order,
+	// when the PodSet doesn't fit within a single domain at the required
+	// level. The PodSet fits at the first of the levels which accommodates
+	// it, and doesn't fit if none of them does. It is only used along with
+	// required.
+	//
+	// +optional
+	// +listType=atomic
+	RequiredFallback []string `json:"requiredFallback,omitempty"`
+
+	// colocationGroup is the key of the colocation group of the PodSet. The
+	// PodSets of the workload sharing the key are placed within the same
+	// topology domain at the colocation level, while the PodSets with
+	// different keys are placed independently.
+	//
+	// +optional
+	ColocationGroup *string `json:"colocationGroup,omitempty"`
+
+	// colocationLevel indicates the topology level of the domain shared by
+	// the PodSets of the colocation group. If not specified, the level
+	// requested by the PodSet is used.
+	//
+	// +optional
+	ColocationLevel *string `json:"colocationLevel,omitempty"`
+
+	// strategy indicates how the pods of the PodSet are distributed across
+	// the topology domains. Possible values are:
+	//
+	// - Pack: the pods are placed in as few domains as possible.
+	// - Spread: the pods are distributed round-robin across the domains at
+	// the preferred level, or across the domains below the required one.
+	//
+	// Defaults to Pack.
+	//
+	// +optional
+	// +kubebuilder:validation:Enum=Pack;Spread
+	Strategy *TopologyStrategy `json:"strategy,omitempty"`
 }
 
+// TopologyStrategy indicates how the pods of a PodSet are distributed across
+// the topology domains.
+type TopologyStrategy string
+
+const (
+	// TopologyStrategyPack places the pods in as few topology domains as
+	// possible.
+	TopologyStrategyPack TopologyStrategy = "Pack"
+
+	// TopologyStrategySpread distributes the pods round-robin across the
+	// topology domains, as far as their capacity allows.
+	TopologyStrategySpread TopologyStrategy = "Spread"
+)
+
 type Admission struct {
 	// clusterQueue is the name of the ClusterQueue that admitted this workload.
 	ClusterQueue ClusterQueueReference `json:"clusterQueue"`
@@ -1050,6 +1050,26 @@ func (in *PodSetTopologyRequest) DeepCopyInto(out *PodSetTopologyRequest) {
 		*out = new(string)
 		**out = **in
 	}
+	if in.RequiredFallback != nil {
+		in, out := &in.RequiredFallback, &out.RequiredFallback
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ColocationGroup != nil {
+		in, out := &in.ColocationGroup, &out.ColocationGroup
+		*out = new(string)
+		**out = **in
+	}
+	if in.ColocationLevel != nil {
+		in, out := &in.ColocationLevel, &out.ColocationLevel
+		*out = new(string)
+		**out = **in
+	}
+	if in.Strategy != nil {
+		in, out := &in.Strategy, &out.Strategy
+		*out = new(TopologyStrategy)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PodSetTopologyRequest.
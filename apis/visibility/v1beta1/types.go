@@ -17,6 +17,7 @@ limitations under the License.
 package v1beta1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -59,6 +60,26 @@ type LocalQueueList struct {
 	Items []LocalQueue `json:"items"`
 }
 
+// +genclient
+// +kubebuilder:object:root=true
+// +k8s:openapi-gen=true
+// +genclient:nonNamespaced
+// +genclient:method=GetTopologyCapacity,verb=get,subresource=topologycapacity,result=sigs.k8s.io/kueue/apis/visibility/v1beta1.TopologyCapacity
+type ResourceFlavor struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Capacity TopologyCapacity `json:"topologyCapacity"`
+}
+
+// +kubebuilder:object:root=true
+type ResourceFlavorList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []ResourceFlavor `json:"items"`
+}
+
 // PendingWorkload is a user-facing representation of a pending workload that summarizes the relevant information for
 // position in the cluster queue.
 type PendingWorkload struct {
@@ -105,9 +126,48 @@ type PendingWorkloadOptions struct {
 	Limit int64 `json:"limit,omitempty"`
 }
 
+// TopologyDomainCapacity is a user-facing representation of the capacity of
+// a topology domain.
+type TopologyDomainCapacity struct {
+	// Values indicates the ordered node label values identifying the domain
+	Values []string `json:"values"`
+
+	// Total indicates the capacity of all nodes in the domain
+	Total corev1.ResourceList `json:"total,omitempty"`
+
+	// Free indicates the capacity of the nodes in the domain which is not
+	// used by the workloads admitted using Topology Aware Scheduling
+	Free corev1.ResourceList `json:"free,omitempty"`
+}
+
+// TopologyLevelCapacity contains the capacity of the topology domains at a
+// single level of the topology.
+type TopologyLevelCapacity struct {
+	// Name indicates the node label key of the level
+	Name string `json:"name"`
+
+	// Domains indicates the capacity of the topology domains at the level
+	Domains []TopologyDomainCapacity `json:"domains"`
+}
+
+// +k8s:openapi-gen=true
+// +kubebuilder:object:root=true
+
+// TopologyCapacity contains the total and free capacity of the topology
+// domains of a ResourceFlavor, per topology level.
+type TopologyCapacity struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// Levels indicates the capacity per topology level, ordered from the
+	// top level to the lowest level
+	Levels []TopologyLevelCapacity `json:"levels"`
+}
+
 func init() {
 	SchemeBuilder.Register(
 		&PendingWorkloadsSummary{},
 		&PendingWorkloadOptions{},
+		&TopologyCapacity{},
 	)
 }
@@ -20,7 +20,8 @@ package v1alpha1
 // TopologyLevelApplyConfiguration represents a declarative configuration of the TopologyLevel type for use
 // with apply.
 type TopologyLevelApplyConfiguration struct {
-	NodeLabel *string `json:"nodeLabel,omitempty"`
+	NodeLabel      *string `json:"nodeLabel,omitempty"`
+	FromAnnotation *bool   `json:"fromAnnotation,omitempty"`
 }
 
 // TopologyLevelApplyConfiguration constructs a declarative configuration of the TopologyLevel type for use with
@@ -36,3 +37,11 @@ func (b *TopologyLevelApplyConfiguration) WithNodeLabel(value string) *TopologyL
 	b.NodeLabel = &value
 	return b
 }
+
+// WithFromAnnotation sets the FromAnnotation field in the declarative configuration to the given value
+// and returns the receiver, so that objects can be built by chaining "With" function invocations.
+// If called multiple times, the FromAnnotation field is set to the value of the last call.
+func (b *TopologyLevelApplyConfiguration) WithFromAnnotation(value bool) *TopologyLevelApplyConfiguration {
+	b.FromAnnotation = &value
+	return b
+}
@@ -17,11 +17,19 @@ limitations under the License.
 
 package v1beta1
 
+import (
+	kueuev1beta1 "sigs.k8s.io/kueue/apis/kueue/v1beta1"
+)
+
 // PodSetTopologyRequestApplyConfiguration represents a declarative configuration of the PodSetTopologyRequest type for use
 // with apply.
 type PodSetTopologyRequestApplyConfiguration struct {
-	Required  *string `json:"required,omitempty"`
-	Preferred *string `json:"preferred,omitempty"`
+	Required         *string                        `json:"required,omitempty"`
+	Preferred        *string                        `json:"preferred,omitempty"`
+	RequiredFallback []string                       `json:"requiredFallback,omitempty"`
+	ColocationGroup  *string                        `json:"colocationGroup,omitempty"`
+	ColocationLevel  *string                        `json:"colocationLevel,omitempty"`
+	Strategy         *kueuev1beta1.TopologyStrategy `json:"strategy,omitempty"`
 }
 
 // PodSetTopologyRequestApplyConfiguration constructs a declarative configuration of the PodSetTopologyRequest type for use with
@@ -45,3 +53,37 @@ func (b *PodSetTopologyRequestApplyConfiguration) WithPreferred(value string) *P
 	b.Preferred = &value
 	return b
 }
+
+// WithRequiredFallback adds the given value to the RequiredFallback field in the declarative configuration
+// and returns the receiver, so that objects can be build by chaining "With" function invocations.
+// If called multiple times, values provided by each call will be appended to the RequiredFallback field.
+func (b *PodSetTopologyRequestApplyConfiguration) WithRequiredFallback(values ...string) *PodSetTopologyRequestApplyConfiguration {
+	for i := range values {
+		b.RequiredFallback = append(b.RequiredFallback, values[i])
+	}
+	return b
+}
+
+// WithColocationGroup sets the ColocationGroup field in the declarative configuration to the given value
+// and returns the receiver, so that objects can be built by chaining "With" function invocations.
+// If called multiple times, the ColocationGroup field is set to the value of the last call.
+func (b *PodSetTopologyRequestApplyConfiguration) WithColocationGroup(value string) *PodSetTopologyRequestApplyConfiguration {
+	b.ColocationGroup = &value
+	return b
+}
+
+// WithColocationLevel sets the ColocationLevel field in the declarative configuration to the given value
+// and returns the receiver, so that objects can be built by chaining "With" function invocations.
+// If called multiple times, the ColocationLevel field is set to the value of the last call.
+func (b *PodSetTopologyRequestApplyConfiguration) WithColocationLevel(value string) *PodSetTopologyRequestApplyConfiguration {
+	b.ColocationLevel = &value
+	return b
+}
+
+// WithStrategy sets the Strategy field in the declarative configuration to the given value
+// and returns the receiver, so that objects can be built by chaining "With" function invocations.
+// If called multiple times, the Strategy field is set to the value of the last call.
+func (b *PodSetTopologyRequestApplyConfiguration) WithStrategy(value kueuev1beta1.TopologyStrategy) *PodSetTopologyRequestApplyConfiguration {
+	b.Strategy = &value
+	return b
+}
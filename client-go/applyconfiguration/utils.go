@@ -169,6 +169,14 @@ func ForKind(kind schema.GroupVersionKind) interface{} {
 		return &applyconfigurationvisibilityv1beta1.PendingWorkloadApplyConfiguration{}
 	case visibilityv1beta1.SchemeGroupVersion.WithKind("PendingWorkloadsSummary"):
 		return &applyconfigurationvisibilityv1beta1.PendingWorkloadsSummaryApplyConfiguration{}
+	case visibilityv1beta1.SchemeGroupVersion.WithKind("ResourceFlavor"):
+		return &applyconfigurationvisibilityv1beta1.ResourceFlavorApplyConfiguration{}
+	case visibilityv1beta1.SchemeGroupVersion.WithKind("TopologyCapacity"):
+		return &applyconfigurationvisibilityv1beta1.TopologyCapacityApplyConfiguration{}
+	case visibilityv1beta1.SchemeGroupVersion.WithKind("TopologyDomainCapacity"):
+		return &applyconfigurationvisibilityv1beta1.TopologyDomainCapacityApplyConfiguration{}
+	case visibilityv1beta1.SchemeGroupVersion.WithKind("TopologyLevelCapacity"):
+		return &applyconfigurationvisibilityv1beta1.TopologyLevelCapacityApplyConfiguration{}
 
 	}
 	return nil
@@ -35,6 +35,10 @@ func (c *FakeVisibilityV1beta1) LocalQueues(namespace string) v1beta1.LocalQueue
 	return &FakeLocalQueues{c, namespace}
 }
 
+func (c *FakeVisibilityV1beta1) ResourceFlavors() v1beta1.ResourceFlavorInterface {
+	return &FakeResourceFlavors{c}
+}
+
 // RESTClient returns a RESTClient that is used to communicate
 // with API server by this client implementation.
 func (c *FakeVisibilityV1beta1) RESTClient() rest.Interface {
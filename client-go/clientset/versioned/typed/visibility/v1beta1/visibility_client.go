@@ -29,6 +29,7 @@ type VisibilityV1beta1Interface interface {
 	RESTClient() rest.Interface
 	ClusterQueuesGetter
 	LocalQueuesGetter
+	ResourceFlavorsGetter
 }
 
 // VisibilityV1beta1Client is used to interact with features provided by the visibility.kueue.x-k8s.io group.
@@ -44,6 +45,10 @@ func (c *VisibilityV1beta1Client) LocalQueues(namespace string) LocalQueueInterf
 	return newLocalQueues(c, namespace)
 }
 
+func (c *VisibilityV1beta1Client) ResourceFlavors() ResourceFlavorInterface {
+	return newResourceFlavors(c)
+}
+
 // NewForConfig creates a new VisibilityV1beta1Client for the given config.
 // NewForConfig is equivalent to NewForConfigAndClient(c, httpClient),
 // where httpClient was generated with rest.HTTPClientFor(c).
@@ -89,6 +89,8 @@ func (f *sharedInformerFactory) ForResource(resource schema.GroupVersionResource
 		return &genericInformer{resource: resource.GroupResource(), informer: f.Visibility().V1beta1().ClusterQueues().Informer()}, nil
 	case visibilityv1beta1.SchemeGroupVersion.WithResource("localqueues"):
 		return &genericInformer{resource: resource.GroupResource(), informer: f.Visibility().V1beta1().LocalQueues().Informer()}, nil
+	case visibilityv1beta1.SchemeGroupVersion.WithResource("resourceflavors"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Visibility().V1beta1().ResourceFlavors().Informer()}, nil
 
 	}
 
@@ -27,6 +27,8 @@ type Interface interface {
 	ClusterQueues() ClusterQueueInformer
 	// LocalQueues returns a LocalQueueInformer.
 	LocalQueues() LocalQueueInformer
+	// ResourceFlavors returns a ResourceFlavorInformer.
+	ResourceFlavors() ResourceFlavorInformer
 }
 
 type version struct {
@@ -49,3 +51,8 @@ func (v *version) ClusterQueues() ClusterQueueInformer {
 func (v *version) LocalQueues() LocalQueueInformer {
 	return &localQueueInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
 }
+
+// ResourceFlavors returns a ResourceFlavorInformer.
+func (v *version) ResourceFlavors() ResourceFlavorInformer {
+	return &resourceFlavorInformer{factory: v.factory, tweakListOptions: v.tweakListOptions}
+}
@@ -28,3 +28,7 @@ type LocalQueueListerExpansion interface{}
 // LocalQueueNamespaceListerExpansion allows custom methods to be added to
 // LocalQueueNamespaceLister.
 type LocalQueueNamespaceListerExpansion interface{}
+
+// ResourceFlavorListerExpansion allows custom methods to be added to
+// ResourceFlavorLister.
+type ResourceFlavorListerExpansion interface{}
@@ -189,7 +189,7 @@ func main() {
 	go cCache.CleanUpOnContext(ctx)
 
 	if features.Enabled(features.VisibilityOnDemand) {
-		go visibility.CreateAndStartVisibilityServer(ctx, queues)
+		go visibility.CreateAndStartVisibilityServer(ctx, queues, cCache)
 	}
 
 	setupScheduler(mgr, cCache, queues, &cfg)
@@ -60,6 +60,8 @@ type options struct {
 	workloadInfoOptions []workload.InfoOption
 	podsReadyTracking   bool
 	fairSharingEnabled  bool
+	tasCapacitySource   CapacitySource
+	tasPressureConds    []corev1.NodeConditionType
 }
 
 // Option configures the reconciler.
@@ -93,6 +95,23 @@ func WithFairSharing(enabled bool) Option {
 	}
 }
 
+// WithTASCapacitySource sets the source of the node capacity used by
+// Topology Aware Scheduling. By default, the node Allocatable is used.
+func WithTASCapacitySource(source CapacitySource) Option {
+	return func(o *options) {
+		o.tasCapacitySource = source
+	}
+}
+
+// WithTASNodePressureConditions sets the node conditions which, when true,
+// exclude the node from the capacity used by Topology Aware Scheduling. By
+// default, the nodes under MemoryPressure or DiskPressure are excluded.
+func WithTASNodePressureConditions(conditions ...corev1.NodeConditionType) Option {
+	return func(o *options) {
+		o.tasPressureConds = conditions
+	}
+}
+
 var defaultOptions = options{}
 
 // Cache keeps track of the Workloads that got admitted through ClusterQueues.
@@ -129,6 +148,12 @@ func New(client client.Client, opts ...Option) *Cache {
 		hm:                  hierarchy.NewManager[*clusterQueue, *cohort](newCohort),
 		tasCache:            NewTASCache(client),
 	}
+	if options.tasCapacitySource != nil {
+		c.tasCache.capacitySource = options.tasCapacitySource
+	}
+	if options.tasPressureConds != nil {
+		c.tasCache.pressureConditions = options.tasPressureConds
+	}
 	c.podsReadyCond.L = &c.RWMutex
 	return c
 }
@@ -245,6 +270,27 @@ func (c *Cache) TASCache() *TASCache {
 	return &c.tasCache
 }
 
+// AddTASFlavorCache sets the TAS cache of the flavor, restoring the usage of
+// the workloads which already reserve quota in the flavor. On manager restart
+// the workloads may be added to the cache before the TAS cache of the flavor
+// is created, so their usage, persisted as the topology assignments in the
+// workload admission, is restored here to prevent double booking.
+func (c *Cache) AddTASFlavorCache(name kueue.ResourceFlavorReference, tasFlavorCache *TASFlavorCache) {
+	c.Lock()
+	defer c.Unlock()
+	for _, cq := range c.hm.ClusterQueues {
+		for wlKey, wi := range cq.Workloads {
+			for fr := range wi.FlavorResourceUsage() {
+				if fr.Flavor == name {
+					tasFlavorCache.addUsage(wlKey, wi.TASUsage())
+					break
+				}
+			}
+		}
+	}
+	c.tasCache.Set(name, tasFlavorCache)
+}
+
 func (c *Cache) AddOrUpdateResourceFlavor(rf *kueue.ResourceFlavor) sets.Set[string] {
 	c.Lock()
 	defer c.Unlock()
@@ -478,13 +478,13 @@ func (c *clusterQueue) updateWorkloadUsage(wi *workload.Info, m int64) {
 		if m == 1 {
 			addUsage(c, fr, q)
 			if tasFlvCache != nil {
-				tasFlvCache.addUsage(tasUsage)
+				tasFlvCache.addUsage(workload.Key(wi.Obj), tasUsage)
 			}
 		}
 		if m == -1 {
 			removeUsage(c, fr, q)
 			if tasFlvCache != nil {
-				tasFlvCache.removeUsage(tasUsage)
+				tasFlvCache.removeUsage(workload.Key(wi.Obj))
 			}
 		}
 	}
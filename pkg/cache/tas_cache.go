@@ -20,21 +20,39 @@ import (
 	"maps"
 	"sync"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
+	kueuealpha "sigs.k8s.io/kueue/apis/kueue/v1alpha1"
 	kueue "sigs.k8s.io/kueue/apis/kueue/v1beta1"
+	"sigs.k8s.io/kueue/pkg/resources"
+	"sigs.k8s.io/kueue/pkg/util/limitrange"
 )
 
+// defaultNodePressureConditions are the node conditions which, when true,
+// exclude the node from the TAS capacity by default.
+var defaultNodePressureConditions = []corev1.NodeConditionType{
+	corev1.NodeMemoryPressure,
+	corev1.NodeDiskPressure,
+}
+
 type TASCache struct {
 	sync.RWMutex
-	client  client.Client
-	flavors map[kueue.ResourceFlavorReference]*TASFlavorCache
+	client             client.Client
+	capacitySource     CapacitySource
+	pressureConditions []corev1.NodeConditionType
+	flavors            map[kueue.ResourceFlavorReference]*TASFlavorCache
+	nonTASPods         *nonTASPods
 }
 
 func NewTASCache(client client.Client) TASCache {
 	return TASCache{
-		client:  client,
-		flavors: make(map[kueue.ResourceFlavorReference]*TASFlavorCache),
+		client:             client,
+		capacitySource:     allocatableCapacitySource{},
+		pressureConditions: defaultNodePressureConditions,
+		flavors:            make(map[kueue.ResourceFlavorReference]*TASFlavorCache),
+		nonTASPods:         newNonTASPods(),
 	}
 }
 
@@ -62,3 +80,99 @@ func (t *TASCache) Delete(name kueue.ResourceFlavorReference) {
 	defer t.Unlock()
 	delete(t.flavors, name)
 }
+
+// UpdatePod replaces the pod maintained for the snapshots, whose requests are
+// subtracted from the capacity of its node unless the pod is scheduled by
+// TAS or is terminated.
+func (t *TASCache) UpdatePod(pod *corev1.Pod) {
+	t.nonTASPods.update(pod)
+}
+
+// DeletePod removes the pod from the pods maintained for the snapshots.
+func (t *TASCache) DeletePod(key types.NamespacedName) {
+	t.nonTASPods.delete(key)
+}
+
+// nonTASPods maintains the requests of the running pods which aren't
+// scheduled by TAS, such as the DaemonSet pods, per node. The usage of the
+// pods scheduled by TAS is already accounted for by the admitted workloads.
+// The pods are only maintained from the pod events, which include the
+// existing pods when the watch starts, so the snapshots never list the pods.
+type nonTASPods struct {
+	sync.RWMutex
+
+	// pods maintains the node and the requests of the pods by the pod key,
+	// updated incrementally on the pod events.
+	pods map[types.NamespacedName]nonTASPod
+
+	// requestsPerNode maintains the total requests of the pods per node. The
+	// requests are replaced rather than mutated, so they are shared with the
+	// snapshots.
+	requestsPerNode map[string]resources.Requests
+}
+
+type nonTASPod struct {
+	nodeName string
+	requests resources.Requests
+}
+
+func newNonTASPods() *nonTASPods {
+	return &nonTASPods{
+		pods:            make(map[types.NamespacedName]nonTASPod),
+		requestsPerNode: make(map[string]resources.Requests),
+	}
+}
+
+// perNode returns the total requests of the pods per node.
+func (p *nonTASPods) perNode() map[string]resources.Requests {
+	p.RLock()
+	defer p.RUnlock()
+	return maps.Clone(p.requestsPerNode)
+}
+
+func (p *nonTASPods) update(pod *corev1.Pod) {
+	p.Lock()
+	defer p.Unlock()
+	p.set(pod)
+}
+
+func (p *nonTASPods) delete(key types.NamespacedName) {
+	p.Lock()
+	defer p.Unlock()
+	p.remove(key)
+}
+
+func (p *nonTASPods) set(pod *corev1.Pod) {
+	key := client.ObjectKeyFromObject(pod)
+	p.remove(key)
+	if pod.Spec.NodeName == "" || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return
+	}
+	if _, found := pod.Labels[kueuealpha.TASLabel]; found {
+		return
+	}
+	requests := resources.NewRequests(limitrange.TotalRequests(&pod.Spec))
+	requests[corev1.ResourcePods] = 1
+	p.pods[key] = nonTASPod{nodeName: pod.Spec.NodeName, requests: requests}
+	total := p.requestsPerNode[pod.Spec.NodeName].Clone()
+	if total == nil {
+		total = resources.Requests{}
+	}
+	total.Add(requests)
+	p.requestsPerNode[pod.Spec.NodeName] = total
+}
+
+func (p *nonTASPods) remove(key types.NamespacedName) {
+	pod, found := p.pods[key]
+	if !found {
+		return
+	}
+	delete(p.pods, key)
+	total := p.requestsPerNode[pod.nodeName].Clone()
+	total.Sub(pod.requests)
+	if total[corev1.ResourcePods] <= 0 {
+		delete(p.requestsPerNode, pod.nodeName)
+		return
+	}
+	p.requestsPerNode[pod.nodeName] = total
+}
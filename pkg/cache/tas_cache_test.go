@@ -18,25 +18,83 @@ package cache
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/go-logr/logr"
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/component-base/metrics/testutil"
 	"k8s.io/utils/ptr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
+	kueuealpha "sigs.k8s.io/kueue/apis/kueue/v1alpha1"
 	kueue "sigs.k8s.io/kueue/apis/kueue/v1beta1"
+	"sigs.k8s.io/kueue/pkg/features"
+	"sigs.k8s.io/kueue/pkg/metrics"
 	"sigs.k8s.io/kueue/pkg/resources"
+	utiltas "sigs.k8s.io/kueue/pkg/util/tas"
 	utiltesting "sigs.k8s.io/kueue/pkg/util/testing"
+	"sigs.k8s.io/kueue/pkg/workload"
+)
+
+// fakeCapacitySource overrides the capacity of the nodes by name, and falls
+// back to the node Allocatable.
+type fakeCapacitySource map[string]corev1.ResourceList
+
+func (f fakeCapacitySource) NodeCapacity(_ context.Context, node *corev1.Node) (corev1.ResourceList, error) {
+	if capacity, found := f[node.Name]; found {
+		return capacity, nil
+	}
+	return node.Status.Allocatable, nil
+}
+
+// lockProbeCapacitySource records the nodes whose capacity is requested
+// while the lock of the flavor is held, and falls back to the node
+// Allocatable.
+type lockProbeCapacitySource struct {
+	flavor      *TASFlavorCache
+	lockedNodes []string
+}
+
+func (l *lockProbeCapacitySource) NodeCapacity(_ context.Context, node *corev1.Node) (corev1.ResourceList, error) {
+	if l.flavor.TryLock() {
+		l.flavor.Unlock()
+	} else {
+		l.lockedNodes = append(l.lockedNodes, node.Name)
+	}
+	return node.Status.Allocatable, nil
+}
+
+const (
+	tasBlockLabel = "cloud.com/topology-block"
+	tasRackLabel  = "cloud.com/topology-rack"
+	tasHostLabel  = "kubernetes.io/hostname"
+
+	tasChassisAnnotation = "vendor.com/chassis"
 )
 
 func TestFindTopologyAssignment(t *testing.T) {
 	const (
-		tasBlockLabel = "cloud.com/topology-block"
-		tasRackLabel  = "cloud.com/topology-rack"
-		tasHostLabel  = "kubernetes.io/hostname"
+		carbonIntensityLabel      = "cloud.com/carbon-intensity"
+		regionLabel               = "topology.kubernetes.io/region"
+		densificationCeilingLabel = "mycorp.com/densification-ceiling"
+		spotLabel                 = "cloud.com/spot"
+		gpuCliqueLabel            = "nvidia.com/gpu.clique"
+
+		licenseResource corev1.ResourceName = "mycorp.com/license"
 	)
 
 	defaultNodes := []corev1.Node{
@@ -137,6 +195,136 @@ func TestFindTopologyAssignment(t *testing.T) {
 			},
 		},
 	}
+	//       b1
+	//   /       \
+	//  r1        r2
+	//  |       /  |  \
+	//  x1:5  x2:2 x3:2 x4:2
+	//
+	capacityBufferNodes := []corev1.Node{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "b1-r1-x1",
+				Labels: map[string]string{
+					tasBlockLabel: "b1",
+					tasRackLabel:  "r1",
+					tasHostLabel:  "x1",
+				},
+			},
+			Status: corev1.NodeStatus{
+				Allocatable: corev1.ResourceList{
+					corev1.ResourceCPU: resource.MustParse("5"),
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "b1-r2-x2",
+				Labels: map[string]string{
+					tasBlockLabel: "b1",
+					tasRackLabel:  "r2",
+					tasHostLabel:  "x2",
+				},
+			},
+			Status: corev1.NodeStatus{
+				Allocatable: corev1.ResourceList{
+					corev1.ResourceCPU: resource.MustParse("2"),
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "b1-r2-x3",
+				Labels: map[string]string{
+					tasBlockLabel: "b1",
+					tasRackLabel:  "r2",
+					tasHostLabel:  "x3",
+				},
+			},
+			Status: corev1.NodeStatus{
+				Allocatable: corev1.ResourceList{
+					corev1.ResourceCPU: resource.MustParse("2"),
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "b1-r2-x4",
+				Labels: map[string]string{
+					tasBlockLabel: "b1",
+					tasRackLabel:  "r2",
+					tasHostLabel:  "x4",
+				},
+			},
+			Status: corev1.NodeStatus{
+				Allocatable: corev1.ResourceList{
+					corev1.ResourceCPU: resource.MustParse("2"),
+				},
+			},
+		},
+	}
+
+	rackWithFourHostsNodes := []corev1.Node{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "b1-r1-x1",
+				Labels: map[string]string{
+					tasBlockLabel: "b1",
+					tasRackLabel:  "r1",
+				},
+			},
+			Status: corev1.NodeStatus{
+				Allocatable: corev1.ResourceList{
+					corev1.ResourceCPU: resource.MustParse("1"),
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "b1-r1-x2",
+				Labels: map[string]string{
+					tasBlockLabel: "b1",
+					tasRackLabel:  "r1",
+				},
+			},
+			Status: corev1.NodeStatus{
+				Allocatable: corev1.ResourceList{
+					corev1.ResourceCPU: resource.MustParse("1"),
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "b1-r1-x3",
+				Labels: map[string]string{
+					tasBlockLabel: "b1",
+					tasRackLabel:  "r1",
+				},
+			},
+			Status: corev1.NodeStatus{
+				Allocatable: corev1.ResourceList{
+					corev1.ResourceCPU: resource.MustParse("1"),
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "b1-r1-x4",
+				Labels: map[string]string{
+					tasBlockLabel: "b1",
+					tasRackLabel:  "r1",
+				},
+			},
+			Status: corev1.NodeStatus{
+				Allocatable: corev1.ResourceList{
+					corev1.ResourceCPU: resource.MustParse("1"),
+				},
+			},
+		},
+	}
+
+	now := time.Now()
+
 	defaultOneLevel := []string{
 		tasHostLabel,
 	}
@@ -151,13 +339,32 @@ func TestFindTopologyAssignment(t *testing.T) {
 	}
 
 	cases := map[string]struct {
-		request        kueue.PodSetTopologyRequest
-		levels         []string
-		nodeLabels     map[string]string
-		nodes          []corev1.Node
-		requests       resources.Requests
-		count          int32
-		wantAssignment *kueue.TopologyAssignment
+		request         kueue.PodSetTopologyRequest
+		levels          []string
+		nodeLabels      map[string]string
+		nodeSelector    *metav1.LabelSelector
+		nodeAnnotations map[string]string
+		nodes           []corev1.Node
+		requests        resources.Requests
+		count           int32
+		pendingNodes    []PendingNode
+		capacitySource  CapacitySource
+		opts            []FindTopologyAssignmentOption
+		wantAssignment  *kueue.TopologyAssignment
+		wantReason      TopologyAssignmentErrorReason
+		wantResource    corev1.ResourceName
+		wantShortfall   int64
+		wantProvisional []kueue.TopologyDomainAssignment
+
+		// alternatives is the number of the assignments requested from
+		// FindTopologyAssignmentAlternatives, which is not called if zero.
+		alternatives     int
+		wantAlternatives []*kueue.TopologyAssignment
+
+		includeUnschedulable bool
+		reservationFraction  float64
+		subHostLevel         string
+		annotationLevels     []string
 	}{
 		"minimize the number of used racks before optimizing the number of nodes": {
 			// Solution by optimizing the number of racks then nodes: [r3]: [x3,x4,x5,x6]
@@ -388,6 +595,7 @@ func TestFindTopologyAssignment(t *testing.T) {
 			},
 			count:          4,
 			wantAssignment: nil,
+			wantReason:     TopologyNotFit,
 		},
 		"block required; single Pod fits in a block": {
 			nodes: defaultNodes,
@@ -429,17 +637,17 @@ func TestFindTopologyAssignment(t *testing.T) {
 				Levels: defaultTwoLevels,
 				Domains: []kueue.TopologyDomainAssignment{
 					{
-						Count: 3,
+						Count: 1,
 						Values: []string{
 							"b1",
-							"r2",
+							"r1",
 						},
 					},
 					{
-						Count: 1,
+						Count: 3,
 						Values: []string{
 							"b1",
-							"r1",
+							"r2",
 						},
 					},
 				},
@@ -456,6 +664,9 @@ func TestFindTopologyAssignment(t *testing.T) {
 			},
 			count:          1,
 			wantAssignment: nil,
+			wantReason:     InsufficientClusterCapacity,
+			wantResource:   corev1.ResourceCPU,
+			wantShortfall:  1000,
 		},
 		"block required; too many Pods to fit requested": {
 			nodes: defaultNodes,
@@ -468,6 +679,7 @@ func TestFindTopologyAssignment(t *testing.T) {
 			},
 			count:          5,
 			wantAssignment: nil,
+			wantReason:     TopologyNotFit,
 		},
 		"rack required; single Pod requiring memory": {
 			nodes: defaultNodes,
@@ -506,17 +718,17 @@ func TestFindTopologyAssignment(t *testing.T) {
 				Levels: defaultTwoLevels,
 				Domains: []kueue.TopologyDomainAssignment{
 					{
-						Count: 3,
+						Count: 1,
 						Values: []string{
 							"b1",
-							"r2",
+							"r1",
 						},
 					},
 					{
-						Count: 1,
+						Count: 3,
 						Values: []string{
 							"b1",
-							"r1",
+							"r2",
 						},
 					},
 				},
@@ -536,24 +748,24 @@ func TestFindTopologyAssignment(t *testing.T) {
 				Levels: defaultTwoLevels,
 				Domains: []kueue.TopologyDomainAssignment{
 					{
-						Count: 3,
+						Count: 1,
 						Values: []string{
 							"b1",
-							"r2",
+							"r1",
 						},
 					},
 					{
-						Count: 2,
+						Count: 3,
 						Values: []string{
-							"b2",
+							"b1",
 							"r2",
 						},
 					},
 					{
-						Count: 1,
+						Count: 2,
 						Values: []string{
-							"b1",
-							"r1",
+							"b2",
+							"r2",
 						},
 					},
 				},
@@ -573,24 +785,24 @@ func TestFindTopologyAssignment(t *testing.T) {
 				Levels: defaultTwoLevels,
 				Domains: []kueue.TopologyDomainAssignment{
 					{
-						Count: 3,
+						Count: 1,
 						Values: []string{
 							"b1",
-							"r2",
+							"r1",
 						},
 					},
 					{
-						Count: 2,
+						Count: 3,
 						Values: []string{
-							"b2",
+							"b1",
 							"r2",
 						},
 					},
 					{
-						Count: 1,
+						Count: 2,
 						Values: []string{
-							"b1",
-							"r1",
+							"b2",
+							"r2",
 						},
 					},
 				},
@@ -607,6 +819,8 @@ func TestFindTopologyAssignment(t *testing.T) {
 			},
 			count:          10,
 			wantAssignment: nil,
+			wantReason:     InsufficientClusterCapacity,
+			wantResource:   corev1.ResourceCPU,
 		},
 		"only nodes with matching labels are considered; no matching node": {
 			nodes: []corev1.Node{
@@ -638,6 +852,7 @@ func TestFindTopologyAssignment(t *testing.T) {
 			},
 			count:          1,
 			wantAssignment: nil,
+			wantReason:     NoMatchingNodes,
 		},
 		"only nodes with matching labels are considered; matching node is found": {
 			nodes: []corev1.Node{
@@ -680,15 +895,14 @@ func TestFindTopologyAssignment(t *testing.T) {
 				},
 			},
 		},
-		"only nodes with matching levels are considered; no host label on node": {
+		"only nodes matching the selector are considered; no matching node": {
 			nodes: []corev1.Node{
 				{
 					ObjectMeta: metav1.ObjectMeta{
 						Name: "b1-r1-x1",
 						Labels: map[string]string{
-							tasBlockLabel: "b1",
-							tasRackLabel:  "r1",
-							// the node doesn't have the tasHostLabel required by topology
+							"zone":       "zone-a",
+							tasHostLabel: "x1",
 						},
 					},
 					Status: corev1.NodeStatus{
@@ -700,31 +914,9927 @@ func TestFindTopologyAssignment(t *testing.T) {
 				},
 			},
 			request: kueue.PodSetTopologyRequest{
-				Required: ptr.To(tasRackLabel),
+				Required: ptr.To(tasHostLabel),
 			},
-			levels: defaultThreeLevels,
+			nodeSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{
+						Key:      "zone",
+						Operator: metav1.LabelSelectorOpNotIn,
+						Values:   []string{"zone-a"},
+					},
+				},
+			},
+			levels: defaultOneLevel,
 			requests: resources.Requests{
 				corev1.ResourceCPU: 1000,
 			},
 			count:          1,
 			wantAssignment: nil,
+			wantReason:     NoMatchingNodes,
 		},
-	}
-	for name, tc := range cases {
-		t.Run(name, func(t *testing.T) {
-			ctx := context.Background()
-
-			initialObjects := make([]client.Object, 0)
-			for i := range tc.nodes {
-				initialObjects = append(initialObjects, &tc.nodes[i])
-			}
-			client := utiltesting.NewFakeClient(initialObjects...)
-			tasCache := NewTASCache(client)
-			tasFlavorCache := tasCache.NewTASFlavorCache(tc.levels, tc.nodeLabels)
-			snapshot := tasFlavorCache.snapshot(ctx)
-			gotAssignment := snapshot.FindTopologyAssignment(&tc.request, tc.requests, tc.count)
-			if diff := cmp.Diff(tc.wantAssignment, gotAssignment); diff != "" {
-				t.Errorf("unexpected topology assignment (-want,+got): %s", diff)
+		"only nodes matching the selector are considered; matching node is found": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x1",
+						Labels: map[string]string{
+							"zone":       "zone-a",
+							tasHostLabel: "x1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU:    resource.MustParse("1"),
+							corev1.ResourceMemory: resource.MustParse("1Gi"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasHostLabel),
+			},
+			nodeSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{
+						Key:      "zone",
+						Operator: metav1.LabelSelectorOpIn,
+						Values:   []string{"zone-a", "zone-b"},
+					},
+				},
+			},
+			levels: defaultOneLevel,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 1,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultOneLevel,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 1,
+						Values: []string{
+							"x1",
+						},
+					},
+				},
+			},
+		},
+		"only nodes matching the selector are considered; the selector matches multiple zones": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "x1",
+						Labels: map[string]string{
+							"zone":       "zone-a",
+							tasHostLabel: "x1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("1"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "x2",
+						Labels: map[string]string{
+							"zone":       "zone-b",
+							tasHostLabel: "x2",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("1"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "x3",
+						Labels: map[string]string{
+							"zone":       "zone-c",
+							tasHostLabel: "x3",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("1"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Preferred: ptr.To(tasHostLabel),
+			},
+			nodeSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{
+						Key:      "zone",
+						Operator: metav1.LabelSelectorOpIn,
+						Values:   []string{"zone-a", "zone-b"},
+					},
+				},
+			},
+			levels: defaultOneLevel,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 2,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultOneLevel,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 1,
+						Values: []string{
+							"x1",
+						},
+					},
+					{
+						Count: 1,
+						Values: []string{
+							"x2",
+						},
+					},
+				},
+			},
+		},
+		"only nodes matching the selector are considered; the NotIn selector excludes a zone": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "x1",
+						Labels: map[string]string{
+							"zone":       "zone-a",
+							tasHostLabel: "x1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("1"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "x2",
+						Labels: map[string]string{
+							"zone":       "zone-b",
+							tasHostLabel: "x2",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("1"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "x3",
+						Labels: map[string]string{
+							"zone":       "zone-c",
+							tasHostLabel: "x3",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("1"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Preferred: ptr.To(tasHostLabel),
+			},
+			nodeSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{
+						Key:      "zone",
+						Operator: metav1.LabelSelectorOpNotIn,
+						Values:   []string{"zone-b"},
+					},
+				},
+			},
+			levels: defaultOneLevel,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count:        3,
+			wantReason:   InsufficientClusterCapacity,
+			wantResource: corev1.ResourceCPU,
+		},
+		"only nodes with matching levels are considered; no host label on node": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+							// the node doesn't have the tasHostLabel required by topology
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU:    resource.MustParse("1"),
+							corev1.ResourceMemory: resource.MustParse("1Gi"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultThreeLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count:          1,
+			wantAssignment: nil,
+			wantReason:     NoMatchingNodes,
+		},
+		"rack preferred; no-splinter threshold prefers 4+3 over 6+1": {
+			//       b1
+			//   /       \
+			//  r1:6     r2:4
+			//
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("6"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r2",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r2",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("4"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Preferred: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 7,
+			opts:  []FindTopologyAssignmentOption{WithNoSplinterThreshold(3)},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultTwoLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 4,
+						Values: []string{
+							"b1",
+							"r1",
+						},
+					},
+					{
+						Count: 3,
+						Values: []string{
+							"b1",
+							"r2",
+						},
+					},
+				},
+			},
+		},
+		"rack required; pods fit on the pending nodes": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+							tasHostLabel:  "x1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("1"),
+						},
+					},
+				},
+			},
+			pendingNodes: []PendingNode{
+				{
+					Name: "b1-r2-x2",
+					Labels: map[string]string{
+						tasBlockLabel: "b1",
+						tasRackLabel:  "r2",
+						tasHostLabel:  "x2",
+					},
+					Capacity: corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("2"),
+					},
+				},
+				{
+					Name: "b1-r2-x3",
+					Labels: map[string]string{
+						tasBlockLabel: "b1",
+						tasRackLabel:  "r2",
+						tasHostLabel:  "x3",
+					},
+					Capacity: corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("1"),
+					},
+				},
+				{
+					Name: "b1-r3-x4",
+					Labels: map[string]string{
+						tasBlockLabel: "b1",
+						tasRackLabel:  "r3",
+						// the pending node doesn't have the tasHostLabel required by topology
+					},
+					Capacity: corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("4"),
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultThreeLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 3,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultThreeLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 2,
+						Values: []string{
+							"b1",
+							"r2",
+							"x2",
+						},
+					},
+					{
+						Count: 1,
+						Values: []string{
+							"b1",
+							"r2",
+							"x3",
+						},
+					},
+				},
+			},
+			wantProvisional: []kueue.TopologyDomainAssignment{
+				{
+					Count: 2,
+					Values: []string{
+						"b1",
+						"r2",
+						"x2",
+					},
+				},
+				{
+					Count: 1,
+					Values: []string{
+						"b1",
+						"r2",
+						"x3",
+					},
+				},
+			},
+		},
+		"chassis required; pods fit on the pending node with the chassis annotation": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "x1",
+						Labels: map[string]string{
+							tasHostLabel: "x1",
+						},
+						Annotations: map[string]string{
+							tasChassisAnnotation: "c1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("1"),
+						},
+					},
+				},
+			},
+			pendingNodes: []PendingNode{
+				{
+					Name: "x2",
+					Labels: map[string]string{
+						tasHostLabel: "x2",
+					},
+					Annotations: map[string]string{
+						tasChassisAnnotation: "c2",
+					},
+					Capacity: corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("2"),
+					},
+				},
+				{
+					Name: "x3",
+					Labels: map[string]string{
+						tasHostLabel: "x3",
+						// the chassis is read from the annotation, not the label
+						tasChassisAnnotation: "c3",
+					},
+					Capacity: corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("4"),
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasChassisAnnotation),
+			},
+			levels:           []string{tasChassisAnnotation, tasHostLabel},
+			annotationLevels: []string{tasChassisAnnotation},
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 2,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: []string{tasChassisAnnotation, tasHostLabel},
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 2,
+						Values: []string{
+							"c2",
+							"x2",
+						},
+					},
+				},
+			},
+			wantProvisional: []kueue.TopologyDomainAssignment{
+				{
+					Count: 2,
+					Values: []string{
+						"c2",
+						"x2",
+					},
+				},
+			},
+		},
+		"GPU clique required; the pod fits on the pending node without the clique label": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "x1",
+						Labels: map[string]string{
+							tasHostLabel: "x1",
+						},
+						Annotations: map[string]string{
+							kueuealpha.NodeNVLinkGroupsAnnotation: "4",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("32"),
+							gpuResourceName:    resource.MustParse("4"),
+						},
+					},
+				},
+			},
+			pendingNodes: []PendingNode{
+				{
+					Name: "x2",
+					Labels: map[string]string{
+						tasHostLabel: "x2",
+					},
+					Capacity: corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("32"),
+						gpuResourceName:    resource.MustParse("8"),
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(gpuCliqueLabel),
+			},
+			levels:       []string{tasHostLabel, gpuCliqueLabel},
+			subHostLevel: gpuCliqueLabel,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+				gpuResourceName:    8,
+			},
+			count: 1,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: []string{tasHostLabel, gpuCliqueLabel},
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 1,
+						Values: []string{
+							"x2",
+							"0",
+						},
+					},
+				},
+			},
+			wantProvisional: []kueue.TopologyDomainAssignment{
+				{
+					Count: 1,
+					Values: []string{
+						"x2",
+						"0",
+					},
+				},
+			},
+		},
+		"rack preferred; locality budget 0 forces a single rack": {
+			nodes: defaultNodes,
+			request: kueue.PodSetTopologyRequest{
+				Preferred: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 3,
+			opts:  []FindTopologyAssignmentOption{WithLocalityBudget(0)},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultTwoLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 3,
+						Values: []string{
+							"b1",
+							"r2",
+						},
+					},
+				},
+			},
+		},
+		"rack preferred; locality budget 0 fails when no single rack fits": {
+			nodes: defaultNodes,
+			request: kueue.PodSetTopologyRequest{
+				Preferred: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count:          4,
+			opts:           []FindTopologyAssignmentOption{WithLocalityBudget(0)},
+			wantAssignment: nil,
+			wantReason:     TopologyNotFit,
+		},
+		"rack preferred; locality budget 1 allows full spread": {
+			nodes: defaultNodes,
+			request: kueue.PodSetTopologyRequest{
+				Preferred: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 6,
+			opts:  []FindTopologyAssignmentOption{WithLocalityBudget(1)},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultTwoLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 1,
+						Values: []string{
+							"b1",
+							"r1",
+						},
+					},
+					{
+						Count: 3,
+						Values: []string{
+							"b1",
+							"r2",
+						},
+					},
+					{
+						Count: 2,
+						Values: []string{
+							"b2",
+							"r2",
+						},
+					},
+				},
+			},
+		},
+		"rack required; capacity buffer rounds down to zero for a small workload": {
+			nodes: capacityBufferNodes,
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultThreeLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 2,
+			opts:  []FindTopologyAssignmentOption{WithCapacityBuffer(ProportionalCapacityBuffer(0.25))},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultThreeLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 2,
+						Values: []string{
+							"b1",
+							"r2",
+							"x2",
+						},
+					},
+				},
+			},
+		},
+		"rack required; large workload with capacity buffer prefers the rack with fewer hosts": {
+			nodes: capacityBufferNodes,
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultThreeLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 4,
+			opts:  []FindTopologyAssignmentOption{WithCapacityBuffer(ProportionalCapacityBuffer(0.25))},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultThreeLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 4,
+						Values: []string{
+							"b1",
+							"r1",
+							"x1",
+						},
+					},
+				},
+			},
+		},
+		"rack required; excluded node is avoided but its rack is reused": {
+			nodes: defaultNodes,
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultThreeLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 2,
+			opts:  []FindTopologyAssignmentOption{WithExcludedNodes("b1-r2-x3")},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultThreeLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 1,
+						Values: []string{
+							"b1",
+							"r2",
+							"x1",
+						},
+					},
+					{
+						Count: 1,
+						Values: []string{
+							"b1",
+							"r2",
+							"x4",
+						},
+					},
+				},
+			},
+		},
+		"rack required; historically flaky rack is deprioritized": {
+			nodes: defaultNodes,
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 2,
+			opts: []FindTopologyAssignmentOption{WithDomainHistory(map[utiltas.TopologyDomainID]DomainHistory{
+				"b1,r2": {Succeeded: 2, Failed: 8},
+				"b2,r2": {Succeeded: 9, Failed: 1},
+			})},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultTwoLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 2,
+						Values: []string{
+							"b2",
+							"r2",
+						},
+					},
+				},
+			},
+		},
+		"rack required; rack containing the data nodes is preferred": {
+			nodes: defaultNodes,
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 2,
+			opts:  []FindTopologyAssignmentOption{WithDataNodes("b2-r2-x6")},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultTwoLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 2,
+						Values: []string{
+							"b2",
+							"r2",
+						},
+					},
+				},
+			},
+		},
+		"rack required; data nodes in a rack which doesn't fit are ignored": {
+			nodes: defaultNodes,
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 2,
+			opts:  []FindTopologyAssignmentOption{WithDataNodes("b1-r1-x1")},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultTwoLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 2,
+						Values: []string{
+							"b1",
+							"r2",
+						},
+					},
+				},
+			},
+		},
+		"host required; multi-GPU pods fit within a single NVLink group": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "x1",
+						Labels: map[string]string{
+							tasHostLabel: "x1",
+						},
+						Annotations: map[string]string{
+							kueuealpha.NodeNVLinkGroupsAnnotation: "2,2,2,2",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							gpuResourceName: resource.MustParse("8"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "x2",
+						Labels: map[string]string{
+							tasHostLabel: "x2",
+						},
+						Annotations: map[string]string{
+							kueuealpha.NodeNVLinkGroupsAnnotation: "4,4",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							gpuResourceName: resource.MustParse("8"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasHostLabel),
+			},
+			levels: defaultOneLevel,
+			requests: resources.Requests{
+				gpuResourceName: 4,
+			},
+			count: 2,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultOneLevel,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 2,
+						Values: []string{
+							"x2",
+						},
+					},
+				},
+			},
+		},
+		"rack required; unhealthy GPUs are not counted towards the capacity": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							gpuResourceName: resource.MustParse("4"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x2",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+						},
+						Annotations: map[string]string{
+							kueuealpha.NodeUnhealthyGPUsAnnotation: "3",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							gpuResourceName: resource.MustParse("4"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r2-x3",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r2",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							gpuResourceName: resource.MustParse("6"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				gpuResourceName: 1,
+			},
+			count: 6,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultTwoLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 6,
+						Values: []string{
+							"b1",
+							"r2",
+						},
+					},
+				},
+			},
+		},
+		"rack required; one spare host is reserved in the rack": {
+			nodes: rackWithFourHostsNodes,
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 3,
+			opts:  []FindTopologyAssignmentOption{WithSparesPerDomain(1)},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultTwoLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 3,
+						Values: []string{
+							"b1",
+							"r1",
+						},
+					},
+				},
+			},
+		},
+		"rack required; all hosts can't be used when one spare host is reserved": {
+			nodes: rackWithFourHostsNodes,
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count:          4,
+			opts:           []FindTopologyAssignmentOption{WithSparesPerDomain(1)},
+			wantAssignment: nil,
+			wantReason:     InsufficientCapacity,
+		},
+		"rack required; recently active rack is preferred over an idle one": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("2"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r2",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r2",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("2"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 2,
+			opts: []FindTopologyAssignmentOption{WithDomainLastActivity(map[utiltas.TopologyDomainID]time.Time{
+				"b1,r1": now.Add(-time.Hour),
+				"b1,r2": now,
+			})},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultTwoLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 2,
+						Values: []string{
+							"b1",
+							"r2",
+						},
+					},
+				},
+			},
+		},
+		"block required; tight latency budget yields the first fitting block": {
+			nodes: defaultNodes,
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasBlockLabel),
+			},
+			levels: defaultThreeLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 2,
+			opts:  []FindTopologyAssignmentOption{WithLatencyBudget(0)},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultThreeLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 1,
+						Values: []string{
+							"b1",
+							"r2",
+							"x1",
+						},
+					},
+					{
+						Count: 1,
+						Values: []string{
+							"b1",
+							"r2",
+							"x3",
+						},
+					},
+				},
+			},
+		},
+		"block required; generous latency budget yields the tightest placement": {
+			nodes: defaultNodes,
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasBlockLabel),
+			},
+			levels: defaultThreeLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 2,
+			opts:  []FindTopologyAssignmentOption{WithLatencyBudget(time.Hour)},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultThreeLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 2,
+						Values: []string{
+							"b2",
+							"r2",
+							"x6",
+						},
+					},
+				},
+			},
+		},
+		"rack required; provisioning class limit forces packing onto the existing nodes": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("3"),
+						},
+					},
+				},
+			},
+			pendingNodes: []PendingNode{
+				{
+					Name: "b1-r2-x2",
+					Labels: map[string]string{
+						tasBlockLabel: "b1",
+						tasRackLabel:  "r2",
+					},
+					Capacity: corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("2"),
+					},
+				},
+				{
+					Name: "b1-r2-x3",
+					Labels: map[string]string{
+						tasBlockLabel: "b1",
+						tasRackLabel:  "r2",
+					},
+					Capacity: corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("2"),
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 3,
+			opts:  []FindTopologyAssignmentOption{WithMaxNewNodes(1)},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultTwoLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 3,
+						Values: []string{
+							"b1",
+							"r1",
+						},
+					},
+				},
+			},
+		},
+		"block required; minimizing nodes packs onto one fat node": {
+			//       b1                  b2
+			//       |                   |
+			//       r1                  r2
+			//  /   |   |   \            |
+			// x1:1 x2:1 x3:1 x4:1       x5:3
+			//
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+							tasHostLabel:  "x1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("1"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x2",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+							tasHostLabel:  "x2",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("1"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x3",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+							tasHostLabel:  "x3",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("1"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x4",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+							tasHostLabel:  "x4",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("1"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b2-r2-x5",
+						Labels: map[string]string{
+							tasBlockLabel: "b2",
+							tasRackLabel:  "r2",
+							tasHostLabel:  "x5",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("3"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasBlockLabel),
+			},
+			levels: defaultThreeLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 3,
+			opts:  []FindTopologyAssignmentOption{WithMinimizeNodes()},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultThreeLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 3,
+						Values: []string{
+							"b2",
+							"r2",
+							"x5",
+						},
+					},
+				},
+			},
+		},
+		"rack required; low-carbon rack is preferred for a batch workload": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1",
+						Labels: map[string]string{
+							tasBlockLabel:        "b1",
+							tasRackLabel:         "r1",
+							carbonIntensityLabel: "400",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("2"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r2",
+						Labels: map[string]string{
+							tasBlockLabel:        "b1",
+							tasRackLabel:         "r2",
+							carbonIntensityLabel: "100",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("2"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 2,
+			opts:  []FindTopologyAssignmentOption{WithCarbonIntensity(carbonIntensityLabel, CarbonModeBatch)},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultTwoLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 2,
+						Values: []string{
+							"b1",
+							"r2",
+						},
+					},
+				},
+			},
+		},
+		"rack required; carbon intensity is ignored for a latency-sensitive workload": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1",
+						Labels: map[string]string{
+							tasBlockLabel:        "b1",
+							tasRackLabel:         "r1",
+							carbonIntensityLabel: "400",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("2"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r2",
+						Labels: map[string]string{
+							tasBlockLabel:        "b1",
+							tasRackLabel:         "r2",
+							carbonIntensityLabel: "100",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("2"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 2,
+			opts:  []FindTopologyAssignmentOption{WithCarbonIntensity(carbonIntensityLabel, CarbonModeLatencySensitive)},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultTwoLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 2,
+						Values: []string{
+							"b1",
+							"r1",
+						},
+					},
+				},
+			},
+		},
+		"rack required; requested level is not defined for the flavor": {
+			nodes: defaultNodes,
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultOneLevel,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count:          1,
+			wantAssignment: nil,
+			wantReason:     InvalidTopologyLevel,
+		},
+		"rack required; capacity source overrides the node allocatable": {
+			nodes: defaultNodes,
+			capacitySource: fakeCapacitySource{
+				"b2-r2-x6": corev1.ResourceList{
+					corev1.ResourceCPU: resource.MustParse("4"),
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 4,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultTwoLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 4,
+						Values: []string{
+							"b2",
+							"r2",
+						},
+					},
+				},
+			},
+		},
+		"urgent workload prefers the host Ready for longer within the rack": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+							tasHostLabel:  "x1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("2"),
+						},
+						Conditions: []corev1.NodeCondition{
+							{
+								Type:               corev1.NodeReady,
+								Status:             corev1.ConditionTrue,
+								LastTransitionTime: metav1.NewTime(now.Add(-time.Minute)),
+							},
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x2",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+							tasHostLabel:  "x2",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("2"),
+						},
+						Conditions: []corev1.NodeCondition{
+							{
+								Type:               corev1.NodeReady,
+								Status:             corev1.ConditionTrue,
+								LastTransitionTime: metav1.NewTime(now.Add(-time.Hour)),
+							},
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultThreeLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 2,
+			opts: []FindTopologyAssignmentOption{
+				WithMinNodeReadyAge(10 * time.Minute),
+			},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultThreeLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 2,
+						Values: []string{
+							"b1",
+							"r1",
+							"x2",
+						},
+					},
+				},
+			},
+		},
+		"long GPU job is placed in the rack below its GPU-hour budget": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							gpuResourceName: resource.MustParse("8"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r2-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r2",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							gpuResourceName: resource.MustParse("8"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				gpuResourceName: 4,
+			},
+			count: 2,
+			opts: []FindTopologyAssignmentOption{
+				WithGPUHourBudget(100, map[utiltas.TopologyDomainID]float64{
+					"b1,r1": 90,
+				}, 10*time.Hour),
+			},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultTwoLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 2,
+						Values: []string{
+							"b1",
+							"r2",
+						},
+					},
+				},
+			},
+		},
+		"long GPU job exceeding the GPU-hour budget of any rack doesn't fit": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							gpuResourceName: resource.MustParse("8"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				gpuResourceName: 4,
+			},
+			count: 2,
+			opts: []FindTopologyAssignmentOption{
+				WithGPUHourBudget(50, nil, 10*time.Hour),
+			},
+			wantReason: InsufficientCapacity,
+		},
+		"rack required; the host under memory pressure is excluded": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+							tasHostLabel:  "x1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("2"),
+						},
+						Conditions: []corev1.NodeCondition{
+							{
+								Type:   corev1.NodeMemoryPressure,
+								Status: corev1.ConditionTrue,
+							},
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x2",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+							tasHostLabel:  "x2",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("2"),
+						},
+						Conditions: []corev1.NodeCondition{
+							{
+								Type:   corev1.NodeMemoryPressure,
+								Status: corev1.ConditionFalse,
+							},
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultThreeLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 2,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultThreeLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 2,
+						Values: []string{
+							"b1",
+							"r1",
+							"x2",
+						},
+					},
+				},
+			},
+		},
+		"rack required; the host under memory pressure doesn't contribute capacity": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+							tasHostLabel:  "x1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("2"),
+						},
+						Conditions: []corev1.NodeCondition{
+							{
+								Type:   corev1.NodeMemoryPressure,
+								Status: corev1.ConditionTrue,
+							},
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x2",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+							tasHostLabel:  "x2",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("2"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultThreeLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count:        3,
+			wantReason:   InsufficientClusterCapacity,
+			wantResource: corev1.ResourceCPU,
+		},
+		"rack required; the rack in the preferred region is chosen": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+							regionLabel:   "us-west",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("4"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r2-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r2",
+							regionLabel:   "us-east",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("4"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 2,
+			opts: []FindTopologyAssignmentOption{
+				WithRegionAffinity(regionLabel, "us-east"),
+			},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultTwoLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 2,
+						Values: []string{
+							"b1",
+							"r2",
+						},
+					},
+				},
+			},
+		},
+		"rack required; fall back to the other region when the preferred region is full": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+							regionLabel:   "us-west",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("4"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r2-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r2",
+							regionLabel:   "us-east",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("1"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 2,
+			opts: []FindTopologyAssignmentOption{
+				WithRegionAffinity(regionLabel, "us-east"),
+			},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultTwoLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 2,
+						Values: []string{
+							"b1",
+							"r1",
+						},
+					},
+				},
+			},
+		},
+		"rack required; the rack with enough license tokens is chosen despite ample CPU in the other rack": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("16"),
+							licenseResource:    resource.MustParse("1"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x2",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("16"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r2-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r2",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("2"),
+							licenseResource:    resource.MustParse("1"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r2-x2",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r2",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("2"),
+							licenseResource:    resource.MustParse("1"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+				licenseResource:    1,
+			},
+			count: 2,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultTwoLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 2,
+						Values: []string{
+							"b1",
+							"r2",
+						},
+					},
+				},
+			},
+		},
+		"rack required; insufficient license tokens block the placement despite ample CPU": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("16"),
+							licenseResource:    resource.MustParse("1"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x2",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("16"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+				licenseResource:    1,
+			},
+			count:        2,
+			wantReason:   InsufficientClusterCapacity,
+			wantResource: licenseResource,
+		},
+		"rack required; millicpu precision packs two pods in the rack": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("3500m"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1200,
+			},
+			count: 2,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultTwoLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 2,
+						Values: []string{
+							"b1",
+							"r1",
+						},
+					},
+				},
+			},
+		},
+		"rack required; whole-CPU granularity leaves room for one pod only": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("3500m"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1200,
+			},
+			count: 2,
+			opts: []FindTopologyAssignmentOption{
+				WithResourceGranularity(corev1.ResourceList{
+					corev1.ResourceCPU: resource.MustParse("1"),
+				}),
+			},
+			wantReason: InsufficientCapacity,
+		},
+		"block required; at most 2 racks; the block fitting the pods in 2 racks is chosen and the hosts are packed densely": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+							tasHostLabel:  "x1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("3"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r2-x2",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r2",
+							tasHostLabel:  "x2",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("3"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r3-x3",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r3",
+							tasHostLabel:  "x3",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("3"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b2-r1-x4",
+						Labels: map[string]string{
+							tasBlockLabel: "b2",
+							tasRackLabel:  "r1",
+							tasHostLabel:  "x4",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("2"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b2-r1-x5",
+						Labels: map[string]string{
+							tasBlockLabel: "b2",
+							tasRackLabel:  "r1",
+							tasHostLabel:  "x5",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("2"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b2-r2-x6",
+						Labels: map[string]string{
+							tasBlockLabel: "b2",
+							tasRackLabel:  "r2",
+							tasHostLabel:  "x6",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("3"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasBlockLabel),
+			},
+			levels: defaultThreeLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 7,
+			opts: []FindTopologyAssignmentOption{
+				WithMaxDomains(tasRackLabel, 2),
+			},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultThreeLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 2,
+						Values: []string{
+							"b2",
+							"r1",
+							"x4",
+						},
+					},
+					{
+						Count: 2,
+						Values: []string{
+							"b2",
+							"r1",
+							"x5",
+						},
+					},
+					{
+						Count: 3,
+						Values: []string{
+							"b2",
+							"r2",
+							"x6",
+						},
+					},
+				},
+			},
+		},
+		"block required; at most 2 racks; no block fits the pods in 2 racks": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+							tasHostLabel:  "x1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("3"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r2-x2",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r2",
+							tasHostLabel:  "x2",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("3"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r3-x3",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r3",
+							tasHostLabel:  "x3",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("3"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b2-r1-x4",
+						Labels: map[string]string{
+							tasBlockLabel: "b2",
+							tasRackLabel:  "r1",
+							tasHostLabel:  "x4",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("2"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b2-r1-x5",
+						Labels: map[string]string{
+							tasBlockLabel: "b2",
+							tasRackLabel:  "r1",
+							tasHostLabel:  "x5",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("2"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b2-r2-x6",
+						Labels: map[string]string{
+							tasBlockLabel: "b2",
+							tasRackLabel:  "r2",
+							tasHostLabel:  "x6",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("3"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasBlockLabel),
+			},
+			levels: defaultThreeLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 8,
+			opts: []FindTopologyAssignmentOption{
+				WithMaxDomains(tasRackLabel, 2),
+			},
+			wantReason: TopologyNotFit,
+		},
+		"block required; the flagged rack is packed only up to its densification ceiling": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1",
+						Labels: map[string]string{
+							tasBlockLabel:             "b1",
+							tasRackLabel:              "r1",
+							densificationCeilingLabel: "0.5",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("4"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r2",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r2",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("4"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasBlockLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 6,
+			opts: []FindTopologyAssignmentOption{
+				WithDensificationCeiling(densificationCeilingLabel),
+			},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultTwoLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 2,
+						Values: []string{
+							"b1",
+							"r1",
+						},
+					},
+					{
+						Count: 4,
+						Values: []string{
+							"b1",
+							"r2",
+						},
+					},
+				},
+			},
+		},
+		"block required; the densification ceiling blocks the placement despite the absolute room": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1",
+						Labels: map[string]string{
+							tasBlockLabel:             "b1",
+							tasRackLabel:              "r1",
+							densificationCeilingLabel: "0.5",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("4"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r2",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r2",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("4"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasBlockLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 7,
+			opts: []FindTopologyAssignmentOption{
+				WithDensificationCeiling(densificationCeilingLabel),
+			},
+			wantReason: InsufficientCapacity,
+		},
+		"rack required; the tight-deadline workload avoids the spot rack": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+							spotLabel:     "true",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("4"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r2-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r2",
+							spotLabel:     "false",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("3"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 2,
+			opts: []FindTopologyAssignmentOption{
+				WithDeadline(spotLabel, now.Add(30*time.Minute), now, time.Hour),
+			},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultTwoLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 2,
+						Values: []string{
+							"b1",
+							"r2",
+						},
+					},
+				},
+			},
+		},
+		"rack required; the workload with slack prefers the spot rack": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+							spotLabel:     "true",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("3"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r2-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r2",
+							spotLabel:     "false",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("4"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 2,
+			opts: []FindTopologyAssignmentOption{
+				WithDeadline(spotLabel, now.Add(2*time.Hour), now, time.Hour),
+			},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultTwoLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 2,
+						Values: []string{
+							"b1",
+							"r1",
+						},
+					},
+				},
+			},
+		},
+		"rack required; minimize the fragmentation among the racks using the same number of hosts": {
+			// Solution by the most free rack: [r1]: [x1:3,x2:1], leaving x2 partially used
+			// Solution by the least slack: [r3]: [x6:4], leaving x6 partially used
+			// Solution by the fragmentation penalty: [r2]: [x4:4], leaving no partially used hosts
+			//
+			//              b1
+			//    /         |       \
+			//   r1         r2       r3
+			//  |   \   \    |   \     |
+			// x1:3,x2:3,x3:1,x4:4,x5:2,x6:5
+			//
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+							tasHostLabel:  "x1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("3"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x2",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+							tasHostLabel:  "x2",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("3"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x3",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+							tasHostLabel:  "x3",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("1"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r2-x4",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r2",
+							tasHostLabel:  "x4",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("4"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r2-x5",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r2",
+							tasHostLabel:  "x5",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("2"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r3-x6",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r3",
+							tasHostLabel:  "x6",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("5"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultThreeLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 4,
+			opts: []FindTopologyAssignmentOption{
+				WithFragmentationPenalty(),
+			},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultThreeLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 4,
+						Values: []string{
+							"b1",
+							"r2",
+							"x4",
+						},
+					},
+				},
+			},
+		},
+		"rack required; without the fragmentation penalty the rack with the most free capacity is used": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+							tasHostLabel:  "x1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("3"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x2",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+							tasHostLabel:  "x2",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("3"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x3",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+							tasHostLabel:  "x3",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("1"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r2-x4",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r2",
+							tasHostLabel:  "x4",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("4"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r2-x5",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r2",
+							tasHostLabel:  "x5",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("2"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r3-x6",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r3",
+							tasHostLabel:  "x6",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("5"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultThreeLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 4,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultThreeLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 3,
+						Values: []string{
+							"b1",
+							"r1",
+							"x1",
+						},
+					},
+					{
+						Count: 1,
+						Values: []string{
+							"b1",
+							"r1",
+							"x2",
+						},
+					},
+				},
+			},
+		},
+		"rack required; the cordoned node is excluded": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+						},
+					},
+					Spec: corev1.NodeSpec{
+						Unschedulable: true,
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("4"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r2-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r2",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("2"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 2,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultTwoLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 2,
+						Values: []string{
+							"b1",
+							"r2",
+						},
+					},
+				},
+			},
+		},
+		"rack required; the NotReady node is excluded": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("4"),
+						},
+						Conditions: []corev1.NodeCondition{
+							{
+								Type:   corev1.NodeReady,
+								Status: corev1.ConditionFalse,
+							},
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r2-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r2",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("2"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 2,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultTwoLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 2,
+						Values: []string{
+							"b1",
+							"r2",
+						},
+					},
+				},
+			},
+		},
+		"rack required; the cordoned node is included on request": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+						},
+					},
+					Spec: corev1.NodeSpec{
+						Unschedulable: true,
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("4"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r2-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r2",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("2"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count:                2,
+			includeUnschedulable: true,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultTwoLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 2,
+						Values: []string{
+							"b1",
+							"r1",
+						},
+					},
+				},
+			},
+		},
+		"rack required; the tainted node is excluded without the toleration": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+						},
+					},
+					Spec: corev1.NodeSpec{
+						Taints: []corev1.Taint{
+							{
+								Key:    "dedicated",
+								Value:  "training",
+								Effect: corev1.TaintEffectNoSchedule,
+							},
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("4"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r2-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r2",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("2"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 2,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultTwoLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 2,
+						Values: []string{
+							"b1",
+							"r2",
+						},
+					},
+				},
+			},
+		},
+		"rack required; the rack backed solely by the tainted node doesn't fit without the toleration": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+						},
+					},
+					Spec: corev1.NodeSpec{
+						Taints: []corev1.Taint{
+							{
+								Key:    "dedicated",
+								Value:  "training",
+								Effect: corev1.TaintEffectNoSchedule,
+							},
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("4"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r2-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r2",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("2"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count:      3,
+			wantReason: InsufficientCapacity,
+		},
+		"rack required; the tainted node is included with the matching toleration": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+						},
+					},
+					Spec: corev1.NodeSpec{
+						Taints: []corev1.Taint{
+							{
+								Key:    "dedicated",
+								Value:  "training",
+								Effect: corev1.TaintEffectNoSchedule,
+							},
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("4"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r2-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r2",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("2"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 3,
+			opts: []FindTopologyAssignmentOption{
+				WithTolerations(corev1.Toleration{
+					Key:      "dedicated",
+					Operator: corev1.TolerationOpEqual,
+					Value:    "training",
+					Effect:   corev1.TaintEffectNoSchedule,
+				}),
+			},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultTwoLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 3,
+						Values: []string{
+							"b1",
+							"r1",
+						},
+					},
+				},
+			},
+		},
+		"rack required with block fallback; the rack doesn't fit, the block does": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+							tasHostLabel:  "x1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("2"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r2-x2",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r2",
+							tasHostLabel:  "x2",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("2"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b2-r3-x3",
+						Labels: map[string]string{
+							tasBlockLabel: "b2",
+							tasRackLabel:  "r3",
+							tasHostLabel:  "x3",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("1"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required:         ptr.To(tasRackLabel),
+				RequiredFallback: []string{tasBlockLabel},
+			},
+			levels: defaultThreeLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 4,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultThreeLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 2,
+						Values: []string{
+							"b1",
+							"r1",
+							"x1",
+						},
+					},
+					{
+						Count: 2,
+						Values: []string{
+							"b1",
+							"r2",
+							"x2",
+						},
+					},
+				},
+			},
+		},
+		"rack required with block fallback; neither the rack nor the block fits": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+							tasHostLabel:  "x1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("2"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r2-x2",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r2",
+							tasHostLabel:  "x2",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("2"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b2-r3-x3",
+						Labels: map[string]string{
+							tasBlockLabel: "b2",
+							tasRackLabel:  "r3",
+							tasHostLabel:  "x3",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("1"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required:         ptr.To(tasRackLabel),
+				RequiredFallback: []string{tasBlockLabel},
+			},
+			levels: defaultThreeLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count:      5,
+			wantReason: TopologyNotFit,
+		},
+		"host required; node with 8 GPUs fits exactly two 4-GPU pods": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "x1",
+						Labels: map[string]string{
+							tasHostLabel: "x1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU:    resource.MustParse("64"),
+							corev1.ResourceMemory: resource.MustParse("256Gi"),
+							gpuResourceName:       resource.MustParse("8"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasHostLabel),
+			},
+			levels: defaultOneLevel,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+				gpuResourceName:    4,
+			},
+			count: 2,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultOneLevel,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 2,
+						Values: []string{
+							"x1",
+						},
+					},
+				},
+			},
+		},
+		"host required; not enough GPUs although cpu and memory are plentiful": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "x1",
+						Labels: map[string]string{
+							tasHostLabel: "x1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU:    resource.MustParse("64"),
+							corev1.ResourceMemory: resource.MustParse("256Gi"),
+							gpuResourceName:       resource.MustParse("8"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasHostLabel),
+			},
+			levels: defaultOneLevel,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+				gpuResourceName:    4,
+			},
+			count:        3,
+			wantReason:   InsufficientClusterCapacity,
+			wantResource: gpuResourceName,
+		},
+		"rack required; GPU pods are spread over the hosts by their GPU capacity": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+							tasHostLabel:  "x1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("64"),
+							gpuResourceName:    resource.MustParse("8"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x2",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+							tasHostLabel:  "x2",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("64"),
+							gpuResourceName:    resource.MustParse("4"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x3",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+							tasHostLabel:  "x3",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("64"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultThreeLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+				gpuResourceName:    4,
+			},
+			count: 3,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultThreeLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 2,
+						Values: []string{
+							"b1",
+							"r1",
+							"x1",
+						},
+					},
+					{
+						Count: 1,
+						Values: []string{
+							"b1",
+							"r1",
+							"x2",
+						},
+					},
+				},
+			},
+		},
+		"rack preferred; pack strategy places the pods in a single rack": {
+			nodes: defaultNodes,
+			request: kueue.PodSetTopologyRequest{
+				Preferred: ptr.To(tasRackLabel),
+				Strategy:  ptr.To(kueue.TopologyStrategyPack),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 3,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultTwoLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 3,
+						Values: []string{
+							"b1",
+							"r2",
+						},
+					},
+				},
+			},
+		},
+		"rack preferred; spread strategy places one pod per rack": {
+			nodes: defaultNodes,
+			request: kueue.PodSetTopologyRequest{
+				Preferred: ptr.To(tasRackLabel),
+				Strategy:  ptr.To(kueue.TopologyStrategySpread),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 3,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultTwoLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 1,
+						Values: []string{
+							"b1",
+							"r1",
+						},
+					},
+					{
+						Count: 1,
+						Values: []string{
+							"b1",
+							"r2",
+						},
+					},
+					{
+						Count: 1,
+						Values: []string{
+							"b2",
+							"r2",
+						},
+					},
+				},
+			},
+		},
+		"block required; pack strategy places the pods in a single rack of the block": {
+			nodes: defaultNodes,
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasBlockLabel),
+				Strategy: ptr.To(kueue.TopologyStrategyPack),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 3,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultTwoLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 3,
+						Values: []string{
+							"b1",
+							"r2",
+						},
+					},
+				},
+			},
+		},
+		"block required; spread strategy spreads the pods over the racks of the block within their capacity": {
+			nodes: defaultNodes,
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasBlockLabel),
+				Strategy: ptr.To(kueue.TopologyStrategySpread),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 3,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultTwoLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 1,
+						Values: []string{
+							"b1",
+							"r1",
+						},
+					},
+					{
+						Count: 2,
+						Values: []string{
+							"b1",
+							"r2",
+						},
+					},
+				},
+			},
+		},
+		"block required; spread strategy doesn't exceed the capacity of the block": {
+			nodes: defaultNodes,
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasBlockLabel),
+				Strategy: ptr.To(kueue.TopologyStrategySpread),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count:      5,
+			wantReason: TopologyNotFit,
+		},
+		"rack preferred; at most 2 pods per rack spill the third pod to the sibling rack": {
+			nodes: defaultNodes,
+			request: kueue.PodSetTopologyRequest{
+				Preferred: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 3,
+			opts: []FindTopologyAssignmentOption{
+				WithMaxPodsPerDomain(tasRackLabel, 2),
+			},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultTwoLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 1,
+						Values: []string{
+							"b1",
+							"r1",
+						},
+					},
+					{
+						Count: 2,
+						Values: []string{
+							"b1",
+							"r2",
+						},
+					},
+				},
+			},
+		},
+		"block required; at most 2 pods per rack spill the third pod to the sibling rack": {
+			nodes: defaultNodes,
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasBlockLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 3,
+			opts: []FindTopologyAssignmentOption{
+				WithMaxPodsPerDomain(tasRackLabel, 2),
+			},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultTwoLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 1,
+						Values: []string{
+							"b1",
+							"r1",
+						},
+					},
+					{
+						Count: 2,
+						Values: []string{
+							"b1",
+							"r2",
+						},
+					},
+				},
+			},
+		},
+		"rack required; at most 2 pods per rack make the workload infeasible": {
+			nodes: defaultNodes,
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 3,
+			opts: []FindTopologyAssignmentOption{
+				WithMaxPodsPerDomain(tasRackLabel, 2),
+			},
+			wantReason: TopologyNotFit,
+		},
+		"block required; the limit of pods per domain at an undefined level": {
+			nodes: defaultNodes,
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasBlockLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 3,
+			opts: []FindTopologyAssignmentOption{
+				WithMaxPodsPerDomain(tasHostLabel, 2),
+			},
+			wantReason: InvalidTopologyLevel,
+		},
+		"host required; fractional CPU capacity fits the pods requesting its exact fraction": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "x1",
+						Labels: map[string]string{
+							tasHostLabel: "x1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("1.5"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasHostLabel),
+			},
+			levels: defaultOneLevel,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 500,
+			},
+			count: 3,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultOneLevel,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 3,
+						Values: []string{
+							"x1",
+						},
+					},
+				},
+			},
+		},
+		"host required; fractional CPU capacity is rounded down to whole pods": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "x1",
+						Labels: map[string]string{
+							tasHostLabel: "x1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("1500m"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasHostLabel),
+			},
+			levels: defaultOneLevel,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count:        2,
+			wantReason:   InsufficientClusterCapacity,
+			wantResource: corev1.ResourceCPU,
+		},
+		"block required, rack preferred; the pods pack into a single rack of the block": {
+			nodes: defaultNodes,
+			request: kueue.PodSetTopologyRequest{
+				Required:  ptr.To(tasBlockLabel),
+				Preferred: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 3,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultTwoLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 3,
+						Values: []string{
+							"b1",
+							"r2",
+						},
+					},
+				},
+			},
+		},
+		"block required, rack preferred; the pods spill to the second rack of the same block": {
+			nodes: defaultNodes,
+			request: kueue.PodSetTopologyRequest{
+				Required:  ptr.To(tasBlockLabel),
+				Preferred: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 4,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultTwoLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 1,
+						Values: []string{
+							"b1",
+							"r1",
+						},
+					},
+					{
+						Count: 3,
+						Values: []string{
+							"b1",
+							"r2",
+						},
+					},
+				},
+			},
+		},
+		"block required, rack preferred; the pods never cross the block": {
+			nodes: defaultNodes,
+			request: kueue.PodSetTopologyRequest{
+				Required:  ptr.To(tasBlockLabel),
+				Preferred: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count:      5,
+			wantReason: TopologyNotFit,
+		},
+		"block required, undefined level preferred": {
+			nodes: defaultNodes,
+			request: kueue.PodSetTopologyRequest{
+				Required:  ptr.To(tasBlockLabel),
+				Preferred: ptr.To(tasHostLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count:      1,
+			wantReason: InvalidTopologyLevel,
+		},
+		"rack required, block preferred; the preferred level is above the required one": {
+			nodes: defaultNodes,
+			request: kueue.PodSetTopologyRequest{
+				Required:  ptr.To(tasRackLabel),
+				Preferred: ptr.To(tasBlockLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count:      1,
+			wantReason: InvalidTopologyLevel,
+		},
+		"host required; without the reservation fraction the whole node capacity is usable": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "x1",
+						Labels: map[string]string{
+							tasHostLabel: "x1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("4"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasHostLabel),
+			},
+			levels: defaultOneLevel,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 4000,
+			},
+			count: 1,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultOneLevel,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 1,
+						Values: []string{
+							"x1",
+						},
+					},
+				},
+			},
+		},
+		"host required; the reservation fraction leaves the node capacity unassigned": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "x1",
+						Labels: map[string]string{
+							tasHostLabel: "x1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("4"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasHostLabel),
+			},
+			levels:              defaultOneLevel,
+			reservationFraction: 0.25,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 4000,
+			},
+			count:         1,
+			wantReason:    InsufficientClusterCapacity,
+			wantResource:  corev1.ResourceCPU,
+			wantShortfall: 1000,
+		},
+		"host required; the pod fits in the capacity left usable by the reservation fraction": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "x1",
+						Labels: map[string]string{
+							tasHostLabel: "x1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("4"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasHostLabel),
+			},
+			levels:              defaultOneLevel,
+			reservationFraction: 0.25,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 3000,
+			},
+			count: 1,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultOneLevel,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 1,
+						Values: []string{
+							"x1",
+						},
+					},
+				},
+			},
+		},
+		"rack required; without the resource weights the racks fitting the same number of pods are chosen by name": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("4"),
+							gpuResourceName:    resource.MustParse("4"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r2",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r2",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("4"),
+							gpuResourceName:    resource.MustParse("8"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+				gpuResourceName:    1,
+			},
+			count: 4,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultTwoLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 4,
+						Values: []string{
+							"b1",
+							"r1",
+						},
+					},
+				},
+			},
+		},
+		"rack required; the resource weights prefer the rack leaving more GPUs free among the racks fitting the same number of pods": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("4"),
+							gpuResourceName:    resource.MustParse("4"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r2",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r2",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("4"),
+							gpuResourceName:    resource.MustParse("8"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+				gpuResourceName:    1,
+			},
+			count: 4,
+			opts: []FindTopologyAssignmentOption{
+				WithResourceWeights(map[corev1.ResourceName]float64{
+					corev1.ResourceCPU: 1,
+					gpuResourceName:    10,
+				}),
+			},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultTwoLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 4,
+						Values: []string{
+							"b1",
+							"r2",
+						},
+					},
+				},
+			},
+		},
+		"rack required; the resource weights do not override the number of pods fitting in the racks": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("6"),
+							gpuResourceName:    resource.MustParse("4"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r2",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r2",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("2"),
+							gpuResourceName:    resource.MustParse("8"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+				gpuResourceName:    1,
+			},
+			count: 2,
+			opts: []FindTopologyAssignmentOption{
+				WithResourceWeights(map[corev1.ResourceName]float64{
+					corev1.ResourceCPU: 1,
+					gpuResourceName:    10,
+				}),
+			},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultTwoLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 2,
+						Values: []string{
+							"b1",
+							"r1",
+						},
+					},
+				},
+			},
+		},
+		"rack required; pods without requests fit in the first rack regardless of the capacity": {
+			nodes: defaultNodes,
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels:   defaultTwoLevels,
+			requests: resources.Requests{},
+			count:    3,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultTwoLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 3,
+						Values: []string{
+							"b1",
+							"r1",
+						},
+					},
+				},
+			},
+		},
+		"host required; pods requesting zero quantities fit on a single host": {
+			nodes: defaultNodes,
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasHostLabel),
+			},
+			levels: defaultThreeLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 0,
+			},
+			count: 3,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultThreeLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 3,
+						Values: []string{
+							"b1",
+							"r1",
+							"x1",
+						},
+					},
+				},
+			},
+		},
+		"rack required; pods without requests are limited by the max pods per host": {
+			nodes: defaultNodes,
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels:   defaultThreeLevels,
+			requests: resources.Requests{},
+			count:    3,
+			opts: []FindTopologyAssignmentOption{
+				WithMaxPodsPerDomain(tasHostLabel, 1),
+			},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultThreeLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 1,
+						Values: []string{
+							"b1",
+							"r2",
+							"x1",
+						},
+					},
+					{
+						Count: 1,
+						Values: []string{
+							"b1",
+							"r2",
+							"x3",
+						},
+					},
+					{
+						Count: 1,
+						Values: []string{
+							"b1",
+							"r2",
+							"x4",
+						},
+					},
+				},
+			},
+		},
+		"host required; pods without requests don't fit beyond the max pods per host": {
+			nodes: defaultNodes,
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasHostLabel),
+			},
+			levels:   defaultThreeLevels,
+			requests: resources.Requests{},
+			count:    3,
+			opts: []FindTopologyAssignmentOption{
+				WithMaxPodsPerDomain(tasHostLabel, 2),
+			},
+			wantReason: TopologyNotFit,
+		},
+		"rack required; the excluded rack is skipped even though it fits the pods": {
+			nodes: defaultNodes,
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 2,
+			opts: []FindTopologyAssignmentOption{
+				WithExcludedDomains(map[string][]string{
+					tasRackLabel: {"r2"},
+				}),
+			},
+			wantReason: TopologyNotFit,
+		},
+		"rack preferred; the pods are spread over the racks other than the excluded one": {
+			nodes: defaultNodes,
+			request: kueue.PodSetTopologyRequest{
+				Preferred: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 2,
+			opts: []FindTopologyAssignmentOption{
+				WithExcludedDomains(map[string][]string{
+					tasRackLabel: {"r2"},
+				}),
+			},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultTwoLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 1,
+						Values: []string{
+							"b1",
+							"r1",
+						},
+					},
+					{
+						Count: 1,
+						Values: []string{
+							"b2",
+							"r1",
+						},
+					},
+				},
+			},
+		},
+		"rack required; the pods land in the other block when a block is excluded": {
+			nodes: defaultNodes,
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 2,
+			opts: []FindTopologyAssignmentOption{
+				WithExcludedDomains(map[string][]string{
+					tasBlockLabel: {"b1"},
+				}),
+			},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultTwoLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 2,
+						Values: []string{
+							"b2",
+							"r2",
+						},
+					},
+				},
+			},
+		},
+		"rack required; excluding the domains at an undefined level": {
+			nodes: defaultNodes,
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 1,
+			opts: []FindTopologyAssignmentOption{
+				WithExcludedDomains(map[string][]string{
+					"cloud.com/topology-zone": {"z1"},
+				}),
+			},
+			wantReason: InvalidTopologyLevel,
+		},
+		"GPU clique required; a 4-GPU pod fits within a single clique of the node": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "x1",
+						Labels: map[string]string{
+							tasHostLabel: "x1",
+						},
+						Annotations: map[string]string{
+							kueuealpha.NodeNVLinkGroupsAnnotation: "4,4",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("32"),
+							gpuResourceName:    resource.MustParse("8"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(gpuCliqueLabel),
+			},
+			levels:       []string{tasHostLabel, gpuCliqueLabel},
+			subHostLevel: gpuCliqueLabel,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+				gpuResourceName:    4,
+			},
+			count: 1,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: []string{tasHostLabel, gpuCliqueLabel},
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 1,
+						Values: []string{
+							"x1",
+							"0",
+						},
+					},
+				},
+			},
+		},
+		"GPU clique required; two 4-GPU pods fit on the node but not within a single clique": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "x1",
+						Labels: map[string]string{
+							tasHostLabel: "x1",
+						},
+						Annotations: map[string]string{
+							kueuealpha.NodeNVLinkGroupsAnnotation: "4,4",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("32"),
+							gpuResourceName:    resource.MustParse("8"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(gpuCliqueLabel),
+			},
+			levels:       []string{tasHostLabel, gpuCliqueLabel},
+			subHostLevel: gpuCliqueLabel,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+				gpuResourceName:    4,
+			},
+			count:      2,
+			wantReason: TopologyNotFit,
+		},
+		"host required; two 4-GPU pods are placed in the separate cliques of the node": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "x1",
+						Labels: map[string]string{
+							tasHostLabel: "x1",
+						},
+						Annotations: map[string]string{
+							kueuealpha.NodeNVLinkGroupsAnnotation: "4,4",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("32"),
+							gpuResourceName:    resource.MustParse("8"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasHostLabel),
+			},
+			levels:       []string{tasHostLabel, gpuCliqueLabel},
+			subHostLevel: gpuCliqueLabel,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+				gpuResourceName:    4,
+			},
+			count: 2,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: []string{tasHostLabel, gpuCliqueLabel},
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 1,
+						Values: []string{
+							"x1",
+							"0",
+						},
+					},
+					{
+						Count: 1,
+						Values: []string{
+							"x1",
+							"1",
+						},
+					},
+				},
+			},
+		},
+		"GPU clique required; two 2-GPU pods share a clique": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "x1",
+						Labels: map[string]string{
+							tasHostLabel: "x1",
+						},
+						Annotations: map[string]string{
+							kueuealpha.NodeNVLinkGroupsAnnotation: "4,4",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("32"),
+							gpuResourceName:    resource.MustParse("8"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(gpuCliqueLabel),
+			},
+			levels:       []string{tasHostLabel, gpuCliqueLabel},
+			subHostLevel: gpuCliqueLabel,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+				gpuResourceName:    2,
+			},
+			count: 2,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: []string{tasHostLabel, gpuCliqueLabel},
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 2,
+						Values: []string{
+							"x1",
+							"0",
+						},
+					},
+				},
+			},
+		},
+		"GPU clique required; a 5-GPU pod exceeds the capacity of any clique": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "x1",
+						Labels: map[string]string{
+							tasHostLabel: "x1",
+						},
+						Annotations: map[string]string{
+							kueuealpha.NodeNVLinkGroupsAnnotation: "4,4",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("32"),
+							gpuResourceName:    resource.MustParse("8"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(gpuCliqueLabel),
+			},
+			levels:       []string{tasHostLabel, gpuCliqueLabel},
+			subHostLevel: gpuCliqueLabel,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+				gpuResourceName:    5,
+			},
+			count:         1,
+			wantReason:    InsufficientClusterCapacity,
+			wantResource:  gpuResourceName,
+			wantShortfall: 1,
+		},
+		"host required; the pods allocatable of the node limits the number of pods": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "x1",
+						Labels: map[string]string{
+							tasHostLabel: "x1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU:  resource.MustParse("4"),
+							corev1.ResourcePods: resource.MustParse("2"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasHostLabel),
+			},
+			levels: defaultOneLevel,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 100,
+			},
+			count:        3,
+			wantReason:   InsufficientClusterCapacity,
+			wantResource: corev1.ResourcePods,
+		},
+		"host required; the pods fit within the pods allocatable of the node": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "x1",
+						Labels: map[string]string{
+							tasHostLabel: "x1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU:  resource.MustParse("4"),
+							corev1.ResourcePods: resource.MustParse("2"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasHostLabel),
+			},
+			levels: defaultOneLevel,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 100,
+			},
+			count: 2,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultOneLevel,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 2,
+						Values: []string{
+							"x1",
+						},
+					},
+				},
+			},
+		},
+		"rack required; the pods exceeding the pods allocatable of a node spill over to another node": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+							tasHostLabel:  "x1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU:  resource.MustParse("4"),
+							corev1.ResourcePods: resource.MustParse("2"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "x2",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+							tasHostLabel:  "x2",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU:  resource.MustParse("1"),
+							corev1.ResourcePods: resource.MustParse("110"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultThreeLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 500,
+			},
+			count: 3,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultThreeLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 2,
+						Values: []string{
+							"b1",
+							"r1",
+							"x1",
+						},
+					},
+					{
+						Count: 1,
+						Values: []string{
+							"b1",
+							"r1",
+							"x2",
+						},
+					},
+				},
+			},
+		},
+		"rack required; the warm nodes break the tie between the racks fitting the same number of pods": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("1"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r2",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r2",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("1"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 1,
+			opts: []FindTopologyAssignmentOption{
+				WithWarmNodes("b1-r2"),
+			},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultTwoLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 1,
+						Values: []string{
+							"b1",
+							"r2",
+						},
+					},
+				},
+			},
+		},
+		"rack required; the warm nodes do not override the number of pods fitting in the racks": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("2"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r2",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r2",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("1"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 2,
+			opts: []FindTopologyAssignmentOption{
+				WithWarmNodes("b1-r2"),
+			},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultTwoLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 2,
+						Values: []string{
+							"b1",
+							"r1",
+						},
+					},
+				},
+			},
+		},
+		"block preferred; the warm nodes break the tie between the hosts": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+							tasHostLabel:  "x1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("1"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "x2",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+							tasHostLabel:  "x2",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("1"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "x3",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+							tasHostLabel:  "x3",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("1"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Preferred: ptr.To(tasBlockLabel),
+			},
+			levels: defaultThreeLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 2,
+			opts: []FindTopologyAssignmentOption{
+				WithWarmNodes("x3", "missing"),
+			},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultThreeLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 1,
+						Values: []string{
+							"b1",
+							"r1",
+							"x1",
+						},
+					},
+					{
+						Count: 1,
+						Values: []string{
+							"b1",
+							"r1",
+							"x3",
+						},
+					},
+				},
+			},
+		},
+		"rack required; the lexicographically first hosts are chosen among the equal hosts": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x3",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+							tasHostLabel:  "x3",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("1"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+							tasHostLabel:  "x1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("1"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x4",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+							tasHostLabel:  "x4",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("1"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x2",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+							tasHostLabel:  "x2",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("1"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			levels: defaultThreeLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 2,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultThreeLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 1,
+						Values: []string{
+							"b1",
+							"r1",
+							"x1",
+						},
+					},
+					{
+						Count: 1,
+						Values: []string{
+							"b1",
+							"r1",
+							"x2",
+						},
+					},
+				},
+			},
+		},
+		"block required; equal per domain splits the pods 3+3 across the racks rather than 4+2": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("4"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r2",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r2",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("3"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasBlockLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 6,
+			opts:  []FindTopologyAssignmentOption{WithEqualPerDomain()},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultTwoLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 3,
+						Values: []string{
+							"b1",
+							"r1",
+						},
+					},
+					{
+						Count: 3,
+						Values: []string{
+							"b1",
+							"r2",
+						},
+					},
+				},
+			},
+		},
+		"block required; equal per domain fails when the pods can only be split 4+2": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("4"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r2",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r2",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("2"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasBlockLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count:      6,
+			opts:       []FindTopologyAssignmentOption{WithEqualPerDomain()},
+			wantReason: TopologyNotFit,
+		},
+		"block required; single Pod which cannot be split fits on the only big node of the block": {
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x1",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+							tasHostLabel:  "x1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("1"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r1-x2",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r1",
+							tasHostLabel:  "x2",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("1"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r2-x3",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r2",
+							tasHostLabel:  "x3",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("4"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "b1-r2-x4",
+						Labels: map[string]string{
+							tasBlockLabel: "b1",
+							tasRackLabel:  "r2",
+							tasHostLabel:  "x4",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("1"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasBlockLabel),
+			},
+			levels: defaultThreeLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 4000,
+			},
+			count: 1,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultThreeLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 1,
+						Values: []string{
+							"b1",
+							"r2",
+							"x3",
+						},
+					},
+				},
+			},
+		},
+		"host required; the two best distinct host placements": {
+			nodes: defaultNodes,
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasHostLabel),
+			},
+			levels: defaultThreeLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 1,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultThreeLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 1,
+						Values: []string{
+							"b2",
+							"r2",
+							"x6",
+						},
+					},
+				},
+			},
+			alternatives: 2,
+			wantAlternatives: []*kueue.TopologyAssignment{
+				{
+					Levels: defaultThreeLevels,
+					Domains: []kueue.TopologyDomainAssignment{
+						{
+							Count: 1,
+							Values: []string{
+								"b2",
+								"r2",
+								"x6",
+							},
+						},
+					},
+				},
+				{
+					Levels: defaultThreeLevels,
+					Domains: []kueue.TopologyDomainAssignment{
+						{
+							Count: 1,
+							Values: []string{
+								"b1",
+								"r1",
+								"x1",
+							},
+						},
+					},
+				},
+			},
+		},
+		"block required; the alternatives are limited by the number of fitting blocks": {
+			nodes: defaultNodes,
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasBlockLabel),
+			},
+			levels: defaultTwoLevels,
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 3,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: defaultTwoLevels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 3,
+						Values: []string{
+							"b1",
+							"r2",
+						},
+					},
+				},
+			},
+			alternatives: 3,
+			wantAlternatives: []*kueue.TopologyAssignment{
+				{
+					Levels: defaultTwoLevels,
+					Domains: []kueue.TopologyDomainAssignment{
+						{
+							Count: 3,
+							Values: []string{
+								"b1",
+								"r2",
+							},
+						},
+					},
+				},
+				{
+					Levels: defaultTwoLevels,
+					Domains: []kueue.TopologyDomainAssignment{
+						{
+							Count: 1,
+							Values: []string{
+								"b2",
+								"r1",
+							},
+						},
+						{
+							Count: 2,
+							Values: []string{
+								"b2",
+								"r2",
+							},
+						},
+					},
+				},
+			},
+		},
+		"block required; the power zone axis requires a single power zone within the block": {
+			//      b1          b2
+			//    /    \      /    \
+			//  x1:p1 x2:p2 x3:p2 x4:p2
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "x1",
+						Labels: map[string]string{
+							tasBlockLabel:     "b1",
+							tasHostLabel:      "x1",
+							"cloud.com/power": "p1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("1"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "x2",
+						Labels: map[string]string{
+							tasBlockLabel:     "b1",
+							tasHostLabel:      "x2",
+							"cloud.com/power": "p2",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("1"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "x3",
+						Labels: map[string]string{
+							tasBlockLabel:     "b2",
+							tasHostLabel:      "x3",
+							"cloud.com/power": "p2",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("1"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "x4",
+						Labels: map[string]string{
+							tasBlockLabel:     "b2",
+							tasHostLabel:      "x4",
+							"cloud.com/power": "p2",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("1"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasBlockLabel),
+			},
+			levels: []string{tasBlockLabel, tasHostLabel},
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 2,
+			opts:  []FindTopologyAssignmentOption{WithRequiredAxes("cloud.com/power")},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: []string{tasBlockLabel, tasHostLabel},
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 1,
+						Values: []string{
+							"b2",
+							"x3",
+						},
+					},
+					{
+						Count: 1,
+						Values: []string{
+							"b2",
+							"x4",
+						},
+					},
+				},
+			},
+		},
+		"block required; no block fits within a single power zone": {
+			//      b1          b2
+			//    /    \      /    \
+			//  x1:p1 x2:p2 x3:p2 x4:p1
+			nodes: []corev1.Node{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "x1",
+						Labels: map[string]string{
+							tasBlockLabel:     "b1",
+							tasHostLabel:      "x1",
+							"cloud.com/power": "p1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("1"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "x2",
+						Labels: map[string]string{
+							tasBlockLabel:     "b1",
+							tasHostLabel:      "x2",
+							"cloud.com/power": "p2",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("1"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "x3",
+						Labels: map[string]string{
+							tasBlockLabel:     "b2",
+							tasHostLabel:      "x3",
+							"cloud.com/power": "p2",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("1"),
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "x4",
+						Labels: map[string]string{
+							tasBlockLabel:     "b2",
+							tasHostLabel:      "x4",
+							"cloud.com/power": "p1",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Allocatable: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("1"),
+						},
+					},
+				},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasBlockLabel),
+			},
+			levels: []string{tasBlockLabel, tasHostLabel},
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count:      2,
+			opts:       []FindTopologyAssignmentOption{WithRequiredAxes("cloud.com/power")},
+			wantReason: TopologyNotFit,
+		},
+		"host required; the nodes with the same host label in different racks are excluded": {
+			nodes: defaultNodes,
+			nodeSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{tasBlockLabel: "b1"},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasHostLabel),
+			},
+			levels: []string{tasHostLabel},
+			requests: resources.Requests{
+				corev1.ResourceCPU: 2000,
+			},
+			count:         1,
+			wantReason:    InsufficientClusterCapacity,
+			wantResource:  corev1.ResourceCPU,
+			wantShortfall: 1000,
+		},
+		"host preferred; only the nodes with a unique host label are assigned": {
+			nodes: defaultNodes,
+			nodeSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{tasBlockLabel: "b1"},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Preferred: ptr.To(tasHostLabel),
+			},
+			levels: []string{tasHostLabel},
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count: 2,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: []string{tasHostLabel},
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Count: 1,
+						Values: []string{
+							"x3",
+						},
+					},
+					{
+						Count: 1,
+						Values: []string{
+							"x4",
+						},
+					},
+				},
+			},
+		},
+		"host preferred; the excluded nodes with the same host label offer no capacity": {
+			nodes: defaultNodes,
+			nodeSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{tasBlockLabel: "b1"},
+			},
+			request: kueue.PodSetTopologyRequest{
+				Preferred: ptr.To(tasHostLabel),
+			},
+			levels: []string{tasHostLabel},
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			count:        3,
+			wantReason:   InsufficientClusterCapacity,
+			wantResource: corev1.ResourceCPU,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			initialObjects := make([]client.Object, 0)
+			for i := range tc.nodes {
+				initialObjects = append(initialObjects, &tc.nodes[i])
+			}
+			client := utiltesting.NewFakeClient(initialObjects...)
+			tasCache := NewTASCache(client)
+			if tc.capacitySource != nil {
+				tasCache.capacitySource = tc.capacitySource
+			}
+			tasFlavorCache := tasCache.NewTASFlavorCache(tc.levels, tc.nodeLabels,
+				WithIncludeUnschedulable(tc.includeUnschedulable),
+				WithReservationFraction(tc.reservationFraction),
+				WithSubHostLevel(tc.subHostLevel),
+				WithNodeSelector(tc.nodeSelector),
+				WithNodeAnnotations(tc.nodeAnnotations))
+			tasFlavorCache.SetAnnotationLevels(tc.annotationLevels...)
+			tasFlavorCache.SetPendingNodes(tc.pendingNodes)
+			snapshot := tasFlavorCache.snapshot(ctx)
+			failures := metrics.TopologyAssignmentsTotal.WithLabelValues(requestedLevelKey(&tc.request), metrics.TopologyAssignmentFailure)
+			failuresBefore, err := testutil.GetCounterMetricValue(failures)
+			if err != nil {
+				t.Fatalf("failed to read the failure counter: %v", err)
+			}
+			gotAssignment, gotErr := snapshot.FindTopologyAssignment(&tc.request, tc.requests, tc.count, tc.opts...)
+			if diff := cmp.Diff(tc.wantAssignment, gotAssignment); diff != "" {
+				t.Errorf("unexpected topology assignment (-want,+got): %s", diff)
+			}
+			failuresAfter, err := testutil.GetCounterMetricValue(failures)
+			if err != nil {
+				t.Fatalf("failed to read the failure counter: %v", err)
+			}
+			var wantFailures float64
+			if tc.wantAssignment == nil {
+				wantFailures = 1
+			}
+			if gotFailures := failuresAfter - failuresBefore; gotFailures != wantFailures {
+				t.Errorf("unexpected number of reported failures, want=%v, got=%v", wantFailures, gotFailures)
+			}
+			var gotReason TopologyAssignmentErrorReason
+			var gotResource corev1.ResourceName
+			var gotShortfall int64
+			var assignmentErr *TopologyAssignmentError
+			if errors.As(gotErr, &assignmentErr) {
+				gotReason = assignmentErr.Reason
+				gotResource = assignmentErr.Resource
+				gotShortfall = assignmentErr.Shortfall()
+			}
+			if gotReason != tc.wantReason {
+				t.Errorf("unexpected error reason, want=%q, got=%q (error: %v)", tc.wantReason, gotReason, gotErr)
+			}
+			if gotResource != tc.wantResource {
+				t.Errorf("unexpected error resource, want=%q, got=%q", tc.wantResource, gotResource)
+			}
+			if gotShortfall != tc.wantShortfall {
+				t.Errorf("unexpected error shortfall, want=%d, got=%d", tc.wantShortfall, gotShortfall)
+			}
+			if diff := cmp.Diff(tc.wantProvisional, snapshot.ProvisionalDomains(gotAssignment)); diff != "" {
+				t.Errorf("unexpected provisional domains (-want,+got): %s", diff)
+			}
+			if tc.alternatives > 0 {
+				gotAlternatives, _ := snapshot.FindTopologyAssignmentAlternatives(&tc.request, tc.requests, tc.count, tc.alternatives, tc.opts...)
+				if diff := cmp.Diff(tc.wantAlternatives, gotAlternatives); diff != "" {
+					t.Errorf("unexpected alternative assignments (-want,+got): %s", diff)
+				}
+			}
+
+			var gotDomains []kueue.TopologyDomainAssignment
+			gotFuncErr := snapshot.FindTopologyAssignmentFunc(&tc.request, tc.requests, tc.count, func(domain kueue.TopologyDomainAssignment) bool {
+				gotDomains = append(gotDomains, domain)
+				return true
+			}, tc.opts...)
+			if diff := cmp.Diff(gotErr, gotFuncErr); diff != "" {
+				t.Errorf("unexpected error of the streamed assignment (-want,+got): %s", diff)
+			}
+			var wantDomains []kueue.TopologyDomainAssignment
+			if gotAssignment != nil {
+				wantDomains = gotAssignment.Domains
+			}
+			if diff := cmp.Diff(wantDomains, gotDomains, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("unexpected streamed domains (-want,+got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestBestTopologyFit(t *testing.T) {
+	levels := []string{
+		tasBlockLabel,
+		tasRackLabel,
+	}
+
+	cases := map[string]struct {
+		nodesPerCluster map[string][]corev1.Node
+		levelKey        string
+		count           int32
+		wantCluster     string
+		wantFound       bool
+	}{
+		"cluster with the tightest fitting rack is chosen": {
+			nodesPerCluster: map[string][]corev1.Node{
+				"worker1": {
+					*utiltesting.MakeNode("b1-r1").
+						Label(tasBlockLabel, "b1").
+						Label(tasRackLabel, "r1").
+						StatusAllocatable(corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("8"),
+						}).
+						Obj(),
+				},
+				"worker2": {
+					*utiltesting.MakeNode("b1-r1").
+						Label(tasBlockLabel, "b1").
+						Label(tasRackLabel, "r1").
+						StatusAllocatable(corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("4"),
+						}).
+						Obj(),
+					*utiltesting.MakeNode("b1-r2").
+						Label(tasBlockLabel, "b1").
+						Label(tasRackLabel, "r2").
+						StatusAllocatable(corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("6"),
+						}).
+						Obj(),
+				},
+			},
+			levelKey:    tasRackLabel,
+			count:       5,
+			wantCluster: "worker2",
+			wantFound:   true,
+		},
+		"cluster which can only fit the pods at a higher level is skipped": {
+			nodesPerCluster: map[string][]corev1.Node{
+				"worker1": {
+					*utiltesting.MakeNode("b1-r1").
+						Label(tasBlockLabel, "b1").
+						Label(tasRackLabel, "r1").
+						StatusAllocatable(corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("8"),
+						}).
+						Obj(),
+				},
+				"worker2": {
+					*utiltesting.MakeNode("b1-r1").
+						Label(tasBlockLabel, "b1").
+						Label(tasRackLabel, "r1").
+						StatusAllocatable(corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("3"),
+						}).
+						Obj(),
+					*utiltesting.MakeNode("b1-r2").
+						Label(tasBlockLabel, "b1").
+						Label(tasRackLabel, "r2").
+						StatusAllocatable(corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("3"),
+						}).
+						Obj(),
+				},
+			},
+			levelKey:    tasRackLabel,
+			count:       5,
+			wantCluster: "worker1",
+			wantFound:   true,
+		},
+		"no cluster fits the pods": {
+			nodesPerCluster: map[string][]corev1.Node{
+				"worker1": {
+					*utiltesting.MakeNode("b1-r1").
+						Label(tasBlockLabel, "b1").
+						Label(tasRackLabel, "r1").
+						StatusAllocatable(corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("4"),
+						}).
+						Obj(),
+				},
+				"worker2": {
+					*utiltesting.MakeNode("b1-r1").
+						Label(tasBlockLabel, "b1").
+						Label(tasRackLabel, "r1").
+						StatusAllocatable(corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("3"),
+						}).
+						Obj(),
+					*utiltesting.MakeNode("b1-r2").
+						Label(tasBlockLabel, "b1").
+						Label(tasRackLabel, "r2").
+						StatusAllocatable(corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("3"),
+						}).
+						Obj(),
+				},
+			},
+			levelKey:  tasRackLabel,
+			count:     5,
+			wantFound: false,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			summaries := make(map[string]*TopologySummary, len(tc.nodesPerCluster))
+			for cluster, nodes := range tc.nodesPerCluster {
+				initialObjects := make([]client.Object, 0, len(nodes))
+				for i := range nodes {
+					initialObjects = append(initialObjects, &nodes[i])
+				}
+				tasCache := NewTASCache(utiltesting.NewFakeClient(initialObjects...))
+				summaries[cluster] = tasCache.NewTASFlavorCache(levels, nil).Summary(ctx)
+			}
+			requests := resources.Requests{
+				corev1.ResourceCPU: 1000,
+			}
+			gotCluster, gotFound := BestTopologyFit(summaries, tc.levelKey, requests, tc.count)
+			if gotFound != tc.wantFound {
+				t.Errorf("unexpected found, want=%v, got=%v", tc.wantFound, gotFound)
+			}
+			if gotCluster != tc.wantCluster {
+				t.Errorf("unexpected cluster, want=%q, got=%q", tc.wantCluster, gotCluster)
+			}
+		})
+	}
+}
+
+func TestShortfallFor(t *testing.T) {
+	levels := []string{
+		tasBlockLabel,
+		tasRackLabel,
+	}
+
+	cases := map[string]struct {
+		nodes         []corev1.Node
+		request       kueue.PodSetTopologyRequest
+		count         int32
+		wantShortfall []DomainShortfall
+		wantReason    TopologyAssignmentErrorReason
+	}{
+		"over-large job reports the deficit per rack": {
+			nodes: []corev1.Node{
+				*utiltesting.MakeNode("b1-r1-x1").
+					Label(tasBlockLabel, "b1").
+					Label(tasRackLabel, "r1").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("3"),
+					}).
+					Obj(),
+				*utiltesting.MakeNode("b1-r2-x1").
+					Label(tasBlockLabel, "b1").
+					Label(tasRackLabel, "r2").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("1"),
+					}).
+					Obj(),
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			count: 4,
+			wantShortfall: []DomainShortfall{
+				{
+					Level:       tasRackLabel,
+					Values:      []string{"b1", "r1"},
+					MissingPods: 1,
+					Deficit: resources.Requests{
+						corev1.ResourceCPU: 1000,
+					},
+				},
+				{
+					Level:       tasRackLabel,
+					Values:      []string{"b1", "r2"},
+					MissingPods: 3,
+					Deficit: resources.Requests{
+						corev1.ResourceCPU: 3000,
+					},
+				},
+			},
+		},
+		"domain in which the job fits has no deficit": {
+			nodes: []corev1.Node{
+				*utiltesting.MakeNode("b1-r1-x1").
+					Label(tasBlockLabel, "b1").
+					Label(tasRackLabel, "r1").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("2"),
+					}).
+					Obj(),
+				*utiltesting.MakeNode("b2-r1-x1").
+					Label(tasBlockLabel, "b2").
+					Label(tasRackLabel, "r1").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("1"),
+					}).
+					Obj(),
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasBlockLabel),
+			},
+			count: 2,
+			wantShortfall: []DomainShortfall{
+				{
+					Level:   tasBlockLabel,
+					Values:  []string{"b1"},
+					Deficit: resources.Requests{},
+				},
+				{
+					Level:       tasBlockLabel,
+					Values:      []string{"b2"},
+					MissingPods: 1,
+					Deficit: resources.Requests{
+						corev1.ResourceCPU: 1000,
+					},
+				},
+			},
+		},
+		"invalid topology level": {
+			nodes: []corev1.Node{
+				*utiltesting.MakeNode("b1-r1-x1").
+					Label(tasBlockLabel, "b1").
+					Label(tasRackLabel, "r1").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("1"),
+					}).
+					Obj(),
+			},
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To("cloud.com/topology-zone"),
+			},
+			count:      1,
+			wantReason: InvalidTopologyLevel,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			initialObjects := make([]client.Object, 0, len(tc.nodes))
+			for i := range tc.nodes {
+				initialObjects = append(initialObjects, &tc.nodes[i])
+			}
+			tasCache := NewTASCache(utiltesting.NewFakeClient(initialObjects...))
+			snapshot := tasCache.NewTASFlavorCache(levels, nil).snapshot(ctx)
+			requests := resources.Requests{
+				corev1.ResourceCPU: 1000,
+			}
+			gotShortfall, gotErr := snapshot.ShortfallFor(&tc.request, requests, tc.count)
+			if diff := cmp.Diff(tc.wantShortfall, gotShortfall); diff != "" {
+				t.Errorf("unexpected shortfall (-want,+got): %s", diff)
+			}
+			var gotReason TopologyAssignmentErrorReason
+			var assignmentErr *TopologyAssignmentError
+			if errors.As(gotErr, &assignmentErr) {
+				gotReason = assignmentErr.Reason
+			}
+			if gotReason != tc.wantReason {
+				t.Errorf("unexpected error reason, want=%q, got=%q (error: %v)", tc.wantReason, gotReason, gotErr)
+			}
+		})
+	}
+}
+
+func TestFeasibleLevels(t *testing.T) {
+	levels := []string{tasBlockLabel, tasRackLabel, tasHostLabel}
+	// The same nodes as defaultNodes in TestFindTopologyAssignment.
+	nodes := []client.Object{
+		utiltesting.MakeNode("b1-r1-x1").
+			Label(tasBlockLabel, "b1").
+			Label(tasRackLabel, "r1").
+			Label(tasHostLabel, "x1").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("1"),
+				corev1.ResourceMemory: resource.MustParse("1Gi"),
+			}).
+			Obj(),
+		utiltesting.MakeNode("b1-r2-x2").
+			Label(tasBlockLabel, "b1").
+			Label(tasRackLabel, "r2").
+			Label(tasHostLabel, "x2").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("1"),
+				corev1.ResourceMemory: resource.MustParse("1Gi"),
+			}).
+			Obj(),
+		utiltesting.MakeNode("b1-r2-x3").
+			Label(tasBlockLabel, "b1").
+			Label(tasRackLabel, "r2").
+			Label(tasHostLabel, "x3").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("1"),
+				corev1.ResourceMemory: resource.MustParse("1Gi"),
+			}).
+			Obj(),
+		utiltesting.MakeNode("b1-r2-x4").
+			Label(tasBlockLabel, "b1").
+			Label(tasRackLabel, "r2").
+			Label(tasHostLabel, "x4").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("1"),
+				corev1.ResourceMemory: resource.MustParse("1Gi"),
+			}).
+			Obj(),
+		utiltesting.MakeNode("b2-r1-x5").
+			Label(tasBlockLabel, "b2").
+			Label(tasRackLabel, "r1").
+			Label(tasHostLabel, "x5").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("1"),
+				corev1.ResourceMemory: resource.MustParse("1Gi"),
+			}).
+			Obj(),
+		utiltesting.MakeNode("b2-r2-x6").
+			Label(tasBlockLabel, "b2").
+			Label(tasRackLabel, "r2").
+			Label(tasHostLabel, "x6").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("2"),
+				corev1.ResourceMemory: resource.MustParse("4Gi"),
+			}).
+			Obj(),
+	}
+	requests := resources.Requests{
+		corev1.ResourceCPU:    100,
+		corev1.ResourceMemory: 1024 * 1024 * 1024,
+	}
+
+	cases := map[string]struct {
+		count int32
+		want  []string
+	}{
+		"single pod fits at every level": {
+			count: 1,
+			want:  []string{tasHostLabel, tasRackLabel, tasBlockLabel},
+		},
+		"four pods fit on the largest host": {
+			count: 4,
+			want:  []string{tasHostLabel, tasRackLabel, tasBlockLabel},
+		},
+		"five pods fit only in a block": {
+			count: 5,
+			want:  []string{tasBlockLabel},
+		},
+		"too many pods to fit in any domain": {
+			count: 6,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ctx, _ := utiltesting.ContextWithLog(t)
+			tasCache := NewTASCache(utiltesting.NewFakeClient(nodes...))
+			snapshot := tasCache.NewTASFlavorCache(levels, nil).snapshot(ctx)
+			got := snapshot.FeasibleLevels(requests, tc.count)
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("unexpected feasible levels (-want,+got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestFindTopologyAssignmentForPodSets(t *testing.T) {
+	levels := []string{
+		tasBlockLabel,
+		tasRackLabel,
+	}
+
+	makePodSet := func(count int32, group string) PodSetTopologyRequests {
+		return PodSetTopologyRequests{
+			TopologyRequest: &kueue.PodSetTopologyRequest{
+				Preferred:       ptr.To(tasRackLabel),
+				ColocationGroup: ptr.To(group),
+			},
+			Requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			Count: count,
+		}
+	}
+	makeAssignment := func(count int32, values ...string) *kueue.TopologyAssignment {
+		return &kueue.TopologyAssignment{
+			Levels: levels,
+			Domains: []kueue.TopologyDomainAssignment{
+				{
+					Count:  count,
+					Values: values,
+				},
+			},
+		}
+	}
+
+	cases := map[string]struct {
+		nodes           []corev1.Node
+		podSets         []PodSetTopologyRequests
+		wantAssignments []*kueue.TopologyAssignment
+		wantReason      TopologyAssignmentErrorReason
+	}{
+		"two colocation groups each stay within a rack": {
+			nodes: []corev1.Node{
+				*utiltesting.MakeNode("b1-r1").
+					Label(tasBlockLabel, "b1").
+					Label(tasRackLabel, "r1").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("4"),
+					}).
+					Obj(),
+				*utiltesting.MakeNode("b1-r2").
+					Label(tasBlockLabel, "b1").
+					Label(tasRackLabel, "r2").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("4"),
+					}).
+					Obj(),
+			},
+			podSets: []PodSetTopologyRequests{
+				makePodSet(2, "a"),
+				makePodSet(2, "b"),
+				makePodSet(2, "a"),
+				makePodSet(1, "b"),
+			},
+			wantAssignments: []*kueue.TopologyAssignment{
+				makeAssignment(2, "b1", "r1"),
+				makeAssignment(2, "b1", "r2"),
+				makeAssignment(2, "b1", "r1"),
+				makeAssignment(1, "b1", "r2"),
+			},
+		},
+		"colocation group is moved to the rack which accommodates all PodSets": {
+			nodes: []corev1.Node{
+				*utiltesting.MakeNode("b1-r1").
+					Label(tasBlockLabel, "b1").
+					Label(tasRackLabel, "r1").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("3"),
+					}).
+					Obj(),
+				*utiltesting.MakeNode("b1-r2").
+					Label(tasBlockLabel, "b1").
+					Label(tasRackLabel, "r2").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("4"),
+					}).
+					Obj(),
+			},
+			podSets: []PodSetTopologyRequests{
+				makePodSet(2, "a"),
+				makePodSet(2, "a"),
+			},
+			wantAssignments: []*kueue.TopologyAssignment{
+				makeAssignment(2, "b1", "r2"),
+				makeAssignment(2, "b1", "r2"),
+			},
+		},
+		"colocation group doesn't fit within a single rack": {
+			nodes: []corev1.Node{
+				*utiltesting.MakeNode("b1-r1").
+					Label(tasBlockLabel, "b1").
+					Label(tasRackLabel, "r1").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("4"),
+					}).
+					Obj(),
+				*utiltesting.MakeNode("b1-r2").
+					Label(tasBlockLabel, "b1").
+					Label(tasRackLabel, "r2").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("4"),
+					}).
+					Obj(),
+			},
+			podSets: []PodSetTopologyRequests{
+				makePodSet(3, "a"),
+				makePodSet(3, "a"),
+			},
+			wantReason: TopologyNotFit,
+		},
+		"invalid colocation level": {
+			nodes: []corev1.Node{
+				*utiltesting.MakeNode("b1-r1").
+					Label(tasBlockLabel, "b1").
+					Label(tasRackLabel, "r1").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("4"),
+					}).
+					Obj(),
+			},
+			podSets: []PodSetTopologyRequests{
+				{
+					TopologyRequest: &kueue.PodSetTopologyRequest{
+						Preferred:       ptr.To(tasRackLabel),
+						ColocationGroup: ptr.To("a"),
+						ColocationLevel: ptr.To("cloud.com/topology-zone"),
+					},
+					Requests: resources.Requests{
+						corev1.ResourceCPU: 1000,
+					},
+					Count: 1,
+				},
+			},
+			wantReason: InvalidTopologyLevel,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			initialObjects := make([]client.Object, 0, len(tc.nodes))
+			for i := range tc.nodes {
+				initialObjects = append(initialObjects, &tc.nodes[i])
+			}
+			tasCache := NewTASCache(utiltesting.NewFakeClient(initialObjects...))
+			snapshot := tasCache.NewTASFlavorCache(levels, nil).snapshot(ctx)
+			wantFreeCapacity := snapshot.capacityPerLevel()
+			gotAssignments, gotErr := snapshot.FindTopologyAssignmentForPodSets(tc.podSets)
+			if diff := cmp.Diff(tc.wantAssignments, gotAssignments); diff != "" {
+				t.Errorf("unexpected topology assignments (-want,+got): %s", diff)
+			}
+			var gotReason TopologyAssignmentErrorReason
+			var assignmentErr *TopologyAssignmentError
+			if errors.As(gotErr, &assignmentErr) {
+				gotReason = assignmentErr.Reason
+			}
+			if gotReason != tc.wantReason {
+				t.Errorf("unexpected error reason, want=%q, got=%q (error: %v)", tc.wantReason, gotReason, gotErr)
+			}
+			if diff := cmp.Diff(wantFreeCapacity, snapshot.capacityPerLevel()); diff != "" {
+				t.Errorf("unexpected free capacity after the assignment (-want,+got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestGPUHourLedger(t *testing.T) {
+	now := time.Now()
+	requests := resources.Requests{
+		gpuResourceName: 4,
+	}
+	ledger := NewGPUHourLedger(time.Hour)
+	ledger.CommitAssignment(&kueue.TopologyAssignment{
+		Domains: []kueue.TopologyDomainAssignment{
+			{Values: []string{"b1", "r1"}, Count: 1},
+		},
+	}, requests, 10*time.Hour, now.Add(-2*time.Hour))
+	ledger.CommitAssignment(&kueue.TopologyAssignment{
+		Domains: []kueue.TopologyDomainAssignment{
+			{Values: []string{"b1", "r1"}, Count: 2},
+			{Values: []string{"b1", "r2"}, Count: 1},
+		},
+	}, requests, 10*time.Hour, now.Add(-time.Minute))
+	ledger.CommitAssignment(&kueue.TopologyAssignment{
+		Domains: []kueue.TopologyDomainAssignment{
+			{Values: []string{"b1", "r2"}, Count: 1},
+		},
+	}, resources.Requests{corev1.ResourceCPU: 1000}, 10*time.Hour, now)
+
+	wantCommitted := map[utiltas.TopologyDomainID]float64{
+		"b1":    120,
+		"b1,r1": 80,
+		"b1,r2": 40,
+	}
+	if diff := cmp.Diff(wantCommitted, ledger.Committed(now)); diff != "" {
+		t.Errorf("unexpected committed GPU-hours (-want,+got): %s", diff)
+	}
+}
+
+func TestAddTASFlavorCacheRestoresReservations(t *testing.T) {
+	const flavorName = "tas-flavor"
+	features.SetFeatureGateDuringTest(t, features.TopologyAwareScheduling, true)
+	ctx := context.Background()
+
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "r1-x1",
+			Labels: map[string]string{
+				tasRackLabel: "r1",
+			},
+		},
+		Status: corev1.NodeStatus{
+			Allocatable: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("4"),
+				corev1.ResourceMemory: resource.MustParse("4Gi"),
+			},
+		},
+	}
+	wl := utiltesting.MakeWorkload("wl", "default").
+		PodSets(*utiltesting.MakePodSet("main", 3).
+			Request(corev1.ResourceCPU, "1").
+			Request(corev1.ResourceMemory, "1Gi").
+			Obj()).
+		ReserveQuota(utiltesting.MakeAdmission("cq", "main").
+			Assignment(corev1.ResourceCPU, flavorName, "3").
+			Assignment(corev1.ResourceMemory, flavorName, "3Gi").
+			AssignmentPodCount(3).
+			TopologyAssignment(&kueue.TopologyAssignment{
+				Levels: []string{tasRackLabel},
+				Domains: []kueue.TopologyDomainAssignment{
+					{Values: []string{"r1"}, Count: 3},
+				},
+			}).
+			Obj()).
+		Obj()
+	cq := utiltesting.MakeClusterQueue("cq").
+		ResourceGroup(*utiltesting.MakeFlavorQuotas(flavorName).
+			Resource(corev1.ResourceCPU, "10").
+			Resource(corev1.ResourceMemory, "10Gi").
+			Obj()).
+		Obj()
+
+	// Simulate the manager restart, in which the workload is added to the
+	// cache before the TAS cache of the flavor is created.
+	cache := New(utiltesting.NewFakeClient(node))
+	cache.AddOrUpdateResourceFlavor(utiltesting.MakeResourceFlavor(flavorName).TopologyName("default").Obj())
+	if err := cache.AddClusterQueue(ctx, cq); err != nil {
+		t.Fatalf("Failed adding the ClusterQueue: %v", err)
+	}
+	if !cache.AddOrUpdateWorkload(wl) {
+		t.Fatalf("Failed adding the workload")
+	}
+	tasCache := cache.TASCache()
+	cache.AddTASFlavorCache(flavorName, tasCache.NewTASFlavorCache([]string{tasRackLabel}, nil))
+
+	wantFree := resources.Requests{
+		corev1.ResourceCPU:    1000,
+		corev1.ResourceMemory: 1024 * 1024 * 1024,
+	}
+	capacity := tasCache.Get(flavorName).CapacityPerLevel(ctx)
+	if diff := cmp.Diff(wantFree, capacity[0][0].Free); diff != "" {
+		t.Errorf("unexpected free capacity after the restore (-want,+got): %s", diff)
+	}
+
+	// The workload update received after the restore is not double booked.
+	if !cache.AddOrUpdateWorkload(wl) {
+		t.Fatalf("Failed updating the workload")
+	}
+	capacity = tasCache.Get(flavorName).CapacityPerLevel(ctx)
+	if diff := cmp.Diff(wantFree, capacity[0][0].Free); diff != "" {
+		t.Errorf("unexpected free capacity after the workload update (-want,+got): %s", diff)
+	}
+}
+
+func TestTASFlavorSnapshotCapacityPerLevelCache(t *testing.T) {
+	ctx := context.Background()
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "b1-r1-x1",
+			Labels: map[string]string{
+				tasBlockLabel: "b1",
+				tasRackLabel:  "r1",
+			},
+		},
+		Status: corev1.NodeStatus{
+			Allocatable: corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("4"),
+			},
+		},
+	}
+	tasCache := NewTASCache(utiltesting.NewFakeClient(node))
+	snapshot := tasCache.NewTASFlavorCache([]string{tasBlockLabel, tasRackLabel}, nil).snapshot(ctx)
+
+	initialVersion := snapshot.version
+	initialCapacity := snapshot.capacityPerLevel()
+	if snapshot.version != initialVersion {
+		t.Errorf("unexpected version change on read, want=%d, got=%d", initialVersion, snapshot.version)
+	}
+	if snapshot.cachedCapacityPerLevel.version != initialVersion {
+		t.Errorf("unexpected version of the cached capacity, want=%d, got=%d", initialVersion, snapshot.cachedCapacityPerLevel.version)
+	}
+
+	snapshot.addUsage("b1,r1", resources.Requests{corev1.ResourceCPU: 1000})
+	if snapshot.version <= initialVersion {
+		t.Errorf("expected the version to increase after the mutation, initial=%d, got=%d", initialVersion, snapshot.version)
+	}
+	wantFree := [][]resources.Requests{
+		{{corev1.ResourceCPU: 3000}},
+		{{corev1.ResourceCPU: 3000}},
+	}
+	gotCapacity := snapshot.capacityPerLevel()
+	gotFree := make([][]resources.Requests, len(gotCapacity))
+	for levelIdx, domains := range gotCapacity {
+		for _, domain := range domains {
+			gotFree[levelIdx] = append(gotFree[levelIdx], domain.Free)
+		}
+	}
+	if diff := cmp.Diff(wantFree, gotFree); diff != "" {
+		t.Errorf("unexpected free capacity after the mutation (-want,+got): %s", diff)
+	}
+	if snapshot.cachedCapacityPerLevel.version != snapshot.version {
+		t.Errorf("unexpected version of the cached capacity, want=%d, got=%d", snapshot.version, snapshot.cachedCapacityPerLevel.version)
+	}
+	if diff := cmp.Diff(resources.Requests{corev1.ResourceCPU: 4000}, initialCapacity[1][0].Free); diff != "" {
+		t.Errorf("unexpected change of the previously returned capacity (-want,+got): %s", diff)
+	}
+}
+
+func TestDomainFreeCapacity(t *testing.T) {
+	levels := []string{tasBlockLabel, tasRackLabel, tasHostLabel}
+	// The same nodes as defaultNodes in TestFindTopologyAssignment, plus a
+	// cordoned node which is not accounted.
+	cordoned := utiltesting.MakeNode("b2-r1-x7").
+		Label(tasBlockLabel, "b2").
+		Label(tasRackLabel, "r1").
+		Label(tasHostLabel, "x7").
+		StatusAllocatable(corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("8"),
+			corev1.ResourceMemory: resource.MustParse("8Gi"),
+		}).
+		Obj()
+	cordoned.Spec.Unschedulable = true
+	nodes := []client.Object{
+		utiltesting.MakeNode("b1-r1-x1").
+			Label(tasBlockLabel, "b1").
+			Label(tasRackLabel, "r1").
+			Label(tasHostLabel, "x1").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("1"),
+				corev1.ResourceMemory: resource.MustParse("1Gi"),
+			}).
+			Obj(),
+		utiltesting.MakeNode("b1-r2-x2").
+			Label(tasBlockLabel, "b1").
+			Label(tasRackLabel, "r2").
+			Label(tasHostLabel, "x2").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("1"),
+				corev1.ResourceMemory: resource.MustParse("1Gi"),
+			}).
+			Obj(),
+		utiltesting.MakeNode("b1-r2-x3").
+			Label(tasBlockLabel, "b1").
+			Label(tasRackLabel, "r2").
+			Label(tasHostLabel, "x3").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("1"),
+				corev1.ResourceMemory: resource.MustParse("1Gi"),
+			}).
+			Obj(),
+		utiltesting.MakeNode("b1-r2-x4").
+			Label(tasBlockLabel, "b1").
+			Label(tasRackLabel, "r2").
+			Label(tasHostLabel, "x4").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("1"),
+				corev1.ResourceMemory: resource.MustParse("1Gi"),
+			}).
+			Obj(),
+		utiltesting.MakeNode("b2-r1-x5").
+			Label(tasBlockLabel, "b2").
+			Label(tasRackLabel, "r1").
+			Label(tasHostLabel, "x5").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("1"),
+				corev1.ResourceMemory: resource.MustParse("1Gi"),
+			}).
+			Obj(),
+		utiltesting.MakeNode("b2-r2-x6").
+			Label(tasBlockLabel, "b2").
+			Label(tasRackLabel, "r2").
+			Label(tasHostLabel, "x6").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("2"),
+				corev1.ResourceMemory: resource.MustParse("4Gi"),
+			}).
+			Obj(),
+		cordoned,
+	}
+	const gi = 1024 * 1024 * 1024
+
+	cases := map[string]struct {
+		level string
+		usage map[utiltas.TopologyDomainID]resources.Requests
+		want  map[string]resources.Requests
+	}{
+		"block level": {
+			level: tasBlockLabel,
+			want: map[string]resources.Requests{
+				"b1": {corev1.ResourceCPU: 4000, corev1.ResourceMemory: 4 * gi},
+				"b2": {corev1.ResourceCPU: 3000, corev1.ResourceMemory: 5 * gi},
+			},
+		},
+		"rack level": {
+			level: tasRackLabel,
+			want: map[string]resources.Requests{
+				"b1,r1": {corev1.ResourceCPU: 1000, corev1.ResourceMemory: 1 * gi},
+				"b1,r2": {corev1.ResourceCPU: 3000, corev1.ResourceMemory: 3 * gi},
+				"b2,r1": {corev1.ResourceCPU: 1000, corev1.ResourceMemory: 1 * gi},
+				"b2,r2": {corev1.ResourceCPU: 2000, corev1.ResourceMemory: 4 * gi},
+			},
+		},
+		"rack level with usage": {
+			level: tasRackLabel,
+			usage: map[utiltas.TopologyDomainID]resources.Requests{
+				"b1,r2,x3": {corev1.ResourceCPU: 1000, corev1.ResourceMemory: 1 * gi},
+				"b2,r2,x6": {corev1.ResourceCPU: 500},
+			},
+			want: map[string]resources.Requests{
+				"b1,r1": {corev1.ResourceCPU: 1000, corev1.ResourceMemory: 1 * gi},
+				"b1,r2": {corev1.ResourceCPU: 2000, corev1.ResourceMemory: 2 * gi},
+				"b2,r1": {corev1.ResourceCPU: 1000, corev1.ResourceMemory: 1 * gi},
+				"b2,r2": {corev1.ResourceCPU: 1500, corev1.ResourceMemory: 4 * gi},
+			},
+		},
+		"undefined level": {
+			level: "cloud.com/topology-zone",
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ctx, _ := utiltesting.ContextWithLog(t)
+			tasCache := NewTASCache(utiltesting.NewFakeClient(nodes...))
+			snapshot := tasCache.NewTASFlavorCache(levels, nil).snapshot(ctx)
+			for domainID, usage := range tc.usage {
+				snapshot.addUsage(domainID, usage)
+			}
+			got := snapshot.DomainFreeCapacity(tc.level)
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("unexpected free capacity (-want,+got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestCoTenancyExclusion(t *testing.T) {
+	const jobClassLabel = "example.com/job-class"
+	levels := []string{tasRackLabel, tasHostLabel}
+
+	makePod := func(name, nodeName, jobClass string, phase corev1.PodPhase) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "default",
+				Labels: map[string]string{
+					jobClassLabel: jobClass,
+				},
+			},
+			Spec: corev1.PodSpec{
+				NodeName: nodeName,
+			},
+			Status: corev1.PodStatus{
+				Phase: phase,
+			},
+		}
+	}
+
+	cases := map[string]struct {
+		classes        []string
+		wantExcluded   []string
+		wantAssignment *kueue.TopologyAssignment
+	}{
+		"node hosting an excluded class is skipped within the rack": {
+			classes:      []string{"noisy"},
+			wantExcluded: []string{"x1"},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: levels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{Count: 2, Values: []string{"r1", "x2"}},
+				},
+			},
+		},
+		"no excluded classes": {
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: levels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{Count: 2, Values: []string{"r1", "x1"}},
+				},
+			},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			tasCache := NewTASCache(utiltesting.NewFakeClient(
+				utiltesting.MakeNode("x1").
+					Label(tasRackLabel, "r1").
+					Label(tasHostLabel, "x1").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("2"),
+					}).
+					Obj(),
+				utiltesting.MakeNode("x2").
+					Label(tasRackLabel, "r1").
+					Label(tasHostLabel, "x2").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("2"),
+					}).
+					Obj(),
+				makePod("noisy-running", "x1", "noisy", corev1.PodRunning),
+				makePod("noisy-finished", "x2", "noisy", corev1.PodSucceeded),
+				makePod("quiet-running", "x2", "quiet", corev1.PodRunning),
+			))
+			tasFlavorCache := tasCache.NewTASFlavorCache(levels, nil)
+
+			gotExcluded, err := tasFlavorCache.NodesHostingExcludedClasses(ctx, CoTenancyExclusion{
+				LabelKey: jobClassLabel,
+				Classes:  tc.classes,
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.wantExcluded, gotExcluded); diff != "" {
+				t.Errorf("unexpected excluded nodes (-want,+got): %s", diff)
+			}
+
+			request := &kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			}
+			requests := resources.Requests{
+				corev1.ResourceCPU: 1000,
+			}
+			gotAssignment, err := tasFlavorCache.snapshot(ctx).FindTopologyAssignment(request, requests, 2, WithExcludedNodes(gotExcluded...))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.wantAssignment, gotAssignment); diff != "" {
+				t.Errorf("unexpected topology assignment (-want,+got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestTenantAffinity(t *testing.T) {
+	const tenantLabel = "example.com/tenant"
+	levels := []string{tasRackLabel, tasHostLabel}
+
+	makePod := func(name, nodeName, tenant string, phase corev1.PodPhase) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "default",
+				Labels: map[string]string{
+					tenantLabel: tenant,
+				},
+			},
+			Spec: corev1.PodSpec{
+				NodeName: nodeName,
+			},
+			Status: corev1.PodStatus{
+				Phase: phase,
+			},
+		}
+	}
+
+	cases := map[string]struct {
+		tenant          string
+		count           int32
+		wantTenantNodes []string
+		wantAssignment  *kueue.TopologyAssignment
+	}{
+		"the node owned by the tenant is preferred within the rack": {
+			tenant:          "a",
+			count:           2,
+			wantTenantNodes: []string{"x3"},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: levels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{Count: 2, Values: []string{"r1", "x3"}},
+				},
+			},
+		},
+		"the pods spread to fresh nodes once the nodes owned by the tenant are full": {
+			tenant:          "a",
+			count:           3,
+			wantTenantNodes: []string{"x3"},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: levels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{Count: 1, Values: []string{"r1", "x1"}},
+					{Count: 2, Values: []string{"r1", "x3"}},
+				},
+			},
+		},
+		"the node hosting only finished pods of the tenant is not preferred": {
+			tenant: "b",
+			count:  2,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: levels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{Count: 2, Values: []string{"r1", "x1"}},
+				},
+			},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			tasCache := NewTASCache(utiltesting.NewFakeClient(
+				utiltesting.MakeNode("x1").
+					Label(tasRackLabel, "r1").
+					Label(tasHostLabel, "x1").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("2"),
+					}).
+					Obj(),
+				utiltesting.MakeNode("x2").
+					Label(tasRackLabel, "r1").
+					Label(tasHostLabel, "x2").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("2"),
+					}).
+					Obj(),
+				utiltesting.MakeNode("x3").
+					Label(tasRackLabel, "r1").
+					Label(tasHostLabel, "x3").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("2"),
+					}).
+					Obj(),
+				makePod("a-running", "x3", "a", corev1.PodRunning),
+				makePod("b-finished", "x2", "b", corev1.PodSucceeded),
+			))
+			tasFlavorCache := tasCache.NewTASFlavorCache(levels, nil)
+
+			gotTenantNodes, err := tasFlavorCache.NodesHostingTenant(ctx, tenantLabel, tc.tenant)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.wantTenantNodes, gotTenantNodes, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("unexpected tenant nodes (-want,+got): %s", diff)
+			}
+
+			request := &kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			}
+			requests := resources.Requests{
+				corev1.ResourceCPU: 1000,
+			}
+			gotAssignment, err := tasFlavorCache.snapshot(ctx).FindTopologyAssignment(request, requests, tc.count, WithTenantNodes(gotTenantNodes...))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.wantAssignment, gotAssignment); diff != "" {
+				t.Errorf("unexpected topology assignment (-want,+got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestAnnotationTopologyLevel(t *testing.T) {
+	levels := []string{tasBlockLabel, tasChassisAnnotation, tasHostLabel}
+
+	ctx := context.Background()
+	tasCache := NewTASCache(utiltesting.NewFakeClient(
+		utiltesting.MakeNode("x1").
+			Label(tasBlockLabel, "b1").
+			Label(tasHostLabel, "x1").
+			Annotation(tasChassisAnnotation, "c1").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("2"),
+			}).
+			Obj(),
+		utiltesting.MakeNode("x2").
+			Label(tasBlockLabel, "b1").
+			Label(tasHostLabel, "x2").
+			Annotation(tasChassisAnnotation, "c1").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("2"),
+			}).
+			Obj(),
+		utiltesting.MakeNode("x3").
+			Label(tasBlockLabel, "b1").
+			Label(tasHostLabel, "x3").
+			Annotation(tasChassisAnnotation, "c2").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("3"),
+			}).
+			Obj(),
+		// the node without the chassis annotation is excluded
+		utiltesting.MakeNode("x4").
+			Label(tasBlockLabel, "b1").
+			Label(tasHostLabel, "x4").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("8"),
+			}).
+			Obj(),
+	))
+	tasFlavorCache := tasCache.NewTASFlavorCache(levels, nil)
+	tasFlavorCache.SetAnnotationLevels(tasChassisAnnotation)
+
+	request := &kueue.PodSetTopologyRequest{
+		Required: ptr.To(tasChassisAnnotation),
+	}
+	requests := resources.Requests{
+		corev1.ResourceCPU: 1000,
+	}
+	gotAssignment, err := tasFlavorCache.snapshot(ctx).FindTopologyAssignment(request, requests, 4)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wantAssignment := &kueue.TopologyAssignment{
+		Levels: levels,
+		Domains: []kueue.TopologyDomainAssignment{
+			{Count: 2, Values: []string{"b1", "c1", "x1"}},
+			{Count: 2, Values: []string{"b1", "c1", "x2"}},
+		},
+	}
+	if diff := cmp.Diff(wantAssignment, gotAssignment); diff != "" {
+		t.Errorf("unexpected topology assignment (-want,+got): %s", diff)
+	}
+}
+
+func TestExplainTopologyAssignment(t *testing.T) {
+	levels := []string{tasBlockLabel, tasRackLabel, tasHostLabel}
+
+	//       b1         b2
+	//     /    \       |
+	//    r1    r2      r3
+	//    |      |      |
+	//  x1:2   x2:2   x3:1
+	nodes := []corev1.Node{
+		*utiltesting.MakeNode("x1").
+			Label(tasBlockLabel, "b1").
+			Label(tasRackLabel, "r1").
+			Label(tasHostLabel, "x1").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("2"),
+			}).
+			Obj(),
+		*utiltesting.MakeNode("x2").
+			Label(tasBlockLabel, "b1").
+			Label(tasRackLabel, "r2").
+			Label(tasHostLabel, "x2").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("2"),
+			}).
+			Obj(),
+		*utiltesting.MakeNode("x3").
+			Label(tasBlockLabel, "b2").
+			Label(tasRackLabel, "r3").
+			Label(tasHostLabel, "x3").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("1"),
+			}).
+			Obj(),
+	}
+	blockAssignment := &kueue.TopologyAssignment{
+		Levels: levels,
+		Domains: []kueue.TopologyDomainAssignment{
+			{Count: 2, Values: []string{"b1", "r1", "x1"}},
+			{Count: 2, Values: []string{"b1", "r2", "x2"}},
+		},
+	}
+
+	cases := map[string]struct {
+		request         kueue.PodSetTopologyRequest
+		count           int32
+		wantExplanation *TopologyAssignmentExplanation
+		wantReason      TopologyAssignmentErrorReason
+	}{
+		"preferred host; the chosen assignment fits at the preferred level": {
+			request: kueue.PodSetTopologyRequest{
+				Preferred: ptr.To(tasHostLabel),
+			},
+			count: 2,
+			wantExplanation: &TopologyAssignmentExplanation{
+				Assignment: &kueue.TopologyAssignment{
+					Levels: levels,
+					Domains: []kueue.TopologyDomainAssignment{
+						{Count: 2, Values: []string{"b1", "r1", "x1"}},
+					},
+				},
+				DomainsPerLevel: []int32{1, 1, 1},
+				Alternatives: []LevelAlternative{
+					{
+						Level: tasRackLabel,
+						Assignment: &kueue.TopologyAssignment{
+							Levels: levels,
+							Domains: []kueue.TopologyDomainAssignment{
+								{Count: 2, Values: []string{"b1", "r1", "x1"}},
+							},
+						},
+						DomainsPerLevel: []int32{1, 1, 1},
+					},
+					{
+						Level: tasBlockLabel,
+						Assignment: &kueue.TopologyAssignment{
+							Levels: levels,
+							Domains: []kueue.TopologyDomainAssignment{
+								{Count: 2, Values: []string{"b1", "r1", "x1"}},
+							},
+						},
+						DomainsPerLevel: []int32{1, 1, 1},
+					},
+				},
+			},
+		},
+		"preferred rack; the chosen assignment is relaxed by one level": {
+			request: kueue.PodSetTopologyRequest{
+				Preferred: ptr.To(tasRackLabel),
+			},
+			count: 3,
+			wantExplanation: &TopologyAssignmentExplanation{
+				Assignment: &kueue.TopologyAssignment{
+					Levels: levels,
+					Domains: []kueue.TopologyDomainAssignment{
+						{Count: 2, Values: []string{"b1", "r1", "x1"}},
+						{Count: 1, Values: []string{"b1", "r2", "x2"}},
+					},
+				},
+				DomainsPerLevel: []int32{1, 2, 2},
+				RelaxedLevels:   1,
+				Alternatives: []LevelAlternative{
+					{
+						Level: tasBlockLabel,
+						Assignment: &kueue.TopologyAssignment{
+							Levels: levels,
+							Domains: []kueue.TopologyDomainAssignment{
+								{Count: 2, Values: []string{"b1", "r1", "x1"}},
+								{Count: 1, Values: []string{"b1", "r2", "x2"}},
+							},
+						},
+						DomainsPerLevel: []int32{1, 2, 2},
+					},
+				},
+			},
+		},
+		"preferred host; the chosen assignment is relaxed up to the top level": {
+			request: kueue.PodSetTopologyRequest{
+				Preferred: ptr.To(tasHostLabel),
+			},
+			count: 5,
+			wantExplanation: &TopologyAssignmentExplanation{
+				Assignment: &kueue.TopologyAssignment{
+					Levels: levels,
+					Domains: []kueue.TopologyDomainAssignment{
+						{Count: 2, Values: []string{"b1", "r1", "x1"}},
+						{Count: 2, Values: []string{"b1", "r2", "x2"}},
+						{Count: 1, Values: []string{"b2", "r3", "x3"}},
+					},
+				},
+				DomainsPerLevel: []int32{2, 3, 3},
+				RelaxedLevels:   3,
+				Alternatives: []LevelAlternative{
+					{
+						Level: tasRackLabel,
+					},
+					{
+						Level: tasBlockLabel,
+					},
+				},
+			},
+		},
+		"preferred host; the chosen assignment spreads across racks": {
+			request: kueue.PodSetTopologyRequest{
+				Preferred: ptr.To(tasHostLabel),
+			},
+			count: 3,
+			wantExplanation: &TopologyAssignmentExplanation{
+				Assignment: &kueue.TopologyAssignment{
+					Levels: levels,
+					Domains: []kueue.TopologyDomainAssignment{
+						{Count: 2, Values: []string{"b1", "r1", "x1"}},
+						{Count: 1, Values: []string{"b1", "r2", "x2"}},
+					},
+				},
+				DomainsPerLevel: []int32{1, 2, 2},
+				RelaxedLevels:   2,
+				Alternatives: []LevelAlternative{
+					{
+						Level: tasRackLabel,
+					},
+					{
+						Level: tasBlockLabel,
+						Assignment: &kueue.TopologyAssignment{
+							Levels: levels,
+							Domains: []kueue.TopologyDomainAssignment{
+								{Count: 2, Values: []string{"b1", "r1", "x1"}},
+								{Count: 1, Values: []string{"b1", "r2", "x2"}},
+							},
+						},
+						DomainsPerLevel: []int32{1, 2, 2},
+					},
+				},
+			},
+		},
+		"required rack doesn't fit; the block alternative uses two racks": {
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			count: 4,
+			wantExplanation: &TopologyAssignmentExplanation{
+				Alternatives: []LevelAlternative{
+					{
+						Level:           tasBlockLabel,
+						Assignment:      blockAssignment,
+						DomainsPerLevel: []int32{1, 2, 2},
+					},
+				},
+			},
+			wantReason: TopologyNotFit,
+		},
+		"invalid topology level": {
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To("cloud.com/topology-zone"),
+			},
+			count:      1,
+			wantReason: InvalidTopologyLevel,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			initialObjects := make([]client.Object, 0, len(nodes))
+			for i := range nodes {
+				initialObjects = append(initialObjects, &nodes[i])
+			}
+			tasCache := NewTASCache(utiltesting.NewFakeClient(initialObjects...))
+			snapshot := tasCache.NewTASFlavorCache(levels, nil).snapshot(ctx)
+			requests := resources.Requests{
+				corev1.ResourceCPU: 1000,
+			}
+			gotExplanation, gotErr := snapshot.ExplainTopologyAssignment(&tc.request, requests, tc.count)
+			if diff := cmp.Diff(tc.wantExplanation, gotExplanation); diff != "" {
+				t.Errorf("unexpected explanation (-want,+got): %s", diff)
+			}
+			var gotReason TopologyAssignmentErrorReason
+			var assignmentErr *TopologyAssignmentError
+			if errors.As(gotErr, &assignmentErr) {
+				gotReason = assignmentErr.Reason
+			}
+			if gotReason != tc.wantReason {
+				t.Errorf("unexpected error reason, want=%q, got=%q (error: %v)", tc.wantReason, gotReason, gotErr)
+			}
+		})
+	}
+}
+
+func TestFindIncrementalTopologyAssignment(t *testing.T) {
+	levels := []string{tasRackLabel, tasHostLabel}
+
+	//        r1          r2
+	//      /    \        |
+	//    x1:6   x2:4   x3:8
+	nodes := []corev1.Node{
+		*utiltesting.MakeNode("x1").
+			Label(tasRackLabel, "r1").
+			Label(tasHostLabel, "x1").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("6"),
+			}).
+			Obj(),
+		*utiltesting.MakeNode("x2").
+			Label(tasRackLabel, "r1").
+			Label(tasHostLabel, "x2").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("4"),
+			}).
+			Obj(),
+		*utiltesting.MakeNode("x3").
+			Label(tasRackLabel, "r2").
+			Label(tasHostLabel, "x3").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("8"),
+			}).
+			Obj(),
+	}
+	current := &kueue.TopologyAssignment{
+		Levels: levels,
+		Domains: []kueue.TopologyDomainAssignment{
+			{Count: 4, Values: []string{"r1", "x1"}},
+		},
+	}
+
+	cases := map[string]struct {
+		request        kueue.PodSetTopologyRequest
+		current        *kueue.TopologyAssignment
+		withoutCurrent bool
+		usage          map[utiltas.TopologyDomainID]resources.Requests
+		count          int32
+		wantAssignment *kueue.TopologyAssignment
+		wantReason     TopologyAssignmentErrorReason
+	}{
+		"scale up keeps the current pods and fills the current domains first": {
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			count: 8,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: levels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{Count: 6, Values: []string{"r1", "x1"}},
+					{Count: 2, Values: []string{"r1", "x2"}},
+				},
+			},
+		},
+		"scale up within the current domains only": {
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			count: 6,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: levels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{Count: 6, Values: []string{"r1", "x1"}},
+				},
+			},
+		},
+		"scale up doesn't fit within the required rack of the current assignment": {
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			usage: map[utiltas.TopologyDomainID]resources.Requests{
+				"r1,x2": {corev1.ResourceCPU: 4000},
+			},
+			count:      8,
+			wantReason: InsufficientCapacity,
+		},
+		"scale up falls back to another rack when the rack is only preferred": {
+			request: kueue.PodSetTopologyRequest{
+				Preferred: ptr.To(tasRackLabel),
+			},
+			usage: map[utiltas.TopologyDomainID]resources.Requests{
+				"r1,x2": {corev1.ResourceCPU: 4000},
+			},
+			count: 8,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: levels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{Count: 6, Values: []string{"r1", "x1"}},
+					{Count: 2, Values: []string{"r2", "x3"}},
+				},
+			},
+		},
+		"no current assignment; all the pods are placed": {
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			withoutCurrent: true,
+			count:          2,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: levels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{Count: 2, Values: []string{"r1", "x1"}},
+				},
+			},
+		},
+		"current assignment spanning two racks; the scale up violates the required rack": {
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			current: &kueue.TopologyAssignment{
+				Levels: levels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{Count: 2, Values: []string{"r1", "x1"}},
+					{Count: 2, Values: []string{"r2", "x3"}},
+				},
+			},
+			count:      6,
+			wantReason: TopologyNotFit,
+		},
+		"no scale up": {
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			count:          4,
+			wantAssignment: current,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			initialObjects := make([]client.Object, 0, len(nodes))
+			for i := range nodes {
+				initialObjects = append(initialObjects, &nodes[i])
+			}
+			tasCache := NewTASCache(utiltesting.NewFakeClient(initialObjects...))
+			snapshot := tasCache.NewTASFlavorCache(levels, nil).snapshot(ctx)
+			requests := resources.Requests{
+				corev1.ResourceCPU: 1000,
+			}
+			current := current
+			if tc.current != nil {
+				current = tc.current
+			}
+			if tc.withoutCurrent {
+				current = nil
+			}
+			if current != nil {
+				snapshot.addAssignmentUsage(current, requests)
+			}
+			for domainID, usage := range tc.usage {
+				snapshot.addUsage(domainID, usage)
+			}
+			wantFreeCapacity := snapshot.capacityPerLevel()
+
+			gotAssignment, gotErr := snapshot.FindIncrementalTopologyAssignment(&tc.request, requests, current, tc.count)
+			if diff := cmp.Diff(tc.wantAssignment, gotAssignment); diff != "" {
+				t.Errorf("unexpected topology assignment (-want,+got): %s", diff)
+			}
+			var gotReason TopologyAssignmentErrorReason
+			var assignmentErr *TopologyAssignmentError
+			if errors.As(gotErr, &assignmentErr) {
+				gotReason = assignmentErr.Reason
+			}
+			if gotReason != tc.wantReason {
+				t.Errorf("unexpected error reason, want=%q, got=%q (error: %v)", tc.wantReason, gotReason, gotErr)
+			}
+			if diff := cmp.Diff(wantFreeCapacity, snapshot.capacityPerLevel()); diff != "" {
+				t.Errorf("unexpected free capacity after the assignment (-want,+got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestFindTopologyAssignmentFuncStopsEarly(t *testing.T) {
+	levels := []string{tasRackLabel, tasHostLabel}
+	ctx := context.Background()
+	tasCache := NewTASCache(utiltesting.NewFakeClient(
+		utiltesting.MakeNode("x1").
+			Label(tasRackLabel, "r1").
+			Label(tasHostLabel, "x1").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("1"),
+			}).
+			Obj(),
+		utiltesting.MakeNode("x2").
+			Label(tasRackLabel, "r2").
+			Label(tasHostLabel, "x2").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("1"),
+			}).
+			Obj(),
+		utiltesting.MakeNode("x3").
+			Label(tasRackLabel, "r3").
+			Label(tasHostLabel, "x3").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("1"),
+			}).
+			Obj(),
+	))
+	snapshot := tasCache.NewTASFlavorCache(levels, nil).snapshot(ctx)
+	request := &kueue.PodSetTopologyRequest{Preferred: ptr.To(tasRackLabel)}
+	requests := resources.Requests{corev1.ResourceCPU: 1000}
+
+	var gotDomains []kueue.TopologyDomainAssignment
+	err := snapshot.FindTopologyAssignmentFunc(request, requests, 3, func(domain kueue.TopologyDomainAssignment) bool {
+		gotDomains = append(gotDomains, domain)
+		return len(gotDomains) < 2
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wantDomains := []kueue.TopologyDomainAssignment{
+		{Count: 1, Values: []string{"r1", "x1"}},
+		{Count: 1, Values: []string{"r2", "x2"}},
+	}
+	if diff := cmp.Diff(wantDomains, gotDomains); diff != "" {
+		t.Errorf("unexpected streamed domains (-want,+got): %s", diff)
+	}
+}
+
+func TestFindTopologyAssignmentKeepsOptions(t *testing.T) {
+	levels := []string{tasRackLabel, tasHostLabel}
+
+	ctx := context.Background()
+	tasCache := NewTASCache(utiltesting.NewFakeClient(
+		utiltesting.MakeNode("x1").
+			Label(tasRackLabel, "r1").
+			Label(tasHostLabel, "x1").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("2"),
+			}).
+			Obj(),
+		utiltesting.MakeNode("x2").
+			Label(tasRackLabel, "r2").
+			Label(tasHostLabel, "x2").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("2"),
+			}).
+			Obj(),
+	))
+	tasFlavorCache := tasCache.NewTASFlavorCache(levels, nil)
+	snapshot := tasFlavorCache.snapshot(ctx)
+	request := &kueue.PodSetTopologyRequest{Preferred: ptr.To(tasRackLabel)}
+	requests := resources.Requests{corev1.ResourceCPU: 1000}
+
+	options := &findTopologyAssignmentOptions{}
+	for _, opt := range []FindTopologyAssignmentOption{
+		WithMaxDomains(tasHostLabel, 2),
+		WithResourceWeights(map[corev1.ResourceName]float64{corev1.ResourceCPU: 1}),
+		WithPreferredNode("x1"),
+		WithPreferFullerDomains(),
+	} {
+		opt(options)
+	}
+	if _, _, err := snapshot.findTopologyAssignment(request, requests, 2, options); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the state derived for the call isn't written back to the options of
+	// the caller, so they can be reused for another call
+	if options.maxDomainsLevelIdx != 0 {
+		t.Errorf("unexpected max domains level index set on the options, got=%d", options.maxDomainsLevelIdx)
+	}
+	if options.weightedFreeCapacity != nil {
+		t.Errorf("unexpected weighted free capacity set on the options: %v", options.weightedFreeCapacity)
+	}
+	if options.preferredNodePerDomain != nil {
+		t.Errorf("unexpected preferred node per domain set on the options: %v", options.preferredNodePerDomain)
+	}
+	if options.allocatedFractionPerDomain != nil {
+		t.Errorf("unexpected allocated fraction per domain set on the options: %v", options.allocatedFractionPerDomain)
+	}
+}
+
+func TestFindTopologyAssignmentCache(t *testing.T) {
+	levels := []string{tasRackLabel, tasHostLabel}
+
+	ctx := context.Background()
+	tasCache := NewTASCache(utiltesting.NewFakeClient(
+		utiltesting.MakeNode("x1").
+			Label(tasRackLabel, "r1").
+			Label(tasHostLabel, "x1").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("2"),
+			}).
+			Obj(),
+		utiltesting.MakeNode("x2").
+			Label(tasRackLabel, "r2").
+			Label(tasHostLabel, "x2").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("2"),
+			}).
+			Obj(),
+	))
+	tasFlavorCache := tasCache.NewTASFlavorCache(levels, nil)
+	snapshot := tasFlavorCache.snapshot(ctx)
+	request := &kueue.PodSetTopologyRequest{Required: ptr.To(tasRackLabel)}
+	requests := resources.Requests{corev1.ResourceCPU: 1000}
+
+	first, err := snapshot.FindTopologyAssignment(request, requests, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if hits := snapshot.AssignmentCacheHits(); hits != 0 {
+		t.Errorf("unexpected cache hits after the first query, want=0, got=%d", hits)
+	}
+	second, err := snapshot.FindTopologyAssignment(request, requests, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if hits := snapshot.AssignmentCacheHits(); hits != 1 {
+		t.Errorf("unexpected cache hits after the repeated query, want=1, got=%d", hits)
+	}
+	if diff := cmp.Diff(first, second); diff != "" {
+		t.Errorf("unexpected cached topology assignment (-want,+got): %s", diff)
+	}
+
+	// The queries with a different count or different options are distinct,
+	// while a repeated query with the same options is served from the cache.
+	if _, err := snapshot.FindTopologyAssignment(request, requests, 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	withOptions, err := snapshot.FindTopologyAssignment(request, requests, 2, WithExcludedNodes("x1"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if hits := snapshot.AssignmentCacheHits(); hits != 1 {
+		t.Errorf("unexpected cache hits after the distinct queries, want=1, got=%d", hits)
+	}
+	repeatedWithOptions, err := snapshot.FindTopologyAssignment(request, requests, 2, WithExcludedNodes("x1"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if hits := snapshot.AssignmentCacheHits(); hits != 2 {
+		t.Errorf("unexpected cache hits after the repeated query with the options, want=2, got=%d", hits)
+	}
+	if diff := cmp.Diff(withOptions, repeatedWithOptions); diff != "" {
+		t.Errorf("unexpected cached topology assignment with the options (-want,+got): %s", diff)
+	}
+
+	// The assignment changes the usage, which invalidates the cache.
+	snapshot.Assign(first, requests)
+	third, err := snapshot.FindTopologyAssignment(request, requests, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if hits := snapshot.AssignmentCacheHits(); hits != 2 {
+		t.Errorf("unexpected cache hits after the usage change, want=2, got=%d", hits)
+	}
+	if diff := cmp.Diff(first, third); diff == "" {
+		t.Errorf("expected the assignment to change with the usage, got %v", third)
+	}
+
+	// A new snapshot starts with an empty cache.
+	snapshot = tasFlavorCache.snapshot(ctx)
+	if _, err := snapshot.FindTopologyAssignment(request, requests, 2); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if hits := snapshot.AssignmentCacheHits(); hits != 0 {
+		t.Errorf("unexpected cache hits of the new snapshot, want=0, got=%d", hits)
+	}
+}
+
+func TestAssign(t *testing.T) {
+	levels := []string{tasRackLabel, tasHostLabel}
+
+	ctx := context.Background()
+	tasCache := NewTASCache(utiltesting.NewFakeClient(
+		utiltesting.MakeNode("x1").
+			Label(tasRackLabel, "r1").
+			Label(tasHostLabel, "x1").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("1"),
+			}).
+			Obj(),
+		utiltesting.MakeNode("x2").
+			Label(tasRackLabel, "r1").
+			Label(tasHostLabel, "x2").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("1"),
+			}).
+			Obj(),
+		utiltesting.MakeNode("x3").
+			Label(tasRackLabel, "r2").
+			Label(tasHostLabel, "x3").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("1"),
+			}).
+			Obj(),
+	))
+	snapshot := tasCache.NewTASFlavorCache(levels, nil).snapshot(ctx)
+	request := &kueue.PodSetTopologyRequest{Required: ptr.To(tasRackLabel)}
+	requests := resources.Requests{corev1.ResourceCPU: 1000}
+
+	first, err := snapshot.FindTopologyAssignment(request, requests, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wantFirst := &kueue.TopologyAssignment{
+		Levels: levels,
+		Domains: []kueue.TopologyDomainAssignment{
+			{Count: 1, Values: []string{"r1", "x1"}},
+			{Count: 1, Values: []string{"r1", "x2"}},
+		},
+	}
+	if diff := cmp.Diff(wantFirst, first); diff != "" {
+		t.Errorf("unexpected first assignment (-want,+got): %s", diff)
+	}
+	snapshot.Assign(first, requests)
+
+	if _, err := snapshot.FindTopologyAssignment(request, requests, 2); err == nil {
+		t.Errorf("expected the follow-up assignment to not fit, as the rack is filled")
+	}
+	second, err := snapshot.FindTopologyAssignment(request, requests, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wantSecond := &kueue.TopologyAssignment{
+		Levels: levels,
+		Domains: []kueue.TopologyDomainAssignment{
+			{Count: 1, Values: []string{"r2", "x3"}},
+		},
+	}
+	if diff := cmp.Diff(wantSecond, second); diff != "" {
+		t.Errorf("unexpected follow-up assignment (-want,+got): %s", diff)
+	}
+}
+
+func TestRelease(t *testing.T) {
+	levels := []string{tasRackLabel, tasHostLabel}
+
+	ctx := context.Background()
+	tasCache := NewTASCache(utiltesting.NewFakeClient(
+		utiltesting.MakeNode("x1").
+			Label(tasRackLabel, "r1").
+			Label(tasHostLabel, "x1").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("2"),
+				corev1.ResourceMemory: resource.MustParse("2Gi"),
+			}).
+			Obj(),
+		utiltesting.MakeNode("x2").
+			Label(tasRackLabel, "r1").
+			Label(tasHostLabel, "x2").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("2"),
+				corev1.ResourceMemory: resource.MustParse("2Gi"),
+			}).
+			Obj(),
+		utiltesting.MakeNode("x3").
+			Label(tasRackLabel, "r2").
+			Label(tasHostLabel, "x3").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("2"),
+				corev1.ResourceMemory: resource.MustParse("2Gi"),
+			}).
+			Obj(),
+	))
+	snapshot := tasCache.NewTASFlavorCache(levels, nil).snapshot(ctx)
+	request := &kueue.PodSetTopologyRequest{Required: ptr.To(tasRackLabel)}
+	requests := resources.Requests{corev1.ResourceCPU: 1000, corev1.ResourceMemory: 512 * 1024 * 1024}
+	wantFreeCapacity := map[string]map[string]resources.Requests{
+		tasRackLabel: snapshot.DomainFreeCapacity(tasRackLabel),
+		tasHostLabel: snapshot.DomainFreeCapacity(tasHostLabel),
+	}
+
+	assignment, err := snapshot.FindTopologyAssignment(request, requests, 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	snapshot.Assign(assignment, requests)
+	if diff := cmp.Diff(wantFreeCapacity[tasRackLabel], snapshot.DomainFreeCapacity(tasRackLabel)); diff == "" {
+		t.Errorf("expected the free capacity to be reduced by the assignment")
+	}
+	snapshot.Release(assignment, requests)
+
+	gotFreeCapacity := map[string]map[string]resources.Requests{
+		tasRackLabel: snapshot.DomainFreeCapacity(tasRackLabel),
+		tasHostLabel: snapshot.DomainFreeCapacity(tasHostLabel),
+	}
+	if diff := cmp.Diff(wantFreeCapacity, gotFreeCapacity); diff != "" {
+		t.Errorf("unexpected free capacity after the release (-want,+got): %s", diff)
+	}
+}
+
+func TestInsufficientClusterCapacity(t *testing.T) {
+	levels := []string{tasRackLabel, tasHostLabel}
+
+	ctx := context.Background()
+	tasCache := NewTASCache(utiltesting.NewFakeClient(
+		utiltesting.MakeNode("x1").
+			Label(tasRackLabel, "r1").
+			Label(tasHostLabel, "x1").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("4"),
+			}).
+			Obj(),
+		utiltesting.MakeNode("x2").
+			Label(tasRackLabel, "r1").
+			Label(tasHostLabel, "x2").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("4"),
+			}).
+			Obj(),
+		utiltesting.MakeNode("x3").
+			Label(tasRackLabel, "r2").
+			Label(tasHostLabel, "x3").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("4"),
+			}).
+			Obj(),
+	))
+	snapshot := tasCache.NewTASFlavorCache(levels, nil).snapshot(ctx)
+	request := &kueue.PodSetTopologyRequest{Preferred: ptr.To(tasRackLabel)}
+	requests := resources.Requests{corev1.ResourceCPU: 1000}
+
+	// Only the check of the free capacity of the whole flavor, done before
+	// any domain is evaluated, reports InsufficientClusterCapacity.
+	assignment, err := snapshot.FindTopologyAssignment(request, requests, 100_000)
+	if assignment != nil {
+		t.Errorf("unexpected assignment: %v", assignment)
+	}
+	var assignmentErr *TopologyAssignmentError
+	if !errors.As(err, &assignmentErr) {
+		t.Fatalf("expected a topology assignment error, got: %v", err)
+	}
+	wantErr := &TopologyAssignmentError{
+		Reason:    InsufficientClusterCapacity,
+		Message:   "cannot fit 100000 pods, the free cpu capacity is enough for 12 pods, a pod requests 1 and at most 4 is free in a single domain",
+		Resource:  corev1.ResourceCPU,
+		Requested: 1000,
+		Available: 4000,
+	}
+	if diff := cmp.Diff(wantErr, assignmentErr); diff != "" {
+		t.Errorf("unexpected error (-want,+got): %s", diff)
+	}
+
+	// The pods fit in the free capacity of the whole flavor, but not in the
+	// capacity left to the assignment by the excluded node.
+	_, err = snapshot.FindTopologyAssignment(request, requests, 12, WithExcludedNodes("x3"))
+	if !errors.As(err, &assignmentErr) || assignmentErr.Reason != InsufficientCapacity {
+		t.Errorf("unexpected error, want reason %q, got: %v", InsufficientCapacity, err)
+	}
+
+	if _, err := snapshot.FindTopologyAssignment(request, requests, 12); err != nil {
+		t.Errorf("unexpected error for the pods fitting the whole flavor: %v", err)
+	}
+}
+
+func TestPreferFullerDomains(t *testing.T) {
+	levels := []string{tasRackLabel, tasHostLabel}
+	requests := resources.Requests{corev1.ResourceCPU: 1000}
+	// the rack r2 is 50% full, as the host x3 is fully used
+	usage := &kueue.TopologyAssignment{
+		Levels: levels,
+		Domains: []kueue.TopologyDomainAssignment{
+			{Count: 2, Values: []string{"r2", "x3"}},
+		},
+	}
+
+	cases := map[string]struct {
+		count          int32
+		opts           []FindTopologyAssignmentOption
+		wantAssignment *kueue.TopologyAssignment
+	}{
+		"both racks fit; the empty rack is used by default": {
+			count: 2,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: levels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{Count: 2, Values: []string{"r1", "x1"}},
+				},
+			},
+		},
+		"both racks fit; the fuller rack is used": {
+			count: 2,
+			opts:  []FindTopologyAssignmentOption{WithPreferFullerDomains()},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: levels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{Count: 2, Values: []string{"r2", "x4"}},
+				},
+			},
+		},
+		"spreading over the racks; the empty rack is filled first by default": {
+			count: 5,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: levels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{Count: 2, Values: []string{"r1", "x1"}},
+					{Count: 2, Values: []string{"r1", "x2"}},
+					{Count: 1, Values: []string{"r2", "x4"}},
+				},
+			},
+		},
+		"spreading over the racks; the fuller rack is topped off first": {
+			count: 5,
+			opts:  []FindTopologyAssignmentOption{WithPreferFullerDomains()},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: levels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{Count: 2, Values: []string{"r1", "x1"}},
+					{Count: 1, Values: []string{"r1", "x2"}},
+					{Count: 2, Values: []string{"r2", "x4"}},
+				},
+			},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			tasCache := NewTASCache(utiltesting.NewFakeClient(
+				utiltesting.MakeNode("x1").
+					Label(tasRackLabel, "r1").
+					Label(tasHostLabel, "x1").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("2"),
+					}).
+					Obj(),
+				utiltesting.MakeNode("x2").
+					Label(tasRackLabel, "r1").
+					Label(tasHostLabel, "x2").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("2"),
+					}).
+					Obj(),
+				utiltesting.MakeNode("x3").
+					Label(tasRackLabel, "r2").
+					Label(tasHostLabel, "x3").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("2"),
+					}).
+					Obj(),
+				utiltesting.MakeNode("x4").
+					Label(tasRackLabel, "r2").
+					Label(tasHostLabel, "x4").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("2"),
+					}).
+					Obj(),
+			))
+			snapshot := tasCache.NewTASFlavorCache(levels, nil).snapshot(ctx)
+			snapshot.Assign(usage, requests)
+			request := &kueue.PodSetTopologyRequest{Preferred: ptr.To(tasRackLabel)}
+
+			gotAssignment, err := snapshot.FindTopologyAssignment(request, requests, tc.count, tc.opts...)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.wantAssignment, gotAssignment); diff != "" {
+				t.Errorf("unexpected topology assignment (-want,+got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestFindTopologyAssignmentForTotal(t *testing.T) {
+	levels := []string{tasRackLabel, tasHostLabel}
+
+	//          r1              r2
+	//      /        \          |
+	//    x1:1.5     x2:1     x3:1
+	nodes := []corev1.Node{
+		*utiltesting.MakeNode("x1").
+			Label(tasRackLabel, "r1").
+			Label(tasHostLabel, "x1").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("1500m"),
+				corev1.ResourceMemory: resource.MustParse("4Gi"),
+			}).
+			Obj(),
+		*utiltesting.MakeNode("x2").
+			Label(tasRackLabel, "r1").
+			Label(tasHostLabel, "x2").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("1"),
+				corev1.ResourceMemory: resource.MustParse("4Gi"),
+			}).
+			Obj(),
+		*utiltesting.MakeNode("x3").
+			Label(tasRackLabel, "r2").
+			Label(tasHostLabel, "x3").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("1"),
+				corev1.ResourceMemory: resource.MustParse("4Gi"),
+			}).
+			Obj(),
+	}
+	const gi = 1024 * 1024 * 1024
+
+	cases := map[string]struct {
+		request        kueue.PodSetTopologyRequest
+		total          resources.Requests
+		perPod         resources.Requests
+		wantCount      int32
+		wantAssignment *kueue.TopologyAssignment
+		wantReason     TopologyAssignmentErrorReason
+	}{
+		"the last pod requests the remainder of the total": {
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			total:     resources.Requests{corev1.ResourceCPU: 2500},
+			perPod:    resources.Requests{corev1.ResourceCPU: 1000},
+			wantCount: 3,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: levels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{Count: 2, Values: []string{"r1", "x1"}},
+					{Count: 1, Values: []string{"r1", "x2"}},
+				},
+			},
+		},
+		"the total divides evenly": {
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			total:     resources.Requests{corev1.ResourceCPU: 2000},
+			perPod:    resources.Requests{corev1.ResourceCPU: 1000},
+			wantCount: 2,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: levels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{Count: 1, Values: []string{"r1", "x1"}},
+					{Count: 1, Values: []string{"r1", "x2"}},
+				},
+			},
+		},
+		"the count is derived from the resource needing the most pods": {
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			total: resources.Requests{
+				corev1.ResourceCPU:    1500,
+				corev1.ResourceMemory: 3 * gi,
+			},
+			perPod: resources.Requests{
+				corev1.ResourceCPU:    1000,
+				corev1.ResourceMemory: 2 * gi,
+			},
+			wantCount: 2,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: levels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{Count: 2, Values: []string{"r1", "x1"}},
+				},
+			},
+		},
+		"a total below the per-pod requests yields a single pod": {
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasHostLabel),
+			},
+			total:     resources.Requests{corev1.ResourceCPU: 1500},
+			perPod:    resources.Requests{corev1.ResourceCPU: 2000},
+			wantCount: 1,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: levels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{Count: 1, Values: []string{"r1", "x1"}},
+				},
+			},
+		},
+		"the total doesn't fit": {
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			total:      resources.Requests{corev1.ResourceCPU: 4500},
+			perPod:     resources.Requests{corev1.ResourceCPU: 1000},
+			wantCount:  5,
+			wantReason: InsufficientClusterCapacity,
+		},
+		"no requests per pod": {
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			total:      resources.Requests{corev1.ResourceCPU: 1000},
+			perPod:     resources.Requests{},
+			wantReason: TopologyNotFit,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			initialObjects := make([]client.Object, 0, len(nodes))
+			for i := range nodes {
+				initialObjects = append(initialObjects, &nodes[i])
+			}
+			tasCache := NewTASCache(utiltesting.NewFakeClient(initialObjects...))
+			snapshot := tasCache.NewTASFlavorCache(levels, nil).snapshot(ctx)
+			wantFreeCapacity := snapshot.capacityPerLevel()
+
+			if gotCount, _ := podsForTotal(tc.total, tc.perPod); gotCount != tc.wantCount {
+				t.Errorf("unexpected pod count, want=%d, got=%d", tc.wantCount, gotCount)
+			}
+			gotAssignment, gotErr := snapshot.FindTopologyAssignmentForTotal(&tc.request, tc.total, tc.perPod)
+			if diff := cmp.Diff(tc.wantAssignment, gotAssignment); diff != "" {
+				t.Errorf("unexpected topology assignment (-want,+got): %s", diff)
+			}
+			var gotReason TopologyAssignmentErrorReason
+			var assignmentErr *TopologyAssignmentError
+			if errors.As(gotErr, &assignmentErr) {
+				gotReason = assignmentErr.Reason
+			}
+			if gotReason != tc.wantReason {
+				t.Errorf("unexpected error reason, want=%q, got=%q (error: %v)", tc.wantReason, gotReason, gotErr)
+			}
+			if diff := cmp.Diff(wantFreeCapacity, snapshot.capacityPerLevel()); diff != "" {
+				t.Errorf("unexpected free capacity after the assignment (-want,+got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestNodeScoringStrategy(t *testing.T) {
+	levels := []string{tasRackLabel, tasHostLabel}
+	//               r1
+	//     /         |         \
+	//  x1:3/4     x2:2/4     x3:0/4  (used/capacity)
+	nodes := []corev1.Node{
+		*utiltesting.MakeNode("x1").
+			Label(tasRackLabel, "r1").
+			Label(tasHostLabel, "x1").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("4"),
+			}).
+			Obj(),
+		*utiltesting.MakeNode("x2").
+			Label(tasRackLabel, "r1").
+			Label(tasHostLabel, "x2").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("4"),
+			}).
+			Obj(),
+		*utiltesting.MakeNode("x3").
+			Label(tasRackLabel, "r1").
+			Label(tasHostLabel, "x3").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("4"),
+			}).
+			Obj(),
+	}
+	usage := []workload.TopologyDomainRequests{
+		{Values: []string{"r1", "x1"}, Requests: resources.Requests{corev1.ResourceCPU: 3000}},
+		{Values: []string{"r1", "x2"}, Requests: resources.Requests{corev1.ResourceCPU: 2000}},
+	}
+
+	cases := map[string]struct {
+		opts           []FindTopologyAssignmentOption
+		wantAssignment *kueue.TopologyAssignment
+	}{
+		"default strategy prefers the host fitting the most pods": {
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: levels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{Count: 2, Values: []string{"r1", "x3"}},
+				},
+			},
+		},
+		"least allocated strategy spreads the pods onto the least used hosts": {
+			opts: []FindTopologyAssignmentOption{WithNodeScoringStrategy(NodeScoringLeastAllocated)},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: levels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{Count: 2, Values: []string{"r1", "x3"}},
+				},
+			},
+		},
+		"most allocated strategy packs the pods onto the most used hosts": {
+			opts: []FindTopologyAssignmentOption{WithNodeScoringStrategy(NodeScoringMostAllocated)},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: levels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{Count: 1, Values: []string{"r1", "x1"}},
+					{Count: 1, Values: []string{"r1", "x2"}},
+				},
+			},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			initialObjects := make([]client.Object, 0, len(nodes))
+			for i := range nodes {
+				initialObjects = append(initialObjects, &nodes[i])
+			}
+			tasCache := NewTASCache(utiltesting.NewFakeClient(initialObjects...))
+			tasFlavorCache := tasCache.NewTASFlavorCache(levels, nil)
+			tasFlavorCache.addUsage("default/wl", usage)
+			snapshot := tasFlavorCache.snapshot(ctx)
+			request := &kueue.PodSetTopologyRequest{Required: ptr.To(tasRackLabel)}
+
+			gotAssignment, err := snapshot.FindTopologyAssignment(request, resources.Requests{corev1.ResourceCPU: 1000}, 2, tc.opts...)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.wantAssignment, gotAssignment); diff != "" {
+				t.Errorf("unexpected topology assignment (-want,+got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestFindTopologyAssignmentWithSoftLimits(t *testing.T) {
+	const powerName = corev1.ResourceName("example.com/power")
+	levels := []string{tasRackLabel, tasHostLabel}
+
+	//            r1                  r2
+	//            |                   |
+	//    x1:4 cpu,1 power    x2:3 cpu,4 power
+	nodes := []corev1.Node{
+		*utiltesting.MakeNode("x1").
+			Label(tasRackLabel, "r1").
+			Label(tasHostLabel, "x1").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("4"),
+				powerName:          resource.MustParse("1"),
+			}).
+			Obj(),
+		*utiltesting.MakeNode("x2").
+			Label(tasRackLabel, "r2").
+			Label(tasHostLabel, "x2").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("3"),
+				powerName:          resource.MustParse("4"),
+			}).
+			Obj(),
+	}
+	requests := resources.Requests{
+		corev1.ResourceCPU: 1000,
+		powerName:          1,
+	}
+
+	cases := map[string]struct {
+		count          int32
+		softLimits     []corev1.ResourceName
+		wantAssignment *kueue.TopologyAssignment
+		wantViolations []SoftLimitViolation
+		wantReason     TopologyAssignmentErrorReason
+	}{
+		"the power budget is respected when possible": {
+			count:      3,
+			softLimits: []corev1.ResourceName{powerName},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: levels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{Count: 3, Values: []string{"r2", "x2"}},
+				},
+			},
+		},
+		"the power budget is exceeded when the workload must overfill the rack": {
+			count:      4,
+			softLimits: []corev1.ResourceName{powerName},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: levels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{Count: 4, Values: []string{"r1", "x1"}},
+				},
+			},
+			wantViolations: []SoftLimitViolation{
+				{Values: []string{"r1", "x1"}, Resource: powerName, Excess: 3},
+			},
+		},
+		"the power budget is a hard limit without the soft limits": {
+			count:      4,
+			wantReason: TopologyNotFit,
+		},
+		"the hard limits are never exceeded": {
+			count:      5,
+			softLimits: []corev1.ResourceName{powerName},
+			wantReason: TopologyNotFit,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			initialObjects := make([]client.Object, 0, len(nodes))
+			for i := range nodes {
+				initialObjects = append(initialObjects, &nodes[i])
+			}
+			tasCache := NewTASCache(utiltesting.NewFakeClient(initialObjects...))
+			snapshot := tasCache.NewTASFlavorCache(levels, nil).snapshot(ctx)
+			request := &kueue.PodSetTopologyRequest{Required: ptr.To(tasRackLabel)}
+
+			gotAssignment, gotErr := snapshot.FindTopologyAssignment(request, requests, tc.count, WithSoftLimits(tc.softLimits...))
+			if diff := cmp.Diff(tc.wantAssignment, gotAssignment); diff != "" {
+				t.Errorf("unexpected topology assignment (-want,+got): %s", diff)
+			}
+			var gotReason TopologyAssignmentErrorReason
+			var assignmentErr *TopologyAssignmentError
+			if errors.As(gotErr, &assignmentErr) {
+				gotReason = assignmentErr.Reason
+			}
+			if gotReason != tc.wantReason {
+				t.Errorf("unexpected error reason, want=%q, got=%q (error: %v)", tc.wantReason, gotReason, gotErr)
+			}
+			gotViolations := snapshot.SoftLimitViolations(gotAssignment, requests, tc.softLimits...)
+			if diff := cmp.Diff(tc.wantViolations, gotViolations); diff != "" {
+				t.Errorf("unexpected soft limit violations (-want,+got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestFindTopologyAssignmentSpan(t *testing.T) {
+	levels := []string{tasRackLabel, tasHostLabel}
+	nodes := []corev1.Node{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "x1",
+				Labels: map[string]string{
+					tasRackLabel: "r1",
+					tasHostLabel: "x1",
+				},
+			},
+			Status: corev1.NodeStatus{
+				Allocatable: corev1.ResourceList{
+					corev1.ResourceCPU: resource.MustParse("2"),
+				},
+			},
+		},
+	}
+
+	cases := map[string]struct {
+		request        kueue.PodSetTopologyRequest
+		count          int32
+		wantAttributes map[string]string
+		wantStatus     codes.Code
+	}{
+		"fit": {
+			request: kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			count: 2,
+			wantAttributes: map[string]string{
+				topologyRequiredAttribute:  tasRackLabel,
+				topologyPreferredAttribute: "",
+				topologyRequestsAttribute:  `["cpu=1"]`,
+				topologyCountAttribute:     "2",
+				topologyResultAttribute:    topologyResultFit,
+				topologyLevelAttribute:     tasRackLabel,
+				topologyDomainsAttribute:   "1",
+			},
+			wantStatus: codes.Unset,
+		},
+		"not fit": {
+			request: kueue.PodSetTopologyRequest{
+				Preferred: ptr.To(tasHostLabel),
+			},
+			count: 3,
+			wantAttributes: map[string]string{
+				topologyRequiredAttribute:  "",
+				topologyPreferredAttribute: tasHostLabel,
+				topologyRequestsAttribute:  `["cpu=1"]`,
+				topologyCountAttribute:     "3",
+				topologyResultAttribute:    string(InsufficientClusterCapacity),
+			},
+			wantStatus: codes.Error,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			exporter := tracetest.NewInMemoryExporter()
+			tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+			previousTracerProvider := otel.GetTracerProvider()
+			otel.SetTracerProvider(tracerProvider)
+			t.Cleanup(func() {
+				otel.SetTracerProvider(previousTracerProvider)
+			})
+
+			initialObjects := make([]client.Object, 0, len(nodes))
+			for i := range nodes {
+				initialObjects = append(initialObjects, &nodes[i])
+			}
+			tasCache := NewTASCache(utiltesting.NewFakeClient(initialObjects...))
+			snapshot := tasCache.NewTASFlavorCache(levels, nil).snapshot(ctx)
+			requests := resources.Requests{
+				corev1.ResourceCPU: 1000,
+			}
+			_, _ = snapshot.FindTopologyAssignment(&tc.request, requests, tc.count, WithContext(ctx))
+
+			spans := exporter.GetSpans()
+			if len(spans) != 1 {
+				t.Fatalf("unexpected number of spans, want=1, got=%d", len(spans))
+			}
+			if spans[0].Name != findTopologyAssignmentSpanName {
+				t.Errorf("unexpected span name, want=%q, got=%q", findTopologyAssignmentSpanName, spans[0].Name)
+			}
+			gotAttributes := make(map[string]string, len(spans[0].Attributes))
+			for _, kv := range spans[0].Attributes {
+				gotAttributes[string(kv.Key)] = kv.Value.Emit()
+			}
+			if diff := cmp.Diff(tc.wantAttributes, gotAttributes); diff != "" {
+				t.Errorf("unexpected span attributes (-want,+got): %s", diff)
+			}
+			if spans[0].Status.Code != tc.wantStatus {
+				t.Errorf("unexpected span status, want=%v, got=%v", tc.wantStatus, spans[0].Status.Code)
+			}
+		})
+	}
+}
+
+func TestShrinkReleaseOrder(t *testing.T) {
+	levels := []string{tasRackLabel, tasHostLabel}
+	//          r1           r2        r3
+	//        /    \        |       /    \
+	//     x1:4   x2:1    x3:2    x4:1   x5:1
+	assignment := &kueue.TopologyAssignment{
+		Levels: levels,
+		Domains: []kueue.TopologyDomainAssignment{
+			{Count: 4, Values: []string{"r1", "x1"}},
+			{Count: 1, Values: []string{"r1", "x2"}},
+			{Count: 2, Values: []string{"r2", "x3"}},
+			{Count: 1, Values: []string{"r3", "x4"}},
+			{Count: 1, Values: []string{"r3", "x5"}},
+		},
+	}
+
+	cases := map[string]struct {
+		targetCount int32
+		want        []kueue.TopologyDomainAssignment
+	}{
+		"the smallest racks are released first, keeping the largest rack intact": {
+			targetCount: 5,
+			want: []kueue.TopologyDomainAssignment{
+				{Count: 2, Values: []string{"r2", "x3"}},
+				{Count: 1, Values: []string{"r3", "x4"}},
+				{Count: 1, Values: []string{"r3", "x5"}},
+			},
+		},
+		"the smallest host is released first within the last rack": {
+			targetCount: 3,
+			want: []kueue.TopologyDomainAssignment{
+				{Count: 2, Values: []string{"r2", "x3"}},
+				{Count: 1, Values: []string{"r3", "x4"}},
+				{Count: 1, Values: []string{"r3", "x5"}},
+				{Count: 1, Values: []string{"r1", "x2"}},
+				{Count: 1, Values: []string{"r1", "x1"}},
+			},
+		},
+		"the pods of a domain are released partially": {
+			targetCount: 8,
+			want: []kueue.TopologyDomainAssignment{
+				{Count: 1, Values: []string{"r2", "x3"}},
+			},
+		},
+		"no pods are released when the target is not below the current count": {
+			targetCount: 9,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := ShrinkReleaseOrder(assignment, tc.targetCount)
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("unexpected release order (-want,+got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestPlacementStability(t *testing.T) {
+	levels := []string{tasRackLabel, tasHostLabel}
+	now := time.Now().Truncate(time.Second)
+	matureAge := 24 * time.Hour
+
+	cases := map[string]struct {
+		assignment *kueue.TopologyAssignment
+		matureAge  time.Duration
+		want       float64
+	}{
+		"tight fit": {
+			assignment: &kueue.TopologyAssignment{
+				Levels: levels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{Count: 4, Values: []string{"r1", "x1"}},
+				},
+			},
+			matureAge: matureAge,
+			want:      0,
+		},
+		"roomy fit": {
+			assignment: &kueue.TopologyAssignment{
+				Levels: levels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{Count: 4, Values: []string{"r1", "x2"}},
+				},
+			},
+			matureAge: matureAge,
+			want:      0.5,
+		},
+		"roomy fit on a young node": {
+			assignment: &kueue.TopologyAssignment{
+				Levels: levels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{Count: 4, Values: []string{"r1", "x3"}},
+				},
+			},
+			matureAge: matureAge,
+			want:      0.25,
+		},
+		"roomy fit on a young node, the maturity ignored": {
+			assignment: &kueue.TopologyAssignment{
+				Levels: levels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{Count: 4, Values: []string{"r1", "x3"}},
+				},
+			},
+			want: 0.5,
+		},
+		"tight and roomy fits weighted by the number of pods": {
+			assignment: &kueue.TopologyAssignment{
+				Levels: levels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{Count: 4, Values: []string{"r1", "x1"}},
+					{Count: 4, Values: []string{"r1", "x2"}},
+				},
+			},
+			matureAge: matureAge,
+			want:      0.25,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			tasCache := NewTASCache(utiltesting.NewFakeClient(
+				utiltesting.MakeNode("x1").
+					Label(tasRackLabel, "r1").
+					Label(tasHostLabel, "x1").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("4"),
+					}).
+					StatusConditions(corev1.NodeCondition{
+						Type:               corev1.NodeReady,
+						Status:             corev1.ConditionTrue,
+						LastTransitionTime: metav1.NewTime(now.Add(-48 * time.Hour)),
+					}).
+					Obj(),
+				utiltesting.MakeNode("x2").
+					Label(tasRackLabel, "r1").
+					Label(tasHostLabel, "x2").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("8"),
+					}).
+					StatusConditions(corev1.NodeCondition{
+						Type:               corev1.NodeReady,
+						Status:             corev1.ConditionTrue,
+						LastTransitionTime: metav1.NewTime(now.Add(-48 * time.Hour)),
+					}).
+					Obj(),
+				utiltesting.MakeNode("x3").
+					Label(tasRackLabel, "r1").
+					Label(tasHostLabel, "x3").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("8"),
+					}).
+					StatusConditions(corev1.NodeCondition{
+						Type:               corev1.NodeReady,
+						Status:             corev1.ConditionTrue,
+						LastTransitionTime: metav1.NewTime(now.Add(-12 * time.Hour)),
+					}).
+					Obj(),
+			))
+			snapshot := tasCache.NewTASFlavorCache(levels, nil).snapshot(ctx)
+			requests := resources.Requests{
+				corev1.ResourceCPU: 1000,
+			}
+			got := snapshot.PlacementStability(tc.assignment, requests, now, tc.matureAge)
+			if diff := cmp.Diff(tc.want, got, cmpopts.EquateApprox(0, 1e-6)); diff != "" {
+				t.Errorf("unexpected placement stability (-want,+got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestCompactionThreshold(t *testing.T) {
+	levels := []string{tasRackLabel, tasHostLabel}
+
+	cases := map[string]struct {
+		threshold      *float64
+		wantAssignment []*kueue.TopologyAssignment
+	}{
+		"crossing the threshold switches to tight packing for the next assignment": {
+			// the fragmentation is 0.25 for the first assignment, and 0.625
+			// for the second one
+			threshold: ptr.To(0.3),
+			wantAssignment: []*kueue.TopologyAssignment{
+				{
+					Levels: levels,
+					Domains: []kueue.TopologyDomainAssignment{
+						{Count: 2, Values: []string{"r1", "x1"}},
+					},
+				},
+				{
+					Levels: levels,
+					Domains: []kueue.TopologyDomainAssignment{
+						{Count: 2, Values: []string{"r2", "x2"}},
+					},
+				},
+			},
+		},
+		"the threshold exceeded from the start": {
+			threshold: ptr.To(0.2),
+			wantAssignment: []*kueue.TopologyAssignment{
+				{
+					Levels: levels,
+					Domains: []kueue.TopologyDomainAssignment{
+						{Count: 2, Values: []string{"r2", "x2"}},
+					},
+				},
+				{
+					Levels: levels,
+					Domains: []kueue.TopologyDomainAssignment{
+						{Count: 2, Values: []string{"r1", "x1"}},
+					},
+				},
+			},
+		},
+		"no compaction": {
+			wantAssignment: []*kueue.TopologyAssignment{
+				{
+					Levels: levels,
+					Domains: []kueue.TopologyDomainAssignment{
+						{Count: 2, Values: []string{"r1", "x1"}},
+					},
+				},
+				{
+					Levels: levels,
+					Domains: []kueue.TopologyDomainAssignment{
+						{Count: 2, Values: []string{"r1", "x1"}},
+					},
+				},
+			},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			tasCache := NewTASCache(utiltesting.NewFakeClient(
+				utiltesting.MakeNode("x1").
+					Label(tasRackLabel, "r1").
+					Label(tasHostLabel, "x1").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("8"),
+					}).
+					Obj(),
+				utiltesting.MakeNode("x2").
+					Label(tasRackLabel, "r2").
+					Label(tasHostLabel, "x2").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("8"),
+					}).
+					Obj(),
+			))
+			tasFlavorCache := tasCache.NewTASFlavorCache(levels, nil)
+			tasFlavorCache.SetCompactionThreshold(tc.threshold)
+			snapshot := tasFlavorCache.snapshot(ctx)
+			requests := resources.Requests{
+				corev1.ResourceCPU: 1000,
+			}
+			snapshot.addUsage("r2,x2", resources.Requests{corev1.ResourceCPU: 4000})
+
+			request := &kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			}
+			for i, wantAssignment := range tc.wantAssignment {
+				gotAssignment, err := snapshot.FindTopologyAssignment(request, requests, 2)
+				if err != nil {
+					t.Fatalf("unexpected error for the assignment %d: %v", i, err)
+				}
+				if diff := cmp.Diff(wantAssignment, gotAssignment); diff != "" {
+					t.Errorf("unexpected topology assignment %d (-want,+got): %s", i, diff)
+				}
+				snapshot.addAssignmentUsage(gotAssignment, requests)
+			}
+		})
+	}
+}
+
+func TestBurstHeadroom(t *testing.T) {
+	levels := []string{tasRackLabel, tasHostLabel}
+
+	cases := map[string]struct {
+		opts           []FindTopologyAssignmentOption
+		wantAssignment *kueue.TopologyAssignment
+		wantReason     TopologyAssignmentErrorReason
+	}{
+		"the burst headroom chooses the rack accommodating the burst": {
+			opts: []FindTopologyAssignmentOption{
+				WithBurstHeadroom(4),
+			},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: levels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{Count: 2, Values: []string{"r2", "x2"}},
+				},
+			},
+		},
+		"without the burst headroom the tightest rack is chosen": {
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: levels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{Count: 2, Values: []string{"r1", "x1"}},
+				},
+			},
+		},
+		"the burst headroom not exceeding the current count has no effect": {
+			opts: []FindTopologyAssignmentOption{
+				WithBurstHeadroom(1),
+			},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: levels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{Count: 2, Values: []string{"r1", "x1"}},
+				},
+			},
+		},
+		"the burst headroom not fitting in any rack": {
+			opts: []FindTopologyAssignmentOption{
+				WithBurstHeadroom(5),
+			},
+			wantReason: TopologyNotFit,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			tasCache := NewTASCache(utiltesting.NewFakeClient(
+				utiltesting.MakeNode("x1").
+					Label(tasRackLabel, "r1").
+					Label(tasHostLabel, "x1").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("2"),
+					}).
+					Obj(),
+				utiltesting.MakeNode("x2").
+					Label(tasRackLabel, "r2").
+					Label(tasHostLabel, "x2").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("5"),
+					}).
+					Obj(),
+			))
+			// the flavor is fragmented, so the pods are packed tightly
+			tasFlavorCache := tasCache.NewTASFlavorCache(levels, nil)
+			tasFlavorCache.SetCompactionThreshold(ptr.To(0.0))
+			snapshot := tasFlavorCache.snapshot(ctx)
+			requests := resources.Requests{
+				corev1.ResourceCPU: 1000,
+			}
+			snapshot.addUsage("r2,x2", requests)
+			request := &kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			}
+
+			const count = 2
+			gotAssignment, gotErr := snapshot.FindTopologyAssignment(request, requests, count, tc.opts...)
+			if diff := cmp.Diff(tc.wantAssignment, gotAssignment); diff != "" {
+				t.Errorf("unexpected topology assignment (-want,+got): %s", diff)
+			}
+			var gotReason TopologyAssignmentErrorReason
+			var assignmentErr *TopologyAssignmentError
+			if errors.As(gotErr, &assignmentErr) {
+				gotReason = assignmentErr.Reason
+			}
+			if gotReason != tc.wantReason {
+				t.Errorf("unexpected error reason, want=%q, got=%q (error: %v)", tc.wantReason, gotReason, gotErr)
+			}
+			if gotAssignment != nil {
+				var gotCount int32
+				for _, domain := range gotAssignment.Domains {
+					gotCount += domain.Count
+				}
+				if gotCount != count {
+					t.Errorf("unexpected number of the assigned pods, want=%d, got=%d", count, gotCount)
+				}
+			}
+		})
+	}
+}
+
+func TestNoSharedHost(t *testing.T) {
+	levels := []string{tasRackLabel, tasHostLabel}
+	makePodSet := func(count int32, group string, noSharedHostWith ...int) PodSetTopologyRequests {
+		podSet := PodSetTopologyRequests{
+			TopologyRequest: &kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			},
+			Requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			Count:            count,
+			NoSharedHostWith: noSharedHostWith,
+		}
+		if group != "" {
+			podSet.TopologyRequest.ColocationGroup = ptr.To(group)
+		}
+		return podSet
+	}
+	makeAssignment := func(count int32, values ...string) *kueue.TopologyAssignment {
+		return &kueue.TopologyAssignment{
+			Levels: levels,
+			Domains: []kueue.TopologyDomainAssignment{
+				{Count: count, Values: values},
+			},
+		}
+	}
+
+	cases := map[string]struct {
+		podSets         []PodSetTopologyRequests
+		wantAssignments []*kueue.TopologyAssignment
+		wantReason      TopologyAssignmentErrorReason
+	}{
+		"without the ban the PodSets share a host": {
+			podSets: []PodSetTopologyRequests{
+				makePodSet(2, "a"),
+				makePodSet(5, "a"),
+			},
+			wantAssignments: []*kueue.TopologyAssignment{
+				makeAssignment(2, "r1", "x1"),
+				{
+					Levels: levels,
+					Domains: []kueue.TopologyDomainAssignment{
+						{Count: 1, Values: []string{"r1", "x1"}},
+						{Count: 4, Values: []string{"r1", "x2"}},
+					},
+				},
+			},
+		},
+		"the banned PodSets stay in the rack on separate hosts": {
+			podSets: []PodSetTopologyRequests{
+				makePodSet(2, "a"),
+				makePodSet(2, "a", 0),
+			},
+			wantAssignments: []*kueue.TopologyAssignment{
+				makeAssignment(2, "r1", "x1"),
+				makeAssignment(2, "r1", "x2"),
+			},
+		},
+		"the ban declared on the first PodSet applies to the second": {
+			podSets: []PodSetTopologyRequests{
+				makePodSet(2, "", 1),
+				makePodSet(2, ""),
+			},
+			wantAssignments: []*kueue.TopologyAssignment{
+				makeAssignment(2, "r1", "x1"),
+				makeAssignment(2, "r1", "x2"),
+			},
+		},
+		"the banned PodSets don't fit on separate hosts of a rack": {
+			podSets: []PodSetTopologyRequests{
+				makePodSet(2, "a"),
+				makePodSet(5, "a", 0),
+			},
+			wantReason: TopologyNotFit,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			tasCache := NewTASCache(utiltesting.NewFakeClient(
+				utiltesting.MakeNode("x1").
+					Label(tasRackLabel, "r1").
+					Label(tasHostLabel, "x1").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("4"),
+					}).
+					Obj(),
+				utiltesting.MakeNode("x2").
+					Label(tasRackLabel, "r1").
+					Label(tasHostLabel, "x2").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("4"),
+					}).
+					Obj(),
+			))
+			snapshot := tasCache.NewTASFlavorCache(levels, nil).snapshot(ctx)
+			gotAssignments, gotErr := snapshot.FindTopologyAssignmentForPodSets(tc.podSets)
+			if diff := cmp.Diff(tc.wantAssignments, gotAssignments); diff != "" {
+				t.Errorf("unexpected topology assignments (-want,+got): %s", diff)
+			}
+			var gotReason TopologyAssignmentErrorReason
+			var assignmentErr *TopologyAssignmentError
+			if errors.As(gotErr, &assignmentErr) {
+				gotReason = assignmentErr.Reason
+			}
+			if gotReason != tc.wantReason {
+				t.Errorf("unexpected error reason, want=%q, got=%q (error: %v)", tc.wantReason, gotReason, gotErr)
+			}
+		})
+	}
+}
+
+func TestExternalReservations(t *testing.T) {
+	levels := []string{tasRackLabel, tasHostLabel}
+	makeAssignment := func(count int32, values ...string) *kueue.TopologyAssignment {
+		return &kueue.TopologyAssignment{
+			Levels: levels,
+			Domains: []kueue.TopologyDomainAssignment{
+				{Count: count, Values: values},
+			},
+		}
+	}
+
+	cases := map[string]struct {
+		reservations   map[utiltas.TopologyDomainID]corev1.ResourceList
+		wantAssignment *kueue.TopologyAssignment
+		wantReason     TopologyAssignmentErrorReason
+	}{
+		"without reservations the rack with the most free capacity is used": {
+			wantAssignment: makeAssignment(3, "r1", "x1"),
+		},
+		"the rack reservation moves the pods to the other rack": {
+			reservations: map[utiltas.TopologyDomainID]corev1.ResourceList{
+				utiltas.DomainID([]string{"r1"}): {
+					corev1.ResourceCPU: resource.MustParse("2"),
+				},
+			},
+			wantAssignment: makeAssignment(3, "r2", "x2"),
+		},
+		"the host reservation moves the pods to the other rack": {
+			reservations: map[utiltas.TopologyDomainID]corev1.ResourceList{
+				utiltas.DomainID([]string{"r1", "x1"}): {
+					corev1.ResourceCPU: resource.MustParse("2"),
+				},
+			},
+			wantAssignment: makeAssignment(3, "r2", "x2"),
+		},
+		"the reservations in both racks leave no room": {
+			reservations: map[utiltas.TopologyDomainID]corev1.ResourceList{
+				utiltas.DomainID([]string{"r1"}): {
+					corev1.ResourceCPU: resource.MustParse("2"),
+				},
+				utiltas.DomainID([]string{"r2"}): {
+					corev1.ResourceCPU: resource.MustParse("1"),
+				},
+			},
+			wantReason: TopologyNotFit,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			tasCache := NewTASCache(utiltesting.NewFakeClient(
+				utiltesting.MakeNode("x1").
+					Label(tasRackLabel, "r1").
+					Label(tasHostLabel, "x1").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("4"),
+					}).
+					Obj(),
+				utiltesting.MakeNode("x2").
+					Label(tasRackLabel, "r2").
+					Label(tasHostLabel, "x2").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("3"),
+					}).
+					Obj(),
+			))
+			tasFlavorCache := tasCache.NewTASFlavorCache(levels, nil)
+			tasFlavorCache.SetExternalReservations(tc.reservations)
+			snapshot := tasFlavorCache.snapshot(ctx)
+			request := &kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			}
+			requests := resources.Requests{
+				corev1.ResourceCPU: 1000,
+			}
+			gotAssignment, gotErr := snapshot.FindTopologyAssignment(request, requests, 3)
+			if diff := cmp.Diff(tc.wantAssignment, gotAssignment); diff != "" {
+				t.Errorf("unexpected topology assignment (-want,+got): %s", diff)
+			}
+			var gotReason TopologyAssignmentErrorReason
+			var assignmentErr *TopologyAssignmentError
+			if errors.As(gotErr, &assignmentErr) {
+				gotReason = assignmentErr.Reason
+			}
+			if gotReason != tc.wantReason {
+				t.Errorf("unexpected error reason, want=%q, got=%q (error: %v)", tc.wantReason, gotReason, gotErr)
+			}
+		})
+	}
+}
+
+func TestFindPodPlacement(t *testing.T) {
+	levels := []string{tasBlockLabel, tasRackLabel}
+	ctx := context.Background()
+	tasCache := NewTASCache(utiltesting.NewFakeClient(
+		utiltesting.MakeNode("x1").
+			Label(tasBlockLabel, "b1").
+			Label(tasRackLabel, "r1").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("2"),
+			}).
+			Obj(),
+		utiltesting.MakeNode("x2").
+			Label(tasBlockLabel, "b1").
+			Label(tasRackLabel, "r1").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("3"),
+			}).
+			Obj(),
+		utiltesting.MakeNode("x3").
+			Label(tasBlockLabel, "b1").
+			Label(tasRackLabel, "r2").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("2"),
+			}).
+			Obj(),
+	))
+	snapshot := tasCache.NewTASFlavorCache(levels, nil).snapshot(ctx)
+	request := &kueue.PodSetTopologyRequest{
+		Preferred: ptr.To(tasRackLabel),
+	}
+	requests := resources.Requests{
+		corev1.ResourceCPU: 1000,
+	}
+	const count = 6
+
+	gotPlacement, err := snapshot.FindPodPlacement(request, requests, count)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wantPlacement := &PodPlacement{
+		Assignment: &kueue.TopologyAssignment{
+			Levels: levels,
+			Domains: []kueue.TopologyDomainAssignment{
+				{Count: 5, Values: []string{"b1", "r1"}},
+				{Count: 1, Values: []string{"b1", "r2"}},
+			},
+		},
+		NodePerPod: []string{"x2", "x2", "x2", "x1", "x1", "x3"},
+	}
+	if diff := cmp.Diff(wantPlacement, gotPlacement); diff != "" {
+		t.Errorf("unexpected pod placement (-want,+got): %s", diff)
+	}
+
+	podsPerNode := make(map[string]int32)
+	for _, nodeName := range gotPlacement.NodePerPod {
+		podsPerNode[nodeName]++
+	}
+	if len(gotPlacement.NodePerPod) != count {
+		t.Errorf("unexpected number of placed pods, want=%d, got=%d", count, len(gotPlacement.NodePerPod))
+	}
+	if podsPerNode["x1"]+podsPerNode["x2"] != 5 || podsPerNode["x3"] != 1 {
+		t.Errorf("unexpected pods per node, not matching the assignment: %v", podsPerNode)
+	}
+
+	replayedPlacement, err := snapshot.FindPodPlacement(request, requests, count)
+	if err != nil {
+		t.Fatalf("unexpected error on replay: %v", err)
+	}
+	if diff := cmp.Diff(gotPlacement, replayedPlacement); diff != "" {
+		t.Errorf("unexpected change of the replayed pod placement (-first,+replayed): %s", diff)
+	}
+}
+
+func TestFindPodPlacementNotFittingNodes(t *testing.T) {
+	levels := []string{tasBlockLabel, tasRackLabel}
+	ctx := context.Background()
+	tasCache := NewTASCache(utiltesting.NewFakeClient(
+		utiltesting.MakeNode("x1").
+			Label(tasBlockLabel, "b1").
+			Label(tasRackLabel, "r1").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("1500m"),
+			}).
+			Obj(),
+		utiltesting.MakeNode("x2").
+			Label(tasBlockLabel, "b1").
+			Label(tasRackLabel, "r1").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("1500m"),
+			}).
+			Obj(),
+	))
+	snapshot := tasCache.NewTASFlavorCache(levels, nil).snapshot(ctx)
+	request := &kueue.PodSetTopologyRequest{
+		Required: ptr.To(tasRackLabel),
+	}
+	requests := resources.Requests{
+		corev1.ResourceCPU: 1000,
+	}
+
+	// The rack fits 3 pods in its aggregated capacity, while each of its
+	// nodes fits a single pod.
+	if _, err := snapshot.FindTopologyAssignment(request, requests, 3); err != nil {
+		t.Fatalf("unexpected error of the assignment: %v", err)
+	}
+	gotPlacement, err := snapshot.FindPodPlacement(request, requests, 3)
+	if gotPlacement != nil {
+		t.Errorf("unexpected pod placement on the nodes not fitting the pods: %v", gotPlacement.NodePerPod)
+	}
+	var assignmentErr *TopologyAssignmentError
+	if !errors.As(err, &assignmentErr) || assignmentErr.Reason != TopologyNotFit {
+		t.Errorf("unexpected error, want reason %q, got: %v", TopologyNotFit, err)
+	}
+
+	gotPlacement, err = snapshot.FindPodPlacement(request, requests, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]string{"x1", "x2"}, gotPlacement.NodePerPod); diff != "" {
+		t.Errorf("unexpected nodes of the pods (-want,+got): %s", diff)
+	}
+}
+
+func TestNodeBecomingNotReady(t *testing.T) {
+	levels := []string{tasBlockLabel, tasRackLabel}
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "b1-r1-x1",
+			Labels: map[string]string{
+				tasBlockLabel: "b1",
+				tasRackLabel:  "r1",
+			},
+		},
+		Status: corev1.NodeStatus{
+			Allocatable: corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("4"),
+			},
+			Conditions: []corev1.NodeCondition{
+				{
+					Type:   corev1.NodeReady,
+					Status: corev1.ConditionTrue,
+				},
+			},
+		},
+	}
+	ctx := context.Background()
+	client := utiltesting.NewFakeClient(node)
+	tasCache := NewTASCache(client)
+	tasFlavorCache := tasCache.NewTASFlavorCache(levels, nil)
+	request := &kueue.PodSetTopologyRequest{
+		Required: ptr.To(tasRackLabel),
+	}
+	requests := resources.Requests{
+		corev1.ResourceCPU: 1000,
+	}
+
+	if _, err := tasFlavorCache.snapshot(ctx).FindTopologyAssignment(request, requests, 2); err != nil {
+		t.Fatalf("unexpected error while the node is Ready: %v", err)
+	}
+
+	node.Status.Conditions[0].Status = corev1.ConditionUnknown
+	if err := client.Status().Update(ctx, node); err != nil {
+		t.Fatalf("failed to update the node: %v", err)
+	}
+	tasFlavorCache.UpdateNode(ctx, node)
+	_, gotErr := tasFlavorCache.snapshot(ctx).FindTopologyAssignment(request, requests, 2)
+	var assignmentErr *TopologyAssignmentError
+	if !errors.As(gotErr, &assignmentErr) || assignmentErr.Reason != NoMatchingNodes {
+		t.Errorf("expected the %q error after the node became NotReady, got: %v", NoMatchingNodes, gotErr)
+	}
+}
+
+func TestFindTopologyAssignments(t *testing.T) {
+	levels := []string{tasBlockLabel, tasRackLabel}
+	makeAssignment := func(count int32, rack string) *kueue.TopologyAssignment {
+		return &kueue.TopologyAssignment{
+			Levels: levels,
+			Domains: []kueue.TopologyDomainAssignment{
+				{Count: count, Values: []string{"b1", rack}},
+			},
+		}
+	}
+	driver := PodSetTopologyRequests{
+		TopologyRequest: &kueue.PodSetTopologyRequest{
+			Required: ptr.To(tasRackLabel),
+		},
+		Requests: resources.Requests{
+			corev1.ResourceCPU: 2000,
+		},
+		Count: 1,
+	}
+	workers := PodSetTopologyRequests{
+		TopologyRequest: &kueue.PodSetTopologyRequest{
+			Required: ptr.To(tasRackLabel),
+		},
+		Requests: resources.Requests{
+			corev1.ResourceCPU: 1000,
+		},
+		Count: 3,
+	}
+	ctx := context.Background()
+	tasCache := NewTASCache(utiltesting.NewFakeClient(
+		utiltesting.MakeNode("b1-r1").
+			Label(tasBlockLabel, "b1").
+			Label(tasRackLabel, "r1").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("4"),
+			}).
+			Obj(),
+		utiltesting.MakeNode("b1-r2").
+			Label(tasBlockLabel, "b1").
+			Label(tasRackLabel, "r2").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("3"),
+			}).
+			Obj(),
+	))
+	snapshot := tasCache.NewTASFlavorCache(levels, nil).snapshot(ctx)
+
+	// The independent assignments both use r1, overcommitting it by 1 CPU.
+	independentDriver, err := snapshot.FindTopologyAssignment(driver.TopologyRequest, driver.Requests, driver.Count)
+	if err != nil {
+		t.Fatalf("unexpected error for the driver: %v", err)
+	}
+	independentWorkers, err := snapshot.FindTopologyAssignment(workers.TopologyRequest, workers.Requests, workers.Count)
+	if err != nil {
+		t.Fatalf("unexpected error for the workers: %v", err)
+	}
+	if diff := cmp.Diff([]*kueue.TopologyAssignment{makeAssignment(1, "r1"), makeAssignment(3, "r1")},
+		[]*kueue.TopologyAssignment{independentDriver, independentWorkers}); diff != "" {
+		t.Errorf("unexpected independent topology assignments (-want,+got): %s", diff)
+	}
+
+	// The single call places the workers first, and the driver in the rack
+	// with the capacity left.
+	wantAssignments := []*kueue.TopologyAssignment{
+		makeAssignment(1, "r2"),
+		makeAssignment(3, "r1"),
+	}
+	wantFreeCapacity := snapshot.capacityPerLevel()
+	gotAssignments, gotFit := snapshot.FindTopologyAssignments([]PodSetTopologyRequests{driver, workers})
+	if !gotFit {
+		t.Fatalf("expected the PodSets to fit")
+	}
+	if diff := cmp.Diff(wantAssignments, gotAssignments); diff != "" {
+		t.Errorf("unexpected topology assignments (-want,+got): %s", diff)
+	}
+	if diff := cmp.Diff(wantFreeCapacity, snapshot.capacityPerLevel()); diff != "" {
+		t.Errorf("unexpected free capacity after the assignment (-want,+got): %s", diff)
+	}
+
+	// When the PodSets must coexist in the same rack, the rack which can
+	// accommodate all of them is used.
+	colocated := func(podSet PodSetTopologyRequests) PodSetTopologyRequests {
+		podSet.TopologyRequest = &kueue.PodSetTopologyRequest{
+			Required:        ptr.To(tasRackLabel),
+			ColocationGroup: ptr.To("job"),
+		}
+		return podSet
+	}
+	tasCache = NewTASCache(utiltesting.NewFakeClient(
+		utiltesting.MakeNode("b1-r1").
+			Label(tasBlockLabel, "b1").
+			Label(tasRackLabel, "r1").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("4"),
+			}).
+			Obj(),
+		utiltesting.MakeNode("b1-r2").
+			Label(tasBlockLabel, "b1").
+			Label(tasRackLabel, "r2").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("5"),
+			}).
+			Obj(),
+	))
+	snapshot = tasCache.NewTASFlavorCache(levels, nil).snapshot(ctx)
+	wantAssignments = []*kueue.TopologyAssignment{
+		makeAssignment(1, "r2"),
+		makeAssignment(3, "r2"),
+	}
+	gotAssignments, gotFit = snapshot.FindTopologyAssignments([]PodSetTopologyRequests{colocated(driver), colocated(workers)})
+	if !gotFit {
+		t.Fatalf("expected the colocated PodSets to fit")
+	}
+	if diff := cmp.Diff(wantAssignments, gotAssignments); diff != "" {
+		t.Errorf("unexpected colocated topology assignments (-want,+got): %s", diff)
+	}
+}
+
+func TestStableDomainOrder(t *testing.T) {
+	levels := []string{tasBlockLabel, tasRackLabel}
+	nodes := []corev1.Node{
+		*utiltesting.MakeNode("b1-r1").
+			Label(tasBlockLabel, "b1").
+			Label(tasRackLabel, "r1").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("1"),
+			}).
+			Obj(),
+		*utiltesting.MakeNode("b1-r2").
+			Label(tasBlockLabel, "b1").
+			Label(tasRackLabel, "r2").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("3"),
+			}).
+			Obj(),
+		*utiltesting.MakeNode("b2-r1").
+			Label(tasBlockLabel, "b2").
+			Label(tasRackLabel, "r1").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("2"),
+			}).
+			Obj(),
+		*utiltesting.MakeNode("b2-r2").
+			Label(tasBlockLabel, "b2").
+			Label(tasRackLabel, "r2").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("4"),
+			}).
+			Obj(),
+	}
+	request := &kueue.PodSetTopologyRequest{
+		Preferred: ptr.To(tasRackLabel),
+	}
+	requests := resources.Requests{
+		corev1.ResourceCPU: 1000,
+	}
+	wantAssignment := &kueue.TopologyAssignment{
+		Levels: levels,
+		Domains: []kueue.TopologyDomainAssignment{
+			{Count: 1, Values: []string{"b1", "r1"}},
+			{Count: 3, Values: []string{"b1", "r2"}},
+			{Count: 2, Values: []string{"b2", "r1"}},
+			{Count: 4, Values: []string{"b2", "r2"}},
+		},
+	}
+
+	ctx, log := utiltesting.ContextWithLog(t)
+	tasCache := NewTASCache(utiltesting.NewFakeClient())
+	tasFlavorCache := tasCache.NewTASFlavorCache(levels, nil)
+	random := rand.New(rand.NewSource(0))
+	for i := range 10 {
+		random.Shuffle(len(nodes), func(a, b int) {
+			nodes[a], nodes[b] = nodes[b], nodes[a]
+		})
+		snapshot := tasFlavorCache.snapshotForNodes(ctx, log, nodes)
+		gotAssignment, err := snapshot.FindTopologyAssignment(request, requests, 10)
+		if err != nil {
+			t.Fatalf("unexpected error on call %d: %v", i, err)
+		}
+		if diff := cmp.Diff(wantAssignment, gotAssignment); diff != "" {
+			t.Errorf("unexpected topology assignment on call %d (-want,+got): %s", i, diff)
+		}
+	}
+}
+
+func TestParallelDomainEvaluation(t *testing.T) {
+	levels := []string{tasBlockLabel, tasRackLabel, tasHostLabel}
+	random := rand.New(rand.NewSource(0))
+	var nodes []corev1.Node
+	for i := range 800 {
+		name := fmt.Sprintf("x%d", i)
+		nodes = append(nodes, corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+				Labels: map[string]string{
+					tasBlockLabel: fmt.Sprintf("b%d", i/8),
+					tasRackLabel:  fmt.Sprintf("r%d", i/4%2),
+					tasHostLabel:  name,
+				},
+			},
+			Status: corev1.NodeStatus{
+				Allocatable: corev1.ResourceList{
+					corev1.ResourceCPU: *resource.NewQuantity(int64(random.Intn(8)), resource.DecimalSI),
+				},
+			},
+		})
+	}
+	requests := resources.Requests{corev1.ResourceCPU: 1000}
+
+	cases := map[string]struct {
+		request kueue.PodSetTopologyRequest
+		count   int32
+		opts    []FindTopologyAssignmentOption
+	}{
+		"block required": {
+			request: kueue.PodSetTopologyRequest{Required: ptr.To(tasBlockLabel)},
+			count:   20,
+		},
+		"rack required": {
+			request: kueue.PodSetTopologyRequest{Required: ptr.To(tasRackLabel)},
+			count:   12,
+		},
+		"rack preferred": {
+			request: kueue.PodSetTopologyRequest{Preferred: ptr.To(tasRackLabel)},
+			count:   300,
+		},
+		"rack preferred; at most 2 pods per rack": {
+			request: kueue.PodSetTopologyRequest{Preferred: ptr.To(tasRackLabel)},
+			count:   50,
+			opts:    []FindTopologyAssignmentOption{WithMaxPodsPerDomain(tasRackLabel, 2)},
+		},
+		"block required; doesn't fit": {
+			request: kueue.PodSetTopologyRequest{Required: ptr.To(tasBlockLabel)},
+			count:   100,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ctx, log := utiltesting.ContextWithLog(t)
+			tasCache := NewTASCache(utiltesting.NewFakeClient())
+			tasFlavorCache := tasCache.NewTASFlavorCache(levels, nil)
+
+			serial := tasFlavorCache.snapshotForNodes(ctx, log, nodes)
+			serial.parallelThreshold = 0
+			wantAssignment, wantErr := serial.FindTopologyAssignment(&tc.request, requests, tc.count, tc.opts...)
+
+			parallel := tasFlavorCache.snapshotForNodes(ctx, log, nodes)
+			opts := append([]FindTopologyAssignmentOption{WithContext(ctx), WithParallelThreshold(1)}, tc.opts...)
+			gotAssignment, gotErr := parallel.FindTopologyAssignment(&tc.request, requests, tc.count, opts...)
+
+			if diff := cmp.Diff(wantAssignment, gotAssignment); diff != "" {
+				t.Errorf("unexpected topology assignment of the parallel evaluation (-serial,+parallel): %s", diff)
+			}
+			if diff := cmp.Diff(wantErr, gotErr); diff != "" {
+				t.Errorf("unexpected error of the parallel evaluation (-serial,+parallel): %s", diff)
+			}
+			if diff := cmp.Diff(serial.state, parallel.state); diff != "" {
+				t.Errorf("unexpected state of the domains of the parallel evaluation (-serial,+parallel): %s", diff)
+			}
+		})
+	}
+}
+
+func TestParallelDomainEvaluationCanceled(t *testing.T) {
+	levels := []string{tasRackLabel, tasHostLabel}
+	var nodes []corev1.Node
+	for i := range 4 {
+		name := fmt.Sprintf("x%d", i)
+		nodes = append(nodes, corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+				Labels: map[string]string{
+					tasRackLabel: fmt.Sprintf("r%d", i),
+					tasHostLabel: name,
+				},
+			},
+			Status: corev1.NodeStatus{
+				Allocatable: corev1.ResourceList{
+					corev1.ResourceCPU: resource.MustParse("2"),
+				},
+			},
+		})
+	}
+	ctx, log := utiltesting.ContextWithLog(t)
+	tasCache := NewTASCache(utiltesting.NewFakeClient())
+	snapshot := tasCache.NewTASFlavorCache(levels, nil).snapshotForNodes(ctx, log, nodes)
+	canceledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	request := &kueue.PodSetTopologyRequest{Required: ptr.To(tasRackLabel)}
+	requests := resources.Requests{corev1.ResourceCPU: 1000}
+	_, err := snapshot.FindTopologyAssignment(request, requests, 2, WithContext(canceledCtx), WithParallelThreshold(1))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("unexpected error, want=%v, got=%v", context.Canceled, err)
+	}
+}
+
+func TestRunningPodsConsumeCapacity(t *testing.T) {
+	levels := []string{tasRackLabel, tasHostLabel}
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "x1",
+			Labels: map[string]string{
+				tasRackLabel: "r1",
+				tasHostLabel: "x1",
+			},
+		},
+		Status: corev1.NodeStatus{
+			Allocatable: corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("2"),
+			},
+		},
+	}
+	makePod := func(phase corev1.PodPhase, podLabels map[string]string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "running",
+				Namespace: "default",
+				Labels:    podLabels,
+			},
+			Spec: corev1.PodSpec{
+				NodeName: "x1",
+				Containers: []corev1.Container{
+					{
+						Name: "c",
+						Resources: corev1.ResourceRequirements{
+							Requests: corev1.ResourceList{
+								corev1.ResourceCPU: resource.MustParse("1"),
+							},
+						},
+					},
+				},
+			},
+			Status: corev1.PodStatus{
+				Phase: phase,
+			},
+		}
+	}
+
+	cases := map[string]struct {
+		pod            *corev1.Pod
+		maxPods        string
+		requests       resources.Requests
+		wantAssignment *kueue.TopologyAssignment
+		wantReason     TopologyAssignmentErrorReason
+	}{
+		"running pod takes the last pod slot of the node": {
+			pod:     makePod(corev1.PodRunning, nil),
+			maxPods: "1",
+			requests: resources.Requests{
+				corev1.ResourceCPU: 500,
+			},
+			wantReason: InsufficientClusterCapacity,
+		},
+		"pod fits in the pod slot left by the running pod": {
+			pod:     makePod(corev1.PodRunning, nil),
+			maxPods: "2",
+			requests: resources.Requests{
+				corev1.ResourceCPU: 500,
+			},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: levels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{Count: 1, Values: []string{"r1", "x1"}},
+				},
+			},
+		},
+		"pod doesn't fit in the capacity left by the running pod": {
+			pod: makePod(corev1.PodRunning, nil),
+			requests: resources.Requests{
+				corev1.ResourceCPU: 2000,
+			},
+			wantReason: InsufficientClusterCapacity,
+		},
+		"pod fits in the capacity left by the running pod": {
+			pod: makePod(corev1.PodRunning, nil),
+			requests: resources.Requests{
+				corev1.ResourceCPU: 1000,
+			},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: levels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{Count: 1, Values: []string{"r1", "x1"}},
+				},
+			},
+		},
+		"finished pod doesn't consume the capacity": {
+			pod: makePod(corev1.PodSucceeded, nil),
+			requests: resources.Requests{
+				corev1.ResourceCPU: 2000,
+			},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: levels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{Count: 1, Values: []string{"r1", "x1"}},
+				},
+			},
+		},
+		"pod scheduled by TAS is accounted by the workload usage": {
+			pod: makePod(corev1.PodRunning, map[string]string{kueuealpha.TASLabel: "true"}),
+			requests: resources.Requests{
+				corev1.ResourceCPU: 2000,
+			},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: levels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{Count: 1, Values: []string{"r1", "x1"}},
+				},
+			},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			node := node.DeepCopy()
+			if tc.maxPods != "" {
+				node.Status.Allocatable[corev1.ResourcePods] = resource.MustParse(tc.maxPods)
+			}
+			tasCache := NewTASCache(utiltesting.NewFakeClient(node))
+			tasCache.UpdatePod(tc.pod)
+			tasFlavorCache := tasCache.NewTASFlavorCache(levels, nil)
+			request := &kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			}
+			gotAssignment, gotErr := tasFlavorCache.snapshot(ctx).FindTopologyAssignment(request, tc.requests, 1)
+			if diff := cmp.Diff(tc.wantAssignment, gotAssignment); diff != "" {
+				t.Errorf("unexpected topology assignment (-want,+got): %s", diff)
+			}
+			var gotReason TopologyAssignmentErrorReason
+			var assignmentErr *TopologyAssignmentError
+			if errors.As(gotErr, &assignmentErr) {
+				gotReason = assignmentErr.Reason
+			}
+			if gotReason != tc.wantReason {
+				t.Errorf("unexpected error reason, want=%q, got=%q (error: %v)", tc.wantReason, gotReason, gotErr)
+			}
+		})
+	}
+}
+
+func TestNonTASPodEvents(t *testing.T) {
+	levels := []string{tasRackLabel, tasHostLabel}
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "x1",
+			Labels: map[string]string{
+				tasRackLabel: "r1",
+				tasHostLabel: "x1",
+			},
+		},
+		Status: corev1.NodeStatus{
+			Allocatable: corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("4"),
+			},
+		},
+	}
+	makePod := func(name string, phase corev1.PodPhase) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "default",
+			},
+			Spec: corev1.PodSpec{
+				NodeName: "x1",
+				Containers: []corev1.Container{
+					{
+						Name: "c",
+						Resources: corev1.ResourceRequirements{
+							Requests: corev1.ResourceList{
+								corev1.ResourceCPU: resource.MustParse("1"),
+							},
+						},
+					},
+				},
+			},
+			Status: corev1.PodStatus{
+				Phase: phase,
+			},
+		}
+	}
+
+	ctx := context.Background()
+	cl := utiltesting.NewFakeClient(node)
+	tasCache := NewTASCache(cl)
+	// the watch starts with the events of the existing pods
+	tasCache.UpdatePod(makePod("listed", corev1.PodRunning))
+	tasFlavorCache := tasCache.NewTASFlavorCache(levels, nil)
+	freeCPU := func() int64 {
+		return tasFlavorCache.snapshot(ctx).DomainFreeCapacity(tasHostLabel)["r1,x1"][corev1.ResourceCPU]
+	}
+	if got := freeCPU(); got != 3000 {
+		t.Errorf("unexpected free CPU with the listed pod, want: 3000, got: %d", got)
+	}
+
+	// The pods are only maintained from the pod events, never listed.
+	if err := cl.Create(ctx, makePod("unseen", corev1.PodRunning)); err != nil {
+		t.Fatalf("failed to create the pod: %v", err)
+	}
+	if got := freeCPU(); got != 3000 {
+		t.Errorf("unexpected free CPU with the pod without an event, want: 3000, got: %d", got)
+	}
+	tasCache.UpdatePod(makePod("created", corev1.PodRunning))
+	if got := freeCPU(); got != 2000 {
+		t.Errorf("unexpected free CPU after the pod is created, want: 2000, got: %d", got)
+	}
+	tasCache.UpdatePod(makePod("created", corev1.PodSucceeded))
+	if got := freeCPU(); got != 3000 {
+		t.Errorf("unexpected free CPU after the pod succeeds, want: 3000, got: %d", got)
+	}
+	tasCache.DeletePod(types.NamespacedName{Namespace: "default", Name: "listed"})
+	if got := freeCPU(); got != 4000 {
+		t.Errorf("unexpected free CPU after the pod is deleted, want: 4000, got: %d", got)
+	}
+}
+
+func TestFindPreemptionCandidates(t *testing.T) {
+	levels := []string{tasRackLabel, tasHostLabel}
+	makePod := func(runningPod RunningPod) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      runningPod.Name,
+				Namespace: "default",
+			},
+			Spec: corev1.PodSpec{
+				NodeName: runningPod.NodeName,
+				Priority: ptr.To(runningPod.Priority),
+				Containers: []corev1.Container{
+					{
+						Name: "c",
+						Resources: corev1.ResourceRequirements{
+							Requests: runningPod.Requests.ToResourceList(),
+						},
+					},
+				},
+			},
+			Status: corev1.PodStatus{
+				Phase: corev1.PodRunning,
+			},
+		}
+	}
+	makeRunningPod := func(name, nodeName string, priority int32) RunningPod {
+		return RunningPod{
+			Name:     name,
+			NodeName: nodeName,
+			Priority: priority,
+			Requests: resources.Requests{corev1.ResourceCPU: 1000},
+		}
+	}
+
+	cases := map[string]struct {
+		running        []RunningPod
+		count          int32
+		wantCandidates []RunningPod
+		wantAssignment *kueue.TopologyAssignment
+	}{
+		"evicting one pod in a rack makes the pods fit": {
+			running: []RunningPod{
+				makeRunningPod("p1", "x1", 1),
+				makeRunningPod("p2", "x3", 0),
+				makeRunningPod("p3", "x4", 0),
+			},
+			count:          4,
+			wantCandidates: []RunningPod{makeRunningPod("p1", "x1", 1)},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: levels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{Count: 2, Values: []string{"r1", "x1"}},
+					{Count: 2, Values: []string{"r1", "x2"}},
+				},
+			},
+		},
+		"the pod with the lowest priority is preempted": {
+			running: []RunningPod{
+				makeRunningPod("p1", "x1", 5),
+				makeRunningPod("p2", "x1", 1),
+				makeRunningPod("p3", "x3", 0),
+				makeRunningPod("p4", "x3", 0),
+				makeRunningPod("p5", "x4", 0),
+				makeRunningPod("p6", "x4", 0),
+			},
+			count:          3,
+			wantCandidates: []RunningPod{makeRunningPod("p2", "x1", 1)},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: levels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{Count: 1, Values: []string{"r1", "x1"}},
+					{Count: 2, Values: []string{"r1", "x2"}},
+				},
+			},
+		},
+		"no preemptions are needed if the pods fit": {
+			running: []RunningPod{
+				makeRunningPod("p1", "x1", 1),
+			},
+			count: 3,
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: levels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{Count: 2, Values: []string{"r2", "x3"}},
+					{Count: 1, Values: []string{"r2", "x4"}},
+				},
+			},
+		},
+		"the pods don't fit in a rack even after preempting all the pods": {
+			running: []RunningPod{
+				makeRunningPod("p1", "x1", 1),
+				makeRunningPod("p2", "x3", 0),
+			},
+			count: 5,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			initialObjects := []client.Object{
+				utiltesting.MakeNode("x1").
+					Label(tasRackLabel, "r1").
+					Label(tasHostLabel, "x1").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("2"),
+					}).
+					Obj(),
+				utiltesting.MakeNode("x2").
+					Label(tasRackLabel, "r1").
+					Label(tasHostLabel, "x2").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("2"),
+					}).
+					Obj(),
+				utiltesting.MakeNode("x3").
+					Label(tasRackLabel, "r2").
+					Label(tasHostLabel, "x3").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("2"),
+					}).
+					Obj(),
+				utiltesting.MakeNode("x4").
+					Label(tasRackLabel, "r2").
+					Label(tasHostLabel, "x4").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("2"),
+					}).
+					Obj(),
+			}
+			tasCache := NewTASCache(utiltesting.NewFakeClient(initialObjects...))
+			for _, runningPod := range tc.running {
+				tasCache.UpdatePod(makePod(runningPod))
+			}
+			tasFlavorCache := tasCache.NewTASFlavorCache(levels, nil)
+			snapshot := tasFlavorCache.snapshot(ctx)
+			request := &kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			}
+			requests := resources.Requests{corev1.ResourceCPU: 1000}
+			freeCapacity := snapshot.DomainFreeCapacity(tasHostLabel)
+			gotCandidates, gotAssignment := snapshot.FindPreemptionCandidates(request, requests, tc.count, tc.running)
+			if diff := cmp.Diff(tc.wantCandidates, gotCandidates); diff != "" {
+				t.Errorf("unexpected preemption candidates (-want,+got): %s", diff)
+			}
+			if diff := cmp.Diff(tc.wantAssignment, gotAssignment); diff != "" {
+				t.Errorf("unexpected topology assignment (-want,+got): %s", diff)
+			}
+			if diff := cmp.Diff(freeCapacity, snapshot.DomainFreeCapacity(tasHostLabel)); diff != "" {
+				t.Errorf("unexpected change of the free capacity (-want,+got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestNodeNameForDomain(t *testing.T) {
+	cases := map[string]struct {
+		levels []string
+		values []string
+		want   string
+	}{
+		"host label value differs from the node name": {
+			levels: []string{tasRackLabel, tasHostLabel},
+			values: []string{"r1", "x1"},
+			want:   "r1-x1",
+		},
+		"the same host label value in another rack": {
+			levels: []string{tasRackLabel, tasHostLabel},
+			values: []string{"r2", "x1"},
+			want:   "r2-x2",
+		},
+		"unknown domain": {
+			levels: []string{tasRackLabel, tasHostLabel},
+			values: []string{"r2", "x3"},
+		},
+		"domain above the lowest level": {
+			levels: []string{tasRackLabel, tasHostLabel},
+			values: []string{"r1"},
+		},
+		"lowest level domain with multiple nodes": {
+			levels: []string{tasRackLabel},
+			values: []string{"r1"},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			tasCache := NewTASCache(utiltesting.NewFakeClient(
+				utiltesting.MakeNode("r1-x1").
+					Label(tasRackLabel, "r1").
+					Label(tasHostLabel, "x1").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("1"),
+					}).
+					Obj(),
+				utiltesting.MakeNode("r1-x2").
+					Label(tasRackLabel, "r1").
+					Label(tasHostLabel, "x2").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("1"),
+					}).
+					Obj(),
+				utiltesting.MakeNode("r2-x2").
+					Label(tasRackLabel, "r2").
+					Label(tasHostLabel, "x1").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("1"),
+					}).
+					Obj(),
+			))
+			tasFlavorCache := tasCache.NewTASFlavorCache(tc.levels, nil)
+			got := tasFlavorCache.snapshot(ctx).NodeNameForDomain(tc.values)
+			if got != tc.want {
+				t.Errorf("unexpected node name, want=%q, got=%q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestLevelValueNormalization(t *testing.T) {
+	levels := []string{tasRackLabel, tasHostLabel}
+
+	cases := map[string]struct {
+		opts           []TASFlavorCacheOption
+		wantAssignment *kueue.TopologyAssignment
+		wantReason     TopologyAssignmentErrorReason
+	}{
+		"the racks differing in the casing are separate without the normalization": {
+			wantReason: TopologyNotFit,
+		},
+		"the racks differing in the casing merge under the normalization": {
+			opts: []TASFlavorCacheOption{
+				WithLevelValueNormalization(func(value string) string {
+					return strings.ToLower(strings.TrimSpace(value))
+				}),
+			},
+			wantAssignment: &kueue.TopologyAssignment{
+				Levels: levels,
+				Domains: []kueue.TopologyDomainAssignment{
+					{Count: 1, Values: []string{"r2", "x1"}},
+					{Count: 1, Values: []string{"r2", "x2"}},
+				},
+			},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			tasCache := NewTASCache(utiltesting.NewFakeClient(
+				utiltesting.MakeNode("x1").
+					Label(tasRackLabel, "R2").
+					Label(tasHostLabel, "x1").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("1"),
+					}).
+					Obj(),
+				utiltesting.MakeNode("x2").
+					Label(tasRackLabel, "r2").
+					Label(tasHostLabel, "x2").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("1"),
+					}).
+					Obj(),
+			))
+			tasFlavorCache := tasCache.NewTASFlavorCache(levels, nil, tc.opts...)
+			request := &kueue.PodSetTopologyRequest{
+				Required: ptr.To(tasRackLabel),
+			}
+			requests := resources.Requests{corev1.ResourceCPU: 1000}
+			gotAssignment, gotErr := tasFlavorCache.snapshot(ctx).FindTopologyAssignment(request, requests, 2)
+			if diff := cmp.Diff(tc.wantAssignment, gotAssignment); diff != "" {
+				t.Errorf("unexpected topology assignment (-want,+got): %s", diff)
+			}
+			var gotReason TopologyAssignmentErrorReason
+			var assignmentErr *TopologyAssignmentError
+			if errors.As(gotErr, &assignmentErr) {
+				gotReason = assignmentErr.Reason
+			}
+			if gotReason != tc.wantReason {
+				t.Errorf("unexpected error reason, want=%q, got=%q (error: %v)", tc.wantReason, gotReason, gotErr)
+			}
+		})
+	}
+}
+
+func TestIncrementalNodeUpdates(t *testing.T) {
+	levels := []string{tasRackLabel, tasHostLabel}
+	cordoned := utiltesting.MakeNode("x2").
+		Label(tasRackLabel, "r1").
+		Label(tasHostLabel, "x2").
+		StatusAllocatable(corev1.ResourceList{
+			corev1.ResourceCPU: resource.MustParse("2"),
+		}).
+		Obj()
+	cordoned.Spec.Unschedulable = true
+	withoutRack := utiltesting.MakeNode("x4").
+		Label(tasRackLabel, "r2").
+		Label(tasHostLabel, "x4").
+		StatusAllocatable(corev1.ResourceList{
+			corev1.ResourceCPU: resource.MustParse("1"),
+		}).
+		Obj()
+	delete(withoutRack.Labels, tasRackLabel)
+
+	type nodeEvent struct {
+		add    *corev1.Node
+		update *corev1.Node
+		delete string
+	}
+	events := []nodeEvent{
+		{add: utiltesting.MakeNode("x3").
+			Label(tasRackLabel, "r2").
+			Label(tasHostLabel, "x3").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("4"),
+			}).
+			Obj()},
+		{update: utiltesting.MakeNode("x1").
+			Label(tasRackLabel, "r1").
+			Label(tasHostLabel, "x1").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("3"),
+			}).
+			Obj()},
+		{update: cordoned},
+		{delete: "x3"},
+		{add: utiltesting.MakeNode("x4").
+			Label(tasRackLabel, "r2").
+			Label(tasHostLabel, "x4").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("1"),
+			}).
+			Obj()},
+		{update: withoutRack},
+		{update: utiltesting.MakeNode("x2").
+			Label(tasRackLabel, "r1").
+			Label(tasHostLabel, "x2").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("2"),
+			}).
+			Obj()},
+	}
+
+	ctx, log := utiltesting.ContextWithLog(t)
+	tasCache := NewTASCache(utiltesting.NewFakeClient(
+		utiltesting.MakeNode("x1").
+			Label(tasRackLabel, "r1").
+			Label(tasHostLabel, "x1").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("2"),
+			}).
+			Obj(),
+		utiltesting.MakeNode("x2").
+			Label(tasRackLabel, "r1").
+			Label(tasHostLabel, "x2").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("2"),
+			}).
+			Obj(),
+	))
+	tasFlavorCache := tasCache.NewTASFlavorCache(levels, nil)
+	rebuildCache := tasCache.NewTASFlavorCache(levels, nil)
+	current := map[string]*corev1.Node{
+		"x1": utiltesting.MakeNode("x1").
+			Label(tasRackLabel, "r1").
+			Label(tasHostLabel, "x1").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("2"),
+			}).
+			Obj(),
+		"x2": utiltesting.MakeNode("x2").
+			Label(tasRackLabel, "r1").
+			Label(tasHostLabel, "x2").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("2"),
+			}).
+			Obj(),
+	}
+	// the first snapshot lists the nodes
+	tasFlavorCache.snapshot(ctx)
+
+	for i, event := range events {
+		switch {
+		case event.add != nil:
+			tasFlavorCache.AddNode(ctx, event.add)
+			current[event.add.Name] = event.add
+		case event.update != nil:
+			tasFlavorCache.UpdateNode(ctx, event.update)
+			current[event.update.Name] = event.update
+		default:
+			tasFlavorCache.DeleteNode(event.delete)
+			delete(current, event.delete)
+		}
+		nodes := make([]corev1.Node, 0, len(current))
+		for _, node := range current {
+			if rebuildCache.belongsToFlavor(node) {
+				nodes = append(nodes, *node)
+			}
+		}
+		want := rebuildCache.snapshotForNodes(ctx, log, nodes)
+		got := tasFlavorCache.snapshot(ctx)
+		if diff := cmp.Diff(want.nodes, got.nodes, cmp.AllowUnexported(nodeInfo{})); diff != "" {
+			t.Errorf("unexpected nodes after event %d (-want,+got): %s", i, diff)
+		}
+		if diff := cmp.Diff(want.nodesPerDomain, got.nodesPerDomain); diff != "" {
+			t.Errorf("unexpected nodes per domain after event %d (-want,+got): %s", i, diff)
+		}
+		if diff := cmp.Diff(want.freeCapacityPerDomain, got.freeCapacityPerDomain); diff != "" {
+			t.Errorf("unexpected free capacity after event %d (-want,+got): %s", i, diff)
+		}
+		if diff := cmp.Diff(want.capacityPerLevel(), got.capacityPerLevel()); diff != "" {
+			t.Errorf("unexpected capacity per level after event %d (-want,+got): %s", i, diff)
+		}
+	}
+}
+
+func TestNodeRelabel(t *testing.T) {
+	levels := []string{tasRackLabel, tasHostLabel}
+
+	ctx, _ := utiltesting.ContextWithLog(t)
+	tasCache := NewTASCache(utiltesting.NewFakeClient(
+		utiltesting.MakeNode("x1").
+			Label(tasRackLabel, "r1").
+			Label(tasHostLabel, "x1").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("2"),
+			}).
+			Obj(),
+		utiltesting.MakeNode("x2").
+			Label(tasRackLabel, "r2").
+			Label(tasHostLabel, "x2").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("2"),
+			}).
+			Obj(),
+	))
+	tasFlavorCache := tasCache.NewTASFlavorCache(levels, nil)
+	tasFlavorCache.addUsage("default/wl", []workload.TopologyDomainRequests{{
+		Values:   []string{"r1", "x1"},
+		Requests: resources.Requests{corev1.ResourceCPU: 1000},
+	}})
+	wantBefore := map[string]resources.Requests{
+		"r1": {corev1.ResourceCPU: 1000},
+		"r2": {corev1.ResourceCPU: 2000},
+	}
+	if diff := cmp.Diff(wantBefore, tasFlavorCache.snapshot(ctx).DomainFreeCapacity(tasRackLabel)); diff != "" {
+		t.Errorf("unexpected free capacity before the relabel (-want,+got): %s", diff)
+	}
+
+	// Relabel the last node out of the rack r1.
+	tasFlavorCache.UpdateNode(ctx, utiltesting.MakeNode("x1").
+		Label(tasRackLabel, "r3").
+		Label(tasHostLabel, "x1").
+		StatusAllocatable(corev1.ResourceList{
+			corev1.ResourceCPU: resource.MustParse("2"),
+		}).
+		Obj())
+	snapshot := tasFlavorCache.snapshot(ctx)
+	wantAfter := map[string]resources.Requests{
+		"r2": {corev1.ResourceCPU: 2000},
+		"r3": {corev1.ResourceCPU: 2000},
+	}
+	if diff := cmp.Diff(wantAfter, snapshot.DomainFreeCapacity(tasRackLabel)); diff != "" {
+		t.Errorf("unexpected free capacity after the relabel (-want,+got): %s", diff)
+	}
+	if _, found := snapshot.freeCapacityPerDomain["r1,x1"]; found {
+		t.Errorf("unexpected free capacity of the removed domain %q", "r1,x1")
+	}
+
+	request := &kueue.PodSetTopologyRequest{Required: ptr.To(tasRackLabel)}
+	gotAssignment, err := snapshot.FindTopologyAssignment(request, resources.Requests{corev1.ResourceCPU: 1000}, 4)
+	if err == nil {
+		t.Errorf("unexpected assignment to a rack fitting 4 pods: %v", gotAssignment)
+	}
+	gotAssignment, err = snapshot.FindTopologyAssignment(request, resources.Requests{corev1.ResourceCPU: 1000}, 2,
+		WithExcludedNodes("x2"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wantAssignment := &kueue.TopologyAssignment{
+		Levels: levels,
+		Domains: []kueue.TopologyDomainAssignment{
+			{Count: 2, Values: []string{"r3", "x1"}},
+		},
+	}
+	if diff := cmp.Diff(wantAssignment, gotAssignment); diff != "" {
+		t.Errorf("unexpected topology assignment (-want,+got): %s", diff)
+	}
+}
+
+func TestNodeCapacityWithoutLock(t *testing.T) {
+	levels := []string{tasRackLabel, tasHostLabel}
+
+	ctx, log := utiltesting.ContextWithLog(t)
+	tasCache := NewTASCache(utiltesting.NewFakeClient(utiltesting.MakeNode("x1").
+		Label(tasRackLabel, "r1").
+		Label(tasHostLabel, "x1").
+		StatusAllocatable(corev1.ResourceList{
+			corev1.ResourceCPU: resource.MustParse("2"),
+		}).
+		Obj()))
+	tasFlavorCache := tasCache.NewTASFlavorCache(levels, nil)
+	// the first snapshot lists the nodes, so that the node events are applied
+	tasFlavorCache.snapshot(ctx)
+	capacitySource := &lockProbeCapacitySource{flavor: tasFlavorCache}
+	tasFlavorCache.capacitySource = capacitySource
+
+	tasFlavorCache.AddNode(ctx, utiltesting.MakeNode("x2").
+		Label(tasRackLabel, "r2").
+		Label(tasHostLabel, "x2").
+		StatusAllocatable(corev1.ResourceList{
+			corev1.ResourceCPU: resource.MustParse("2"),
+		}).
+		Obj())
+	tasFlavorCache.UpdateNode(ctx, utiltesting.MakeNode("x2").
+		Label(tasRackLabel, "r3").
+		Label(tasHostLabel, "x2").
+		StatusAllocatable(corev1.ResourceList{
+			corev1.ResourceCPU: resource.MustParse("2"),
+		}).
+		Obj())
+	tasFlavorCache.snapshotForNodes(ctx, log, []corev1.Node{*utiltesting.MakeNode("x1").
+		Label(tasRackLabel, "r1").
+		Label(tasHostLabel, "x1").
+		StatusAllocatable(corev1.ResourceList{
+			corev1.ResourceCPU: resource.MustParse("2"),
+		}).
+		Obj()})
+	if len(capacitySource.lockedNodes) > 0 {
+		t.Errorf("unexpected capacity requests while holding the lock for the nodes: %v", capacitySource.lockedNodes)
+	}
+	wantFreeCapacity := map[string]resources.Requests{
+		"r1": {corev1.ResourceCPU: 2000},
+		"r3": {corev1.ResourceCPU: 2000},
+	}
+	if diff := cmp.Diff(wantFreeCapacity, tasFlavorCache.snapshot(ctx).DomainFreeCapacity(tasRackLabel)); diff != "" {
+		t.Errorf("unexpected free capacity (-want,+got): %s", diff)
+	}
+}
+
+func TestNodesMissingLevels(t *testing.T) {
+	const poolLabel = "cloud.com/pool"
+	levels := []string{tasBlockLabel, tasRackLabel, tasHostLabel}
+	nodes := []client.Object{
+		// the node doesn't have the tasHostLabel required by topology
+		utiltesting.MakeNode("b1-r1-x1").
+			Label(poolLabel, "tas").
+			Label(tasBlockLabel, "b1").
+			Label(tasRackLabel, "r1").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("1"),
+				corev1.ResourceMemory: resource.MustParse("1Gi"),
+			}).
+			Obj(),
+		utiltesting.MakeNode("b1-r2").
+			Label(poolLabel, "tas").
+			Label(tasBlockLabel, "b1").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("1"),
+				corev1.ResourceMemory: resource.MustParse("1Gi"),
+			}).
+			Obj(),
+		// the node doesn't match the node labels of the flavor
+		utiltesting.MakeNode("other").
+			Label(poolLabel, "other").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("1"),
+				corev1.ResourceMemory: resource.MustParse("1Gi"),
+			}).
+			Obj(),
+	}
+
+	ctx, _ := utiltesting.ContextWithLog(t)
+	tasCache := NewTASCache(utiltesting.NewFakeClient(nodes...))
+	tasFlavorCache := tasCache.NewTASFlavorCache(levels, map[string]string{poolLabel: "tas"})
+	snapshot := tasFlavorCache.snapshot(ctx)
+	wantBefore := map[string][]string{
+		"b1-r1-x1": {tasHostLabel},
+		"b1-r2":    {tasRackLabel, tasHostLabel},
+	}
+	if diff := cmp.Diff(wantBefore, snapshot.NodesMissingLevels()); diff != "" {
+		t.Errorf("unexpected nodes missing levels (-want,+got): %s", diff)
+	}
+	request := &kueue.PodSetTopologyRequest{Required: ptr.To(tasRackLabel)}
+	_, err := snapshot.FindTopologyAssignment(request, resources.Requests{corev1.ResourceCPU: 1000}, 1)
+	var assignmentErr *TopologyAssignmentError
+	if !errors.As(err, &assignmentErr) || assignmentErr.Reason != NoMatchingNodes {
+		t.Errorf("unexpected error, want reason %q, got: %v", NoMatchingNodes, err)
+	}
+
+	// Fix the labels of the first node, and delete the second one.
+	tasFlavorCache.UpdateNode(ctx, utiltesting.MakeNode("b1-r1-x1").
+		Label(poolLabel, "tas").
+		Label(tasBlockLabel, "b1").
+		Label(tasRackLabel, "r1").
+		Label(tasHostLabel, "x1").
+		StatusAllocatable(corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("1"),
+			corev1.ResourceMemory: resource.MustParse("1Gi"),
+		}).
+		Obj())
+	tasFlavorCache.DeleteNode("b1-r2")
+	snapshot = tasFlavorCache.snapshot(ctx)
+	if diff := cmp.Diff(map[string][]string{}, snapshot.NodesMissingLevels()); diff != "" {
+		t.Errorf("unexpected nodes missing levels after the fix (-want,+got): %s", diff)
+	}
+	if _, err := snapshot.FindTopologyAssignment(request, resources.Requests{corev1.ResourceCPU: 1000}, 1); err != nil {
+		t.Errorf("unexpected error after the fix: %v", err)
+	}
+}
+
+func TestNodesWithDuplicateHostExcluded(t *testing.T) {
+	levels := []string{tasHostLabel}
+
+	ctx, _ := utiltesting.ContextWithLog(t)
+	tasCache := NewTASCache(utiltesting.NewFakeClient(
+		utiltesting.MakeNode("r1-x1").
+			Label(tasRackLabel, "r1").
+			Label(tasHostLabel, "x1").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("1"),
+			}).
+			Obj(),
+		utiltesting.MakeNode("r2-x1").
+			Label(tasRackLabel, "r2").
+			Label(tasHostLabel, "x1").
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("1"),
+			}).
+			Obj(),
+	))
+	tasFlavorCache := tasCache.NewTASFlavorCache(levels, nil)
+	snapshot := tasFlavorCache.snapshot(ctx)
+	wantBefore := map[string]string{
+		"r1-x1": "x1",
+		"r2-x1": "x1",
+	}
+	if diff := cmp.Diff(wantBefore, snapshot.NodesWithDuplicateHost()); diff != "" {
+		t.Errorf("unexpected nodes with duplicate host (-want,+got): %s", diff)
+	}
+	request := &kueue.PodSetTopologyRequest{Required: ptr.To(tasHostLabel)}
+	_, err := snapshot.FindTopologyAssignment(request, resources.Requests{corev1.ResourceCPU: 1000}, 1)
+	var assignmentErr *TopologyAssignmentError
+	if !errors.As(err, &assignmentErr) || assignmentErr.Reason != NoMatchingNodes {
+		t.Errorf("unexpected error, want reason %q, got: %v", NoMatchingNodes, err)
+	}
+
+	// Fix the label of the second node.
+	tasFlavorCache.UpdateNode(ctx, utiltesting.MakeNode("r2-x1").
+		Label(tasRackLabel, "r2").
+		Label(tasHostLabel, "x2").
+		StatusAllocatable(corev1.ResourceList{
+			corev1.ResourceCPU: resource.MustParse("1"),
+		}).
+		Obj())
+	snapshot = tasFlavorCache.snapshot(ctx)
+	if diff := cmp.Diff(map[string]string{}, snapshot.NodesWithDuplicateHost()); diff != "" {
+		t.Errorf("unexpected nodes with duplicate host after the fix (-want,+got): %s", diff)
+	}
+	gotAssignment, err := snapshot.FindTopologyAssignment(request, resources.Requests{corev1.ResourceCPU: 1000}, 1)
+	if err != nil {
+		t.Fatalf("unexpected error after the fix: %v", err)
+	}
+	// the values of the assignment are the values of the host label, which
+	// the pods use as their node selector
+	wantAssignment := &kueue.TopologyAssignment{
+		Levels: levels,
+		Domains: []kueue.TopologyDomainAssignment{
+			{Count: 1, Values: []string{"x1"}},
+		},
+	}
+	if diff := cmp.Diff(wantAssignment, gotAssignment); diff != "" {
+		t.Errorf("unexpected topology assignment (-want,+got): %s", diff)
+	}
+}
+
+func BenchmarkTASFlavorCacheSnapshot(b *testing.B) {
+	const nodeCount = 5000
+	levels := []string{tasBlockLabel, tasRackLabel, tasHostLabel}
+	objects := make([]client.Object, 0, nodeCount)
+	for i := range nodeCount {
+		name := fmt.Sprintf("x%d", i)
+		objects = append(objects, utiltesting.MakeNode(name).
+			Label(tasBlockLabel, fmt.Sprintf("b%d", i/500)).
+			Label(tasRackLabel, fmt.Sprintf("r%d", i/25)).
+			Label(tasHostLabel, name).
+			StatusAllocatable(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("8"),
+			}).
+			Obj())
+	}
+	ctx := context.Background()
+	tasCache := NewTASCache(utiltesting.NewFakeClient(objects...))
+
+	b.Run("full rebuild", func(b *testing.B) {
+		tasFlavorCache := tasCache.NewTASFlavorCache(levels, nil)
+		for range b.N {
+			tasFlavorCache.Lock()
+			tasFlavorCache.nodes = nil
+			tasFlavorCache.Unlock()
+			tasFlavorCache.snapshot(ctx)
+		}
+	})
+	b.Run("incremental", func(b *testing.B) {
+		tasFlavorCache := tasCache.NewTASFlavorCache(levels, nil)
+		tasFlavorCache.snapshot(ctx)
+		b.ResetTimer()
+		for i := range b.N {
+			node := objects[i%nodeCount].(*corev1.Node).DeepCopy()
+			node.Status.Allocatable[corev1.ResourceCPU] = resource.MustParse("4")
+			tasFlavorCache.UpdateNode(ctx, node)
+			tasFlavorCache.snapshot(ctx)
+		}
+	})
+}
+
+func BenchmarkFindTopologyAssignment(b *testing.B) {
+	const nodeCount = 5000
+	levels := []string{tasBlockLabel, tasRackLabel, tasHostLabel}
+	nodes := make([]corev1.Node, 0, nodeCount)
+	for i := range nodeCount {
+		name := fmt.Sprintf("x%d", i)
+		nodes = append(nodes, corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+				Labels: map[string]string{
+					tasBlockLabel: fmt.Sprintf("b%d", i/500),
+					tasRackLabel:  fmt.Sprintf("r%d", i/25),
+					tasHostLabel:  name,
+				},
+			},
+			Status: corev1.NodeStatus{
+				Allocatable: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("7500m"),
+					corev1.ResourceMemory: resource.MustParse("32Gi"),
+				},
+			},
+		})
+	}
+	ctx := context.Background()
+	tasCache := NewTASCache(utiltesting.NewFakeClient())
+	snapshot := tasCache.NewTASFlavorCache(levels, nil).snapshotForNodes(ctx, logr.Discard(), nodes)
+	request := &kueue.PodSetTopologyRequest{
+		Required: ptr.To(tasBlockLabel),
+	}
+	requests := resources.Requests{
+		corev1.ResourceCPU:    1500,
+		corev1.ResourceMemory: 4 * 1024 * 1024 * 1024,
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		if _, err := snapshot.FindTopologyAssignment(request, requests, 100); err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func BenchmarkFindTopologyAssignmentWide(b *testing.B) {
+	const nodeCount = 20000
+	levels := []string{tasBlockLabel, tasRackLabel, tasHostLabel}
+	nodes := make([]corev1.Node, 0, nodeCount)
+	for i := range nodeCount {
+		name := fmt.Sprintf("x%d", i)
+		nodes = append(nodes, corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+				Labels: map[string]string{
+					tasBlockLabel: fmt.Sprintf("b%d", i/8),
+					tasRackLabel:  fmt.Sprintf("r%d", i/4),
+					tasHostLabel:  name,
+				},
+			},
+			Status: corev1.NodeStatus{
+				Allocatable: corev1.ResourceList{
+					corev1.ResourceCPU: resource.MustParse("8"),
+				},
+			},
+		})
+	}
+	ctx := context.Background()
+	tasCache := NewTASCache(utiltesting.NewFakeClient())
+	request := &kueue.PodSetTopologyRequest{
+		Required: ptr.To(tasBlockLabel),
+	}
+	requests := resources.Requests{
+		corev1.ResourceCPU: 1000,
+	}
+
+	for name, parallelThreshold := range map[string]int{"serial": 0, "parallel": 1} {
+		b.Run(name, func(b *testing.B) {
+			snapshot := tasCache.NewTASFlavorCache(levels, nil).snapshotForNodes(ctx, logr.Discard(), nodes)
+			snapshot.parallelThreshold = parallelThreshold
+			b.ReportAllocs()
+			b.ResetTimer()
+			for range b.N {
+				if _, err := snapshot.FindTopologyAssignment(request, requests, 64); err != nil {
+					b.Fatalf("unexpected error: %v", err)
+				}
 			}
 		})
 	}
@@ -18,15 +18,26 @@ package cache
 
 import (
 	"context"
+	"fmt"
 	"maps"
+	"math"
 	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
+	kueuealpha "sigs.k8s.io/kueue/apis/kueue/v1alpha1"
 	"sigs.k8s.io/kueue/pkg/resources"
 	utiltas "sigs.k8s.io/kueue/pkg/util/tas"
 	"sigs.k8s.io/kueue/pkg/workload"
@@ -42,78 +53,849 @@ const (
 	subtract usageOp = false
 )
 
+// CapacitySource provides the schedulable capacity of the nodes used by
+// Topology Aware Scheduling, for example when the capacity is tracked in a
+// custom resource rather than in the node status.
+type CapacitySource interface {
+	// NodeCapacity returns the schedulable capacity of the node.
+	NodeCapacity(ctx context.Context, node *corev1.Node) (corev1.ResourceList, error)
+}
+
+// allocatableCapacitySource is the default CapacitySource, which uses the
+// node Allocatable.
+type allocatableCapacitySource struct{}
+
+func (allocatableCapacitySource) NodeCapacity(_ context.Context, node *corev1.Node) (corev1.ResourceList, error) {
+	return node.Status.Allocatable, nil
+}
+
 type TASFlavorCache struct {
 	sync.RWMutex
 
-	client client.Client
+	client         client.Client
+	capacitySource CapacitySource
+
+	// nonTASPods maintains the requests of the pods which aren't scheduled
+	// by TAS per node, shared by the flavors.
+	nonTASPods *nonTASPods
+
+	// pressureConditions are the node conditions which, when true, exclude
+	// the node from the capacity.
+	pressureConditions []corev1.NodeConditionType
+
+	// includeUnschedulable indicates the cordoned and the NotReady nodes are
+	// included in the capacity.
+	includeUnschedulable bool
+
+	// reservationFraction is the fraction of the capacity of each node which
+	// is left unassigned, as the headroom for the scaling within the domain.
+	reservationFraction float64
+
+	// subHostLevel is the lowest level whose domains partition the nodes by
+	// their NVLink groups.
+	subHostLevel string
 
 	// nodeLabels is a map of nodeLabels defined in the ResourceFlavor object.
 	NodeLabels map[string]string
+
+	// nodeSelector further restricts the nodes of the flavor, beyond the
+	// equality of the NodeLabels.
+	nodeSelector labels.Selector
+
+	// nodeAnnotations further restricts the nodes of the flavor to the ones
+	// with the annotations of the given values.
+	nodeAnnotations map[string]string
+
 	// levels is a list of levels defined in the Topology object referenced
 	// by the flavor corresponding to the cache.
 	Levels []string
 
+	// compactionThreshold is the average fragmentation of the lowest level
+	// domains above which the assignment packs the pods tightly.
+	compactionThreshold *float64
+
+	// annotationLevels are the levels whose values are read from the node
+	// annotations rather than from the node labels.
+	annotationLevels sets.Set[string]
+
+	// normalizeLevelValue, if set, is applied to the level values of the
+	// nodes.
+	normalizeLevelValue func(string) string
+
 	// usage maintains the usage per topology domain
 	usage map[utiltas.TopologyDomainID]resources.Requests
+
+	// reservations maintains the usage of each workload, by the workload key,
+	// so that the usage of a workload is accounted at most once, also when
+	// it is restored after the cache is rebuilt.
+	reservations map[string][]workload.TopologyDomainRequests
+
+	// pendingNodes are the nodes which are not yet in the cluster, but are
+	// expected to join it, for example once a ProvisioningRequest completes.
+	pendingNodes []PendingNode
+
+	// externalReservations holds the capacity reserved by other controllers
+	// in the topology domains, at any level, keyed by the domain ID.
+	externalReservations map[utiltas.TopologyDomainID]resources.Requests
+
+	// nodes maintains the nodes of the flavor by the node name, updated
+	// incrementally on the node events. The nil map means the nodes are
+	// listed on the next snapshot.
+	nodes map[string]*nodeEntry
+
+	// nodesMissingLevels maintains the label levels missing on the nodes
+	// which match the node labels of the flavor, by the node name, along
+	// with the nodes.
+	nodesMissingLevels map[string][]string
+}
+
+// PendingNode describes a node which is expected to join the cluster, for
+// example as a result of an in-flight ProvisioningRequest.
+type PendingNode struct {
+	Name        string
+	Labels      map[string]string
+	Annotations map[string]string
+	Capacity    corev1.ResourceList
 }
 
-func (t *TASCache) NewTASFlavorCache(labels []string, nodeLabels map[string]string) *TASFlavorCache {
-	return &TASFlavorCache{
-		client:     t.client,
-		Levels:     slices.Clone(labels),
-		NodeLabels: maps.Clone(nodeLabels),
-		usage:      make(map[utiltas.TopologyDomainID]resources.Requests),
+// asNode returns the pending node as a node, so that it is matched and its
+// level values are resolved the same way as for the listed nodes.
+func (n *PendingNode) asNode() *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        n.Name,
+			Labels:      n.Labels,
+			Annotations: n.Annotations,
+		},
 	}
 }
 
-func (c *TASFlavorCache) snapshot(ctx context.Context) *TASFlavorSnapshot {
+// TASFlavorCacheOption configures the TASFlavorCache.
+type TASFlavorCacheOption func(*TASFlavorCache)
+
+// WithIncludeUnschedulable makes the snapshots include the capacity of the
+// cordoned nodes and of the nodes which are not Ready. By default, such nodes
+// are excluded.
+func WithIncludeUnschedulable(include bool) TASFlavorCacheOption {
+	return func(c *TASFlavorCache) {
+		c.includeUnschedulable = include
+	}
+}
+
+// WithReservationFraction makes the snapshots leave the given fraction of
+// the capacity of each node unassigned, as the headroom for the workloads
+// scaling within the domain. For example, the fraction of 0.25 leaves 3 CPU
+// usable on a node with 4 CPU. The fractions outside of [0, 1) are ignored.
+func WithReservationFraction(fraction float64) TASFlavorCacheOption {
+	return func(c *TASFlavorCache) {
+		if fraction >= 0 && fraction < 1 {
+			c.reservationFraction = fraction
+		}
+	}
+}
+
+// WithSubHostLevel declares the lowest topology level, for example
+// nvidia.com/gpu.clique, as a level below the host whose domains are the
+// NVLink groups of the node, based on the NodeNVLinkGroupsAnnotation. The
+// domains are identified by the index of the group within the node, and each
+// of them is assigned the share of the node capacity proportional to its
+// number of GPUs. The node without the NVLink groups forms a single domain.
+// The level is ignored unless it is the lowest level of the topology.
+func WithSubHostLevel(levelKey string) TASFlavorCacheOption {
+	return func(c *TASFlavorCache) {
+		c.subHostLevel = levelKey
+	}
+}
+
+// WithNodeSelector restricts the nodes of the flavor to the ones matching
+// the selector, in addition to the node labels, which allows the set-based
+// requirements such as In, NotIn or Exists. The nil selector doesn't restrict
+// the nodes, while the invalid selector matches no nodes.
+func WithNodeSelector(selector *metav1.LabelSelector) TASFlavorCacheOption {
+	return func(c *TASFlavorCache) {
+		if selector == nil {
+			c.nodeSelector = nil
+			return
+		}
+		nodeSelector, err := metav1.LabelSelectorAsSelector(selector)
+		if err != nil {
+			nodeSelector = labels.Nothing()
+		}
+		c.nodeSelector = nodeSelector
+	}
+}
+
+// WithNodeAnnotations restricts the nodes of the flavor to the ones with all
+// the given annotations, with the given values, analogously to the node
+// labels, for example to offer only a canary or reserved pool of nodes. The
+// pending nodes, which have no annotations, are then excluded.
+func WithNodeAnnotations(annotations map[string]string) TASFlavorCacheOption {
+	return func(c *TASFlavorCache) {
+		c.nodeAnnotations = maps.Clone(annotations)
+	}
+}
+
+// WithLevelValueNormalization makes the snapshots apply the function to the
+// level values of the nodes, for example to trim and lowercase them, so that
+// the nodes whose labels differ only in the casing or the whitespace share
+// the domains. The assignments then hold the normalized values. By default,
+// the values are used as is.
+func WithLevelValueNormalization(normalize func(string) string) TASFlavorCacheOption {
+	return func(c *TASFlavorCache) {
+		c.normalizeLevelValue = normalize
+	}
+}
+
+func (t *TASCache) NewTASFlavorCache(labels []string, nodeLabels map[string]string, opts ...TASFlavorCacheOption) *TASFlavorCache {
+	c := &TASFlavorCache{
+		client:             t.client,
+		capacitySource:     t.capacitySource,
+		pressureConditions: t.pressureConditions,
+		nonTASPods:         t.nonTASPods,
+		Levels:             slices.Clone(labels),
+		NodeLabels:         maps.Clone(nodeLabels),
+		usage:              make(map[utiltas.TopologyDomainID]resources.Requests),
+		reservations:       make(map[string][]workload.TopologyDomainRequests),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// SetPendingNodes replaces the set of pending nodes whose capacity is
+// included in the subsequent snapshots. The topology domains relying on the
+// pending nodes are reported as provisional by the snapshot.
+func (c *TASFlavorCache) SetPendingNodes(nodes []PendingNode) {
+	c.Lock()
+	defer c.Unlock()
+	c.pendingNodes = slices.Clone(nodes)
+}
+
+// SetExternalReservations replaces the capacity reserved by other
+// controllers, for example a batch operator, in the topology domains. The
+// reservations are keyed by the ID of the domain, at any level, and the
+// reserved capacity is not available to the assignments made on the
+// subsequent snapshots.
+func (c *TASFlavorCache) SetExternalReservations(reservations map[utiltas.TopologyDomainID]corev1.ResourceList) {
+	c.Lock()
+	defer c.Unlock()
+	c.externalReservations = make(map[utiltas.TopologyDomainID]resources.Requests, len(reservations))
+	for domainID, reserved := range reservations {
+		c.externalReservations[domainID] = resources.NewRequests(reserved)
+	}
+}
+
+// SetAnnotationLevels declares the levels whose values are read from the
+// node annotations rather than from the node labels.
+func (c *TASFlavorCache) SetAnnotationLevels(levelKeys ...string) {
+	c.Lock()
+	defer c.Unlock()
+	c.annotationLevels = sets.New(levelKeys...)
+	// the level values of the nodes depend on the annotation levels
+	c.nodes = nil
+}
+
+// SetCompactionThreshold sets the average fragmentation of the lowest level
+// domains, between 0 and 1, above which the assignment switches from
+// choosing the domains with the most free capacity to packing the pods
+// tightly, to compact the flavor. The nil threshold disables the compaction.
+func (c *TASFlavorCache) SetCompactionThreshold(threshold *float64) {
+	c.Lock()
+	defer c.Unlock()
+	c.compactionThreshold = threshold
+}
+
+// CompactionThreshold returns the compaction threshold of the flavor, or nil
+// if the compaction is disabled.
+func (c *TASFlavorCache) CompactionThreshold() *float64 {
+	c.RLock()
+	defer c.RUnlock()
+	return c.compactionThreshold
+}
+
+// AnnotationLevels returns the levels whose values are read from the node
+// annotations, sorted by the level key.
+func (c *TASFlavorCache) AnnotationLevels() []string {
+	c.RLock()
+	defer c.RUnlock()
+	return sets.List(c.annotationLevels)
+}
+
+// LabelLevels returns the levels whose values are read from the node labels.
+func (c *TASFlavorCache) LabelLevels() []string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.labelLevels()
+}
+
+func (c *TASFlavorCache) labelLevels() []string {
+	return slices.DeleteFunc(slices.Clone(c.Levels), func(levelKey string) bool {
+		return c.annotationLevels.Has(levelKey) || (c.hasSubHostLevel() && levelKey == c.subHostLevel)
+	})
+}
+
+// hasSubHostLevel checks if the lowest level of the topology partitions the
+// nodes by their NVLink groups.
+func (c *TASFlavorCache) hasSubHostLevel() bool {
+	return c.subHostLevel != "" && len(c.Levels) > 0 && c.Levels[len(c.Levels)-1] == c.subHostLevel
+}
+
+// levelValues returns the values of the levels for the node, the values of
+// the annotation levels are read from the node annotations.
+func (c *TASFlavorCache) levelValues(node *corev1.Node) []string {
+	result := utiltas.LevelValues(c.Levels, node.Labels)
+	for levelIdx, levelKey := range c.Levels {
+		if c.annotationLevels.Has(levelKey) {
+			result[levelIdx] = node.Annotations[levelKey]
+		}
+	}
+	c.normalizeLevelValues(result)
+	return result
+}
+
+// normalizeLevelValues applies the normalization, if any, to the level
+// values in place.
+func (c *TASFlavorCache) normalizeLevelValues(levelValues []string) {
+	if c.normalizeLevelValue == nil {
+		return
+	}
+	for i := range levelValues {
+		levelValues[i] = c.normalizeLevelValue(levelValues[i])
+	}
+}
+
+// CapacityPerLevel returns the total and free capacity of the topology
+// domains at each level, based on the current state of the cluster.
+func (c *TASFlavorCache) CapacityPerLevel(ctx context.Context) [][]DomainCapacity {
+	return c.snapshot(ctx).capacityPerLevel()
+}
+
+// CoTenancyExclusion declares the job classes with which a workload must not
+// share a node. The job class of a pod is the value of its label with the
+// given key.
+type CoTenancyExclusion struct {
+	// LabelKey is the key of the pod label holding the job class.
+	LabelKey string
+
+	// Classes are the excluded job classes.
+	Classes []string
+}
+
+// NodesHostingExcludedClasses returns the names of the nodes hosting running
+// pods of the job classes excluded by the co-tenancy exclusion. The result is
+// meant to be passed to WithExcludedNodes.
+func (c *TASFlavorCache) NodesHostingExcludedClasses(ctx context.Context, exclusion CoTenancyExclusion) ([]string, error) {
+	if len(exclusion.Classes) == 0 {
+		return nil, nil
+	}
+	return c.nodesHostingPods(ctx, exclusion.LabelKey, exclusion.Classes...)
+}
+
+// NodesHostingTenant returns the names of the nodes hosting running pods of
+// the tenant. The tenant of a pod is the value of its label with the given
+// key. The result is meant to be passed to WithTenantNodes.
+func (c *TASFlavorCache) NodesHostingTenant(ctx context.Context, labelKey, tenant string) ([]string, error) {
+	return c.nodesHostingPods(ctx, labelKey, tenant)
+}
+
+// nodesHostingPods returns the names of the nodes hosting running pods with
+// one of the values of the label with the given key.
+func (c *TASFlavorCache) nodesHostingPods(ctx context.Context, labelKey string, values ...string) ([]string, error) {
+	requirement, err := labels.NewRequirement(labelKey, selection.In, values)
+	if err != nil {
+		return nil, err
+	}
+	podList := &corev1.PodList{}
+	if err := c.client.List(ctx, podList, client.MatchingLabelsSelector{Selector: labels.NewSelector().Add(*requirement)}); err != nil {
+		return nil, err
+	}
+	result := sets.New[string]()
+	for _, pod := range podList.Items {
+		if pod.Spec.NodeName == "" || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		result.Insert(pod.Spec.NodeName)
+	}
+	return sets.List(result), nil
+}
+
+// AddNode adds the node to the nodes maintained for the snapshots, so that
+// the subsequent snapshots don't need to list and process all the nodes. The
+// nodes which don't belong to the flavor, or which are excluded from its
+// capacity, are ignored. The events received before the first snapshot are
+// ignored too, as the first snapshot lists all the nodes.
+func (c *TASFlavorCache) AddNode(ctx context.Context, node *corev1.Node) {
+	c.applyNode(ctx, node)
+}
+
+// UpdateNode replaces the node maintained for the snapshots, removing it if
+// the node no longer belongs to the flavor or is excluded from its capacity.
+func (c *TASFlavorCache) UpdateNode(ctx context.Context, node *corev1.Node) {
+	c.applyNode(ctx, node)
+}
+
+// applyNode sets the node maintained for the snapshots. The capacity source
+// may call external services, so the capacity of the node is computed before
+// taking the lock, and only the entry is swapped under it. The node labels,
+// the node selector and the node annotations of the flavor are immutable, so
+// they are matched without the lock.
+func (c *TASFlavorCache) applyNode(ctx context.Context, node *corev1.Node) {
 	log := ctrl.LoggerFrom(ctx)
+	var nodeCapacity corev1.ResourceList
+	var capacityFound bool
+	if c.matchesNodeLabels(node) {
+		nodeCapacity, capacityFound = c.nodeCapacity(ctx, log, node)
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.setNode(log, node, nodeCapacity, capacityFound)
+}
+
+// DeleteNode removes the node from the nodes maintained for the snapshots.
+func (c *TASFlavorCache) DeleteNode(name string) {
+	c.Lock()
+	defer c.Unlock()
+	if c.nodes != nil {
+		delete(c.nodes, name)
+		delete(c.nodesMissingLevels, name)
+	}
+}
+
+func (c *TASFlavorCache) setNode(log logr.Logger, node *corev1.Node, nodeCapacity corev1.ResourceList, capacityFound bool) {
+	if c.nodes == nil {
+		return
+	}
+	delete(c.nodes, node.Name)
+	delete(c.nodesMissingLevels, node.Name)
+	if !c.matchesNodeLabels(node) {
+		return
+	}
+	if missing := c.missingLevels(node); len(missing) > 0 {
+		log.V(3).Info("Excluding the node without the labels of the topology levels from TAS", "node", klog.KObj(node), "levels", missing)
+		c.nodesMissingLevels[node.Name] = missing
+		return
+	}
+	if !capacityFound {
+		return
+	}
+	if entry, found := c.newNodeEntry(log, node, nodeCapacity); found {
+		c.nodes[node.Name] = entry
+	}
+}
+
+// belongsToFlavor returns true if the node matches the node labels of the
+// flavor and has the labels of all the label levels.
+func (c *TASFlavorCache) belongsToFlavor(node *corev1.Node) bool {
+	return c.matchesNodeLabels(node) && len(c.missingLevels(node)) == 0
+}
+
+// matchesNodeLabels returns true if the node matches the node labels, the
+// node selector and the node annotations of the flavor.
+func (c *TASFlavorCache) matchesNodeLabels(node *corev1.Node) bool {
+	for k, v := range c.NodeLabels {
+		if node.Labels[k] != v {
+			return false
+		}
+	}
+	for k, v := range c.nodeAnnotations {
+		if value, found := node.Annotations[k]; !found || value != v {
+			return false
+		}
+	}
+	return c.nodeSelector == nil || c.nodeSelector.Matches(labels.Set(node.Labels))
+}
+
+// missingLevels returns the label levels for which the node doesn't have the
+// label.
+func (c *TASFlavorCache) missingLevels(node *corev1.Node) []string {
+	var result []string
+	for _, levelKey := range c.labelLevels() {
+		if _, found := node.Labels[levelKey]; !found {
+			result = append(result, levelKey)
+		}
+	}
+	return result
+}
+
+// syncNodes lists the nodes of the flavor, unless they are already
+// maintained incrementally. The lock is held while listing, so that the node
+// events received meanwhile are applied on top of the listed nodes. The nodes
+// are listed regardless of the label levels, so that the nodes missing them
+// are reported.
+func (c *TASFlavorCache) syncNodes(ctx context.Context, log logr.Logger) {
+	c.Lock()
+	defer c.Unlock()
+	if c.nodes != nil {
+		return
+	}
 	nodeList := &corev1.NodeList{}
 	requiredLabels := client.MatchingLabels{}
 	for k, v := range c.NodeLabels {
 		requiredLabels[k] = v
 	}
-	requiredLabelKeys := client.HasLabels{}
-	requiredLabelKeys = append(requiredLabelKeys, c.Levels...)
-	err := c.client.List(ctx, nodeList, requiredLabels, requiredLabelKeys)
+	err := c.client.List(ctx, nodeList, requiredLabels)
 	if err != nil {
 		log.Error(err, "failed to list nodes for TAS", "nodeLabels", c.NodeLabels)
+		return
+	}
+	c.nodes = make(map[string]*nodeEntry, len(nodeList.Items))
+	c.nodesMissingLevels = make(map[string][]string)
+	for i := range nodeList.Items {
+		node := &nodeList.Items[i]
+		// the capacity is computed under the lock only once, when the nodes
+		// are listed before the first snapshot
+		nodeCapacity, capacityFound := c.nodeCapacity(ctx, log, node)
+		c.setNode(log, node, nodeCapacity, capacityFound)
 	}
-	return c.snapshotForNodes(log, nodeList.Items)
 }
 
-func (c *TASFlavorCache) snapshotForNodes(log logr.Logger, nodes []corev1.Node) *TASFlavorSnapshot {
+func (c *TASFlavorCache) snapshot(ctx context.Context) *TASFlavorSnapshot {
+	log := ctrl.LoggerFrom(ctx)
+	c.syncNodes(ctx, log)
+
 	c.RLock()
 	defer c.RUnlock()
+	snapshot := c.snapshotForEntries(ctx, log, c.nodes)
+	snapshot.nodesMissingLevels = maps.Clone(c.nodesMissingLevels)
+	return snapshot
+}
 
+// snapshotForNodes returns the snapshot rebuilt from the given nodes,
+// regardless of the nodes maintained incrementally.
+func (c *TASFlavorCache) snapshotForNodes(ctx context.Context, log logr.Logger, nodes []corev1.Node) *TASFlavorSnapshot {
+	nodeCapacities := make(map[string]corev1.ResourceList, len(nodes))
+	for i := range nodes {
+		if nodeCapacity, found := c.nodeCapacity(ctx, log, &nodes[i]); found {
+			nodeCapacities[nodes[i].Name] = nodeCapacity
+		}
+	}
+
+	c.RLock()
+	defer c.RUnlock()
+
+	entries := make(map[string]*nodeEntry, len(nodes))
+	for i := range nodes {
+		nodeCapacity, found := nodeCapacities[nodes[i].Name]
+		if !found {
+			continue
+		}
+		if entry, found := c.newNodeEntry(log, &nodes[i], nodeCapacity); found {
+			entries[nodes[i].Name] = entry
+		}
+	}
+	return c.snapshotForEntries(ctx, log, entries)
+}
+
+// nodeEntry holds the information about the node used by the snapshots. It
+// is computed once per node event, and it is never mutated afterwards.
+type nodeEntry struct {
+	levelValues  []string
+	capacity     resources.Requests
+	labels       map[string]string
+	taints       []corev1.Taint
+	nvlinkGroups []int64
+	readySince   time.Time
+}
+
+// nodeCapacity returns the capacity of the node from the capacity source, or
+// false if it can't be determined. It doesn't need the lock.
+func (c *TASFlavorCache) nodeCapacity(ctx context.Context, log logr.Logger, node *corev1.Node) (corev1.ResourceList, bool) {
+	nodeCapacity, err := c.capacitySource.NodeCapacity(ctx, node)
+	if err != nil {
+		log.Error(err, "failed to get the node capacity for TAS", "node", klog.KObj(node))
+		return nil, false
+	}
+	return nodeCapacity, true
+}
+
+// newNodeEntry returns the entry of the node with the given capacity, or
+// false if the node is excluded from the capacity of the flavor.
+func (c *TASFlavorCache) newNodeEntry(log logr.Logger, node *corev1.Node, nodeCapacity corev1.ResourceList) (*nodeEntry, bool) {
+	if condition, found := c.pressureCondition(node); found {
+		log.V(3).Info("Excluding the node under pressure from TAS", "node", klog.KObj(node), "condition", condition)
+		return nil, false
+	}
+	if reason, found := c.unschedulableReason(node); found {
+		log.V(3).Info("Excluding the unschedulable node from TAS", "node", klog.KObj(node), "reason", reason)
+		return nil, false
+	}
+	if missing, found := c.missingAnnotationLevel(node); found {
+		log.V(3).Info("Excluding the node without the annotation of the topology level from TAS", "node", klog.KObj(node), "level", missing)
+		return nil, false
+	}
+	capacity := resources.NewRequests(nodeCapacity)
+	if unhealthyGPUs := unhealthyGPUs(log, node); unhealthyGPUs > 0 {
+		capacity[gpuResourceName] = max(capacity[gpuResourceName]-unhealthyGPUs, 0)
+	}
+	c.reserveHeadroom(capacity)
+	return &nodeEntry{
+		levelValues:  c.levelValues(node),
+		capacity:     capacity,
+		labels:       node.Labels,
+		taints:       node.Spec.Taints,
+		nvlinkGroups: nvlinkGroups(log, node),
+		readySince:   readySince(node),
+	}, true
+}
+
+func (c *TASFlavorCache) snapshotForEntries(ctx context.Context, log logr.Logger, entries map[string]*nodeEntry) *TASFlavorSnapshot {
 	log.V(3).Info("Constructing TAS snapshot", "nodeLabels", c.NodeLabels,
-		"levels", c.Levels, "nodeCount", len(nodes))
+		"levels", c.Levels, "nodeCount", len(entries))
 	snapshot := newTASFlavorSnapshot(log, c.Levels)
-	for _, node := range nodes {
-		levelValues := utiltas.LevelValues(c.Levels, node.Labels)
-		capacity := resources.NewRequests(node.Status.Allocatable)
+	snapshot.compactionThreshold = c.compactionThreshold
+	snapshot.externalReservations = c.externalReservations
+	podRequests := c.nonTASPods.perNode()
+	snapshot.nodesWithDuplicateHost = c.nodesWithDuplicateHost(entries)
+	if len(snapshot.nodesWithDuplicateHost) > 0 {
+		log.V(3).Info("Excluding the nodes sharing the value of the host label from TAS", "nodes", snapshot.nodesWithDuplicateHost)
+	}
+	for _, name := range slices.Sorted(maps.Keys(entries)) {
+		entry := entries[name]
+		if _, found := snapshot.nodesWithDuplicateHost[name]; found {
+			continue
+		}
+		capacity := entry.capacity.Clone()
+		// Only the resources advertised by the node are reduced, so that the
+		// pods don't limit the nodes without the pods allocatable.
+		for resourceName, value := range podRequests[name] {
+			if free, found := capacity[resourceName]; found {
+				capacity[resourceName] = max(free-value, 0)
+			}
+		}
+		if c.hasSubHostLevel() {
+			addPartitions(snapshot, name, entry, capacity)
+			continue
+		}
+		domainID := utiltas.DomainID(entry.levelValues)
+		snapshot.levelValuesPerDomain[domainID] = entry.levelValues
+		snapshot.addNode(name, domainID, capacity, entry.labels, entry.taints, entry.nvlinkGroups, entry.readySince)
+	}
+	for _, node := range c.pendingNodes {
+		if !c.matchesPendingNode(&node) {
+			continue
+		}
+		levelValues := c.levelValues(node.asNode())
+		if c.hasSubHostLevel() {
+			levelValues[len(levelValues)-1] = "0"
+		}
+		capacity := resources.NewRequests(node.Capacity)
+		c.reserveHeadroom(capacity)
 		domainID := utiltas.DomainID(levelValues)
 		snapshot.levelValuesPerDomain[domainID] = levelValues
-		snapshot.addCapacity(domainID, capacity)
+		snapshot.addPendingNode(node.Name, domainID, capacity, node.Labels)
 	}
 	snapshot.initialize()
 	for domainID, usage := range c.usage {
+		// The usage of the domains which no longer have nodes, for example
+		// after the nodes are relabeled, isn't carried over, so that the
+		// domains aren't offered to the assignment.
+		if _, found := snapshot.capacityPerDomain[domainID]; !found {
+			continue
+		}
 		snapshot.addUsage(domainID, usage)
 	}
 	return snapshot
 }
 
-func (c *TASFlavorCache) addUsage(topologyRequests []workload.TopologyDomainRequests) {
+// nodesWithDuplicateHost returns, by the node name, the value of the host
+// label of the nodes sharing it, along with the values of all the levels
+// above, with another node. For example, the nodes of different racks when
+// the rack isn't a level of the topology. Such nodes can't be told apart by
+// the node selector of the pods, so they are excluded from the snapshot
+// rather than merged into a single domain with their capacity summed.
+func (c *TASFlavorCache) nodesWithDuplicateHost(entries map[string]*nodeEntry) map[string]string {
+	hostLevelIdx := slices.Index(c.Levels, corev1.LabelHostname)
+	if hostLevelIdx == -1 {
+		return nil
+	}
+	nodesPerHost := make(map[utiltas.TopologyDomainID][]string)
+	for name, entry := range entries {
+		hostID := utiltas.DomainID(entry.levelValues[:hostLevelIdx+1])
+		nodesPerHost[hostID] = append(nodesPerHost[hostID], name)
+	}
+	result := make(map[string]string)
+	for _, nodeNames := range nodesPerHost {
+		if len(nodeNames) < 2 {
+			continue
+		}
+		for _, name := range nodeNames {
+			result[name] = entries[name].levelValues[hostLevelIdx]
+		}
+	}
+	return result
+}
+
+// addPartitions adds the NVLink groups of the node to the snapshot as the
+// nodes of the sub-host level domains, named after the node and the index of
+// the group.
+func addPartitions(snapshot *TASFlavorSnapshot, name string, entry *nodeEntry, capacity resources.Requests) {
+	for i, partitionCapacity := range nvlinkPartitions(capacity, entry.nvlinkGroups) {
+		levelValues := slices.Clone(entry.levelValues)
+		levelValues[len(levelValues)-1] = strconv.Itoa(i)
+		domainID := utiltas.DomainID(levelValues)
+		partitionName := fmt.Sprintf("%s/%d", name, i)
+		snapshot.levelValuesPerDomain[domainID] = levelValues
+		snapshot.addNode(partitionName, domainID, partitionCapacity, entry.labels, entry.taints, nil, entry.readySince)
+		node := snapshot.nodes[partitionName]
+		node.host = name
+		snapshot.nodes[partitionName] = node
+	}
+}
+
+// nvlinkPartitions splits the capacity of the node between its NVLink
+// groups, proportionally to the number of GPUs in each group, rounding down.
+// It returns the whole capacity if the node has no NVLink groups.
+func nvlinkPartitions(capacity resources.Requests, groups []int64) []resources.Requests {
+	var totalGPUs int64
+	for _, size := range groups {
+		totalGPUs += size
+	}
+	if totalGPUs == 0 {
+		return []resources.Requests{capacity}
+	}
+	result := make([]resources.Requests, 0, len(groups))
+	for _, size := range groups {
+		partition := make(resources.Requests, len(capacity))
+		for name, value := range capacity {
+			partition[name] = value * size / totalGPUs
+		}
+		result = append(result, partition)
+	}
+	return result
+}
+
+// reserveHeadroom reduces the capacity of the node by the reservation
+// fraction, rounding the usable capacity down.
+func (c *TASFlavorCache) reserveHeadroom(capacity resources.Requests) {
+	if c.reservationFraction == 0 {
+		return
+	}
+	for name, value := range capacity {
+		capacity[name] = int64(math.Floor(float64(value) * (1 - c.reservationFraction)))
+	}
+}
+
+// nvlinkGroups returns the number of GPUs in each NVLink group of the node,
+// based on the NodeNVLinkGroupsAnnotation.
+func nvlinkGroups(log logr.Logger, node *corev1.Node) []int64 {
+	value, found := node.Annotations[kueuealpha.NodeNVLinkGroupsAnnotation]
+	if !found {
+		return nil
+	}
+	groups := make([]int64, 0)
+	for _, group := range strings.Split(value, ",") {
+		size, err := strconv.ParseInt(strings.TrimSpace(group), 10, 64)
+		if err != nil || size < 0 {
+			log.V(2).Info("Ignoring invalid NVLink groups annotation", "node", klog.KObj(node), "value", value)
+			return nil
+		}
+		groups = append(groups, size)
+	}
+	return groups
+}
+
+// unhealthyGPUs returns the number of unhealthy GPUs of the node, based on
+// the NodeUnhealthyGPUsAnnotation.
+func unhealthyGPUs(log logr.Logger, node *corev1.Node) int64 {
+	value, found := node.Annotations[kueuealpha.NodeUnhealthyGPUsAnnotation]
+	if !found {
+		return 0
+	}
+	count, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || count < 0 {
+		log.V(2).Info("Ignoring invalid unhealthy GPUs annotation", "node", klog.KObj(node), "value", value)
+		return 0
+	}
+	return count
+}
+
+// pressureCondition returns the pressure condition which is true for the
+// node, if any.
+func (c *TASFlavorCache) pressureCondition(node *corev1.Node) (corev1.NodeConditionType, bool) {
+	for _, cond := range node.Status.Conditions {
+		if cond.Status == corev1.ConditionTrue && slices.Contains(c.pressureConditions, cond.Type) {
+			return cond.Type, true
+		}
+	}
+	return "", false
+}
+
+// unschedulableReason returns the reason for which the node can't host new
+// pods, if it is cordoned or its Ready condition is reported as not true. The
+// nodes which don't report the Ready condition yet are considered schedulable.
+func (c *TASFlavorCache) unschedulableReason(node *corev1.Node) (string, bool) {
+	if c.includeUnschedulable {
+		return "", false
+	}
+	if node.Spec.Unschedulable {
+		return "Cordoned", true
+	}
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == corev1.NodeReady && cond.Status != corev1.ConditionTrue {
+			return "NotReady", true
+		}
+	}
+	return "", false
+}
+
+// readySince returns the time at which the node became Ready, or zero time
+// if the node is not Ready.
+func readySince(node *corev1.Node) time.Time {
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == corev1.NodeReady && cond.Status == corev1.ConditionTrue {
+			return cond.LastTransitionTime.Time
+		}
+	}
+	return time.Time{}
+}
+
+// missingAnnotationLevel returns the first annotation level for which the
+// node doesn't have the annotation.
+func (c *TASFlavorCache) missingAnnotationLevel(node *corev1.Node) (string, bool) {
+	for _, levelKey := range c.Levels {
+		if _, found := node.Annotations[levelKey]; c.annotationLevels.Has(levelKey) && !found {
+			return levelKey, true
+		}
+	}
+	return "", false
+}
+
+// matchesPendingNode checks if the pending node would be listed for the
+// flavor, based on the node labels and annotations and the topology levels.
+// The values of the annotation levels are read from the annotations, and the
+// value of the sub-host level is assigned by the snapshot.
+func (c *TASFlavorCache) matchesPendingNode(node *PendingNode) bool {
+	asNode := node.asNode()
+	if !c.belongsToFlavor(asNode) {
+		return false
+	}
+	_, missingAnnotation := c.missingAnnotationLevel(asNode)
+	return !missingAnnotation
+}
+
+func (c *TASFlavorCache) addUsage(wlKey string, topologyRequests []workload.TopologyDomainRequests) {
+	c.Lock()
+	defer c.Unlock()
+	if _, found := c.reservations[wlKey]; found {
+		return
+	}
+	c.reservations[wlKey] = topologyRequests
 	c.updateUsage(topologyRequests, add)
 }
 
-func (c *TASFlavorCache) removeUsage(topologyRequests []workload.TopologyDomainRequests) {
+func (c *TASFlavorCache) removeUsage(wlKey string) {
+	c.Lock()
+	defer c.Unlock()
+	topologyRequests, found := c.reservations[wlKey]
+	if !found {
+		return
+	}
+	delete(c.reservations, wlKey)
 	c.updateUsage(topologyRequests, subtract)
 }
 
 func (c *TASFlavorCache) updateUsage(topologyRequests []workload.TopologyDomainRequests, op usageOp) {
-	c.Lock()
-	defer c.Unlock()
 	for _, tr := range topologyRequests {
 		domainID := utiltas.DomainID(tr.Values)
 		_, found := c.usage[domainID]
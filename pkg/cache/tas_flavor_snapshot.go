@@ -17,14 +17,30 @@ limitations under the License.
 package cache
 
 import (
+	"cmp"
+	"context"
 	"errors"
+	"fmt"
+	"iter"
+	"maps"
+	"math"
+	"runtime"
 	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/dump"
+	"k8s.io/apimachinery/pkg/util/sets"
+	corev1helpers "k8s.io/component-helpers/scheduling/corev1"
+	"k8s.io/utils/ptr"
 
 	kueue "sigs.k8s.io/kueue/apis/kueue/v1beta1"
+	"sigs.k8s.io/kueue/pkg/metrics"
 	"sigs.k8s.io/kueue/pkg/resources"
+	"sigs.k8s.io/kueue/pkg/util/parallelize"
 	utiltas "sigs.k8s.io/kueue/pkg/util/tas"
 )
 
@@ -32,6 +48,80 @@ var (
 	errCodeAssumptionsViolated = errors.New("code assumptions violated")
 )
 
+// parallelDomainsThreshold is the default number of the top level domains
+// from which the domains are evaluated in parallel, if more than one CPU is
+// available. Below it, the serial walk of the tree takes a few microseconds,
+// which is comparable to the cost of starting the goroutines and merging
+// their counts, so the parallel evaluation only pays off for wide topologies.
+// It can be overridden per assignment with WithParallelThreshold.
+const parallelDomainsThreshold = 64
+
+// TopologyAssignmentErrorReason indicates why the topology assignment could
+// not be found.
+type TopologyAssignmentErrorReason string
+
+const (
+	// InvalidTopologyLevel indicates that the requested topology level is not
+	// one of the levels of the flavor's topology.
+	InvalidTopologyLevel TopologyAssignmentErrorReason = "InvalidTopologyLevel"
+
+	// TopologyNotFit indicates that the pods cannot fit within the topology.
+	TopologyNotFit TopologyAssignmentErrorReason = "TopologyNotFit"
+
+	// InsufficientCapacity indicates that the domains available to the
+	// assignment, for example after the nodes excluded by the options, can't
+	// accommodate the pods, regardless of the topology.
+	InsufficientCapacity TopologyAssignmentErrorReason = "InsufficientCapacity"
+
+	// InsufficientClusterCapacity indicates that the free capacity of a
+	// resource in the whole topology of the flavor isn't enough for the pods.
+	InsufficientClusterCapacity TopologyAssignmentErrorReason = "InsufficientClusterCapacity"
+
+	// NoMatchingNodes indicates that no nodes of the flavor are available
+	// for the topology assignment.
+	NoMatchingNodes TopologyAssignmentErrorReason = "NoMatchingNodes"
+)
+
+// TopologyAssignmentError is returned by FindTopologyAssignment when the
+// topology assignment could not be found.
+type TopologyAssignmentError struct {
+	Reason  TopologyAssignmentErrorReason
+	Message string
+
+	// Level is the topology level key the error refers to, set for
+	// InvalidTopologyLevel.
+	Level string
+
+	// Resource is the resource whose capacity is insufficient, set for
+	// InsufficientClusterCapacity.
+	Resource corev1.ResourceName
+
+	// Requested is the quantity of the Resource requested by a single pod,
+	// set for InsufficientClusterCapacity.
+	Requested int64
+
+	// Available is the largest free quantity of the Resource in a single
+	// lowest level domain, set for InsufficientClusterCapacity.
+	Available int64
+}
+
+func (e *TopologyAssignmentError) Error() string {
+	return e.Message
+}
+
+// Shortfall returns the quantity of the Resource missing for a single pod in
+// the lowest level domain with the most of it free. It is 0 if a single pod
+// fits, but the free capacity of the flavor is not enough for all the pods.
+func (e *TopologyAssignmentError) Shortfall() int64 {
+	return max(e.Requested-e.Available, 0)
+}
+
+const (
+	// gpuResourceName is the name of the resource considered when grouping
+	// GPUs into NVLink groups.
+	gpuResourceName corev1.ResourceName = "nvidia.com/gpu"
+)
+
 // domain holds the static information about placement of a topology
 // domain in the hierarchy of topology domains.
 type domain struct {
@@ -52,6 +142,482 @@ type domain struct {
 type domainByID map[utiltas.TopologyDomainID]*domain
 type statePerDomain map[utiltas.TopologyDomainID]int32
 
+// FindTopologyAssignmentOption configures the behavior of
+// FindTopologyAssignment.
+type FindTopologyAssignmentOption func(*findTopologyAssignmentOptions)
+
+type findTopologyAssignmentOptions struct {
+	// noSplinterThreshold is the minimal number of pods per spread domain
+	noSplinterThreshold int32
+	// localityBudget is the tolerated spread, from 0 (tight) to 1 (anywhere)
+	localityBudget *float64
+	// capacityBuffer is the number of pods to leave room for in each leaf
+	capacityBuffer CapacityBufferFunc
+	// excludedNodes are the nodes not used by the assignment
+	excludedNodes sets.Set[string]
+	// excludedDomains are the domain values per level not used by the assignment
+	excludedDomains map[string][]string
+	// tolerations are the tolerations of the pods
+	tolerations []corev1.Toleration
+	// domainHistory holds the past job outcomes per domain
+	domainHistory map[utiltas.TopologyDomainID]DomainHistory
+	// dataNodes are the nodes holding the data read by the workload
+	dataNodes sets.Set[string]
+	// sparesPerDomain is the number of spare hosts per requested domain
+	sparesPerDomain int32
+	// lastActivity is the time the domains were last active
+	lastActivity map[utiltas.TopologyDomainID]time.Time
+	// latencyBudget limits the time spent searching for the best domain
+	latencyBudget *time.Duration
+	// maxNewNodes limits the number of pending nodes used
+	maxNewNodes *int32
+	// minimizeNodes makes the assignment use the fewest nodes
+	minimizeNodes bool
+	// fragmentationPenalty makes the assignment leave the fewest partial leaves
+	fragmentationPenalty bool
+	// preferFullerDomains makes the assignment top off the fuller domains first
+	preferFullerDomains bool
+	// allocatedFractionPerDomain is the allocated fraction of the domains
+	allocatedFractionPerDomain map[utiltas.TopologyDomainID]float64
+	// carbonIntensityLabel is the node label holding the carbon intensity
+	carbonIntensityLabel string
+	// carbonMode indicates how the carbon intensity affects the assignment
+	carbonMode CarbonMode
+	// readyBefore is the time before which the preferred nodes became Ready
+	readyBefore *time.Time
+	// gpuHourBudget is the GPU-hours which may be committed to a domain
+	gpuHourBudget *float64
+	// committedGPUHours are the GPU-hours already committed to the domains
+	committedGPUHours map[utiltas.TopologyDomainID]float64
+	// estimatedDuration is the estimated duration of the workload
+	estimatedDuration time.Duration
+	// regionLabelKey is the node label holding the region
+	regionLabelKey string
+	// preferredRegion is the region preferred by the workload
+	preferredRegion string
+	// spotLabelKey is the node label marking the spot nodes
+	spotLabelKey string
+	// preferSpot indicates whether the spot nodes are preferred or avoided
+	preferSpot bool
+	// granularity is the rounding granularity per resource
+	granularity resources.Requests
+	// maxDomainsLevelKey is the level at which at most maxDomains are used
+	maxDomainsLevelKey string
+	maxDomains         int32
+	// maxDomainsLevelIdx is the index of maxDomainsLevelKey
+	maxDomainsLevelIdx int
+	// maxPodsPerDomainLevelKey is the level of the maxPodsPerDomain limit
+	maxPodsPerDomainLevelKey string
+	maxPodsPerDomain         int32
+	// tenantNodes are the nodes already hosting the pods of the tenant
+	tenantNodes sets.Set[string]
+	// densificationCeilingLabel is the node label holding the packing ceiling
+	densificationCeilingLabel string
+	// burstCount is the expected future number of pods of the workload
+	burstCount int32
+	// tightPack prefers the fitting domain with the fewest free pods
+	tightPack bool
+	// ctx is the context of the scheduling cycle
+	ctx context.Context
+	// parallelThreshold overrides the threshold of the parallel evaluation
+	parallelThreshold *int
+	// withinDomain restricts the assignment to the domain
+	withinDomain *utiltas.TopologyDomainID
+	// resourceWeights are the weights of the resources to break the ties
+	resourceWeights map[corev1.ResourceName]float64
+	// weightedFreeCapacity is the weighted free capacity of the domains
+	weightedFreeCapacity map[utiltas.TopologyDomainID]float64
+	// warmNodes are the nodes which previously ran the workload
+	warmNodes sets.Set[string]
+	// warmNodesPerDomain is the number of warmNodes in the domains
+	warmNodesPerDomain map[utiltas.TopologyDomainID]int32
+	// preferredNode is the node near which the pods are placed
+	preferredNode string
+	// preferredNodePerDomain is non-zero for the domains with preferredNode
+	preferredNodePerDomain map[utiltas.TopologyDomainID]int32
+	// softLimits are the resources whose capacity may be exceeded
+	softLimits sets.Set[corev1.ResourceName]
+	// nodeScoringStrategy indicates how the pods are spread over the leaves
+	nodeScoringStrategy NodeScoringStrategy
+	// equalPerDomain requires the same number of pods in each leaf used
+	equalPerDomain bool
+	// equalCount is the number of pods in each leaf used, if split equally
+	equalCount int32
+	// requiredAxes are the node labels which must be the same on all nodes
+	requiredAxes []string
+}
+
+// CarbonMode indicates how the carbon intensity of the topology domains
+// affects the assignment.
+type CarbonMode int
+
+const (
+	// CarbonModeLatencySensitive ignores the carbon intensity, to keep the
+	// placement of latency-sensitive workloads tight.
+	CarbonModeLatencySensitive CarbonMode = iota
+
+	// CarbonModeBatch prefers, among the domains which can accommodate the
+	// workload, the ones with the lower carbon intensity. It is suitable for
+	// deferrable batch workloads.
+	CarbonModeBatch
+)
+
+// NodeScoringStrategy indicates how the pods are spread over the lowest level
+// domains, such as the hosts, within the chosen domain, mirroring the
+// scoring strategies of kube-scheduler.
+type NodeScoringStrategy int
+
+const (
+	// NodeScoringDefault prefers the lowest level domains which can
+	// accommodate the most pods.
+	NodeScoringDefault NodeScoringStrategy = iota
+
+	// NodeScoringLeastAllocated prefers the lowest level domains with the
+	// lowest fraction of the capacity allocated, which spreads the pods.
+	NodeScoringLeastAllocated
+
+	// NodeScoringMostAllocated prefers the lowest level domains with the
+	// highest fraction of the capacity allocated, which packs the pods onto
+	// the already used domains.
+	NodeScoringMostAllocated
+)
+
+// CapacityBufferFunc returns the number of additional pods for which free
+// capacity should be left in each of the lowest level topology domains used
+// by a workload of count pods.
+type CapacityBufferFunc func(count int32) int32
+
+// ProportionalCapacityBuffer returns a CapacityBufferFunc which reserves free
+// capacity for the given fraction of the workload size, rounded down.
+func ProportionalCapacityBuffer(ratio float64) CapacityBufferFunc {
+	return func(count int32) int32 {
+		return int32(math.Floor(ratio * float64(count)))
+	}
+}
+
+// WithNoSplinterThreshold avoids leaving fewer than threshold pods in any of
+// the domains the pods are spread across.
+func WithNoSplinterThreshold(threshold int32) FindTopologyAssignmentOption {
+	return func(o *findTopologyAssignmentOptions) {
+		o.noSplinterThreshold = threshold
+	}
+}
+
+// WithCapacityBuffer leaves free capacity in each lowest level domain for the
+// number of pods returned by bufferFn for the workload size.
+func WithCapacityBuffer(bufferFn CapacityBufferFunc) FindTopologyAssignmentOption {
+	return func(o *findTopologyAssignmentOptions) {
+		o.capacityBuffer = bufferFn
+	}
+}
+
+// WithExcludedNodes makes the assignment avoid the given nodes.
+func WithExcludedNodes(nodeNames ...string) FindTopologyAssignmentOption {
+	return func(o *findTopologyAssignmentOptions) {
+		o.excludedNodes = sets.New(nodeNames...)
+	}
+}
+
+// WithExcludedDomains makes the assignment avoid the domains with the given
+// values, per level key, in all their parent domains.
+func WithExcludedDomains(excludedDomains map[string][]string) FindTopologyAssignmentOption {
+	return func(o *findTopologyAssignmentOptions) {
+		o.excludedDomains = excludedDomains
+	}
+}
+
+// WithTolerations sets the tolerations of the pods, the nodes with untolerated
+// NoSchedule or NoExecute taints are not used.
+func WithTolerations(tolerations ...corev1.Toleration) FindTopologyAssignmentOption {
+	return func(o *findTopologyAssignmentOptions) {
+		o.tolerations = tolerations
+	}
+}
+
+// DomainHistory holds the number of jobs which succeeded and failed in a
+// topology domain.
+type DomainHistory struct {
+	Succeeded int32
+	Failed    int32
+}
+
+// successRate returns the fraction of the jobs which succeeded in the domain.
+// Domains without history are considered reliable.
+func (h DomainHistory) successRate() float64 {
+	total := h.Succeeded + h.Failed
+	if total == 0 {
+		return 1
+	}
+	return float64(h.Succeeded) / float64(total)
+}
+
+// WithDomainHistory prefers the fitting domains with the higher job success
+// rate, keyed by the domain ID at any level.
+func WithDomainHistory(history map[utiltas.TopologyDomainID]DomainHistory) FindTopologyAssignmentOption {
+	return func(o *findTopologyAssignmentOptions) {
+		o.domainHistory = history
+	}
+}
+
+// WithDataNodes prefers the fitting domains with more of the given data nodes.
+func WithDataNodes(nodeNames ...string) FindTopologyAssignmentOption {
+	return func(o *findTopologyAssignmentOptions) {
+		o.dataNodes = sets.New(nodeNames...)
+	}
+}
+
+// WithRegionAffinity prefers the fitting domains with more of the nodes in the
+// region given by the node label, ahead of the other preferences.
+func WithRegionAffinity(labelKey, region string) FindTopologyAssignmentOption {
+	return func(o *findTopologyAssignmentOptions) {
+		o.regionLabelKey = labelKey
+		o.preferredRegion = region
+	}
+}
+
+// WithDeadline prefers the fitting domains with more spot nodes if at least
+// minSpotSlack is left until the deadline, and fewer spot nodes otherwise.
+func WithDeadline(spotLabelKey string, deadline, now time.Time, minSpotSlack time.Duration) FindTopologyAssignmentOption {
+	return func(o *findTopologyAssignmentOptions) {
+		o.spotLabelKey = spotLabelKey
+		o.preferSpot = deadline.Sub(now) >= minSpotSlack
+	}
+}
+
+// WithResourceGranularity rounds the requests up, and the free capacity down,
+// to the given granularity per resource.
+func WithResourceGranularity(granularity corev1.ResourceList) FindTopologyAssignmentOption {
+	return func(o *findTopologyAssignmentOptions) {
+		o.granularity = resources.NewRequests(granularity)
+	}
+}
+
+// WithMaxDomains limits the number of domains used at the given level.
+func WithMaxDomains(levelKey string, maxDomains int32) FindTopologyAssignmentOption {
+	return func(o *findTopologyAssignmentOptions) {
+		o.maxDomainsLevelKey = levelKey
+		o.maxDomains = maxDomains
+	}
+}
+
+// WithMaxPodsPerDomain limits the number of pods in any domain at the given
+// level, the excess pods spill over to the sibling domains.
+func WithMaxPodsPerDomain(levelKey string, maxPods int32) FindTopologyAssignmentOption {
+	return func(o *findTopologyAssignmentOptions) {
+		o.maxPodsPerDomainLevelKey = levelKey
+		o.maxPodsPerDomain = maxPods
+	}
+}
+
+// WithSparesPerDomain reserves the given number of hosts as spares in each
+// domain at the requested level.
+func WithSparesPerDomain(spares int32) FindTopologyAssignmentOption {
+	return func(o *findTopologyAssignmentOptions) {
+		o.sparesPerDomain = spares
+	}
+}
+
+// WithDomainLastActivity breaks the ties in favor of the most recently active
+// domains, keyed by the domain ID at any level.
+func WithDomainLastActivity(lastActivity map[utiltas.TopologyDomainID]time.Time) FindTopologyAssignmentOption {
+	return func(o *findTopologyAssignmentOptions) {
+		o.lastActivity = lastActivity
+	}
+}
+
+// WithResourceWeights breaks the ties in favor of the domains leaving the most
+// free capacity, weighted per resource.
+func WithResourceWeights(weights map[corev1.ResourceName]float64) FindTopologyAssignmentOption {
+	return func(o *findTopologyAssignmentOptions) {
+		o.resourceWeights = weights
+	}
+}
+
+// WithWarmNodes breaks the ties in favor of the domains with more of the
+// nodes which previously ran the workload.
+func WithWarmNodes(nodeNames ...string) FindTopologyAssignmentOption {
+	return func(o *findTopologyAssignmentOptions) {
+		o.warmNodes = sets.New(nodeNames...)
+	}
+}
+
+// WithPreferredNode breaks the remaining ties in favor of the domains
+// containing the given node.
+func WithPreferredNode(nodeName string) FindTopologyAssignmentOption {
+	return func(o *findTopologyAssignmentOptions) {
+		o.preferredNode = nodeName
+	}
+}
+
+// WithLatencyBudget searches the fitting domains for the tightest placement,
+// returning the best one found once the budget is exceeded.
+func WithLatencyBudget(budget time.Duration) FindTopologyAssignmentOption {
+	return func(o *findTopologyAssignmentOptions) {
+		o.latencyBudget = ptr.To(budget)
+	}
+}
+
+// WithMaxNewNodes limits the number of pending nodes used by the assignment.
+func WithMaxNewNodes(maxNodes int32) FindTopologyAssignmentOption {
+	return func(o *findTopologyAssignmentOptions) {
+		o.maxNewNodes = ptr.To(maxNodes)
+	}
+}
+
+// WithMinimizeNodes prefers the fitting domain in which the pods use the
+// fewest nodes, and then the fewest domains.
+func WithMinimizeNodes() FindTopologyAssignmentOption {
+	return func(o *findTopologyAssignmentOptions) {
+		o.minimizeNodes = true
+	}
+}
+
+// WithFragmentationPenalty breaks the ties in favor of the domain leaving the
+// fewest partially used lowest level domains.
+func WithFragmentationPenalty() FindTopologyAssignmentOption {
+	return func(o *findTopologyAssignmentOptions) {
+		o.fragmentationPenalty = true
+	}
+}
+
+// WithPreferFullerDomains tops off the partially allocated domains before the
+// empty ones.
+func WithPreferFullerDomains() FindTopologyAssignmentOption {
+	return func(o *findTopologyAssignmentOptions) {
+		o.preferFullerDomains = true
+	}
+}
+
+// WithBurstHeadroom reserves the capacity for the workload scaling up to
+// burstCount pods, by choosing the domains which accommodate burstCount pods.
+// The assignment still holds only the pods of the PodSet.
+func WithBurstHeadroom(burstCount int32) FindTopologyAssignmentOption {
+	return func(o *findTopologyAssignmentOptions) {
+		o.burstCount = burstCount
+	}
+}
+
+// WithTenantNodes prefers, within the selected domain, the domains with the
+// nodes already hosting the pods of the tenant.
+func WithTenantNodes(nodeNames ...string) FindTopologyAssignmentOption {
+	return func(o *findTopologyAssignmentOptions) {
+		o.tenantNodes = sets.New(nodeNames...)
+	}
+}
+
+// WithDensificationCeiling packs the nodes only up to the fraction of their
+// capacity given by the node label, if it is in [0, 1).
+func WithDensificationCeiling(labelKey string) FindTopologyAssignmentOption {
+	return func(o *findTopologyAssignmentOptions) {
+		o.densificationCeilingLabel = labelKey
+	}
+}
+
+// WithCarbonIntensity configures the carbon-aware assignment, based on the
+// average of the node label values in the domains.
+func WithCarbonIntensity(labelKey string, mode CarbonMode) FindTopologyAssignmentOption {
+	return func(o *findTopologyAssignmentOptions) {
+		o.carbonIntensityLabel = labelKey
+		o.carbonMode = mode
+	}
+}
+
+// WithNodeScoringStrategy sets how the pods are spread over the lowest level
+// domains within the chosen domain.
+func WithNodeScoringStrategy(strategy NodeScoringStrategy) FindTopologyAssignmentOption {
+	return func(o *findTopologyAssignmentOptions) {
+		o.nodeScoringStrategy = strategy
+	}
+}
+
+// WithEqualPerDomain requires the same number of pods in each lowest level
+// domain used, preferring the split using the fewest domains.
+func WithEqualPerDomain() FindTopologyAssignmentOption {
+	return func(o *findTopologyAssignmentOptions) {
+		o.equalPerDomain = true
+	}
+}
+
+// WithRequiredAxes requires the assignment to fit within a single value of
+// each of the given node labels, trying the values in order.
+func WithRequiredAxes(labelKeys ...string) FindTopologyAssignmentOption {
+	return func(o *findTopologyAssignmentOptions) {
+		o.requiredAxes = labelKeys
+	}
+}
+
+// WithSoftLimits exceeds the capacity of the given resources rather than
+// failing, as reported by SoftLimitViolations.
+func WithSoftLimits(resourceNames ...corev1.ResourceName) FindTopologyAssignmentOption {
+	return func(o *findTopologyAssignmentOptions) {
+		o.softLimits = sets.New(resourceNames...)
+	}
+}
+
+// WithMinNodeReadyAge prefers, within the selected domain, the nodes which
+// have been Ready for at least minAge.
+func WithMinNodeReadyAge(minAge time.Duration) FindTopologyAssignmentOption {
+	return func(o *findTopologyAssignmentOptions) {
+		o.readyBefore = ptr.To(time.Now().Add(-minAge))
+	}
+}
+
+// WithGPUHourBudget caps the GPU-hours committed to any domain in the rolling
+// window, including the committed ones as returned by GPUHourLedger.Committed.
+func WithGPUHourBudget(budget float64, committed map[utiltas.TopologyDomainID]float64, estimatedDuration time.Duration) FindTopologyAssignmentOption {
+	return func(o *findTopologyAssignmentOptions) {
+		o.gpuHourBudget = ptr.To(budget)
+		o.committedGPUHours = committed
+		o.estimatedDuration = estimatedDuration
+	}
+}
+
+// WithContext sets the context of the scheduling cycle, which holds the parent
+// of the span and cancels the parallel evaluation of the domains.
+func WithContext(ctx context.Context) FindTopologyAssignmentOption {
+	return func(o *findTopologyAssignmentOptions) {
+		o.ctx = ctx
+	}
+}
+
+// WithParallelThreshold sets the number of the top level domains from which
+// the domains are evaluated in parallel, 0 disables it.
+func WithParallelThreshold(threshold int) FindTopologyAssignmentOption {
+	return func(o *findTopologyAssignmentOptions) {
+		o.parallelThreshold = ptr.To(threshold)
+	}
+}
+
+// nodeInfo holds the information about a node required to exclude it from
+// an assignment.
+type nodeInfo struct {
+	// domainID is the ID of the lowest level domain containing the node
+	domainID utiltas.TopologyDomainID
+
+	// capacity is the capacity of the node
+	capacity resources.Requests
+
+	// labels are the labels of the node
+	labels map[string]string
+
+	// nvlinkGroups is the number of GPUs in each NVLink group of the node
+	nvlinkGroups []int64
+
+	// taints are the taints of the node
+	taints []corev1.Taint
+
+	// pending indicates the node is expected to join the cluster
+	pending bool
+
+	// readySince is the time at which the node became Ready, it is zero if
+	// the node is not Ready
+	readySince time.Time
+
+	// host is the name of the node which the NVLink group belongs to, for the
+	// nodes of the sub-host level domains, it is empty otherwise
+	host string
+}
+
 type TASFlavorSnapshot struct {
 	log logr.Logger
 
@@ -63,6 +629,13 @@ type TASFlavorSnapshot struct {
 	// lowest level of topology
 	freeCapacityPerDomain map[utiltas.TopologyDomainID]resources.Requests
 
+	// capacityPerDomain stores the total capacity per domain, only for the
+	// lowest level of topology
+	capacityPerDomain map[utiltas.TopologyDomainID]resources.Requests
+
+	// nodes stores the information about the nodes, by node name
+	nodes map[string]nodeInfo
+
 	// levelValuesPerDomain stores the mapping from domain ID back to the
 	// ordered list of values. It stores the information for all levels.
 	levelValuesPerDomain map[utiltas.TopologyDomainID][]string
@@ -70,6 +643,20 @@ type TASFlavorSnapshot struct {
 	// domainsPerLevel stores the static tree information
 	domainsPerLevel []domainByID
 
+	// provisionalDomains stores the lowest level domains which rely on the
+	// capacity of pending nodes.
+	provisionalDomains sets.Set[utiltas.TopologyDomainID]
+
+	// nodesMissingLevels stores the label levels missing on the nodes which
+	// match the node labels of the flavor, by the node name. Such nodes are
+	// excluded from the snapshot.
+	nodesMissingLevels map[string][]string
+
+	// nodesWithDuplicateHost stores the value of the host label shared by
+	// the nodes with another node, by the node name. Such nodes are excluded
+	// from the snapshot.
+	nodesWithDuplicateHost map[string]string
+
 	// statePerLevel is a temporary state of the topology domains during the
 	// assignment algorithm.
 	//
@@ -81,6 +668,59 @@ type TASFlavorSnapshot struct {
 	// determine the actual assignments) it denotes the number of pods actually
 	// assigned to the given domain.
 	state statePerDomain
+
+	// nodeState is a temporary state of the nodes during the assignment
+	// algorithm, denoting the number of pods which can fit on a given node.
+	nodeState map[string]int32
+
+	// nodesPerDomain stores the names of the nodes in each lowest level domain
+	nodesPerDomain map[utiltas.TopologyDomainID][]string
+
+	// version is increased on every change to the domain tree or to the
+	// capacity of the domains. The cached aggregates carry the version they
+	// were computed at, and are recomputed lazily when it is stale.
+	version uint64
+
+	// cachedCapacityPerLevel caches the result of capacityPerLevel.
+	cachedCapacityPerLevel *versionedCapacityPerLevel
+
+	// cachedAssignments caches the results of FindTopologyAssignment, keyed
+	// by the fingerprint of the query and its options.
+	cachedAssignments *versionedAssignments
+
+	// assignmentCacheHits is the number of the FindTopologyAssignment calls
+	// served from the cachedAssignments.
+	assignmentCacheHits int
+
+	// compactionThreshold is the average fragmentation of the lowest level
+	// domains above which the assignment packs the pods tightly, to compact
+	// the flavor.
+	compactionThreshold *float64
+
+	// externalReservations holds the capacity reserved by other controllers
+	// in the topology domains, at any level, keyed by the domain ID.
+	externalReservations map[utiltas.TopologyDomainID]resources.Requests
+
+	// parallelThreshold is the number of the top level domains from which
+	// the subtrees of the top level domains are evaluated in parallel. The
+	// domains are always evaluated serially if it is 0.
+	parallelThreshold int
+}
+
+type versionedAssignments struct {
+	version uint64
+	results map[string]cachedAssignment
+}
+
+type cachedAssignment struct {
+	assignment *kueue.TopologyAssignment
+	levelKey   string
+	err        error
+}
+
+type versionedCapacityPerLevel struct {
+	version  uint64
+	capacity [][]DomainCapacity
 }
 
 func newTASFlavorSnapshot(log logr.Logger, levels []string) *TASFlavorSnapshot {
@@ -88,9 +728,17 @@ func newTASFlavorSnapshot(log logr.Logger, levels []string) *TASFlavorSnapshot {
 		log:                   log,
 		levelKeys:             slices.Clone(levels),
 		freeCapacityPerDomain: make(map[utiltas.TopologyDomainID]resources.Requests),
+		capacityPerDomain:     make(map[utiltas.TopologyDomainID]resources.Requests),
+		nodes:                 make(map[string]nodeInfo),
+		nodesPerDomain:        make(map[utiltas.TopologyDomainID][]string),
 		levelValuesPerDomain:  make(map[utiltas.TopologyDomainID][]string),
 		domainsPerLevel:       make([]domainByID, len(levels)),
+		provisionalDomains:    sets.New[utiltas.TopologyDomainID](),
 		state:                 make(statePerDomain),
+		nodeState:             make(map[string]int32),
+	}
+	if runtime.GOMAXPROCS(0) > 1 {
+		snapshot.parallelThreshold = parallelDomainsThreshold
 	}
 	return snapshot
 }
@@ -128,9 +776,11 @@ func (s *TASFlavorSnapshot) initialize() {
 			}
 			childDomain.parentID = parentID
 			parent.childIDs = append(parent.childIDs, childID)
+			childDomain = parent
 			childID = parentID
 		}
 	}
+	s.version++
 }
 
 func (s *TASFlavorSnapshot) sortName(levelIdx int, domainID utiltas.TopologyDomainID) string {
@@ -151,107 +801,2525 @@ func (s *TASFlavorSnapshot) sortName(levelIdx int, domainID utiltas.TopologyDoma
 func (s *TASFlavorSnapshot) addCapacity(domainID utiltas.TopologyDomainID, capacity resources.Requests) {
 	s.initializeFreeCapacityPerDomain(domainID)
 	s.freeCapacityPerDomain[domainID].Add(capacity)
+	if _, found := s.capacityPerDomain[domainID]; !found {
+		s.capacityPerDomain[domainID] = resources.Requests{}
+	}
+	s.capacityPerDomain[domainID].Add(capacity)
+	s.version++
 }
 
-func (s *TASFlavorSnapshot) addUsage(domainID utiltas.TopologyDomainID, usage resources.Requests) {
-	s.initializeFreeCapacityPerDomain(domainID)
-	s.freeCapacityPerDomain[domainID].Sub(usage)
+func (s *TASFlavorSnapshot) addNode(name string, domainID utiltas.TopologyDomainID, capacity resources.Requests, labels map[string]string, taints []corev1.Taint, nvlinkGroups []int64, readySince time.Time) {
+	s.nodes[name] = nodeInfo{
+		domainID:     domainID,
+		capacity:     capacity,
+		labels:       labels,
+		taints:       taints,
+		nvlinkGroups: nvlinkGroups,
+		readySince:   readySince,
+	}
+	s.nodesPerDomain[domainID] = append(s.nodesPerDomain[domainID], name)
+	s.addCapacity(domainID, capacity)
 }
 
-func (s *TASFlavorSnapshot) initializeFreeCapacityPerDomain(domainID utiltas.TopologyDomainID) {
-	if _, found := s.freeCapacityPerDomain[domainID]; !found {
-		s.freeCapacityPerDomain[domainID] = resources.Requests{}
+func (s *TASFlavorSnapshot) addPendingNode(name string, domainID utiltas.TopologyDomainID, capacity resources.Requests, labels map[string]string) {
+	s.addNode(name, domainID, capacity, labels, nil, nil, time.Time{})
+	node := s.nodes[name]
+	node.pending = true
+	s.nodes[name] = node
+	s.provisionalDomains.Insert(domainID)
+}
+
+// excludedCapacityPerDomain returns the capacity of the excluded nodes,
+// aggregated per the lowest level domain.
+func (s *TASFlavorSnapshot) excludedCapacityPerDomain(excludedNodes sets.Set[string]) map[utiltas.TopologyDomainID]resources.Requests {
+	result := make(map[utiltas.TopologyDomainID]resources.Requests)
+	for nodeName := range excludedNodes {
+		node, found := s.nodes[nodeName]
+		if !found {
+			continue
+		}
+		if _, found := result[node.domainID]; !found {
+			result[node.domainID] = resources.Requests{}
+		}
+		result[node.domainID].Add(node.capacity)
 	}
+	return result
 }
 
-// Algorithm overview:
-// Phase 1:
-//
-//	determine pod counts for each topology domain. Start at the lowest level
-//	and bubble up the numbers to the top level
-//
-// Phase 2:
-//
-//	a) select the domain at requested level with count >= requestedCount
-//	b) traverse the structure down level-by-level optimizing the number of used
-//	  domains at each level
-func (s *TASFlavorSnapshot) FindTopologyAssignment(
-	topologyRequest *kueue.PodSetTopologyRequest,
-	requests resources.Requests,
-	count int32) *kueue.TopologyAssignment {
-	required := topologyRequest.Required != nil
-	levelIdx, found := s.resolveLevelIdx(topologyRequest)
-	if !found {
+// nvlinkLimitPerDomain returns the maximal number of pods requesting
+// multiple GPUs which fit in the lowest level domains, when all GPUs of a pod
+// need to fit within a single NVLink group of a node. The limit is based on
+// the capacity of the nodes, and is only returned for the domains containing
+// nodes with NVLink groups.
+func (s *TASFlavorSnapshot) nvlinkLimitPerDomain(requests, granularity resources.Requests, excludedNodes sets.Set[string]) map[utiltas.TopologyDomainID]int32 {
+	gpusPerPod := requests[gpuResourceName]
+	if gpusPerPod <= 1 {
 		return nil
 	}
-	// phase 1 - determine the number of pods which can fit in each topology domain
-	s.fillInCounts(requests)
-
-	// phase 2a: determine the level at which the assignment is done along with
-	// the domains which can accommodate all pods
-	fitLevelIdx, currFitDomain := s.findLevelWithFitDomains(levelIdx, required, count)
-	if len(currFitDomain) == 0 {
+	nodeCounts := make(map[utiltas.TopologyDomainID]int32)
+	groupedDomains := sets.New[utiltas.TopologyDomainID]()
+	for nodeName, node := range s.nodes {
+		if excludedNodes.Has(nodeName) {
+			continue
+		}
+		nodeCount := requests.CountIn(roundDown(node.capacity, granularity))
+		if len(node.nvlinkGroups) > 0 {
+			var groupCount int32
+			for _, groupSize := range node.nvlinkGroups {
+				groupCount += int32(groupSize / gpusPerPod)
+			}
+			nodeCount = min(nodeCount, groupCount)
+			groupedDomains.Insert(node.domainID)
+		}
+		nodeCounts[node.domainID] += nodeCount
+	}
+	if groupedDomains.Len() == 0 {
 		return nil
 	}
-
-	// phase 2b: traverse the tree down level-by-level optimizing the number of
-	// topology domains at each level
-	currFitDomain = s.updateCountsToMinimum(currFitDomain, count)
-	for levelIdx := fitLevelIdx; levelIdx+1 < len(s.domainsPerLevel); levelIdx++ {
-		lowerFitDomains := s.lowerLevelDomains(levelIdx, currFitDomain)
-		sortedLowerDomains := s.sortedDomains(lowerFitDomains)
-		currFitDomain = s.updateCountsToMinimum(sortedLowerDomains, count)
+	result := make(map[utiltas.TopologyDomainID]int32, groupedDomains.Len())
+	for domainID := range groupedDomains {
+		result[domainID] = nodeCounts[domainID]
 	}
-	return s.buildAssignment(currFitDomain)
+	return result
 }
 
-func (s *TASFlavorSnapshot) resolveLevelIdx(
-	topologyRequest *kueue.PodSetTopologyRequest) (int, bool) {
-	var levelKey string
-	if topologyRequest.Required != nil {
-		levelKey = *topologyRequest.Required
-	} else if topologyRequest.Preferred != nil {
-		levelKey = *topologyRequest.Preferred
+// withPartitions returns the names of the nodes along with the names of
+// their NVLink groups, for the nodes partitioned at the sub-host level.
+func (s *TASFlavorSnapshot) withPartitions(nodeNames sets.Set[string]) sets.Set[string] {
+	if nodeNames.Len() == 0 {
+		return nodeNames
 	}
-	levelIdx := slices.Index(s.levelKeys, levelKey)
-	if levelIdx == -1 {
-		return levelIdx, false
+	result := nodeNames.Clone()
+	for name, node := range s.nodes {
+		if node.host != "" && nodeNames.Has(node.host) {
+			result.Insert(name)
+		}
 	}
-	return levelIdx, true
+	return result
 }
 
-func (s *TASFlavorSnapshot) findLevelWithFitDomains(levelIdx int, required bool, count int32) (int, []*domain) {
-	levelDomains := s.domainsForLevel(levelIdx)
-	if len(levelDomains) == 0 {
-		return 0, nil
-	}
-	sortedDomain := s.sortedDomains(levelDomains)
-	topDomain := sortedDomain[0]
-	if s.state[topDomain.id] < count {
-		if required {
-			return 0, nil
+// nodesInDomains returns the names of the nodes in the domains with the
+// given values, per topology level key.
+func (s *TASFlavorSnapshot) nodesInDomains(domains map[string][]string) sets.Set[string] {
+	result := sets.New[string]()
+	for nodeName, node := range s.nodes {
+		levelValues := s.levelValuesPerDomain[node.domainID]
+		for levelKey, values := range domains {
+			if levelIdx := slices.Index(s.levelKeys, levelKey); levelIdx >= 0 && slices.Contains(values, levelValues[levelIdx]) {
+				result.Insert(nodeName)
+				break
+			}
 		}
-		if levelIdx > 0 {
-			return s.findLevelWithFitDomains(levelIdx-1, required, count)
+	}
+	return result
+}
+
+// untoleratedNodes returns the names of the nodes with a NoSchedule or
+// NoExecute taint which isn't tolerated.
+func (s *TASFlavorSnapshot) untoleratedNodes(tolerations []corev1.Toleration) sets.Set[string] {
+	result := sets.New[string]()
+	for nodeName, node := range s.nodes {
+		_, untolerated := corev1helpers.FindMatchingUntoleratedTaint(node.taints, tolerations, func(t *corev1.Taint) bool {
+			return t.Effect == corev1.TaintEffectNoSchedule || t.Effect == corev1.TaintEffectNoExecute
+		})
+		if untolerated {
+			result.Insert(nodeName)
 		}
-		lastIdx := 0
-		remainingCount := count - s.state[sortedDomain[lastIdx].id]
-		for remainingCount > 0 && lastIdx < len(sortedDomain)-1 {
-			lastIdx++
-			remainingCount -= s.state[sortedDomain[lastIdx].id]
+	}
+	return result
+}
+
+// excessPendingNodes returns the names of the pending nodes which exceed the
+// maxNodes limit. The pending nodes which can accommodate the most pods are
+// kept within the limit, and ties are resolved by the node name.
+func (s *TASFlavorSnapshot) excessPendingNodes(requests resources.Requests, maxNodes int32, excludedNodes sets.Set[string]) sets.Set[string] {
+	var pendingNodes []string
+	for nodeName, node := range s.nodes {
+		if node.pending && !excludedNodes.Has(nodeName) {
+			pendingNodes = append(pendingNodes, nodeName)
 		}
-		if remainingCount > 0 {
-			return 0, nil
+	}
+	s.sortNodesByCount(requests, pendingNodes)
+	return sets.New(pendingNodes[min(max(int(maxNodes), 0), len(pendingNodes)):]...)
+}
+
+// spareNodes returns the names of the nodes reserved as spares in each
+// domain at the given level. The nodes which can accommodate the most pods
+// are reserved, and ties are resolved by the node name.
+func (s *TASFlavorSnapshot) spareNodes(requests resources.Requests, levelIdx int, spares int32, excludedNodes sets.Set[string]) sets.Set[string] {
+	nodesPerDomain := make(map[utiltas.TopologyDomainID][]string)
+	for nodeName, node := range s.nodes {
+		if excludedNodes.Has(nodeName) {
+			continue
 		}
-		return 0, sortedDomain[:lastIdx+1]
+		domainID := utiltas.DomainID(s.levelValuesPerDomain[node.domainID][:levelIdx+1])
+		nodesPerDomain[domainID] = append(nodesPerDomain[domainID], nodeName)
+	}
+	result := sets.New[string]()
+	for _, nodeNames := range nodesPerDomain {
+		s.sortNodesByCount(requests, nodeNames)
+		result.Insert(nodeNames[:min(int(spares), len(nodeNames))]...)
 	}
-	return levelIdx, []*domain{topDomain}
+	return result
 }
 
-func (s *TASFlavorSnapshot) updateCountsToMinimum(domains []*domain, count int32) []*domain {
-	result := make([]*domain, 0)
-	remainingCount := count
-	for i := 0; i < len(domains); i++ {
+// sortNodesByCount sorts the nodes by the number of pods they can
+// accommodate, in the descending order, and then by the node name.
+func (s *TASFlavorSnapshot) sortNodesByCount(requests resources.Requests, nodeNames []string) {
+	slices.SortFunc(nodeNames, func(a, b string) int {
+		aCount := requests.CountIn(s.nodes[a].capacity)
+		bCount := requests.CountIn(s.nodes[b].capacity)
+		switch {
+		case aCount == bCount:
+			return strings.Compare(a, b)
+		case aCount > bCount:
+			return -1
+		default:
+			return 1
+		}
+	})
+}
+
+func (s *TASFlavorSnapshot) addUsage(domainID utiltas.TopologyDomainID, usage resources.Requests) {
+	s.initializeFreeCapacityPerDomain(domainID)
+	s.freeCapacityPerDomain[domainID].Sub(usage)
+	s.version++
+}
+
+func (s *TASFlavorSnapshot) removeUsage(domainID utiltas.TopologyDomainID, usage resources.Requests) {
+	s.initializeFreeCapacityPerDomain(domainID)
+	s.freeCapacityPerDomain[domainID].Add(usage)
+	s.version++
+}
+
+func (s *TASFlavorSnapshot) initializeFreeCapacityPerDomain(domainID utiltas.TopologyDomainID) {
+	if _, found := s.freeCapacityPerDomain[domainID]; !found {
+		s.freeCapacityPerDomain[domainID] = resources.Requests{}
+	}
+}
+
+// WithLocalityBudget relaxes the requested level by the fraction of the levels
+// above it, from 0 (a single requested domain) to 1 (the entire topology).
+func WithLocalityBudget(budget float64) FindTopologyAssignmentOption {
+	return func(o *findTopologyAssignmentOptions) {
+		o.localityBudget = ptr.To(min(max(budget, 0), 1))
+	}
+}
+
+// DomainCapacity holds the total and free capacity of a topology domain.
+type DomainCapacity struct {
+	// Values are the ordered label values identifying the domain.
+	Values []string
+
+	// Total is the capacity of the nodes in the domain.
+	Total resources.Requests
+
+	// Free is the capacity of the nodes in the domain which is not used by
+	// the admitted workloads.
+	Free resources.Requests
+}
+
+// capacityPerLevel returns the total and free capacity of the domains at
+// each topology level. The domains at each level are sorted by their values.
+// The result is cached until the snapshot changes, so it must not be
+// modified by the caller.
+func (s *TASFlavorSnapshot) capacityPerLevel() [][]DomainCapacity {
+	if s.cachedCapacityPerLevel == nil || s.cachedCapacityPerLevel.version != s.version {
+		s.cachedCapacityPerLevel = &versionedCapacityPerLevel{
+			version:  s.version,
+			capacity: s.computeCapacityPerLevel(),
+		}
+	}
+	return s.cachedCapacityPerLevel.capacity
+}
+
+func (s *TASFlavorSnapshot) computeCapacityPerLevel() [][]DomainCapacity {
+	result := make([][]DomainCapacity, len(s.levelKeys))
+	capacities := make(map[utiltas.TopologyDomainID]*DomainCapacity)
+	lastLevelIdx := len(s.domainsPerLevel) - 1
+	for levelIdx := lastLevelIdx; levelIdx >= 0; levelIdx-- {
+		for domainID, domain := range s.domainsPerLevel[levelIdx] {
+			capacity := &DomainCapacity{
+				Values: slices.Clone(s.levelValuesPerDomain[domainID]),
+				Total:  resources.Requests{},
+				Free:   resources.Requests{},
+			}
+			if levelIdx == lastLevelIdx {
+				capacity.Total.Add(s.capacityPerDomain[domainID])
+				capacity.Free.Add(s.freeCapacityPerDomain[domainID])
+			}
+			for _, childID := range domain.childIDs {
+				capacity.Total.Add(capacities[childID].Total)
+				capacity.Free.Add(capacities[childID].Free)
+			}
+			capacities[domainID] = capacity
+			result[levelIdx] = append(result[levelIdx], *capacity)
+		}
+		slices.SortFunc(result[levelIdx], func(a, b DomainCapacity) int {
+			return slices.Compare(a.Values, b.Values)
+		})
+	}
+	return result
+}
+
+// DomainFreeCapacity returns the free capacity summed over the nodes of each
+// domain at the given topology level, keyed by the domain ID. The capacity is
+// accounted the same way as by FindTopologyAssignment, so it only includes
+// the nodes matching the flavor and excludes the usage of the admitted
+// workloads. It returns nil if the level is not defined in the topology.
+func (s *TASFlavorSnapshot) DomainFreeCapacity(level string) map[string]resources.Requests {
+	levelIdx := slices.Index(s.levelKeys, level)
+	if levelIdx < 0 {
+		return nil
+	}
+	domains := s.capacityPerLevel()[levelIdx]
+	result := make(map[string]resources.Requests, len(domains))
+	for _, domain := range domains {
+		result[string(utiltas.DomainID(domain.Values))] = domain.Free.Clone()
+	}
+	return result
+}
+
+// Algorithm overview:
+// Phase 1:
+//
+//	determine pod counts for each topology domain. Start at the lowest level
+//	and bubble up the numbers to the top level
+//
+// Phase 2:
+//
+//	a) select the domain at requested level with count >= requestedCount
+//	b) traverse the structure down level-by-level optimizing the number of used
+//	  domains at each level
+//
+// All the requested resources are consumable dimensions of the capacity,
+// including the extended resources advertised by the nodes, such as per-node
+// license tokens. The nodes which don't advertise a requested resource don't
+// contribute any capacity for the workload.
+//
+// The assignment is recorded as an OpenTelemetry span, holding the shape of
+// the request, the result, and the level at which the pods fit.
+//
+// The results are cached until the snapshot changes, as the same queries
+// recur within a scheduling cycle. The calls with a capacity buffer are never
+// cached, as the function can't be compared.
+func (s *TASFlavorSnapshot) FindTopologyAssignment(
+	topologyRequest *kueue.PodSetTopologyRequest,
+	requests resources.Requests,
+	count int32,
+	opts ...FindTopologyAssignmentOption) (*kueue.TopologyAssignment, error) {
+	options := &findTopologyAssignmentOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	key, cacheable := assignmentCacheKey(topologyRequest, requests, count, options)
+	if !cacheable {
+		assignment, _, err := s.findTopologyAssignmentTraced(topologyRequest, requests, count, options)
+		return assignment, err
+	}
+	if s.cachedAssignments == nil || s.cachedAssignments.version != s.version {
+		s.cachedAssignments = &versionedAssignments{
+			version: s.version,
+			results: make(map[string]cachedAssignment),
+		}
+	} else if result, found := s.cachedAssignments.results[key]; found {
+		s.assignmentCacheHits++
+		span := startTopologyAssignmentSpan(options.ctx, topologyRequest, requests, count)
+		reportTopologyAssignment(topologyRequest, time.Now(), result.err)
+		var domains int
+		if result.assignment != nil {
+			domains = len(result.assignment.Domains)
+		}
+		endCachedTopologyAssignmentSpan(span, domains, result.levelKey, result.err)
+		return result.assignment.DeepCopy(), result.err
+	}
+	assignment, fitLevelKey, err := s.findTopologyAssignmentTraced(topologyRequest, requests, count, options)
+	// the search is cut short when the scheduling cycle is cancelled, so its
+	// result isn't the answer to the query
+	if s.cachedAssignments.version == s.version && (options.ctx == nil || options.ctx.Err() == nil) {
+		s.cachedAssignments.results[key] = cachedAssignment{
+			assignment: assignment.DeepCopy(),
+			levelKey:   fitLevelKey,
+			err:        err,
+		}
+	}
+	return assignment, err
+}
+
+// AssignmentCacheHits returns the number of the FindTopologyAssignment calls
+// served from the cache of the snapshot.
+func (s *TASFlavorSnapshot) AssignmentCacheHits() int {
+	return s.assignmentCacheHits
+}
+
+// assignmentCacheKey returns the fingerprint of the FindTopologyAssignment
+// query, consisting of the topology request, the requests, the count and the
+// options. It returns false if the query can't be cached.
+func assignmentCacheKey(
+	topologyRequest *kueue.PodSetTopologyRequest,
+	requests resources.Requests,
+	count int32,
+	options *findTopologyAssignmentOptions) (string, bool) {
+	if options.capacityBuffer != nil {
+		return "", false
+	}
+	// the context only carries the span and the cancellation, which don't
+	// change the assignment
+	keyOptions := *options
+	keyOptions.ctx = nil
+	return dump.ForHash(struct {
+		TopologyRequest *kueue.PodSetTopologyRequest
+		Requests        resources.Requests
+		Count           int32
+		Options         findTopologyAssignmentOptions
+	}{topologyRequest, requests, count, keyOptions}), true
+}
+
+func (s *TASFlavorSnapshot) findTopologyAssignmentTraced(
+	topologyRequest *kueue.PodSetTopologyRequest,
+	requests resources.Requests,
+	count int32,
+	options *findTopologyAssignmentOptions) (*kueue.TopologyAssignment, string, error) {
+	span := startTopologyAssignmentSpan(options.ctx, topologyRequest, requests, count)
+	start := time.Now()
+	leaves, fitLevelIdx, err := s.findTopologyAssignment(topologyRequest, requests, count, options)
+	reportTopologyAssignment(topologyRequest, start, err)
+	var assignment *kueue.TopologyAssignment
+	var fitLevelKey string
+	if err == nil {
+		assignment = s.buildAssignment(leaves)
+		fitLevelKey = s.levelKeys[fitLevelIdx]
+	}
+	endTopologyAssignmentSpan(span, len(leaves), fitLevelKey, err)
+	return assignment, fitLevelKey, err
+}
+
+// FindTopologyAssignmentFunc finds the same assignment as
+// FindTopologyAssignment, but rather than building the list of the domains
+// upfront, it calls yield for each of the domains, in the order of their
+// values. It stops early if yield returns false. This avoids holding the
+// whole assignment in memory for the workloads with many pods.
+func (s *TASFlavorSnapshot) FindTopologyAssignmentFunc(
+	topologyRequest *kueue.PodSetTopologyRequest,
+	requests resources.Requests,
+	count int32,
+	yield func(kueue.TopologyDomainAssignment) bool,
+	opts ...FindTopologyAssignmentOption) error {
+	options := &findTopologyAssignmentOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	span := startTopologyAssignmentSpan(options.ctx, topologyRequest, requests, count)
+	start := time.Now()
+	leaves, fitLevelIdx, err := s.findTopologyAssignment(topologyRequest, requests, count, options)
+	reportTopologyAssignment(topologyRequest, start, err)
+	var fitLevelKey string
+	if err == nil {
+		fitLevelKey = s.levelKeys[fitLevelIdx]
+	}
+	endTopologyAssignmentSpan(span, len(leaves), fitLevelKey, err)
+	if err != nil {
+		return err
+	}
+	slices.SortFunc(leaves, func(a, b *domain) int {
+		return slices.Compare(s.levelValuesPerDomain[a.id], s.levelValuesPerDomain[b.id])
+	})
+	for _, leaf := range leaves {
+		if !yield(kueue.TopologyDomainAssignment{
+			Values: s.asLevelValues(leaf.id),
+			Count:  s.state[leaf.id],
+		}) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// findTopologyAssignmentWithFallback requires the pods to fit within a single
+// domain at the required level, or else at the first of the fallback levels
+// which accommodates them.
+func (s *TASFlavorSnapshot) findTopologyAssignmentWithFallback(
+	topologyRequest *kueue.PodSetTopologyRequest,
+	requests resources.Requests,
+	count int32,
+	options *findTopologyAssignmentOptions) ([]*domain, int, error) {
+	levelKeys := append([]string{*topologyRequest.Required}, topologyRequest.RequiredFallback...)
+	for _, levelKey := range levelKeys {
+		levelRequest := topologyRequest.DeepCopy()
+		levelRequest.Required = ptr.To(levelKey)
+		levelRequest.RequiredFallback = nil
+		leaves, fitLevelIdx, err := s.findTopologyAssignment(levelRequest, requests, count, options)
+		var assignmentErr *TopologyAssignmentError
+		if err == nil || !errors.As(err, &assignmentErr) || assignmentErr.Reason != TopologyNotFit {
+			return leaves, fitLevelIdx, err
+		}
+	}
+	return nil, 0, &TopologyAssignmentError{
+		Reason:  TopologyNotFit,
+		Message: fmt.Sprintf("cannot fit %d pods within a single domain at any of the levels %v", count, levelKeys),
+	}
+}
+
+// findTopologyAssignmentWithSoftLimits finds the assignment respecting the
+// capacity of the soft limit resources, or else the assignment ignoring it,
+// if the pods don't fit otherwise.
+func (s *TASFlavorSnapshot) findTopologyAssignmentWithSoftLimits(
+	topologyRequest *kueue.PodSetTopologyRequest,
+	requests resources.Requests,
+	count int32,
+	options *findTopologyAssignmentOptions) ([]*domain, int, error) {
+	strictOptions := *options
+	strictOptions.softLimits = nil
+	leaves, fitLevelIdx, err := s.findTopologyAssignment(topologyRequest, requests, count, &strictOptions)
+	var assignmentErr *TopologyAssignmentError
+	if err == nil || !errors.As(err, &assignmentErr) || !isCapacityReason(assignmentErr.Reason) {
+		return leaves, fitLevelIdx, err
+	}
+	// the options are copied again, as the first attempt modifies them
+	relaxedOptions := *options
+	relaxedOptions.softLimits = nil
+	relaxedRequests := requests.Clone()
+	for resourceName := range options.softLimits {
+		delete(relaxedRequests, resourceName)
+	}
+	return s.findTopologyAssignment(topologyRequest, relaxedRequests, count, &relaxedOptions)
+}
+
+// findTopologyAssignmentWithinAxes tries the combinations of the values of the
+// required axes present on the nodes, in the order of the values, and returns
+// the first assignment using only the nodes with the combination of values.
+func (s *TASFlavorSnapshot) findTopologyAssignmentWithinAxes(
+	topologyRequest *kueue.PodSetTopologyRequest,
+	requests resources.Requests,
+	count int32,
+	options *findTopologyAssignmentOptions) ([]*domain, int, error) {
+	nodesPerCombination := make(map[utiltas.TopologyDomainID]sets.Set[string])
+	for nodeName, node := range s.nodes {
+		values := make([]string, 0, len(options.requiredAxes))
+		for _, labelKey := range options.requiredAxes {
+			value, found := node.labels[labelKey]
+			if !found {
+				break
+			}
+			values = append(values, value)
+		}
+		if len(values) < len(options.requiredAxes) {
+			continue
+		}
+		combination := utiltas.DomainID(values)
+		if _, found := nodesPerCombination[combination]; !found {
+			nodesPerCombination[combination] = sets.New[string]()
+		}
+		nodesPerCombination[combination].Insert(nodeName)
+	}
+	allNodes := sets.KeySet(s.nodes)
+	for _, combination := range slices.Sorted(maps.Keys(nodesPerCombination)) {
+		axesOptions := *options
+		axesOptions.requiredAxes = nil
+		axesOptions.excludedNodes = options.excludedNodes.Union(allNodes.Difference(nodesPerCombination[combination]))
+		leaves, fitLevelIdx, err := s.findTopologyAssignment(topologyRequest, requests, count, &axesOptions)
+		var assignmentErr *TopologyAssignmentError
+		if err == nil || !errors.As(err, &assignmentErr) || !isCapacityReason(assignmentErr.Reason) {
+			return leaves, fitLevelIdx, err
+		}
+	}
+	return nil, 0, &TopologyAssignmentError{
+		Reason:  TopologyNotFit,
+		Message: fmt.Sprintf("cannot fit %d pods within a single value of each of the labels %v", count, options.requiredAxes),
+	}
+}
+
+// findTopologyAssignmentWithEqualSplit tries the numbers of pods per lowest
+// level domain which divide count, from the largest one, and returns the
+// first assignment using exactly that number of pods in each of its lowest
+// level domains.
+func (s *TASFlavorSnapshot) findTopologyAssignmentWithEqualSplit(
+	topologyRequest *kueue.PodSetTopologyRequest,
+	requests resources.Requests,
+	count int32,
+	options *findTopologyAssignmentOptions) ([]*domain, int, error) {
+	for perDomain := count; perDomain > 0; perDomain-- {
+		if count%perDomain != 0 {
+			continue
+		}
+		equalOptions := *options
+		equalOptions.equalPerDomain = false
+		equalOptions.equalCount = perDomain
+		// the adjustment of the counts to avoid splinters breaks the split
+		equalOptions.noSplinterThreshold = 0
+		leaves, fitLevelIdx, err := s.findTopologyAssignment(topologyRequest, requests, count, &equalOptions)
+		var assignmentErr *TopologyAssignmentError
+		if err != nil && (!errors.As(err, &assignmentErr) || !isCapacityReason(assignmentErr.Reason)) {
+			return nil, 0, err
+		}
+		if err == nil && !slices.ContainsFunc(leaves, func(leaf *domain) bool { return s.state[leaf.id] != perDomain }) {
+			return leaves, fitLevelIdx, nil
+		}
+	}
+	return nil, 0, &TopologyAssignmentError{
+		Reason:  TopologyNotFit,
+		Message: fmt.Sprintf("cannot split %d pods equally across the lowest level domains", count),
+	}
+}
+
+// findTopologyAssignment implements FindTopologyAssignment, it returns the
+// lowest level domains with the assigned pods, along with the index of the
+// level at which the pods fit.
+func (s *TASFlavorSnapshot) findTopologyAssignment(
+	topologyRequest *kueue.PodSetTopologyRequest,
+	requests resources.Requests,
+	count int32,
+	options *findTopologyAssignmentOptions) ([]*domain, int, error) {
+	// The state derived for this call, such as the index of the max domains
+	// level or the per-domain scores, is set on a copy, leaving the options
+	// of the caller unchanged.
+	callOptions := *options
+	options = &callOptions
+	if len(options.requiredAxes) > 0 {
+		return s.findTopologyAssignmentWithinAxes(topologyRequest, requests, count, options)
+	}
+	if options.equalPerDomain {
+		return s.findTopologyAssignmentWithEqualSplit(topologyRequest, requests, count, options)
+	}
+	if len(options.softLimits) > 0 {
+		return s.findTopologyAssignmentWithSoftLimits(topologyRequest, requests, count, options)
+	}
+	if topologyRequest.Required != nil && len(topologyRequest.RequiredFallback) > 0 {
+		return s.findTopologyAssignmentWithFallback(topologyRequest, requests, count, options)
+	}
+	levelIdx, err := s.requestedLevelIdx(topologyRequest)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := s.resolveMaxDomainsLevelIdx(options); err != nil {
+		return nil, 0, err
+	}
+	if options.maxPodsPerDomainLevelKey != "" && !slices.Contains(s.levelKeys, options.maxPodsPerDomainLevelKey) {
+		return nil, 0, &TopologyAssignmentError{
+			Reason:  InvalidTopologyLevel,
+			Message: fmt.Sprintf("topology level %q is not defined for the flavor, the levels are: %v", options.maxPodsPerDomainLevelKey, s.levelKeys),
+			Level:   options.maxPodsPerDomainLevelKey,
+		}
+	}
+	for _, levelKey := range slices.Sorted(maps.Keys(options.excludedDomains)) {
+		if !slices.Contains(s.levelKeys, levelKey) {
+			return nil, 0, &TopologyAssignmentError{
+				Reason:  InvalidTopologyLevel,
+				Message: fmt.Sprintf("topology level %q is not defined for the flavor, the levels are: %v", levelKey, s.levelKeys),
+				Level:   levelKey,
+			}
+		}
+	}
+	if len(s.nodes) == 0 {
+		return nil, 0, &TopologyAssignmentError{
+			Reason:  NoMatchingNodes,
+			Message: "no nodes matching the flavor are available for the topology assignment",
+		}
+	}
+	options.tightPack = s.needsCompaction()
+	// The domains are chosen to accommodate the burst, while the assignment
+	// only holds the pods of the PodSet, leaving the headroom free.
+	fitCount := max(count, options.burstCount)
+	minLevelIdx := s.resolveMinLevelIdx(topologyRequest, levelIdx, options)
+	if levelIdx, err = s.innerPreferredLevelIdx(topologyRequest, levelIdx); err != nil {
+		return nil, 0, err
+	}
+	// The pods which don't fit in the free capacity of the whole flavor are
+	// rejected before any of the domains is evaluated. The pods without any
+	// requests aren't constrained by the capacity, see fillInCounts, so they
+	// are never rejected here.
+	if unconstrained := len(requests) == 0; !unconstrained {
+		if resourceName, limitCount, found := s.limitingResource(requests, fitCount); found {
+			requested, available := s.bestDomainAvailability(requests, resourceName)
+			requestedQuantity := resources.ResourceQuantity(resourceName, requested)
+			availableQuantity := resources.ResourceQuantity(resourceName, available)
+			return nil, 0, &TopologyAssignmentError{
+				Reason: InsufficientClusterCapacity,
+				Message: fmt.Sprintf("cannot fit %d pods, the free %s capacity is enough for %d pods, a pod requests %s and at most %s is free in a single domain",
+					fitCount, resourceName, limitCount, requestedQuantity.String(), availableQuantity.String()),
+				Resource:  resourceName,
+				Requested: requested,
+				Available: available,
+			}
+		}
+	}
+	// phase 1 - determine the number of pods which can fit in each topology domain
+	s.fillInCounts(requests, fitCount, levelIdx, options)
+	if options.ctx != nil && options.ctx.Err() != nil {
+		// the counts may be incomplete if the parallel evaluation was stopped
+		return nil, 0, options.ctx.Err()
+	}
+	if len(options.resourceWeights) > 0 {
+		options.weightedFreeCapacity = s.weightedFreeCapacityPerDomain(options.resourceWeights)
+	}
+	if len(options.warmNodes) > 0 {
+		options.warmNodesPerDomain = s.countNodesPerDomain(s.withPartitions(options.warmNodes))
+	}
+	if options.preferredNode != "" {
+		options.preferredNodePerDomain = s.countNodesPerDomain(s.withPartitions(sets.New(options.preferredNode)))
+	}
+	if options.preferFullerDomains {
+		options.allocatedFractionPerDomain = s.allocatedFractionPerDomain()
+	}
+
+	// phase 2a: determine the level at which the assignment is done along with
+	// the domains which can accommodate all pods
+	fitLevelIdx, currFitDomain := s.findLevelWithFitDomains(levelIdx, minLevelIdx, fitCount, options)
+	if len(currFitDomain) == 0 {
+		if lowestLevelCount := s.lowestLevelCount(); lowestLevelCount < fitCount {
+			return nil, 0, &TopologyAssignmentError{
+				Reason:  InsufficientCapacity,
+				Message: fmt.Sprintf("cannot fit %d pods, the domains available to the assignment can accommodate %d pods", fitCount, lowestLevelCount),
+			}
+		}
+		return nil, 0, &TopologyAssignmentError{
+			Reason:  TopologyNotFit,
+			Message: fmt.Sprintf("cannot fit %d pods within the topology", fitCount),
+		}
+	}
+
+	assignLevelIdx := fitLevelIdx
+	if ptr.Deref(topologyRequest.Strategy, kueue.TopologyStrategyPack) == kueue.TopologyStrategySpread {
+		assignLevelIdx, currFitDomain = s.spreadDomains(topologyRequest, levelIdx, fitLevelIdx, currFitDomain, count, options)
+	}
+
+	// phase 2b: traverse the tree down level-by-level optimizing the number of
+	// topology domains at each level
+	currFitDomain = s.assignToLowerLevels(assignLevelIdx, currFitDomain, count, options)
+	if options.maxDomainsLevelKey != "" && s.domainsAtLevel(currFitDomain, options.maxDomainsLevelIdx) > options.maxDomains {
+		return nil, 0, &TopologyAssignmentError{
+			Reason:  TopologyNotFit,
+			Message: fmt.Sprintf("cannot fit %d pods within %d domains at level %q", count, options.maxDomains, options.maxDomainsLevelKey),
+		}
+	}
+	return currFitDomain, fitLevelIdx, nil
+}
+
+// spreadDomains distributes the pods round-robin across the domains at the
+// preferred level, or across the child domains of the domain fitting the
+// pods at the required level. The domains are visited in the order of their
+// free capacity, and each of them receives at most as many pods as it can
+// accommodate. It returns the level of the domains along with the domains
+// receiving the pods, whose state is set to the number of their pods.
+func (s *TASFlavorSnapshot) spreadDomains(
+	topologyRequest *kueue.PodSetTopologyRequest,
+	levelIdx, fitLevelIdx int,
+	fitDomains []*domain,
+	count int32,
+	options *findTopologyAssignmentOptions) (int, []*domain) {
+	spreadLevelIdx := levelIdx
+	candidates := s.domainsForLevel(levelIdx)
+	if topologyRequest.Required != nil {
+		if fitLevelIdx+1 == len(s.domainsPerLevel) {
+			return fitLevelIdx, fitDomains
+		}
+		spreadLevelIdx = fitLevelIdx + 1
+		candidates = s.lowerLevelDomains(fitLevelIdx, fitDomains)
+	}
+	sortedCandidates := s.sortedDomains(candidates, options)
+	assigned := make(map[utiltas.TopologyDomainID]int32, len(sortedCandidates))
+	remainingCount := count
+	for remainingCount > 0 {
+		progress := false
+		for _, d := range sortedCandidates {
+			if remainingCount == 0 {
+				break
+			}
+			if assigned[d.id] < s.state[d.id] {
+				assigned[d.id]++
+				remainingCount--
+				progress = true
+			}
+		}
+		if !progress {
+			return fitLevelIdx, fitDomains
+		}
+	}
+	result := make([]*domain, 0, len(assigned))
+	for _, d := range sortedCandidates {
+		if assigned[d.id] > 0 {
+			s.state[d.id] = assigned[d.id]
+			result = append(result, d)
+		}
+	}
+	return spreadLevelIdx, result
+}
+
+// resolveMaxDomainsLevelIdx resolves the index of the level at which the
+// number of domains is limited, or returns an error if the level is not
+// defined for the flavor.
+func (s *TASFlavorSnapshot) resolveMaxDomainsLevelIdx(options *findTopologyAssignmentOptions) error {
+	if options.maxDomainsLevelKey == "" {
+		return nil
+	}
+	options.maxDomainsLevelIdx = slices.Index(s.levelKeys, options.maxDomainsLevelKey)
+	if options.maxDomainsLevelIdx == -1 {
+		return &TopologyAssignmentError{
+			Reason:  InvalidTopologyLevel,
+			Message: fmt.Sprintf("topology level %q is not defined for the flavor, the levels are: %v", options.maxDomainsLevelKey, s.levelKeys),
+			Level:   options.maxDomainsLevelKey,
+		}
+	}
+	return nil
+}
+
+// domainsAtLevel returns the number of distinct domains at the level which
+// contain the lowest level domains.
+func (s *TASFlavorSnapshot) domainsAtLevel(leaves []*domain, levelIdx int) int32 {
+	domainIDs := sets.New[utiltas.TopologyDomainID]()
+	for _, leaf := range leaves {
+		domainIDs.Insert(utiltas.DomainID(s.levelValuesPerDomain[leaf.id][:levelIdx+1]))
+	}
+	return int32(domainIDs.Len())
+}
+
+// withinMaxDomains checks if placing count pods in the domain uses no more
+// than the allowed number of domains at the limited level. It leaves the
+// state of the domains and the nodes unchanged.
+func (s *TASFlavorSnapshot) withinMaxDomains(levelIdx int, d *domain, count int32, options *findTopologyAssignmentOptions) bool {
+	if options.maxDomainsLevelKey == "" || options.maxDomainsLevelIdx <= levelIdx {
+		return true
+	}
+	defer s.restoreState(s.subtreeState(levelIdx, d))
+	leaves := s.assignToLowerLevels(levelIdx, []*domain{d}, count, options)
+	return s.domainsAtLevel(leaves, options.maxDomainsLevelIdx) <= options.maxDomains
+}
+
+// FindIncrementalTopologyAssignment finds the topology assignment for the
+// workload scaled up to count pods, keeping the pods of the current
+// assignment in place, so only the additional pods are placed. The capacity
+// used by the current assignment is expected to be already accounted in the
+// snapshot. The additional pods are placed in the domains of the current
+// assignment first, then in the other domains within the same domain at the
+// requested level. If the PodSet only prefers the level, the additional pods
+// may be placed anywhere in the topology as the last resort. Without the
+// current assignment, all the pods are placed as by FindTopologyAssignment.
+func (s *TASFlavorSnapshot) FindIncrementalTopologyAssignment(
+	topologyRequest *kueue.PodSetTopologyRequest,
+	requests resources.Requests,
+	current *kueue.TopologyAssignment,
+	count int32,
+	opts ...FindTopologyAssignmentOption) (*kueue.TopologyAssignment, error) {
+	levelIdx, err := s.requestedLevelIdx(topologyRequest)
+	if err != nil {
+		return nil, err
+	}
+	if current == nil || len(current.Domains) == 0 {
+		return s.FindTopologyAssignment(topologyRequest, requests, count, opts...)
+	}
+	var currentCount int32
+	for _, domain := range current.Domains {
+		currentCount += domain.Count
+	}
+	if count <= currentCount {
+		return current.DeepCopy(), nil
+	}
+	options := &findTopologyAssignmentOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	// place the additional pods in the domains of the current assignment
+	remainingCount := count - currentCount
+	s.fillInCounts(requests, remainingCount, len(s.levelKeys)-1, options)
+	extension := &kueue.TopologyAssignment{
+		Levels: s.levelKeys,
+	}
+	for _, domain := range current.Domains {
+		domainCount := min(s.state[utiltas.DomainID(domain.Values)], remainingCount)
+		if domainCount > 0 {
+			extension.Domains = append(extension.Domains, kueue.TopologyDomainAssignment{
+				Values: domain.Values,
+				Count:  domainCount,
+			})
+			remainingCount -= domainCount
+		}
+	}
+
+	if remainingCount > 0 {
+		usage := s.addAssignmentUsage(extension, requests)
+		defer func() {
+			for domainID, domainUsage := range usage {
+				s.removeUsage(domainID, domainUsage)
+			}
+		}()
+		var rest *kueue.TopologyAssignment
+		if currentDomainID, found := sharedDomain(current, levelIdx); found {
+			withinOpts := append(slices.Clone(opts), func(o *findTopologyAssignmentOptions) {
+				o.withinDomain = ptr.To(currentDomainID)
+			})
+			rest, err = s.FindTopologyAssignment(topologyRequest, requests, remainingCount, withinOpts...)
+		}
+		if rest == nil && topologyRequest.Required == nil {
+			rest, err = s.FindTopologyAssignment(topologyRequest, requests, remainingCount, opts...)
+		}
+		if rest == nil {
+			if err == nil {
+				err = &TopologyAssignmentError{
+					Reason:  TopologyNotFit,
+					Message: fmt.Sprintf("cannot fit %d additional pods within the domain of the current assignment", remainingCount),
+				}
+			}
+			return nil, err
+		}
+		extension.Domains = append(extension.Domains, rest.Domains...)
+	}
+	result := mergeAssignments(current, extension)
+	// the current assignment may already span multiple domains at the level,
+	// for example if the level was only preferred when it was made
+	if _, found := sharedDomain(result, levelIdx); topologyRequest.Required != nil && !found {
+		return nil, &TopologyAssignmentError{
+			Reason:  TopologyNotFit,
+			Message: fmt.Sprintf("cannot fit %d pods within a single domain at the required level %q", count, *topologyRequest.Required),
+		}
+	}
+	return result, nil
+}
+
+// sharedDomain returns the ID of the domain at the given level which contains
+// all the domains of the assignment, if any.
+func sharedDomain(assignment *kueue.TopologyAssignment, levelIdx int) (utiltas.TopologyDomainID, bool) {
+	domainIDs := sets.New[utiltas.TopologyDomainID]()
+	for _, domain := range assignment.Domains {
+		domainIDs.Insert(utiltas.DomainID(domain.Values[:levelIdx+1]))
+	}
+	if domainIDs.Len() != 1 {
+		return "", false
+	}
+	return domainIDs.UnsortedList()[0], true
+}
+
+// mergeAssignments returns the assignment combining the pods of both
+// assignments, keeping the order of the domains of the first assignment.
+func mergeAssignments(first, second *kueue.TopologyAssignment) *kueue.TopologyAssignment {
+	result := first.DeepCopy()
+	domainIdx := make(map[utiltas.TopologyDomainID]int, len(result.Domains))
+	for i, domain := range result.Domains {
+		domainIdx[utiltas.DomainID(domain.Values)] = i
+	}
+	for _, domain := range second.Domains {
+		if i, found := domainIdx[utiltas.DomainID(domain.Values)]; found {
+			result.Domains[i].Count += domain.Count
+			continue
+		}
+		domainIdx[utiltas.DomainID(domain.Values)] = len(result.Domains)
+		result.Domains = append(result.Domains, *domain.DeepCopy())
+	}
+	sortDomainAssignments(result.Domains)
+	return result
+}
+
+// FindTopologyAssignmentForTotal finds the assignment for the workload
+// expressed as the total resource demand rather than the number of pods. The
+// number of pods is derived so that each pod requests at most perPod, and
+// the last pod requests the remainder of the total, if it doesn't divide
+// evenly. For example, the total of 2.5 CPU with 1 CPU per pod yields 3 pods,
+// the last one requesting 0.5 CPU. The resources which aren't requested per
+// pod are ignored.
+//
+// The pods requesting perPod are assigned first, and the remainder pod is
+// added to the assignment as for a scale up. If the remainder pod doesn't fit
+// this way, all pods are assigned as if they requested perPod.
+func (s *TASFlavorSnapshot) FindTopologyAssignmentForTotal(
+	topologyRequest *kueue.PodSetTopologyRequest,
+	total resources.Requests,
+	perPod resources.Requests,
+	opts ...FindTopologyAssignmentOption) (*kueue.TopologyAssignment, error) {
+	count, remainder := podsForTotal(total, perPod)
+	if count == 0 {
+		return nil, &TopologyAssignmentError{
+			Reason:  TopologyNotFit,
+			Message: "the total requests do not require any pods",
+		}
+	}
+	if remainder == nil {
+		return s.FindTopologyAssignment(topologyRequest, perPod, count, opts...)
+	}
+	if count > 1 {
+		full, err := s.FindTopologyAssignment(topologyRequest, perPod, count-1, opts...)
+		if err != nil {
+			return nil, err
+		}
+		usage := s.addAssignmentUsage(full, perPod)
+		assignment, err := s.FindIncrementalTopologyAssignment(topologyRequest, remainder, full, count, opts...)
+		for domainID, domainUsage := range usage {
+			s.removeUsage(domainID, domainUsage)
+		}
+		if err == nil {
+			return assignment, nil
+		}
+	} else if assignment, err := s.FindTopologyAssignment(topologyRequest, remainder, 1, opts...); err == nil {
+		return assignment, nil
+	}
+	return s.FindTopologyAssignment(topologyRequest, perPod, count, opts...)
+}
+
+// podsForTotal returns the number of pods needed for the total requests, with
+// each pod requesting at most perPod, along with the requests of the last
+// pod. The requests of the last pod are nil if all pods request perPod.
+func podsForTotal(total, perPod resources.Requests) (int32, resources.Requests) {
+	var count int64
+	for name, value := range perPod {
+		if value > 0 {
+			count = max(count, (total[name]+value-1)/value)
+		}
+	}
+	if count == 0 {
+		return 0, nil
+	}
+	remainder := resources.Requests{}
+	for name, value := range perPod {
+		remainder[name] = min(max(total[name]-value*(count-1), 0), value)
+	}
+	if maps.Equal(remainder, perPod) {
+		return int32(count), nil
+	}
+	return int32(count), remainder
+}
+
+// ShrinkReleaseOrder returns the pods of the assignment, per the lowest level
+// domain, in the order in which they should be released when an elastic
+// workload shrinks to targetCount pods. The order keeps the remaining pods as
+// tight as possible: the domains with the fewest pods at the highest level
+// are released first, and within them the domains with the fewest pods at
+// the lower levels. The ties are resolved by the domain values. It returns
+// nil if the assignment doesn't exceed targetCount pods.
+func ShrinkReleaseOrder(assignment *kueue.TopologyAssignment, targetCount int32) []kueue.TopologyDomainAssignment {
+	var total int32
+	countPerDomain := make(map[utiltas.TopologyDomainID]int32)
+	for _, domain := range assignment.Domains {
+		total += domain.Count
+		for levelIdx := range domain.Values {
+			countPerDomain[utiltas.DomainID(domain.Values[:levelIdx+1])] += domain.Count
+		}
+	}
+	toRelease := total - targetCount
+	if toRelease <= 0 {
+		return nil
+	}
+	sortedDomains := slices.Clone(assignment.Domains)
+	slices.SortStableFunc(sortedDomains, func(a, b kueue.TopologyDomainAssignment) int {
+		for levelIdx := range min(len(a.Values), len(b.Values)) {
+			aID := utiltas.DomainID(a.Values[:levelIdx+1])
+			bID := utiltas.DomainID(b.Values[:levelIdx+1])
+			if aID == bID {
+				continue
+			}
+			if countCmp := cmp.Compare(countPerDomain[aID], countPerDomain[bID]); countCmp != 0 {
+				return countCmp
+			}
+			return strings.Compare(string(aID), string(bID))
+		}
+		return 0
+	})
+	var result []kueue.TopologyDomainAssignment
+	for _, domain := range sortedDomains {
+		if toRelease == 0 {
+			break
+		}
+		released := min(domain.Count, toRelease)
+		result = append(result, kueue.TopologyDomainAssignment{
+			Values: slices.Clone(domain.Values),
+			Count:  released,
+		})
+		toRelease -= released
+	}
+	return result
+}
+
+// TopologyAssignmentExplanation holds the chosen topology assignment along
+// with the alternative placements at the levels above the requested one,
+// which show the trade-off of the requested level.
+type TopologyAssignmentExplanation struct {
+	// Assignment is the chosen topology assignment, it is nil if the workload
+	// doesn't fit at the requested level.
+	Assignment *kueue.TopologyAssignment
+
+	// DomainsPerLevel is the number of domains used by the chosen
+	// assignment at each level.
+	DomainsPerLevel []int32
+
+	// RelaxedLevels is the number of levels above the requested one which
+	// the chosen assignment spreads across, for example 1 when the workload
+	// preferring a rack is spread across the racks of a single block. It
+	// equals the number of levels up to the requested one when the workload
+	// is spread across the domains at the top level.
+	RelaxedLevels int
+
+	// Alternatives are the best placements requiring the levels above the
+	// requested one, ordered from the lowest to the highest level.
+	Alternatives []LevelAlternative
+}
+
+// LevelAlternative describes the best placement when the workload requires
+// the given level.
+type LevelAlternative struct {
+	// Level is the topology level key required by the placement.
+	Level string
+
+	// Assignment is the topology assignment of the placement, it is nil if
+	// the workload doesn't fit within a single domain at the level.
+	Assignment *kueue.TopologyAssignment
+
+	// DomainsPerLevel is the number of domains used by the placement at
+	// each level.
+	DomainsPerLevel []int32
+}
+
+// ExplainTopologyAssignment finds the topology assignment as
+// FindTopologyAssignment does, and additionally the best placement requiring
+// each of the levels above the requested one. The explanation is returned
+// along with the error when the workload doesn't fit at the requested level,
+// as the alternatives are still meaningful.
+func (s *TASFlavorSnapshot) ExplainTopologyAssignment(
+	topologyRequest *kueue.PodSetTopologyRequest,
+	requests resources.Requests,
+	count int32,
+	opts ...FindTopologyAssignmentOption) (*TopologyAssignmentExplanation, error) {
+	levelIdx, err := s.requestedLevelIdx(topologyRequest)
+	if err != nil {
+		return nil, err
+	}
+	explanation := &TopologyAssignmentExplanation{}
+	assignment, assignmentErr := s.FindTopologyAssignment(topologyRequest, requests, count, opts...)
+	if assignment != nil {
+		explanation.Assignment = assignment
+		explanation.DomainsPerLevel = domainsPerLevel(assignment)
+		explanation.RelaxedLevels = relaxedLevels(levelIdx, explanation.DomainsPerLevel)
+	}
+	for altLevelIdx := levelIdx - 1; altLevelIdx >= 0; altLevelIdx-- {
+		alternative := LevelAlternative{
+			Level: s.levelKeys[altLevelIdx],
+		}
+		altRequest := &kueue.PodSetTopologyRequest{Required: ptr.To(alternative.Level)}
+		if altAssignment, err := s.FindTopologyAssignment(altRequest, requests, count, opts...); err == nil {
+			alternative.Assignment = altAssignment
+			alternative.DomainsPerLevel = domainsPerLevel(altAssignment)
+		}
+		explanation.Alternatives = append(explanation.Alternatives, alternative)
+	}
+	return explanation, assignmentErr
+}
+
+// PodPlacement holds the topology assignment along with the node of each
+// pod, which allows to replay the placement of a gang exactly.
+type PodPlacement struct {
+	// Assignment is the topology assignment.
+	Assignment *kueue.TopologyAssignment
+
+	// NodePerPod holds the name of the node of each pod, indexed by the pod
+	// index.
+	NodePerPod []string
+}
+
+// FindPodPlacement finds the topology assignment as FindTopologyAssignment
+// does, and additionally maps each pod index to a node. The pods are mapped
+// deterministically, following the order of the domains in the assignment,
+// and within each domain the nodes which can accommodate the most pods, and
+// then the node names. It returns an error if the pods of a domain fit in
+// its aggregated capacity, but not in the capacity of its nodes separately.
+func (s *TASFlavorSnapshot) FindPodPlacement(
+	topologyRequest *kueue.PodSetTopologyRequest,
+	requests resources.Requests,
+	count int32,
+	opts ...FindTopologyAssignmentOption) (*PodPlacement, error) {
+	options := &findTopologyAssignmentOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	// the cache is bypassed, as the nodes are mapped based on the state left
+	// by the assignment
+	assignment, _, err := s.findTopologyAssignmentTraced(topologyRequest, requests, count, options)
+	if err != nil {
+		return nil, err
+	}
+	nodePerPod, err := s.nodePerPod(assignment)
+	if err != nil {
+		return nil, err
+	}
+	return &PodPlacement{
+		Assignment: assignment,
+		NodePerPod: nodePerPod,
+	}, nil
+}
+
+// nodePerPod maps the pods of the assignment to the nodes of the domains,
+// based on the number of pods which fit on each node in the last assignment.
+func (s *TASFlavorSnapshot) nodePerPod(assignment *kueue.TopologyAssignment) ([]string, error) {
+	var result []string
+	for _, domainAssignment := range assignment.Domains {
+		var nodeNames []string
+		for _, nodeName := range s.nodesPerDomain[utiltas.DomainID(domainAssignment.Values)] {
+			if s.nodeState[nodeName] > 0 {
+				nodeNames = append(nodeNames, nodeName)
+			}
+		}
+		slices.SortFunc(nodeNames, func(a, b string) int {
+			if countCmp := cmp.Compare(s.nodeState[b], s.nodeState[a]); countCmp != 0 {
+				return countCmp
+			}
+			return strings.Compare(a, b)
+		})
+		remaining := domainAssignment.Count
+		for _, nodeName := range nodeNames {
+			for range min(s.nodeState[nodeName], remaining) {
+				result = append(result, s.hostName(nodeName))
+			}
+			remaining -= min(s.nodeState[nodeName], remaining)
+		}
+		// The domain may accommodate more pods than its nodes separately, as
+		// its capacity is aggregated across the nodes.
+		if remaining > 0 {
+			return nil, &TopologyAssignmentError{
+				Reason: TopologyNotFit,
+				Message: fmt.Sprintf("cannot place %d out of %d pods on the nodes of the domain %v",
+					remaining, domainAssignment.Count, domainAssignment.Values),
+			}
+		}
+	}
+	return result, nil
+}
+
+// hostName returns the name of the node, or the name of the node which the
+// NVLink group belongs to, for the nodes of the sub-host level domains.
+func (s *TASFlavorSnapshot) hostName(nodeName string) string {
+	if host := s.nodes[nodeName].host; host != "" {
+		return host
+	}
+	return nodeName
+}
+
+// NodeNameForDomain returns the name of the node of the lowest level domain
+// with the given values, for example to inject the node selector of a pod,
+// as the value of the host label may differ from the node name. It returns
+// an empty string if the values don't identify a lowest level domain with a
+// single node.
+func (s *TASFlavorSnapshot) NodeNameForDomain(values []string) string {
+	nodeNames := s.nodesPerDomain[utiltas.DomainID(values)]
+	if len(values) != len(s.levelKeys) || len(nodeNames) != 1 {
+		return ""
+	}
+	return s.hostName(nodeNames[0])
+}
+
+// domainsPerLevel returns the number of distinct domains used by the
+// assignment at each level.
+func domainsPerLevel(assignment *kueue.TopologyAssignment) []int32 {
+	result := make([]int32, len(assignment.Levels))
+	for levelIdx := range assignment.Levels {
+		domainIDs := sets.New[utiltas.TopologyDomainID]()
+		for _, domain := range assignment.Domains {
+			domainIDs.Insert(utiltas.DomainID(domain.Values[:levelIdx+1]))
+		}
+		result[levelIdx] = int32(domainIDs.Len())
+	}
+	return result
+}
+
+// relaxedLevels returns the number of levels above the level with the given
+// index, at which the assignment using the given number of domains per level
+// spreads across multiple domains.
+func relaxedLevels(levelIdx int, domainsPerLevel []int32) int {
+	fitLevelIdx := -1
+	for fitLevelIdx+1 < len(domainsPerLevel) && domainsPerLevel[fitLevelIdx+1] == 1 {
+		fitLevelIdx++
+	}
+	return max(levelIdx-fitLevelIdx, 0)
+}
+
+// PlacementStability returns the score, between 0 and 1, of how likely the
+// assignment is to remain valid as the cluster churns, so the placements with
+// a low score can be re-verified sooner. The score of each domain of the
+// assignment is the fraction of the pods fitting in the domain which remain
+// free after the placement, multiplied by the average maturity of the nodes
+// of the domain. A node is mature once it has been Ready for matureAge, and
+// with the non-positive matureAge the maturity is ignored. The result is the
+// average of the domain scores weighted by the number of pods. The assignment
+// is evaluated against the free capacity of the snapshot, so the usage of the
+// assignment is expected not to be accounted in the snapshot.
+func (s *TASFlavorSnapshot) PlacementStability(assignment *kueue.TopologyAssignment, requests resources.Requests, now time.Time, matureAge time.Duration) float64 {
+	var weightedSum float64
+	var totalCount int32
+	for _, domain := range assignment.Domains {
+		if domain.Count <= 0 {
+			continue
+		}
+		domainID := utiltas.DomainID(domain.Values)
+		var slack float64
+		if fit := requests.CountIn(s.freeCapacityPerDomain[domainID]); fit > 0 {
+			slack = max(float64(fit-domain.Count)/float64(fit), 0)
+		}
+		weightedSum += float64(domain.Count) * slack * s.maturity(domainID, now, matureAge)
+		totalCount += domain.Count
+	}
+	if totalCount == 0 {
+		return 0
+	}
+	return weightedSum / float64(totalCount)
+}
+
+// maturity returns the average, over the nodes of the lowest level domain,
+// of the fraction of matureAge for which the node has been Ready.
+func (s *TASFlavorSnapshot) maturity(domainID utiltas.TopologyDomainID, now time.Time, matureAge time.Duration) float64 {
+	if matureAge <= 0 {
+		return 1
+	}
+	nodeNames := s.nodesPerDomain[domainID]
+	if len(nodeNames) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, nodeName := range nodeNames {
+		readySince := s.nodes[nodeName].readySince
+		if readySince.IsZero() {
+			continue
+		}
+		sum += min(float64(now.Sub(readySince))/float64(matureAge), 1)
+	}
+	return sum / float64(len(nodeNames))
+}
+
+// DomainShortfall describes the capacity missing in a topology domain for
+// the workload to fit in it.
+type DomainShortfall struct {
+	// Level is the topology level key of the domain.
+	Level string
+
+	// Values are the ordered label values identifying the domain.
+	Values []string
+
+	// MissingPods is the number of pods which cannot fit in the domain.
+	MissingPods int32
+
+	// Deficit is the additional capacity, per resource, which needs to be
+	// added to the domain for the missing pods to fit.
+	Deficit resources.Requests
+}
+
+// ShortfallFor returns, for each domain at the requested topology level, the
+// capacity which is missing for all pods of the workload to fit in the
+// domain. The deficit assumes that the capacity is added as new nodes, so it
+// is the per-pod requests multiplied by the number of missing pods. The
+// domains are sorted by the number of missing pods, so the first domain is
+// the cheapest one to scale up. Domains in which the workload already fits
+// are reported with no missing pods and an empty deficit.
+func (s *TASFlavorSnapshot) ShortfallFor(
+	topologyRequest *kueue.PodSetTopologyRequest,
+	requests resources.Requests,
+	count int32,
+	opts ...FindTopologyAssignmentOption) ([]DomainShortfall, error) {
+	options := &findTopologyAssignmentOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	levelIdx, err := s.requestedLevelIdx(topologyRequest)
+	if err != nil {
+		return nil, err
+	}
+	s.fillInCounts(requests, count, levelIdx, options)
+	sortedDomains := s.sortedDomains(s.domainsForLevel(levelIdx), options)
+	result := make([]DomainShortfall, 0, len(sortedDomains))
+	for _, d := range sortedDomains {
+		shortfall := DomainShortfall{
+			Level:       s.levelKeys[levelIdx],
+			Values:      slices.Clone(s.levelValuesPerDomain[d.id]),
+			MissingPods: max(count-s.state[d.id], 0),
+			Deficit:     resources.Requests{},
+		}
+		if shortfall.MissingPods > 0 {
+			for name, value := range requests {
+				shortfall.Deficit[name] = value * int64(shortfall.MissingPods)
+			}
+		}
+		result = append(result, shortfall)
+	}
+	return result, nil
+}
+
+// FeasibleLevels returns the topology levels at which all count pods fit
+// within a single domain, given the current free capacity. The levels are
+// ordered from the lowest one, for example host, rack and block, so the
+// first level is the tightest Required constraint the workload can satisfy.
+func (s *TASFlavorSnapshot) FeasibleLevels(requests resources.Requests, count int32) []string {
+	lastLevelIdx := len(s.levelKeys) - 1
+	s.fillInCounts(requests, count, lastLevelIdx, &findTopologyAssignmentOptions{})
+	var result []string
+	for levelIdx := lastLevelIdx; levelIdx >= 0; levelIdx-- {
+		for _, d := range s.domainsPerLevel[levelIdx] {
+			if s.state[d.id] >= count {
+				result = append(result, s.levelKeys[levelIdx])
+				break
+			}
+		}
+	}
+	return result
+}
+
+// requestedLevelIdx returns the index of the topology level requested by the
+// PodSet, or an error if the level is not defined for the flavor.
+func (s *TASFlavorSnapshot) requestedLevelIdx(topologyRequest *kueue.PodSetTopologyRequest) (int, error) {
+	levelKey := requestedLevelKey(topologyRequest)
+	levelIdx := slices.Index(s.levelKeys, levelKey)
+	if levelIdx == -1 {
+		return -1, &TopologyAssignmentError{
+			Reason:  InvalidTopologyLevel,
+			Message: fmt.Sprintf("topology level %q is not defined for the flavor, the levels are: %v", levelKey, s.levelKeys),
+			Level:   levelKey,
+		}
+	}
+	return levelIdx, nil
+}
+
+// limitingResource returns the requested resource whose free capacity in the
+// flavor, summed over the lowest level domains, is enough for the fewest
+// pods, along with the number of pods, if it is fewer than count. The ties
+// are resolved by the resource name. The pods allocatable of the nodes is
+// reported as the pods resource when it is the tightest limit.
+func (s *TASFlavorSnapshot) limitingResource(requests resources.Requests, count int32) (corev1.ResourceName, int32, bool) {
+	var result corev1.ResourceName
+	minFitCount := count
+	for _, resourceName := range slices.Sorted(maps.Keys(requests)) {
+		request := requests[resourceName]
+		if request <= 0 {
+			continue
+		}
+		var fitCount int64
+		for _, capacity := range s.freeCapacityPerDomain {
+			fitCount += max(capacity[resourceName], 0) / request
+		}
+		if fitCount < int64(minFitCount) {
+			result = resourceName
+			minFitCount = int32(fitCount)
+		}
+	}
+	var slotCount int64
+	for _, capacity := range s.freeCapacityPerDomain {
+		slotCount += int64(podSlots(requests, capacity))
+	}
+	if len(s.freeCapacityPerDomain) > 0 && slotCount < int64(minFitCount) {
+		result = corev1.ResourcePods
+		minFitCount = int32(slotCount)
+	}
+	return result, minFitCount, result != ""
+}
+
+// lowestLevelCount returns the number of pods which fit in all the lowest
+// level domains, as set by fillInCounts.
+func (s *TASFlavorSnapshot) lowestLevelCount() int32 {
+	var result int64
+	for domainID := range s.domainsPerLevel[len(s.domainsPerLevel)-1] {
+		result += int64(s.state[domainID])
+	}
+	return int32(min(result, math.MaxInt32))
+}
+
+// isCapacityReason returns true if the reason indicates the pods don't fit
+// in the capacity, rather than a misconfiguration, so that the assignment may
+// be retried with relaxed constraints.
+func isCapacityReason(reason TopologyAssignmentErrorReason) bool {
+	return reason == TopologyNotFit || reason == InsufficientCapacity || reason == InsufficientClusterCapacity
+}
+
+// bestDomainAvailability returns the quantity of the resource requested by a
+// single pod, along with the largest free quantity of the resource in a
+// single lowest level domain. For the pods resource, it is the number of the
+// pod slots.
+func (s *TASFlavorSnapshot) bestDomainAvailability(requests resources.Requests, resourceName corev1.ResourceName) (int64, int64) {
+	var available int64
+	if _, found := requests[resourceName]; !found && resourceName == corev1.ResourcePods {
+		for _, capacity := range s.freeCapacityPerDomain {
+			available = max(available, int64(podSlots(requests, capacity)))
+		}
+		return 1, available
+	}
+	for _, capacity := range s.freeCapacityPerDomain {
+		available = max(available, capacity[resourceName])
+	}
+	return requests[resourceName], available
+}
+
+// FindTopologyAssignmentAlternatives finds up to k distinct topology
+// assignments, ranked by the objective of FindTopologyAssignment, for example
+// to plan the preemptions avoiding a contested domain. The first one is the
+// assignment found by FindTopologyAssignment, and each subsequent one is the
+// best assignment avoiding the domains, at the level at which the pods fit,
+// used by the previous ones. Each of the assignments is valid on its own
+// against the free capacity of the snapshot. The error is returned only if
+// no assignment is found.
+func (s *TASFlavorSnapshot) FindTopologyAssignmentAlternatives(
+	topologyRequest *kueue.PodSetTopologyRequest,
+	requests resources.Requests,
+	count int32,
+	k int,
+	opts ...FindTopologyAssignmentOption) ([]*kueue.TopologyAssignment, error) {
+	var result []*kueue.TopologyAssignment
+	bannedNodes := sets.New[string]()
+	for len(result) < k {
+		options := &findTopologyAssignmentOptions{}
+		for _, opt := range opts {
+			opt(options)
+		}
+		options.excludedNodes = options.excludedNodes.Union(bannedNodes)
+		leaves, fitLevelIdx, err := s.findTopologyAssignment(topologyRequest, requests, count, options)
+		if err != nil {
+			if len(result) == 0 {
+				return nil, err
+			}
+			break
+		}
+		result = append(result, s.buildAssignment(leaves))
+		usedNodes := s.nodesInFitDomains(leaves, fitLevelIdx)
+		if bannedNodes.IsSuperset(usedNodes) {
+			break
+		}
+		bannedNodes = bannedNodes.Union(usedNodes)
+	}
+	return result, nil
+}
+
+// nodesInFitDomains returns the names of the nodes in the domains, at the
+// level with the given index, containing the lowest level domains.
+func (s *TASFlavorSnapshot) nodesInFitDomains(leaves []*domain, fitLevelIdx int) sets.Set[string] {
+	fitDomains := sets.New[utiltas.TopologyDomainID]()
+	for _, leaf := range leaves {
+		fitDomains.Insert(utiltas.DomainID(s.levelValuesPerDomain[leaf.id][:fitLevelIdx+1]))
+	}
+	result := sets.New[string]()
+	for nodeName, node := range s.nodes {
+		if fitDomains.Has(utiltas.DomainID(s.levelValuesPerDomain[node.domainID][:fitLevelIdx+1])) {
+			result.Insert(nodeName)
+		}
+	}
+	return result
+}
+
+// RunningPod describes a pod running on a node of the flavor, which can be
+// preempted to make room for a workload.
+type RunningPod struct {
+	// Name identifies the pod.
+	Name string
+
+	// NodeName is the name of the node the pod is running on.
+	NodeName string
+
+	// Priority is the priority of the pod.
+	Priority int32
+
+	// Requests are the resource requests of the pod.
+	Requests resources.Requests
+}
+
+// FindPreemptionCandidates finds the minimal set of the running pods to
+// preempt so that the pods fit within the topology constraints, along with
+// the assignment found once they are preempted. The running pods are
+// expected to be those of a lower priority than the workload, and the pods
+// with the lowest priority are preempted first. The candidates lie within a
+// single domain at the requested level, where the workload is then placed,
+// and the domain requiring the fewest preemptions is chosen, the ties are
+// resolved by the domain values. No candidates are returned if the workload
+// fits without preemptions, and no assignment is returned if it doesn't fit
+// even after preempting all the running pods of any domain.
+func (s *TASFlavorSnapshot) FindPreemptionCandidates(
+	topologyRequest *kueue.PodSetTopologyRequest,
+	requests resources.Requests,
+	count int32,
+	running []RunningPod,
+	opts ...FindTopologyAssignmentOption) ([]RunningPod, *kueue.TopologyAssignment) {
+	if assignment, err := s.FindTopologyAssignment(topologyRequest, requests, count, opts...); err == nil {
+		return nil, assignment
+	}
+	levelIdx, err := s.requestedLevelIdx(topologyRequest)
+	if err != nil {
+		return nil, nil
+	}
+	podsPerDomain := make(map[utiltas.TopologyDomainID][]RunningPod)
+	for _, pod := range running {
+		node, found := s.nodes[pod.NodeName]
+		if !found {
+			continue
+		}
+		domainID := utiltas.DomainID(s.levelValuesPerDomain[node.domainID][:levelIdx+1])
+		podsPerDomain[domainID] = append(podsPerDomain[domainID], pod)
+	}
+	domainIDs := slices.Collect(maps.Keys(podsPerDomain))
+	slices.SortFunc(domainIDs, func(a, b utiltas.TopologyDomainID) int {
+		return slices.Compare(s.levelValuesPerDomain[a], s.levelValuesPerDomain[b])
+	})
+
+	var candidates []RunningPod
+	var assignment *kueue.TopologyAssignment
+	for _, domainID := range domainIDs {
+		pods := podsPerDomain[domainID]
+		slices.SortStableFunc(pods, func(a, b RunningPod) int {
+			return cmp.Or(cmp.Compare(a.Priority, b.Priority), cmp.Compare(a.Name, b.Name))
+		})
+		domainCandidates, domainAssignment := s.findPreemptionCandidatesInDomain(topologyRequest, requests, count, levelIdx, domainID, pods, opts)
+		if domainAssignment != nil && (assignment == nil || len(domainCandidates) < len(candidates)) {
+			candidates, assignment = domainCandidates, domainAssignment
+		}
+	}
+	return candidates, assignment
+}
+
+// findPreemptionCandidatesInDomain preempts the pods, in the given order,
+// until the workload fits within the domain at the level with the given
+// index, and then puts back the pods, starting from the last one, which
+// aren't needed for the workload to fit. The free capacity of the snapshot is
+// restored before returning.
+func (s *TASFlavorSnapshot) findPreemptionCandidatesInDomain(
+	topologyRequest *kueue.PodSetTopologyRequest,
+	requests resources.Requests,
+	count int32,
+	levelIdx int,
+	domainID utiltas.TopologyDomainID,
+	pods []RunningPod,
+	opts []FindTopologyAssignmentOption) ([]RunningPod, *kueue.TopologyAssignment) {
+	outsideNodes := sets.New[string]()
+	for nodeName, node := range s.nodes {
+		if utiltas.DomainID(s.levelValuesPerDomain[node.domainID][:levelIdx+1]) != domainID {
+			outsideNodes.Insert(nodeName)
+		}
+	}
+	opts = append(slices.Clone(opts), func(o *findTopologyAssignmentOptions) {
+		o.excludedNodes = o.excludedNodes.Union(outsideNodes)
+	})
+	fits := func() *kueue.TopologyAssignment {
+		assignment, err := s.FindTopologyAssignment(topologyRequest, requests, count, opts...)
+		if err != nil {
+			return nil
+		}
+		return assignment
+	}
+
+	preempted := make([]bool, len(pods))
+	defer func() {
+		for i, pod := range pods {
+			if preempted[i] {
+				s.addUsage(s.nodes[pod.NodeName].domainID, s.preemptedUsage(pod))
+			}
+		}
+	}()
+	var assignment *kueue.TopologyAssignment
+	last := -1
+	for i, pod := range pods {
+		s.removeUsage(s.nodes[pod.NodeName].domainID, s.preemptedUsage(pod))
+		preempted[i] = true
+		if assignment = fits(); assignment != nil {
+			last = i
+			break
+		}
+	}
+	if assignment == nil {
+		return nil, nil
+	}
+	for i := last - 1; i >= 0; i-- {
+		s.addUsage(s.nodes[pods[i].NodeName].domainID, s.preemptedUsage(pods[i]))
+		preempted[i] = false
+		if reduced := fits(); reduced != nil {
+			assignment = reduced
+			continue
+		}
+		s.removeUsage(s.nodes[pods[i].NodeName].domainID, s.preemptedUsage(pods[i]))
+		preempted[i] = true
+	}
+	var candidates []RunningPod
+	for i, pod := range pods {
+		if preempted[i] {
+			candidates = append(candidates, pod)
+		}
+	}
+	return candidates, assignment
+}
+
+// preemptedUsage returns the capacity released by preempting the pod,
+// including its pod slot if the pods allocatable of its node is tracked.
+func (s *TASFlavorSnapshot) preemptedUsage(pod RunningPod) resources.Requests {
+	usage := pod.Requests.Clone()
+	if _, found := usage[corev1.ResourcePods]; !found {
+		if _, tracked := s.freeCapacityPerDomain[s.nodes[pod.NodeName].domainID][corev1.ResourcePods]; tracked {
+			usage[corev1.ResourcePods] = 1
+		}
+	}
+	return usage
+}
+
+// PodSetTopologyRequests holds the input to the topology assignment of a
+// single PodSet.
+type PodSetTopologyRequests struct {
+	// TopologyRequest is the topology request of the PodSet.
+	TopologyRequest *kueue.PodSetTopologyRequest
+
+	// Requests are the resource requests of a single pod.
+	Requests resources.Requests
+
+	// Count is the number of pods.
+	Count int32
+
+	// NoSharedHostWith are the indexes of the PodSets whose pods must not
+	// share a host with the pods of this PodSet. The constraint is symmetric,
+	// so it is enough to declare it on one PodSet of the pair.
+	NoSharedHostWith []int
+}
+
+// FindTopologyAssignmentForPodSets finds the topology assignments for the
+// PodSets of a workload, returned in the order of the PodSets. The capacity
+// assigned to a PodSet is not available to the subsequent PodSets. The
+// PodSets sharing a colocation group are placed within a single domain at the
+// colocation level, which is the first domain, in the order of the domain
+// names, accommodating all of them. The pods of the PodSets which must not
+// share a host are placed on the lowest level domains not used by each other.
+func (s *TASFlavorSnapshot) FindTopologyAssignmentForPodSets(
+	podSets []PodSetTopologyRequests,
+	opts ...FindTopologyAssignmentOption) ([]*kueue.TopologyAssignment, error) {
+	freeCapacity := make(map[utiltas.TopologyDomainID]resources.Requests, len(s.freeCapacityPerDomain))
+	for domainID, capacity := range s.freeCapacityPerDomain {
+		freeCapacity[domainID] = capacity.Clone()
+	}
+	defer func() {
+		s.freeCapacityPerDomain = freeCapacity
+		s.version++
+	}()
+
+	result := make([]*kueue.TopologyAssignment, len(podSets))
+	assignedGroups := sets.New[string]()
+	for i, podSet := range podSets {
+		group := ptr.Deref(podSet.TopologyRequest.ColocationGroup, "")
+		if group == "" {
+			podSetOpts := append(slices.Clone(opts), s.noSharedHost(podSets, i, result))
+			assignment, err := s.FindTopologyAssignment(podSet.TopologyRequest, podSet.Requests, podSet.Count, podSetOpts...)
+			if err != nil {
+				return nil, err
+			}
+			s.addAssignmentUsage(assignment, podSet.Requests)
+			result[i] = assignment
+			continue
+		}
+		if assignedGroups.Has(group) {
+			continue
+		}
+		assignedGroups.Insert(group)
+		var groupIdxs []int
+		for j := i; j < len(podSets); j++ {
+			if ptr.Deref(podSets[j].TopologyRequest.ColocationGroup, "") == group {
+				groupIdxs = append(groupIdxs, j)
+			}
+		}
+		if err := s.findColocatedAssignments(group, podSets, groupIdxs, result, opts); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// FindTopologyAssignments finds the topology assignments for the PodSets of
+// a workload against a single view of the capacity, as
+// FindTopologyAssignmentForPodSets does, but places the PodSets with more pods
+// first, and then in the order of the PodSets, so that the largest PodSets
+// aren't split by the smaller ones. The assignments are returned in the order
+// of the PodSets, and the returned bool indicates whether all of them fit.
+func (s *TASFlavorSnapshot) FindTopologyAssignments(
+	podSets []PodSetTopologyRequests,
+	opts ...FindTopologyAssignmentOption) ([]*kueue.TopologyAssignment, bool) {
+	order := make([]int, len(podSets))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		return cmp.Compare(podSets[b].Count, podSets[a].Count)
+	})
+	positions := make([]int, len(podSets))
+	for position, idx := range order {
+		positions[idx] = position
+	}
+	orderedPodSets := make([]PodSetTopologyRequests, len(podSets))
+	for position, idx := range order {
+		podSet := podSets[idx]
+		podSet.NoSharedHostWith = make([]int, 0, len(podSets[idx].NoSharedHostWith))
+		for _, otherIdx := range podSets[idx].NoSharedHostWith {
+			podSet.NoSharedHostWith = append(podSet.NoSharedHostWith, positions[otherIdx])
+		}
+		orderedPodSets[position] = podSet
+	}
+	orderedAssignments, err := s.FindTopologyAssignmentForPodSets(orderedPodSets, opts...)
+	if err != nil {
+		s.log.V(3).Info("The PodSets don't fit within the topology", "error", err)
+		return nil, false
+	}
+	result := make([]*kueue.TopologyAssignment, len(podSets))
+	for position, idx := range order {
+		result[idx] = orderedAssignments[position]
+	}
+	return result, true
+}
+
+// findColocatedAssignments finds the topology assignments for the PodSets of
+// the colocation group, at the given indexes, within a single domain at the
+// colocation level, and stores them in the result. The usage of the
+// assignments is added to the snapshot.
+func (s *TASFlavorSnapshot) findColocatedAssignments(
+	group string,
+	podSets []PodSetTopologyRequests,
+	groupIdxs []int,
+	result []*kueue.TopologyAssignment,
+	opts []FindTopologyAssignmentOption) error {
+	topologyRequest := podSets[groupIdxs[0]].TopologyRequest
+	colocationRequest := topologyRequest
+	if topologyRequest.ColocationLevel != nil {
+		colocationRequest = &kueue.PodSetTopologyRequest{Required: topologyRequest.ColocationLevel}
+	}
+	colocationLevelIdx, err := s.requestedLevelIdx(colocationRequest)
+	if err != nil {
+		return err
+	}
+	candidates := s.domainsForLevel(colocationLevelIdx)
+	slices.SortFunc(candidates, func(a, b *domain) int {
+		return strings.Compare(a.sortName, b.sortName)
+	})
+	for _, candidate := range candidates {
+		candidateOpts := append(slices.Clone(opts), func(o *findTopologyAssignmentOptions) {
+			o.withinDomain = ptr.To(candidate.id)
+		})
+		usage := make(map[utiltas.TopologyDomainID]resources.Requests)
+		assignments := make([]*kueue.TopologyAssignment, 0, len(groupIdxs))
+		for _, idx := range groupIdxs {
+			podSet := podSets[idx]
+			podSetOpts := append(slices.Clone(candidateOpts), s.noSharedHost(podSets, idx, result))
+			assignment, err := s.FindTopologyAssignment(podSet.TopologyRequest, podSet.Requests, podSet.Count, podSetOpts...)
+			if err != nil {
+				var assignmentErr *TopologyAssignmentError
+				if errors.As(err, &assignmentErr) && assignmentErr.Reason == InvalidTopologyLevel {
+					return err
+				}
+				break
+			}
+			result[idx] = assignment
+			for domainID, domainUsage := range s.addAssignmentUsage(assignment, podSet.Requests) {
+				if _, found := usage[domainID]; !found {
+					usage[domainID] = resources.Requests{}
+				}
+				usage[domainID].Add(domainUsage)
+			}
+			assignments = append(assignments, assignment)
+		}
+		if len(assignments) == len(groupIdxs) {
+			return nil
+		}
+		for _, idx := range groupIdxs {
+			result[idx] = nil
+		}
+		for domainID, domainUsage := range usage {
+			s.removeUsage(domainID, domainUsage)
+		}
+	}
+	return &TopologyAssignmentError{
+		Reason:  TopologyNotFit,
+		Message: fmt.Sprintf("cannot fit the PodSets of the colocation group %q within a single domain at level %q", group, s.levelKeys[colocationLevelIdx]),
+	}
+}
+
+// noSharedHost returns the option excluding the nodes of the lowest level
+// domains used by the already assigned PodSets which must not share a host
+// with the PodSet at the given index.
+func (s *TASFlavorSnapshot) noSharedHost(podSets []PodSetTopologyRequests, idx int, result []*kueue.TopologyAssignment) FindTopologyAssignmentOption {
+	bannedDomains := sets.New[utiltas.TopologyDomainID]()
+	for j, assignment := range result {
+		if assignment == nil || j == idx {
+			continue
+		}
+		if !slices.Contains(podSets[idx].NoSharedHostWith, j) && !slices.Contains(podSets[j].NoSharedHostWith, idx) {
+			continue
+		}
+		for _, domainAssignment := range assignment.Domains {
+			bannedDomains.Insert(utiltas.DomainID(domainAssignment.Values))
+		}
+	}
+	bannedNodes := sets.New[string]()
+	for nodeName, node := range s.nodes {
+		if bannedDomains.Has(node.domainID) {
+			bannedNodes.Insert(nodeName)
+		}
+	}
+	return func(o *findTopologyAssignmentOptions) {
+		o.excludedNodes = o.excludedNodes.Union(bannedNodes)
+	}
+}
+
+// Assign subtracts the capacity used by the assignment, with the given
+// requests per pod, from the free capacity of the snapshot, so that the
+// subsequent topology assignments, for example of the other workloads
+// admitted in the same scheduling cycle, don't reuse it.
+func (s *TASFlavorSnapshot) Assign(assignment *kueue.TopologyAssignment, requests resources.Requests) {
+	if assignment == nil {
+		return
+	}
+	s.addAssignmentUsage(assignment, requests)
+}
+
+// Release adds the capacity used by the assignment, with the given requests
+// per pod, back to the free capacity of the snapshot, for example when a
+// speculative admission is rolled back. It is the inverse of Assign.
+func (s *TASFlavorSnapshot) Release(assignment *kueue.TopologyAssignment, requests resources.Requests) {
+	if assignment == nil {
+		return
+	}
+	for _, domainAssignment := range assignment.Domains {
+		usage := resources.Requests{}
+		for name, value := range requests {
+			usage[name] = value * int64(domainAssignment.Count)
+		}
+		s.removeUsage(utiltas.DomainID(domainAssignment.Values), usage)
+	}
+}
+
+// addAssignmentUsage subtracts the capacity used by the assignment from the
+// free capacity of the lowest level domains, and returns the usage per
+// domain.
+func (s *TASFlavorSnapshot) addAssignmentUsage(assignment *kueue.TopologyAssignment, requests resources.Requests) map[utiltas.TopologyDomainID]resources.Requests {
+	result := make(map[utiltas.TopologyDomainID]resources.Requests, len(assignment.Domains))
+	for _, domainAssignment := range assignment.Domains {
+		domainID := utiltas.DomainID(domainAssignment.Values)
+		usage := resources.Requests{}
+		for name, value := range requests {
+			usage[name] = value * int64(domainAssignment.Count)
+		}
+		s.addUsage(domainID, usage)
+		result[domainID] = usage
+	}
+	return result
+}
+
+// assignToLowerLevels traverses the tree down level-by-level from the
+// domains at the fit level, and returns the lowest level domains with the
+// assigned pods.
+func (s *TASFlavorSnapshot) assignToLowerLevels(fitLevelIdx int, fitDomains []*domain, count int32, options *findTopologyAssignmentOptions) []*domain {
+	currFitDomain := s.updateCountsToMinimum(fitDomains, count, options)
+	tenantNodesPerDomain := s.countNodesPerDomain(options.tenantNodes)
+	for levelIdx := fitLevelIdx; levelIdx+1 < len(s.domainsPerLevel); levelIdx++ {
+		lowerFitDomains := s.lowerLevelDomains(levelIdx, currFitDomain)
+		sortedLowerDomains := s.sortedDomains(lowerFitDomains, options)
+		if options.preferFullerDomains {
+			s.fullerDomainsFirst(levelIdx+1, sortedLowerDomains, 1, options.allocatedFractionPerDomain)
+		}
+		if options.nodeScoringStrategy != NodeScoringDefault && levelIdx+1 == len(s.domainsPerLevel)-1 {
+			s.sortByAllocation(sortedLowerDomains, options.nodeScoringStrategy)
+		}
+		if options.readyBefore != nil && levelIdx+1 == len(s.domainsPerLevel)-1 {
+			sortedLowerDomains = s.warmDomainsFirst(sortedLowerDomains, *options.readyBefore)
+		}
+		if len(tenantNodesPerDomain) > 0 {
+			sortedLowerDomains = tenantDomainsFirst(sortedLowerDomains, tenantNodesPerDomain)
+		}
+		currFitDomain = s.updateCountsToMinimum(sortedLowerDomains, count, options)
+	}
+	return currFitDomain
+}
+
+// sortByAllocation sorts the lowest level domains by the fraction of their
+// capacity which is allocated, in the ascending order for LeastAllocated and
+// in the descending order for MostAllocated, keeping the order of the domains
+// with the same fraction.
+func (s *TASFlavorSnapshot) sortByAllocation(domains []*domain, strategy NodeScoringStrategy) {
+	fractions := make(map[utiltas.TopologyDomainID]float64, len(domains))
+	for _, d := range domains {
+		fractions[d.id] = s.allocatedFraction(d.id)
+	}
+	slices.SortStableFunc(domains, func(a, b *domain) int {
+		if strategy == NodeScoringMostAllocated {
+			return cmp.Compare(fractions[b.id], fractions[a.id])
+		}
+		return cmp.Compare(fractions[a.id], fractions[b.id])
+	})
+}
+
+// allocatedFraction returns the fraction of the capacity of the lowest level
+// domain which is allocated, averaged over its resources.
+func (s *TASFlavorSnapshot) allocatedFraction(domainID utiltas.TopologyDomainID) float64 {
+	var sum float64
+	var resourceCount int
+	domainCapacity := s.capacityPerDomain[domainID]
+	// the resources are summed in a fixed order for the stable tie-breaking
+	for _, resourceName := range slices.Sorted(maps.Keys(domainCapacity)) {
+		capacity := domainCapacity[resourceName]
+		if capacity <= 0 {
+			continue
+		}
+		free := min(max(s.freeCapacityPerDomain[domainID][resourceName], 0), capacity)
+		sum += float64(capacity-free) / float64(capacity)
+		resourceCount++
+	}
+	if resourceCount == 0 {
+		return 0
+	}
+	return sum / float64(resourceCount)
+}
+
+// allocatedFractionPerDomain returns the fraction of the capacity of the
+// domains, at all levels, which is allocated, averaged over the resources.
+// The capacity and the allocated quantity of a domain are the sums over its
+// lowest level domains.
+func (s *TASFlavorSnapshot) allocatedFractionPerDomain() map[utiltas.TopologyDomainID]float64 {
+	capacityPerDomain := make(map[utiltas.TopologyDomainID]resources.Requests)
+	allocatedPerDomain := make(map[utiltas.TopologyDomainID]resources.Requests)
+	for leafID, capacity := range s.capacityPerDomain {
+		allocated := make(resources.Requests, len(capacity))
+		for resourceName, value := range capacity {
+			allocated[resourceName] = value - min(max(s.freeCapacityPerDomain[leafID][resourceName], 0), value)
+		}
+		for domainID := range s.withAncestors(leafID) {
+			if _, found := capacityPerDomain[domainID]; !found {
+				capacityPerDomain[domainID] = resources.Requests{}
+				allocatedPerDomain[domainID] = resources.Requests{}
+			}
+			capacityPerDomain[domainID].Add(capacity)
+			allocatedPerDomain[domainID].Add(allocated)
+		}
+	}
+	result := make(map[utiltas.TopologyDomainID]float64, len(capacityPerDomain))
+	for domainID, capacity := range capacityPerDomain {
+		var sum float64
+		var resourceCount int
+		// the resources are summed in a fixed order for the stable tie-breaking
+		for _, resourceName := range slices.Sorted(maps.Keys(capacity)) {
+			if capacity[resourceName] <= 0 {
+				continue
+			}
+			sum += float64(allocatedPerDomain[domainID][resourceName]) / float64(capacity[resourceName])
+			resourceCount++
+		}
+		if resourceCount > 0 {
+			result[domainID] = sum / float64(resourceCount)
+		}
+	}
+	return result
+}
+
+// fullerDomainsFirst sorts the domains at the level by the fraction of the
+// capacity which is allocated, in the descending order, comparing their
+// ancestors from the top level first, so that the pods top off the fuller
+// racks before the fuller hosts in the other racks. The domains which can
+// accommodate count pods are kept ahead of the other ones, and the order of
+// the domains with the same fractions is kept.
+func (s *TASFlavorSnapshot) fullerDomainsFirst(levelIdx int, domains []*domain, count int32, fractions map[utiltas.TopologyDomainID]float64) {
+	fractionsPerDomain := make(map[utiltas.TopologyDomainID][]float64, len(domains))
+	for _, d := range domains {
+		domainFractions := make([]float64, levelIdx+1)
+		for idx, id := levelIdx, d.id; idx >= 0; idx-- {
+			domainFractions[idx] = fractions[id]
+			id = s.domainsPerLevel[idx][id].parentID
+		}
+		fractionsPerDomain[d.id] = domainFractions
+	}
+	slices.SortStableFunc(domains, func(a, b *domain) int {
+		if aFits, bFits := s.state[a.id] >= count, s.state[b.id] >= count; aFits != bFits {
+			if aFits {
+				return -1
+			}
+			return 1
+		}
+		return slices.Compare(fractionsPerDomain[b.id], fractionsPerDomain[a.id])
+	})
+}
+
+// warmDomainsFirst moves the lowest level domains whose nodes all became
+// Ready before the given time ahead of the other domains, keeping the order
+// within both groups.
+func (s *TASFlavorSnapshot) warmDomainsFirst(sortedDomains []*domain, readyBefore time.Time) []*domain {
+	result := make([]*domain, 0, len(sortedDomains))
+	var cold []*domain
+	for _, d := range sortedDomains {
+		if s.isWarmDomain(d.id, readyBefore) {
+			result = append(result, d)
+		} else {
+			cold = append(cold, d)
+		}
+	}
+	return append(result, cold...)
+}
+
+// tenantDomainsFirst moves the domains containing the nodes of the tenant
+// ahead of the other domains, keeping the order within both groups.
+func tenantDomainsFirst(sortedDomains []*domain, tenantNodesPerDomain map[utiltas.TopologyDomainID]int32) []*domain {
+	result := make([]*domain, 0, len(sortedDomains))
+	var fresh []*domain
+	for _, d := range sortedDomains {
+		if tenantNodesPerDomain[d.id] > 0 {
+			result = append(result, d)
+		} else {
+			fresh = append(fresh, d)
+		}
+	}
+	return append(result, fresh...)
+}
+
+func (s *TASFlavorSnapshot) isWarmDomain(domainID utiltas.TopologyDomainID, readyBefore time.Time) bool {
+	for _, nodeName := range s.nodesPerDomain[domainID] {
+		readySince := s.nodes[nodeName].readySince
+		if readySince.IsZero() || readySince.After(readyBefore) {
+			return false
+		}
+	}
+	return true
+}
+
+// isWithinDomain checks if the domain is the ancestor domain or one of its
+// descendants.
+func (s *TASFlavorSnapshot) isWithinDomain(domainID, ancestorID utiltas.TopologyDomainID) bool {
+	values := s.levelValuesPerDomain[domainID]
+	ancestorValues := s.levelValuesPerDomain[ancestorID]
+	return len(values) >= len(ancestorValues) && slices.Equal(values[:len(ancestorValues)], ancestorValues)
+}
+
+// reportTopologyAssignment records the result and the latency of an attempt
+// to find a topology assignment for the requested level.
+func reportTopologyAssignment(topologyRequest *kueue.PodSetTopologyRequest, start time.Time, err error) {
+	result := metrics.TopologyAssignmentSuccess
+	if err != nil {
+		result = metrics.TopologyAssignmentFailure
+	}
+	metrics.TopologyAssignment(requestedLevelKey(topologyRequest), result, time.Since(start))
+}
+
+func requestedLevelKey(topologyRequest *kueue.PodSetTopologyRequest) string {
+	if topologyRequest.Required != nil {
+		return *topologyRequest.Required
+	}
+	return ptr.Deref(topologyRequest.Preferred, "")
+}
+
+// innerPreferredLevelIdx returns the index of the preferred level, if the
+// PodSet prefers a level below the required one. The pods are then packed
+// at the preferred level as tightly as possible, without ever crossing the
+// boundary of a single domain at the required level. Otherwise, it returns
+// the index of the required level. The preferred level above the required one
+// is invalid, as the pods can't be packed beyond the required domain.
+func (s *TASFlavorSnapshot) innerPreferredLevelIdx(topologyRequest *kueue.PodSetTopologyRequest, levelIdx int) (int, error) {
+	if topologyRequest.Required == nil || topologyRequest.Preferred == nil {
+		return levelIdx, nil
+	}
+	preferredLevelIdx := slices.Index(s.levelKeys, *topologyRequest.Preferred)
+	if preferredLevelIdx == -1 {
+		return -1, &TopologyAssignmentError{
+			Reason:  InvalidTopologyLevel,
+			Message: fmt.Sprintf("topology level %q is not defined for the flavor, the levels are: %v", *topologyRequest.Preferred, s.levelKeys),
+			Level:   *topologyRequest.Preferred,
+		}
+	}
+	if preferredLevelIdx < levelIdx {
+		return -1, &TopologyAssignmentError{
+			Reason:  InvalidTopologyLevel,
+			Message: fmt.Sprintf("preferred topology level %q is above the required topology level %q", *topologyRequest.Preferred, *topologyRequest.Required),
+			Level:   *topologyRequest.Preferred,
+		}
+	}
+	return preferredLevelIdx, nil
+}
+
+// resolveMinLevelIdx returns the index of the highest level at which the
+// workload may fit in a single domain. The value of -1 indicates that the
+// workload can be also spread across multiple domains at the top level.
+func (s *TASFlavorSnapshot) resolveMinLevelIdx(
+	topologyRequest *kueue.PodSetTopologyRequest,
+	levelIdx int,
+	options *findTopologyAssignmentOptions) int {
+	if options.localityBudget != nil {
+		relaxedLevels := int(math.Floor(*options.localityBudget * float64(levelIdx+1)))
+		return levelIdx - relaxedLevels
+	}
+	if topologyRequest.Required != nil {
+		return levelIdx
+	}
+	return -1
+}
+
+func (s *TASFlavorSnapshot) findLevelWithFitDomains(levelIdx int, minLevelIdx int, count int32, options *findTopologyAssignmentOptions) (int, []*domain) {
+	levelDomains := s.domainsForLevel(levelIdx)
+	if len(levelDomains) == 0 {
+		return 0, nil
+	}
+	sortedDomain := s.sortedDomains(levelDomains, options)
+	topDomain := sortedDomain[0]
+	if s.state[topDomain.id] < count {
+		if levelIdx <= minLevelIdx {
+			return 0, nil
+		}
+		if levelIdx > 0 {
+			return s.findLevelWithFitDomains(levelIdx-1, minLevelIdx, count, options)
+		}
+		if options.preferFullerDomains {
+			s.fullerDomainsFirst(levelIdx, sortedDomain, 1, options.allocatedFractionPerDomain)
+		}
+		lastIdx := 0
+		remainingCount := count - s.state[sortedDomain[lastIdx].id]
+		for remainingCount > 0 && lastIdx < len(sortedDomain)-1 {
+			lastIdx++
+			remainingCount -= s.state[sortedDomain[lastIdx].id]
+		}
+		if remainingCount > 0 {
+			return 0, nil
+		}
+		return 0, sortedDomain[:lastIdx+1]
+	}
+	if options.maxDomainsLevelKey != "" {
+		sortedDomain = slices.DeleteFunc(sortedDomain, func(d *domain) bool {
+			return s.state[d.id] < count || !s.withinMaxDomains(levelIdx, d, count, options)
+		})
+		if len(sortedDomain) == 0 {
+			return 0, nil
+		}
+	}
+	if options.preferFullerDomains {
+		s.fullerDomainsFirst(levelIdx, sortedDomain, count, options.allocatedFractionPerDomain)
+	}
+	if options.tightPack {
+		return levelIdx, []*domain{s.tightestFitDomain(sortedDomain, count)}
+	}
+	if options.latencyBudget != nil || options.minimizeNodes || options.fragmentationPenalty {
+		return levelIdx, []*domain{s.bestPlacementDomain(levelIdx, sortedDomain, count, options)}
+	}
+	return levelIdx, []*domain{s.preferredFitDomain(sortedDomain, count, options)}
+}
+
+// tightestFitDomain returns the domain with the fewest free pods among the
+// domains which can accommodate count pods. The domains are expected to be
+// sorted, with the first one fitting the pods.
+func (s *TASFlavorSnapshot) tightestFitDomain(sortedDomains []*domain, count int32) *domain {
+	result := sortedDomains[0]
+	for _, d := range sortedDomains {
+		if s.state[d.id] < count {
+			break
+		}
+		if s.state[d.id] < s.state[result.id] {
+			result = d
+		}
+	}
+	return result
+}
+
+// needsCompaction checks if the average fragmentation of the lowest level
+// domains exceeds the compaction threshold of the flavor.
+func (s *TASFlavorSnapshot) needsCompaction() bool {
+	return s.compactionThreshold != nil && s.fragmentation() > *s.compactionThreshold
+}
+
+// fragmentation returns the average fragmentation of the lowest level
+// domains. The fragmentation of a partially used domain is the fraction of
+// its capacity which is free, for the resource with the largest such
+// fraction. The fragmentation of an unused or a full domain is 0.
+func (s *TASFlavorSnapshot) fragmentation() float64 {
+	if len(s.capacityPerDomain) == 0 {
+		return 0
+	}
+	var sum float64
+	for domainID, total := range s.capacityPerDomain {
+		free := s.freeCapacityPerDomain[domainID]
+		var domainFragmentation float64
+		for name, totalValue := range total {
+			if freeValue := free[name]; freeValue > 0 && freeValue < totalValue {
+				domainFragmentation = max(domainFragmentation, float64(freeValue)/float64(totalValue))
+			}
+		}
+		sum += domainFragmentation
+	}
+	return sum / float64(len(s.capacityPerDomain))
+}
+
+// placementCost describes the placement of the pods in a domain.
+type placementCost struct {
+	// nodes is the number of nodes used by the pods
+	nodes int32
+
+	// domains is the number of domains used by the pods below the domain
+	domains int32
+
+	// partialDomains is the change in the number of the partially used
+	// lowest level domains caused by the pods
+	partialDomains int32
+
+	// slack is the number of pods which would remain free in the domain
+	slack int32
+}
+
+func (c placementCost) less(other placementCost, options *findTopologyAssignmentOptions) bool {
+	if options.minimizeNodes && c.nodes != other.nodes {
+		return c.nodes < other.nodes
+	}
+	if c.domains != other.domains {
+		return c.domains < other.domains
+	}
+	if options.fragmentationPenalty && c.partialDomains != other.partialDomains {
+		return c.partialDomains < other.partialDomains
+	}
+	return c.slack < other.slack
+}
+
+// bestPlacementDomain returns the domain resulting in the best placement
+// among the domains which can accommodate count pods. The domains are
+// expected to be sorted, with the first one fitting the pods. The domains
+// are evaluated until the latency budget, if any, is exceeded.
+func (s *TASFlavorSnapshot) bestPlacementDomain(levelIdx int, sortedDomains []*domain, count int32, options *findTopologyAssignmentOptions) *domain {
+	start := time.Now()
+	result := sortedDomains[0]
+	var bestCost placementCost
+	evaluated := false
+	for _, d := range sortedDomains {
+		if s.state[d.id] < count {
+			break
+		}
+		if options.latencyBudget != nil && time.Since(start) >= *options.latencyBudget {
+			break
+		}
+		cost := s.placementCost(levelIdx, d, count, options)
+		if !evaluated || cost.less(bestCost, options) {
+			result = d
+			bestCost = cost
+			evaluated = true
+		}
+	}
+	return result
+}
+
+// placementCost returns the cost of placing count pods in the domain. It
+// leaves the state of the domains and the nodes unchanged.
+func (s *TASFlavorSnapshot) placementCost(levelIdx int, d *domain, count int32, options *findTopologyAssignmentOptions) placementCost {
+	cost := placementCost{
+		slack: s.state[d.id] - count,
+	}
+	savedState := s.subtreeState(levelIdx, d)
+	defer s.restoreState(savedState)
+	usedDomains := sets.New[utiltas.TopologyDomainID]()
+	for _, leaf := range s.assignToLowerLevels(levelIdx, []*domain{d}, count, options) {
+		cost.nodes += s.nodesUsed(leaf.id, s.state[leaf.id])
+		if savedState[leaf.id] > s.state[leaf.id] {
+			cost.partialDomains++
+		}
+		if s.isPartiallyUsed(leaf.id) {
+			cost.partialDomains--
+		}
+		for childLevelIdx, id := len(s.domainsPerLevel)-1, leaf.id; childLevelIdx > levelIdx; childLevelIdx-- {
+			usedDomains.Insert(id)
+			id = s.domainsPerLevel[childLevelIdx][id].parentID
+		}
+	}
+	cost.domains = int32(usedDomains.Len())
+	return cost
+}
+
+// subtreeState returns the state of the domain at the level and of all the
+// domains below it, which are the only ones changed by assigning the pods
+// within the domain.
+func (s *TASFlavorSnapshot) subtreeState(levelIdx int, d *domain) statePerDomain {
+	result := make(statePerDomain)
+	domains := []*domain{d}
+	for ; len(domains) > 0; levelIdx++ {
+		for _, subtreeDomain := range domains {
+			result[subtreeDomain.id] = s.state[subtreeDomain.id]
+		}
+		if levelIdx+1 == len(s.domainsPerLevel) {
+			break
+		}
+		domains = s.lowerLevelDomains(levelIdx, domains)
+	}
+	return result
+}
+
+// restoreState restores the state of the domains saved by subtreeState.
+func (s *TASFlavorSnapshot) restoreState(saved statePerDomain) {
+	maps.Copy(s.state, saved)
+}
+
+// isPartiallyUsed checks if some of the capacity of the lowest level domain
+// is already used.
+func (s *TASFlavorSnapshot) isPartiallyUsed(domainID utiltas.TopologyDomainID) bool {
+	free := s.freeCapacityPerDomain[domainID]
+	for name, total := range s.capacityPerDomain[domainID] {
+		if free[name] < total {
+			return true
+		}
+	}
+	return false
+}
+
+// nodesUsed returns the minimal number of nodes of the lowest level domain
+// which can accommodate count pods, based on the number of pods which fit on
+// each node.
+func (s *TASFlavorSnapshot) nodesUsed(domainID utiltas.TopologyDomainID, count int32) int32 {
+	nodeCounts := make([]int32, 0, len(s.nodesPerDomain[domainID]))
+	for _, nodeName := range s.nodesPerDomain[domainID] {
+		nodeCounts = append(nodeCounts, s.nodeState[nodeName])
+	}
+	slices.SortFunc(nodeCounts, func(a, b int32) int {
+		return cmp.Compare(b, a)
+	})
+	var result int32
+	for _, nodeCount := range nodeCounts {
+		if count <= 0 {
+			break
+		}
+		count -= nodeCount
+		result++
+	}
+	return result
+}
+
+// preferredFitDomain returns the preferred domain among the domains which can
+// accommodate count pods. The domains are expected to be sorted, with the
+// first one fitting the pods. The domains containing more nodes in the
+// preferred region are preferred, followed by the domains with the fraction
+// of spot nodes matching the deadline of the workload, the domains containing
+// more data nodes, the domains with the higher historical success rate, and
+// then by the domains with the lower carbon intensity. Without the
+// preferences it returns the first domain.
+func (s *TASFlavorSnapshot) preferredFitDomain(sortedDomains []*domain, count int32, options *findTopologyAssignmentOptions) *domain {
+	result := sortedDomains[0]
+	carbonAware := options.carbonMode == CarbonModeBatch
+	if len(options.domainHistory) == 0 && len(options.dataNodes) == 0 && !carbonAware && options.regionLabelKey == "" && options.spotLabelKey == "" {
+		return result
+	}
+	regionNodesPerDomain := s.countNodesPerDomain(s.nodesWithLabel(options.regionLabelKey, options.preferredRegion))
+	spotFractionPerDomain := s.spotFractionPerDomain(options.spotLabelKey)
+	dataNodesPerDomain := s.countNodesPerDomain(options.dataNodes)
+	var carbonIntensityPerDomain map[utiltas.TopologyDomainID]float64
+	if carbonAware {
+		carbonIntensityPerDomain = s.carbonIntensityPerDomain(options.carbonIntensityLabel)
+	}
+	for _, d := range sortedDomains[1:] {
+		if s.state[d.id] < count {
+			break
+		}
+		if regionNodesPerDomain[d.id] != regionNodesPerDomain[result.id] {
+			if regionNodesPerDomain[d.id] > regionNodesPerDomain[result.id] {
+				result = d
+			}
+			continue
+		}
+		if spotFractionPerDomain[d.id] != spotFractionPerDomain[result.id] {
+			if (spotFractionPerDomain[d.id] > spotFractionPerDomain[result.id]) == options.preferSpot {
+				result = d
+			}
+			continue
+		}
+		if dataNodesPerDomain[d.id] != dataNodesPerDomain[result.id] {
+			if dataNodesPerDomain[d.id] > dataNodesPerDomain[result.id] {
+				result = d
+			}
+			continue
+		}
+		dRate := options.domainHistory[d.id].successRate()
+		resultRate := options.domainHistory[result.id].successRate()
+		if dRate != resultRate {
+			if dRate > resultRate {
+				result = d
+			}
+			continue
+		}
+		if carbonAware && carbonIntensity(carbonIntensityPerDomain, d.id) < carbonIntensity(carbonIntensityPerDomain, result.id) {
+			result = d
+		}
+	}
+	return result
+}
+
+// carbonIntensityPerDomain returns the average carbon intensity of the nodes
+// in each domain, at all levels, based on the value of the label. The nodes
+// without a valid label value are skipped.
+func (s *TASFlavorSnapshot) carbonIntensityPerDomain(labelKey string) map[utiltas.TopologyDomainID]float64 {
+	sums := make(map[utiltas.TopologyDomainID]float64)
+	counts := make(map[utiltas.TopologyDomainID]int)
+	for _, node := range s.nodes {
+		value, found := node.labels[labelKey]
+		if !found {
+			continue
+		}
+		intensity, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			continue
+		}
+		for domainID := range s.withAncestors(node.domainID) {
+			sums[domainID] += intensity
+			counts[domainID]++
+		}
+	}
+	result := make(map[utiltas.TopologyDomainID]float64, len(sums))
+	for domainID, sum := range sums {
+		result[domainID] = sum / float64(counts[domainID])
+	}
+	return result
+}
+
+// carbonIntensity returns the carbon intensity of the domain. The domains
+// with unknown carbon intensity are considered the least preferred.
+func carbonIntensity(carbonIntensityPerDomain map[utiltas.TopologyDomainID]float64, domainID utiltas.TopologyDomainID) float64 {
+	if intensity, found := carbonIntensityPerDomain[domainID]; found {
+		return intensity
+	}
+	return math.Inf(1)
+}
+
+// nodesWithLabel returns the names of the nodes with the given label value.
+// It returns an empty set if the label key is empty.
+func (s *TASFlavorSnapshot) nodesWithLabel(labelKey, value string) sets.Set[string] {
+	result := sets.New[string]()
+	if labelKey == "" {
+		return result
+	}
+	for nodeName, node := range s.nodes {
+		if nodeValue, found := node.labels[labelKey]; found && nodeValue == value {
+			result.Insert(nodeName)
+		}
+	}
+	return result
+}
+
+// spotFractionPerDomain returns the fraction of the spot nodes, marked by
+// the label with the given key set to "true", among the nodes of each domain,
+// at all levels. It returns an empty map if the label key is empty.
+func (s *TASFlavorSnapshot) spotFractionPerDomain(labelKey string) map[utiltas.TopologyDomainID]float64 {
+	result := make(map[utiltas.TopologyDomainID]float64)
+	if labelKey == "" {
+		return result
+	}
+	nodesPerDomain := s.countNodesPerDomain(sets.KeySet(s.nodes))
+	for domainID, spotNodes := range s.countNodesPerDomain(s.nodesWithLabel(labelKey, "true")) {
+		result[domainID] = float64(spotNodes) / float64(nodesPerDomain[domainID])
+	}
+	return result
+}
+
+// countNodesPerDomain returns the number of the given nodes contained in each
+// domain, at all levels.
+func (s *TASFlavorSnapshot) countNodesPerDomain(nodeNames sets.Set[string]) map[utiltas.TopologyDomainID]int32 {
+	result := make(map[utiltas.TopologyDomainID]int32)
+	for nodeName := range nodeNames {
+		node, found := s.nodes[nodeName]
+		if !found {
+			continue
+		}
+		for domainID := range s.withAncestors(node.domainID) {
+			result[domainID]++
+		}
+	}
+	return result
+}
+
+// withAncestors returns the lowest level domain along with all the domains
+// above it, from the bottom up.
+func (s *TASFlavorSnapshot) withAncestors(leafID utiltas.TopologyDomainID) iter.Seq[utiltas.TopologyDomainID] {
+	return func(yield func(utiltas.TopologyDomainID) bool) {
+		domainID := leafID
+		for levelIdx := len(s.domainsPerLevel) - 1; levelIdx >= 0; levelIdx-- {
+			domain, found := s.domainsPerLevel[levelIdx][domainID]
+			if !found || !yield(domainID) {
+				return
+			}
+			domainID = domain.parentID
+		}
+	}
+}
+
+func (s *TASFlavorSnapshot) updateCountsToMinimum(domains []*domain, count int32, options *findTopologyAssignmentOptions) []*domain {
+	result := make([]*domain, 0)
+	remainingCount := count
+	for i := 0; i < len(domains); i++ {
 		domain := domains[i]
 		if s.state[domain.id] >= remainingCount {
 			s.state[domain.id] = remainingCount
@@ -259,6 +3327,7 @@ func (s *TASFlavorSnapshot) updateCountsToMinimum(domains []*domain, count int32
 			return result
 		}
 		if s.state[domain.id] > 0 {
+			s.state[domain.id] = s.avoidSplinter(domains[i+1:], s.state[domain.id], remainingCount, options)
 			remainingCount -= s.state[domain.id]
 			result = append(result, domain)
 		}
@@ -271,6 +3340,35 @@ func (s *TASFlavorSnapshot) updateCountsToMinimum(domains []*domain, count int32
 	return nil
 }
 
+// avoidSplinter returns the number of pods to assign to a domain which can
+// accommodate domainCount pods, out of the remainingCount pods. If assigning
+// domainCount pods would leave fewer than noSplinterThreshold pods for the
+// next domains, it moves some pods from the domain to the next domains, as
+// long as none of the two groups is smaller than the threshold and the next
+// domains can accommodate them.
+func (s *TASFlavorSnapshot) avoidSplinter(nextDomains []*domain, domainCount, remainingCount int32, options *findTopologyAssignmentOptions) int32 {
+	threshold := options.noSplinterThreshold
+	if threshold <= 0 {
+		return domainCount
+	}
+	leftover := remainingCount - domainCount
+	if leftover >= threshold {
+		return domainCount
+	}
+	adjustedCount := remainingCount - threshold
+	if adjustedCount < threshold {
+		return domainCount
+	}
+	var nextCapacity int32
+	for _, d := range nextDomains {
+		nextCapacity += s.state[d.id]
+	}
+	if nextCapacity < threshold {
+		return domainCount
+	}
+	return adjustedCount
+}
+
 func (s *TASFlavorSnapshot) buildAssignment(domains []*domain) *kueue.TopologyAssignment {
 	assignment := kueue.TopologyAssignment{
 		Levels:  s.levelKeys,
@@ -282,9 +3380,94 @@ func (s *TASFlavorSnapshot) buildAssignment(domains []*domain) *kueue.TopologyAs
 			Count:  s.state[domains[i].id],
 		})
 	}
+	sortDomainAssignments(assignment.Domains)
 	return &assignment
 }
 
+// sortDomainAssignments sorts the domains of an assignment by their values,
+// so that the logically identical assignments are equal.
+func sortDomainAssignments(domains []kueue.TopologyDomainAssignment) {
+	slices.SortFunc(domains, func(a, b kueue.TopologyDomainAssignment) int {
+		return slices.Compare(a.Values, b.Values)
+	})
+}
+
+// SoftLimitViolation describes the soft limit resource whose capacity is
+// exceeded by the assignment in a lowest level domain.
+type SoftLimitViolation struct {
+	// Values are the level values of the domain.
+	Values []string
+
+	// Resource is the name of the soft limit resource.
+	Resource corev1.ResourceName
+
+	// Excess is the amount by which the requests of the pods assigned to the
+	// domain exceed its free capacity of the resource.
+	Excess int64
+}
+
+// SoftLimitViolations returns the soft limit resources whose free capacity is
+// exceeded by the assignment, per lowest level domain, ordered by the level
+// values and then by the resource names. The assignment is evaluated against
+// the free capacity of the snapshot, so its usage is expected not to be
+// accounted in the snapshot.
+func (s *TASFlavorSnapshot) SoftLimitViolations(assignment *kueue.TopologyAssignment, requests resources.Requests, resourceNames ...corev1.ResourceName) []SoftLimitViolation {
+	if assignment == nil {
+		return nil
+	}
+	var result []SoftLimitViolation
+	for _, domain := range assignment.Domains {
+		freeCapacity := s.freeCapacityPerDomain[utiltas.DomainID(domain.Values)]
+		for _, resourceName := range slices.Sorted(slices.Values(resourceNames)) {
+			if excess := requests[resourceName]*int64(domain.Count) - max(freeCapacity[resourceName], 0); excess > 0 {
+				result = append(result, SoftLimitViolation{
+					Values:   domain.Values,
+					Resource: resourceName,
+					Excess:   excess,
+				})
+			}
+		}
+	}
+	slices.SortStableFunc(result, func(a, b SoftLimitViolation) int {
+		return slices.Compare(a.Values, b.Values)
+	})
+	return result
+}
+
+// NodesMissingLevels returns the nodes which match the node labels of the
+// flavor, but are excluded from the snapshot as they are missing the labels
+// of some topology levels, along with the missing levels. It allows to
+// surface the misconfigured nodes, for example as events.
+func (s *TASFlavorSnapshot) NodesMissingLevels() map[string][]string {
+	return maps.Clone(s.nodesMissingLevels)
+}
+
+// NodesWithDuplicateHost returns the nodes which share the value of the host
+// label with another node, for example in a different rack, along with the
+// value. All the nodes sharing the value are excluded from the snapshot, and
+// none of them is assigned pods until the labels are fixed, as the pods
+// can't be directed to one of them. It allows to surface the misconfigured
+// nodes.
+func (s *TASFlavorSnapshot) NodesWithDuplicateHost() map[string]string {
+	return maps.Clone(s.nodesWithDuplicateHost)
+}
+
+// ProvisionalDomains returns the domains of the assignment which rely on the
+// capacity of pending nodes, and so only become valid once the nodes join
+// the cluster.
+func (s *TASFlavorSnapshot) ProvisionalDomains(assignment *kueue.TopologyAssignment) []kueue.TopologyDomainAssignment {
+	if assignment == nil {
+		return nil
+	}
+	var result []kueue.TopologyDomainAssignment
+	for _, domain := range assignment.Domains {
+		if s.provisionalDomains.Has(utiltas.DomainID(domain.Values)) {
+			result = append(result, domain)
+		}
+	}
+	return result
+}
+
 func (s *TASFlavorSnapshot) asLevelValues(domainID utiltas.TopologyDomainID) []string {
 	result := make([]string, len(s.levelKeys))
 	for i := range s.levelKeys {
@@ -316,7 +3499,12 @@ func (s *TASFlavorSnapshot) domainsForLevel(levelIdx int) []*domain {
 	return result
 }
 
-func (s *TASFlavorSnapshot) sortedDomains(infos []*domain) []*domain {
+// sortedDomains returns the domains sorted by the number of pods which fit in
+// them, in the descending order. The ties are resolved by the tie-breaking
+// options, and then by the level values of the domains, so the assignment is
+// deterministic, for example the lexicographically first hosts are chosen
+// among the equal hosts of a rack.
+func (s *TASFlavorSnapshot) sortedDomains(infos []*domain, options *findTopologyAssignmentOptions) []*domain {
 	result := make([]*domain, len(infos))
 	copy(result, infos)
 	slices.SortFunc(result, func(a, b *domain) int {
@@ -324,6 +3512,18 @@ func (s *TASFlavorSnapshot) sortedDomains(infos []*domain) []*domain {
 		bCount := s.state[b.id]
 		switch {
 		case aCount == bCount:
+			if activityCmp := options.lastActivity[b.id].Compare(options.lastActivity[a.id]); activityCmp != 0 {
+				return activityCmp
+			}
+			if weightCmp := cmp.Compare(options.weightedFreeCapacity[b.id], options.weightedFreeCapacity[a.id]); weightCmp != 0 {
+				return weightCmp
+			}
+			if warmCmp := cmp.Compare(options.warmNodesPerDomain[b.id], options.warmNodesPerDomain[a.id]); warmCmp != 0 {
+				return warmCmp
+			}
+			if preferredCmp := cmp.Compare(options.preferredNodePerDomain[b.id], options.preferredNodePerDomain[a.id]); preferredCmp != 0 {
+				return preferredCmp
+			}
 			return strings.Compare(a.sortName, b.sortName)
 		case aCount > bCount:
 			return -1
@@ -334,16 +3534,354 @@ func (s *TASFlavorSnapshot) sortedDomains(infos []*domain) []*domain {
 	return result
 }
 
-func (s *TASFlavorSnapshot) fillInCounts(requests resources.Requests) {
-	for domainID, capacity := range s.freeCapacityPerDomain {
-		s.state[domainID] = requests.CountIn(capacity)
+// weightedFreeCapacityPerDomain returns the free capacity of the domains, at
+// all levels, as the sum of the free capacity of each resource multiplied by
+// its weight.
+func (s *TASFlavorSnapshot) weightedFreeCapacityPerDomain(weights map[corev1.ResourceName]float64) map[utiltas.TopologyDomainID]float64 {
+	result := make(map[utiltas.TopologyDomainID]float64)
+	for leafID, capacity := range s.freeCapacityPerDomain {
+		var weighted float64
+		for name, weight := range weights {
+			weighted += weight * float64(capacity[name])
+		}
+		for domainID := range s.withAncestors(leafID) {
+			result[domainID] += weighted
+		}
+	}
+	return result
+}
+
+// fillInCounts sets the number of pods which can fit in each domain. The pods
+// without any requests aren't constrained by the capacity, so all count pods
+// fit in any lowest level domain with a node available for the assignment,
+// subject to the limit of the pods per domain.
+func (s *TASFlavorSnapshot) fillInCounts(requests resources.Requests, count int32, levelIdx int, options *findTopologyAssignmentOptions) {
+	requests = positiveRequests(roundUp(requests, options.granularity))
+	unconstrained := len(requests) == 0
+	var buffer int32
+	if options.capacityBuffer != nil {
+		buffer = options.capacityBuffer(count)
 	}
+	excludedNodes := s.withPartitions(options.excludedNodes)
+	if untoleratedNodes := s.untoleratedNodes(options.tolerations); untoleratedNodes.Len() > 0 {
+		excludedNodes = excludedNodes.Union(untoleratedNodes)
+	}
+	if len(options.excludedDomains) > 0 {
+		excludedNodes = excludedNodes.Union(s.nodesInDomains(options.excludedDomains))
+	}
+	if options.maxNewNodes != nil {
+		excludedNodes = excludedNodes.Union(s.excessPendingNodes(requests, *options.maxNewNodes, excludedNodes))
+	}
+	if options.sparesPerDomain > 0 {
+		excludedNodes = excludedNodes.Union(s.spareNodes(requests, levelIdx, options.sparesPerDomain, excludedNodes))
+	}
+	excludedCapacity := s.excludedCapacityPerDomain(excludedNodes)
 	lastLevelIdx := len(s.domainsPerLevel) - 1
+	maxPodsLevelIdx := -1
+	if options.maxPodsPerDomainLevelKey != "" {
+		maxPodsLevelIdx = slices.Index(s.levelKeys, options.maxPodsPerDomainLevelKey)
+	}
+	for nodeName, node := range s.nodes {
+		if excludedNodes.Has(nodeName) {
+			s.nodeState[nodeName] = 0
+			continue
+		}
+		capacity := node.capacity
+		if ceiling, found := densificationCeiling(node, options.densificationCeilingLabel); found {
+			above := aboveCeiling(node.capacity, ceiling)
+			if _, found := excludedCapacity[node.domainID]; !found {
+				excludedCapacity[node.domainID] = resources.Requests{}
+			}
+			excludedCapacity[node.domainID].Add(above)
+			capacity = capacity.Clone()
+			capacity.Sub(above)
+		}
+		if unconstrained {
+			s.nodeState[nodeName] = min(count, podSlots(requests, capacity))
+			continue
+		}
+		s.nodeState[nodeName] = min(requests.CountIn(roundDown(capacity, options.granularity)), podSlots(requests, capacity))
+	}
+	nvlinkLimit := s.nvlinkLimitPerDomain(requests, options.granularity, excludedNodes)
+	var reservationLimit map[utiltas.TopologyDomainID]int32
+	if !unconstrained {
+		reservationLimit = s.reservationLimitPerDomain(requests, options.granularity, excludedCapacity)
+	}
+	leafCount := func(domainID utiltas.TopologyDomainID, capacity resources.Requests) int32 {
+		if excluded, found := excludedCapacity[domainID]; found {
+			capacity = capacity.Clone()
+			capacity.Sub(excluded)
+		}
+		var domainCount int32
+		if unconstrained {
+			domainCount = s.unconstrainedCount(domainID, count)
+		} else {
+			domainCount = requests.CountIn(roundDown(capacity, options.granularity))
+		}
+		domainCount = min(domainCount, podSlots(requests, capacity))
+		if limit, found := nvlinkLimit[domainID]; found {
+			domainCount = min(domainCount, limit)
+		}
+		result := max(domainCount-buffer, 0)
+		if options.withinDomain != nil && !s.isWithinDomain(domainID, *options.withinDomain) {
+			result = 0
+		}
+		if limit, found := gpuHourLimit(requests, domainID, options); found {
+			result = min(result, limit)
+		}
+		if limit, found := reservationLimit[domainID]; found {
+			result = min(result, limit)
+		}
+		if maxPodsLevelIdx == lastLevelIdx {
+			result = min(result, options.maxPodsPerDomain)
+		}
+		if options.equalCount > 0 {
+			if result < options.equalCount {
+				result = 0
+			} else {
+				result = options.equalCount
+			}
+		}
+		return result
+	}
+	parentCount := func(levelIdx int, info *domain, childrenCount int32) int32 {
+		result := childrenCount
+		if limit, found := gpuHourLimit(requests, info.id, options); found {
+			result = min(result, limit)
+		}
+		if limit, found := reservationLimit[info.id]; found {
+			result = min(result, limit)
+		}
+		if levelIdx == maxPodsLevelIdx {
+			result = min(result, options.maxPodsPerDomain)
+		}
+		return result
+	}
+	parallelThreshold := ptr.Deref(options.parallelThreshold, s.parallelThreshold)
+	if parallelThreshold > 0 && len(s.domainsPerLevel) > 0 && len(s.domainsPerLevel[0]) >= parallelThreshold {
+		s.fillInCountsInParallel(options.ctx, leafCount, parentCount)
+		return
+	}
+	for domainID, capacity := range s.freeCapacityPerDomain {
+		s.state[domainID] = leafCount(domainID, capacity)
+	}
 	for levelIdx := lastLevelIdx - 1; levelIdx >= 0; levelIdx-- {
 		for _, info := range s.domainsPerLevel[levelIdx] {
+			var childrenCount int32
 			for _, childDomainID := range info.childIDs {
-				s.state[info.id] += s.state[childDomainID]
+				childrenCount += s.state[childDomainID]
+			}
+			s.state[info.id] = parentCount(levelIdx, info, childrenCount)
+		}
+	}
+}
+
+// fillInCountsInParallel computes the counts of the domains, as fillInCounts
+// does, for the subtrees of the top level domains in parallel. The top level
+// domains are split into a chunk per available CPU, and the counts of the
+// chunks are merged in the order of the top level domains, so that the result
+// doesn't depend on the scheduling of the goroutines. If the context is
+// canceled, the counts of the chunks which weren't evaluated are left out.
+func (s *TASFlavorSnapshot) fillInCountsInParallel(
+	ctx context.Context,
+	leafCount func(utiltas.TopologyDomainID, resources.Requests) int32,
+	parentCount func(int, *domain, int32) int32) {
+	topDomains := slices.SortedFunc(maps.Values(s.domainsPerLevel[0]), func(a, b *domain) int {
+		return cmp.Compare(a.id, b.id)
+	})
+	chunks := slices.Collect(slices.Chunk(topDomains, max(len(topDomains)/runtime.GOMAXPROCS(0), 1)))
+	chunkCounts := make([][]domainStateEntry, len(chunks))
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	// The counting can't fail, so the error is always nil.
+	_ = parallelize.Until(ctx, len(chunks), func(i int) error {
+		for _, topDomain := range chunks[i] {
+			s.fillInSubtreeCounts(0, topDomain, &chunkCounts[i], leafCount, parentCount)
+		}
+		return nil
+	})
+	for _, counts := range chunkCounts {
+		for _, c := range counts {
+			s.state[c.id] = c.count
+		}
+	}
+}
+
+// domainStateEntry holds the number of pods which fit in the domain.
+type domainStateEntry struct {
+	id    utiltas.TopologyDomainID
+	count int32
+}
+
+// fillInSubtreeCounts appends the counts of the domain at the level with the
+// given index, and of all the domains below it, to the counts, and returns
+// the count of the domain. It only reads the shared state of the snapshot,
+// so it is safe to call it concurrently for the disjoint subtrees.
+func (s *TASFlavorSnapshot) fillInSubtreeCounts(
+	levelIdx int,
+	d *domain,
+	counts *[]domainStateEntry,
+	leafCount func(utiltas.TopologyDomainID, resources.Requests) int32,
+	parentCount func(int, *domain, int32) int32) int32 {
+	var count int32
+	if levelIdx == len(s.domainsPerLevel)-1 {
+		if capacity, found := s.freeCapacityPerDomain[d.id]; found {
+			count = leafCount(d.id, capacity)
+		} else {
+			count = s.state[d.id]
+		}
+	} else {
+		var childrenCount int32
+		for _, childID := range d.childIDs {
+			childrenCount += s.fillInSubtreeCounts(levelIdx+1, s.domainsPerLevel[levelIdx+1][childID], counts, leafCount, parentCount)
+		}
+		count = parentCount(levelIdx, d, childrenCount)
+	}
+	*counts = append(*counts, domainStateEntry{id: d.id, count: count})
+	return count
+}
+
+// podSlots returns the number of pods allowed by the pods resource of the
+// capacity, such as the max pods of the kubelet advertised in the node
+// allocatable. The pods aren't limited if the capacity doesn't include the
+// pods resource, or if the requests already include it, in which case the
+// pods are counted as any other resource.
+func podSlots(requests, capacity resources.Requests) int32 {
+	if _, found := requests[corev1.ResourcePods]; found {
+		return math.MaxInt32
+	}
+	slots, found := capacity[corev1.ResourcePods]
+	if !found {
+		return math.MaxInt32
+	}
+	return int32(min(max(slots, 0), math.MaxInt32))
+}
+
+// unconstrainedCount returns the number of pods without any requests which
+// fit in the lowest level domain, that is count if any of its nodes is
+// available for the assignment, and 0 otherwise.
+func (s *TASFlavorSnapshot) unconstrainedCount(domainID utiltas.TopologyDomainID, count int32) int32 {
+	for _, nodeName := range s.nodesPerDomain[domainID] {
+		if s.nodeState[nodeName] > 0 {
+			return count
+		}
+	}
+	return 0
+}
+
+// positiveRequests returns the requests without the resources requested in
+// zero quantity, which don't constrain the number of pods.
+func positiveRequests(requests resources.Requests) resources.Requests {
+	for _, value := range requests {
+		if value <= 0 {
+			result := make(resources.Requests, len(requests))
+			for name, value := range requests {
+				if value > 0 {
+					result[name] = value
+				}
+			}
+			return result
+		}
+	}
+	return requests
+}
+
+// densificationCeiling returns the fraction of the node capacity up to which
+// the pods may be packed, based on the label with the given key.
+func densificationCeiling(node nodeInfo, labelKey string) (float64, bool) {
+	if labelKey == "" {
+		return 0, false
+	}
+	value, found := node.labels[labelKey]
+	if !found {
+		return 0, false
+	}
+	ceiling, err := strconv.ParseFloat(value, 64)
+	if err != nil || ceiling < 0 || ceiling >= 1 {
+		return 0, false
+	}
+	return ceiling, true
+}
+
+// aboveCeiling returns the part of the capacity above the ceiling.
+func aboveCeiling(capacity resources.Requests, ceiling float64) resources.Requests {
+	result := make(resources.Requests, len(capacity))
+	for name, value := range capacity {
+		result[name] = value - int64(math.Floor(float64(value)*ceiling))
+	}
+	return result
+}
+
+// roundUp returns the requests rounded up to the granularity.
+func roundUp(requests, granularity resources.Requests) resources.Requests {
+	if len(granularity) == 0 {
+		return requests
+	}
+	result := requests.Clone()
+	for name, value := range result {
+		if step := granularity[name]; step > 0 {
+			result[name] = (value + step - 1) / step * step
+		}
+	}
+	return result
+}
+
+// roundDown returns the capacity rounded down to the granularity.
+func roundDown(capacity, granularity resources.Requests) resources.Requests {
+	if len(granularity) == 0 {
+		return capacity
+	}
+	result := capacity.Clone()
+	for name, value := range result {
+		if step := granularity[name]; step > 0 {
+			result[name] = value / step * step
+		}
+	}
+	return result
+}
+
+// reservationLimitPerDomain returns the number of pods which can be placed in
+// each domain with an external reservation, in the free capacity of the domain
+// left after the reservation. The free capacity of a domain above the lowest
+// level is the sum of the free capacity of its lowest level domains.
+func (s *TASFlavorSnapshot) reservationLimitPerDomain(requests, granularity resources.Requests, excludedCapacity map[utiltas.TopologyDomainID]resources.Requests) map[utiltas.TopologyDomainID]int32 {
+	if len(s.externalReservations) == 0 {
+		return nil
+	}
+	freeCapacity := make(map[utiltas.TopologyDomainID]resources.Requests)
+	for leafID, capacity := range s.freeCapacityPerDomain {
+		for domainID := range s.withAncestors(leafID) {
+			if _, found := freeCapacity[domainID]; !found {
+				freeCapacity[domainID] = resources.Requests{}
+			}
+			freeCapacity[domainID].Add(capacity)
+			if excluded, found := excludedCapacity[leafID]; found {
+				freeCapacity[domainID].Sub(excluded)
 			}
 		}
 	}
+	result := make(map[utiltas.TopologyDomainID]int32, len(s.externalReservations))
+	for domainID, reserved := range s.externalReservations {
+		capacity, found := freeCapacity[domainID]
+		if !found {
+			continue
+		}
+		capacity = capacity.Clone()
+		capacity.Sub(reserved)
+		result[domainID] = max(requests.CountIn(roundDown(capacity, granularity)), 0)
+	}
+	return result
+}
+
+// gpuHourLimit returns the number of pods which can be placed in the domain
+// without exceeding the GPU-hour budget. It returns false if the budget
+// doesn't apply to the workload.
+func gpuHourLimit(requests resources.Requests, domainID utiltas.TopologyDomainID, options *findTopologyAssignmentOptions) (int32, bool) {
+	podGPUHours := float64(requests[gpuResourceName]) * options.estimatedDuration.Hours()
+	if options.gpuHourBudget == nil || podGPUHours <= 0 {
+		return 0, false
+	}
+	remaining := *options.gpuHourBudget - options.committedGPUHours[domainID]
+	return int32(max(math.Floor(remaining/podGPUHours), 0)), true
 }
@@ -0,0 +1,90 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"sync"
+	"time"
+
+	kueue "sigs.k8s.io/kueue/apis/kueue/v1beta1"
+	"sigs.k8s.io/kueue/pkg/resources"
+	utiltas "sigs.k8s.io/kueue/pkg/util/tas"
+)
+
+type gpuHourCommitment struct {
+	domainID utiltas.TopologyDomainID
+	gpuHours float64
+	at       time.Time
+}
+
+// GPUHourLedger tracks the GPU-hours committed to the topology domains in a
+// rolling window, to be used along with WithGPUHourBudget.
+type GPUHourLedger struct {
+	sync.Mutex
+
+	window      time.Duration
+	commitments []gpuHourCommitment
+}
+
+// NewGPUHourLedger creates a GPUHourLedger which accounts the GPU-hours
+// committed within the window.
+func NewGPUHourLedger(window time.Duration) *GPUHourLedger {
+	return &GPUHourLedger{
+		window: window,
+	}
+}
+
+// CommitAssignment records the GPU-hours, estimated as the GPUs requested by
+// the pods multiplied by the estimated duration, committed by the topology
+// assignment. The GPU-hours are accounted to the domains at all levels.
+func (l *GPUHourLedger) CommitAssignment(assignment *kueue.TopologyAssignment, requests resources.Requests, estimatedDuration time.Duration, at time.Time) {
+	podGPUHours := float64(requests[gpuResourceName]) * estimatedDuration.Hours()
+	if podGPUHours <= 0 {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+	for _, domain := range assignment.Domains {
+		for levelIdx := range domain.Values {
+			l.commitments = append(l.commitments, gpuHourCommitment{
+				domainID: utiltas.DomainID(domain.Values[:levelIdx+1]),
+				gpuHours: podGPUHours * float64(domain.Count),
+				at:       at,
+			})
+		}
+	}
+}
+
+// Committed returns the GPU-hours committed to each domain within the window
+// ending at the given time. The commitments older than the window are
+// dropped.
+func (l *GPUHourLedger) Committed(now time.Time) map[utiltas.TopologyDomainID]float64 {
+	l.Lock()
+	defer l.Unlock()
+	windowStart := now.Add(-l.window)
+	result := make(map[utiltas.TopologyDomainID]float64)
+	recent := l.commitments[:0]
+	for _, commitment := range l.commitments {
+		if commitment.at.Before(windowStart) {
+			continue
+		}
+		recent = append(recent, commitment)
+		result[commitment.domainID] += commitment.gpuHours
+	}
+	l.commitments = recent
+	return result
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"context"
+	"slices"
+
+	"sigs.k8s.io/kueue/pkg/resources"
+)
+
+// TopologySummary is a coarse view of the free capacity of a TAS flavor. It
+// holds no identity of the topology domains, so it is lightweight enough to
+// be reported by the MultiKueue worker clusters to the management cluster,
+// which uses it to compare the topology fit of the worker clusters.
+type TopologySummary struct {
+	// Levels denotes the ordered list of topology keys.
+	Levels []string
+
+	// FreeCapacityPerLevel holds, for each level, the free capacity of the
+	// domains at the level.
+	FreeCapacityPerLevel [][]resources.Requests
+}
+
+// Summary returns the TopologySummary based on the current state of the
+// cluster.
+func (c *TASFlavorCache) Summary(ctx context.Context) *TopologySummary {
+	summary := &TopologySummary{
+		Levels: slices.Clone(c.Levels),
+	}
+	for _, domains := range c.CapacityPerLevel(ctx) {
+		freeCapacities := make([]resources.Requests, 0, len(domains))
+		for _, domain := range domains {
+			freeCapacities = append(freeCapacities, domain.Free)
+		}
+		summary.FreeCapacityPerLevel = append(summary.FreeCapacityPerLevel, freeCapacities)
+	}
+	return summary
+}
+
+// FitSlack returns the number of pods which would remain free in the
+// tightest fitting domain at the level, after placing count pods with the
+// given requests in it. It returns false if no domain at the level can
+// accommodate the pods.
+func (s *TopologySummary) FitSlack(levelKey string, requests resources.Requests, count int32) (int32, bool) {
+	levelIdx := slices.Index(s.Levels, levelKey)
+	if levelIdx == -1 || levelIdx >= len(s.FreeCapacityPerLevel) {
+		return 0, false
+	}
+	var slack int32
+	found := false
+	for _, freeCapacity := range s.FreeCapacityPerLevel[levelIdx] {
+		domainCount := requests.CountIn(freeCapacity)
+		if domainCount < count {
+			continue
+		}
+		if !found || domainCount-count < slack {
+			slack = domainCount - count
+			found = true
+		}
+	}
+	return slack, found
+}
+
+// BestTopologyFit returns the name of the cluster whose summary offers the
+// tightest fit for count pods with the given requests in a single domain at
+// the level. Ties are resolved by the cluster name. It returns false if the
+// pods don't fit in any of the clusters.
+func BestTopologyFit(summaries map[string]*TopologySummary, levelKey string, requests resources.Requests, count int32) (string, bool) {
+	var bestCluster string
+	var bestSlack int32
+	found := false
+	for cluster, summary := range summaries {
+		slack, fits := summary.FitSlack(levelKey, requests, count)
+		if !fits {
+			continue
+		}
+		if !found || slack < bestSlack || (slack == bestSlack && cluster < bestCluster) {
+			bestCluster = cluster
+			bestSlack = slack
+			found = true
+		}
+	}
+	return bestCluster, found
+}
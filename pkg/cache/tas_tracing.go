@@ -0,0 +1,116 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/utils/ptr"
+
+	kueue "sigs.k8s.io/kueue/apis/kueue/v1beta1"
+	"sigs.k8s.io/kueue/pkg/resources"
+)
+
+const (
+	tracerName = "sigs.k8s.io/kueue/pkg/cache"
+
+	findTopologyAssignmentSpanName = "FindTopologyAssignment"
+
+	// The attributes of the FindTopologyAssignment span.
+	topologyRequiredAttribute  = "kueue.topology.required"
+	topologyPreferredAttribute = "kueue.topology.preferred"
+	topologyRequestsAttribute  = "kueue.topology.requests"
+	topologyCountAttribute     = "kueue.topology.count"
+	topologyResultAttribute    = "kueue.topology.result"
+	topologyLevelAttribute     = "kueue.topology.level"
+	topologyDomainsAttribute   = "kueue.topology.domains"
+	topologyCacheHitAttribute  = "kueue.topology.cache_hit"
+
+	topologyResultFit = "Fit"
+)
+
+// startTopologyAssignmentSpan starts the span of the assignment, recording
+// the shape of the request. The attributes are only built when the span is
+// recorded, as formatting the requests allocates on the hot path.
+func startTopologyAssignmentSpan(
+	ctx context.Context,
+	topologyRequest *kueue.PodSetTopologyRequest,
+	requests resources.Requests,
+	count int32) trace.Span {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	_, span := otel.Tracer(tracerName).Start(ctx, findTopologyAssignmentSpanName)
+	if span.IsRecording() {
+		span.SetAttributes(
+			attribute.String(topologyRequiredAttribute, ptr.Deref(topologyRequest.Required, "")),
+			attribute.String(topologyPreferredAttribute, ptr.Deref(topologyRequest.Preferred, "")),
+			attribute.StringSlice(topologyRequestsAttribute, requestsAsStrings(requests)),
+			attribute.Int(topologyCountAttribute, int(count)),
+		)
+	}
+	return span
+}
+
+// endTopologyAssignmentSpan records the result of the assignment, along with
+// the level at which the pods fit and the number of the assigned domains, and
+// ends the span.
+func endTopologyAssignmentSpan(span trace.Span, domains int, levelKey string, err error) {
+	defer span.End()
+	if !span.IsRecording() {
+		return
+	}
+	if err != nil {
+		var assignmentErr *TopologyAssignmentError
+		if errors.As(err, &assignmentErr) {
+			span.SetAttributes(attribute.String(topologyResultAttribute, string(assignmentErr.Reason)))
+		}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	span.SetAttributes(
+		attribute.String(topologyResultAttribute, topologyResultFit),
+		attribute.String(topologyLevelAttribute, levelKey),
+		attribute.Int(topologyDomainsAttribute, domains),
+	)
+}
+
+// endCachedTopologyAssignmentSpan records the result of the assignment served
+// from the cache of the snapshot, and ends the span.
+func endCachedTopologyAssignmentSpan(span trace.Span, domains int, levelKey string, err error) {
+	span.SetAttributes(attribute.Bool(topologyCacheHitAttribute, true))
+	endTopologyAssignmentSpan(span, domains, levelKey, err)
+}
+
+// requestsAsStrings returns the requests as name=value pairs sorted by the
+// resource name.
+func requestsAsStrings(requests resources.Requests) []string {
+	result := make([]string, 0, len(requests))
+	for name, quantity := range requests.ToResourceList() {
+		result = append(result, fmt.Sprintf("%s=%s", name, quantity.String()))
+	}
+	slices.Sort(result)
+	return result
+}
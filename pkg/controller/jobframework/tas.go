@@ -26,16 +26,16 @@ import (
 
 func PodSetTopologyRequest(template *corev1.PodTemplateSpec) *kueue.PodSetTopologyRequest {
 	requiredValue, requiredFound := template.Annotations[kueuealpha.PodSetRequiredTopologyAnnotation]
+	preferredValue, preferredFound := template.Annotations[kueuealpha.PodSetPreferredTopologyAnnotation]
+	if !requiredFound && !preferredFound {
+		return nil
+	}
+	result := &kueue.PodSetTopologyRequest{}
 	if requiredFound {
-		return &kueue.PodSetTopologyRequest{
-			Required: ptr.To(requiredValue),
-		}
+		result.Required = ptr.To(requiredValue)
 	}
-	preferredValue, preferredFound := template.Annotations[kueuealpha.PodSetPreferredTopologyAnnotation]
 	if preferredFound {
-		return &kueue.PodSetTopologyRequest{
-			Preferred: ptr.To(preferredValue),
-		}
+		result.Preferred = ptr.To(preferredValue)
 	}
-	return nil
+	return result
 }
@@ -18,9 +18,15 @@ package tas
 
 import (
 	"context"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
@@ -57,6 +63,7 @@ var _ reconcile.Reconciler = (*rfReconciler)(nil)
 var _ predicate.Predicate = (*rfReconciler)(nil)
 
 // +kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
 // +kubebuilder:rbac:groups=kueue.x-k8s.io,resources=topologies,verbs=get;list;watch
 // +kubebuilder:rbac:groups=kueue.x-k8s.io,resources=resourceflavors,verbs=get;list;watch
 
@@ -78,6 +85,7 @@ func (r *rfReconciler) setupWithManager(mgr ctrl.Manager, cache *cache.Cache, cf
 		Named(TASResourceFlavorController).
 		For(&kueue.ResourceFlavor{}).
 		Watches(&corev1.Node{}, &nodeHandler).
+		Watches(&corev1.Pod{}, &nonTASPodHandler{tasCache: cache.TASCache()}).
 		WithOptions(controller.Options{NeedLeaderElection: ptr.To(false)}).
 		WithEventFilter(r).
 		Complete(core.WithLeadingManager(mgr, r, &kueue.ClusterQueue{}, cfg))
@@ -85,16 +93,20 @@ func (r *rfReconciler) setupWithManager(mgr ctrl.Manager, cache *cache.Cache, cf
 
 var _ handler.EventHandler = (*nodeHandler)(nil)
 
-// nodeHandler handles node update events.
+// nodeHandler handles node update events. It maintains the nodes of the TAS
+// flavors incrementally, and triggers the reconcile of the affected flavors.
 type nodeHandler struct {
 	tasCache *cache.TASCache
 }
 
-func (h *nodeHandler) Create(_ context.Context, e event.CreateEvent, q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+func (h *nodeHandler) Create(ctx context.Context, e event.CreateEvent, q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
 	node, isNode := e.Object.(*corev1.Node)
 	if !isNode {
 		return
 	}
+	for _, flavor := range h.tasCache.Clone() {
+		flavor.AddNode(ctx, node)
+	}
 	h.queueReconcileForNode(node, q)
 }
 
@@ -104,6 +116,9 @@ func (h *nodeHandler) Update(ctx context.Context, e event.UpdateEvent, q workque
 	if !isOldNode || !isNewNode {
 		return
 	}
+	for _, flavor := range h.tasCache.Clone() {
+		flavor.UpdateNode(ctx, newNode)
+	}
 	h.queueReconcileForNode(oldNode, q)
 	h.queueReconcileForNode(newNode, q)
 }
@@ -113,6 +128,9 @@ func (h *nodeHandler) Delete(_ context.Context, e event.DeleteEvent, q workqueue
 	if !isNode {
 		return
 	}
+	for _, flavor := range h.tasCache.Clone() {
+		flavor.DeleteNode(node.Name)
+	}
 	h.queueReconcileForNode(node, q)
 }
 
@@ -122,7 +140,7 @@ func (h *nodeHandler) queueReconcileForNode(node *corev1.Node, q workqueue.Typed
 	}
 	// trigger reconcile for TAS flavors affected by the node being created or updated
 	for name, flavor := range h.tasCache.Clone() {
-		if nodeBelongsToFlavor(node, flavor.NodeLabels, flavor.Levels) {
+		if nodeBelongsToFlavor(node, flavor.NodeLabels, flavor.LabelLevels()) {
 			q.AddAfter(reconcile.Request{NamespacedName: types.NamespacedName{
 				Name: string(name),
 			}}, nodeBatchPeriod)
@@ -133,6 +151,35 @@ func (h *nodeHandler) queueReconcileForNode(node *corev1.Node, q workqueue.Typed
 func (h *nodeHandler) Generic(context.Context, event.GenericEvent, workqueue.TypedRateLimitingInterface[reconcile.Request]) {
 }
 
+var _ handler.EventHandler = (*nonTASPodHandler)(nil)
+
+// nonTASPodHandler handles pod events. It maintains the requests of the pods
+// which aren't scheduled by TAS, subtracted from the capacity of their nodes.
+type nonTASPodHandler struct {
+	tasCache *cache.TASCache
+}
+
+func (h *nonTASPodHandler) Create(_ context.Context, e event.CreateEvent, _ workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+	if pod, isPod := e.Object.(*corev1.Pod); isPod {
+		h.tasCache.UpdatePod(pod)
+	}
+}
+
+func (h *nonTASPodHandler) Update(_ context.Context, e event.UpdateEvent, _ workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+	if pod, isPod := e.ObjectNew.(*corev1.Pod); isPod {
+		h.tasCache.UpdatePod(pod)
+	}
+}
+
+func (h *nonTASPodHandler) Delete(_ context.Context, e event.DeleteEvent, _ workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+	if pod, isPod := e.Object.(*corev1.Pod); isPod {
+		h.tasCache.DeletePod(client.ObjectKeyFromObject(pod))
+	}
+}
+
+func (h *nonTASPodHandler) Generic(context.Context, event.GenericEvent, workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+}
+
 func (r *rfReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
 	log := ctrl.LoggerFrom(ctx).WithValues("name", req.NamespacedName.Name)
 	log.V(2).Info("Reconcile TAS Resource Flavor")
@@ -153,8 +200,12 @@ func (r *rfReconciler) Reconcile(ctx context.Context, req reconcile.Request) (re
 				return reconcile.Result{}, err
 			}
 			levels := r.levels(&topology)
-			tasInfo := r.tasCache.NewTASFlavorCache(levels, flv.Spec.NodeLabels)
-			r.tasCache.Set(kueue.ResourceFlavorReference(flv.Name), tasInfo)
+			tasInfo := r.tasCache.NewTASFlavorCache(levels, flv.Spec.NodeLabels, flavorCacheOptions(log, flv)...)
+			tasInfo.SetAnnotationLevels(annotationLevels(&topology)...)
+			r.cache.AddTASFlavorCache(kueue.ResourceFlavorReference(flv.Name), tasInfo)
+		}
+		if tasInfo := r.tasCache.Get(kueue.ResourceFlavorReference(flv.Name)); tasInfo != nil {
+			tasInfo.SetCompactionThreshold(compactionThreshold(log, flv))
 		}
 
 		// requeue inadmissible workloads as a change to the resource flavor
@@ -192,7 +243,19 @@ func (r *rfReconciler) Update(event event.UpdateEvent) bool {
 	if isOldRf && isNewRf {
 		switch {
 		case ptr.Equal(oldRf.Spec.TopologyName, newRf.Spec.TopologyName):
-			return false
+			if newRf.Spec.TopologyName == nil {
+				return false
+			}
+			if slices.ContainsFunc(flavorCacheAnnotations, func(key string) bool {
+				return oldRf.Annotations[key] != newRf.Annotations[key]
+			}) {
+				// the options of the flavor cache are only set when it is
+				// created, so it is recreated when reconciling
+				r.tasCache.Delete(kueue.ResourceFlavorReference(newRf.Name))
+				return true
+			}
+			// the compaction threshold is only read when reconciling
+			return oldRf.Annotations[kueuealpha.ResourceFlavorCompactionThresholdAnnotation] != newRf.Annotations[kueuealpha.ResourceFlavorCompactionThresholdAnnotation]
 		case oldRf.Spec.TopologyName == nil:
 			return true
 		default:
@@ -229,3 +292,89 @@ func (r *rfReconciler) levels(topology *kueuealpha.Topology) []string {
 	}
 	return result
 }
+
+// annotationLevels returns the levels of the topology whose values are read
+// from the node annotations.
+func annotationLevels(topology *kueuealpha.Topology) []string {
+	var result []string
+	for _, level := range topology.Spec.Levels {
+		if level.FromAnnotation {
+			result = append(result, level.NodeLabel)
+		}
+	}
+	return result
+}
+
+// flavorCacheAnnotations are the annotations of the flavor which configure
+// the options of its TAS flavor cache.
+var flavorCacheAnnotations = []string{
+	kueuealpha.ResourceFlavorIncludeUnschedulableNodesAnnotation,
+	kueuealpha.ResourceFlavorReservationFractionAnnotation,
+	kueuealpha.ResourceFlavorSubHostLevelAnnotation,
+	kueuealpha.ResourceFlavorNodeSelectorAnnotation,
+	kueuealpha.ResourceFlavorNodeAnnotationSelectorAnnotation,
+	kueuealpha.ResourceFlavorNormalizeLevelValuesAnnotation,
+}
+
+// flavorCacheOptions returns the options of the TAS flavor cache of the
+// flavor, based on its annotations. The invalid annotations are ignored.
+func flavorCacheOptions(log logr.Logger, flv *kueue.ResourceFlavor) []cache.TASFlavorCacheOption {
+	var opts []cache.TASFlavorCacheOption
+	if value, found := flv.Annotations[kueuealpha.ResourceFlavorIncludeUnschedulableNodesAnnotation]; found {
+		if include, err := strconv.ParseBool(value); err == nil {
+			opts = append(opts, cache.WithIncludeUnschedulable(include))
+		} else {
+			log.V(2).Info("Ignoring invalid include unschedulable nodes annotation", "value", value)
+		}
+	}
+	if value, found := flv.Annotations[kueuealpha.ResourceFlavorReservationFractionAnnotation]; found {
+		if fraction, err := strconv.ParseFloat(value, 64); err == nil && fraction >= 0 && fraction < 1 {
+			opts = append(opts, cache.WithReservationFraction(fraction))
+		} else {
+			log.V(2).Info("Ignoring invalid reservation fraction annotation", "value", value)
+		}
+	}
+	if value, found := flv.Annotations[kueuealpha.ResourceFlavorSubHostLevelAnnotation]; found {
+		opts = append(opts, cache.WithSubHostLevel(value))
+	}
+	if value, found := flv.Annotations[kueuealpha.ResourceFlavorNodeSelectorAnnotation]; found {
+		if selector, err := metav1.ParseToLabelSelector(value); err == nil {
+			opts = append(opts, cache.WithNodeSelector(selector))
+		} else {
+			log.V(2).Info("Ignoring invalid node selector annotation", "value", value, "error", err)
+		}
+	}
+	if value, found := flv.Annotations[kueuealpha.ResourceFlavorNodeAnnotationSelectorAnnotation]; found {
+		if nodeAnnotations, err := labels.ConvertSelectorToLabelsMap(value); err == nil {
+			opts = append(opts, cache.WithNodeAnnotations(nodeAnnotations))
+		} else {
+			log.V(2).Info("Ignoring invalid node annotation selector annotation", "value", value, "error", err)
+		}
+	}
+	if value, found := flv.Annotations[kueuealpha.ResourceFlavorNormalizeLevelValuesAnnotation]; found {
+		if normalize, err := strconv.ParseBool(value); err != nil {
+			log.V(2).Info("Ignoring invalid normalize level values annotation", "value", value)
+		} else if normalize {
+			opts = append(opts, cache.WithLevelValueNormalization(func(v string) string {
+				return strings.ToLower(strings.TrimSpace(v))
+			}))
+		}
+	}
+	return opts
+}
+
+// compactionThreshold returns the compaction threshold of the flavor, based
+// on the ResourceFlavorCompactionThresholdAnnotation, or nil if it is not set
+// or invalid.
+func compactionThreshold(log logr.Logger, flv *kueue.ResourceFlavor) *float64 {
+	value, found := flv.Annotations[kueuealpha.ResourceFlavorCompactionThresholdAnnotation]
+	if !found {
+		return nil
+	}
+	threshold, err := strconv.ParseFloat(value, 64)
+	if err != nil || threshold < 0 || threshold > 1 {
+		log.V(2).Info("Ignoring invalid compaction threshold annotation", "value", value)
+		return nil
+	}
+	return &threshold
+}
@@ -0,0 +1,368 @@
+/*
+Copyright The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tas
+
+import (
+	"testing"
+
+	gocmp "github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	kueuealpha "sigs.k8s.io/kueue/apis/kueue/v1alpha1"
+	"sigs.k8s.io/kueue/pkg/cache"
+	"sigs.k8s.io/kueue/pkg/queue"
+	"sigs.k8s.io/kueue/pkg/resources"
+	utiltesting "sigs.k8s.io/kueue/pkg/util/testing"
+)
+
+func TestRfReconcilerCompactionThreshold(t *testing.T) {
+	const flavorName = "tas-flavor"
+	topology := utiltesting.MakeTopology("default").Levels(defaultTestLevels).Obj()
+	flavor := utiltesting.MakeResourceFlavor(flavorName).TopologyName("default").Obj()
+	flavor.Annotations = map[string]string{
+		kueuealpha.ResourceFlavorCompactionThresholdAnnotation: "0.5",
+	}
+
+	ctx, _ := utiltesting.ContextWithLog(t)
+	kClient := utiltesting.NewClientBuilder().WithObjects(topology, flavor).Build()
+	cqCache := cache.New(kClient)
+	reconciler := newRfReconciler(kClient, queue.NewManager(kClient, cqCache), cqCache, nil)
+	request := reconcile.Request{NamespacedName: client.ObjectKeyFromObject(flavor)}
+
+	if _, err := reconciler.Reconcile(ctx, request); err != nil {
+		t.Fatalf("unexpected reconcile error: %v", err)
+	}
+	tasFlavorCache := reconciler.tasCache.Get(flavorName)
+	if tasFlavorCache == nil {
+		t.Fatalf("the TAS flavor cache is not created")
+	}
+	if diff := gocmp.Diff(ptr.To(0.5), tasFlavorCache.CompactionThreshold()); diff != "" {
+		t.Errorf("unexpected compaction threshold after the creation (-want,+got): %s", diff)
+	}
+
+	updated := flavor.DeepCopy()
+	updated.Annotations[kueuealpha.ResourceFlavorCompactionThresholdAnnotation] = "0.2"
+	if err := kClient.Update(ctx, updated); err != nil {
+		t.Fatalf("unexpected update error: %v", err)
+	}
+	if !reconciler.Update(event.UpdateEvent{ObjectOld: flavor, ObjectNew: updated}) {
+		t.Fatalf("expected the update of the compaction threshold annotation to be reconciled")
+	}
+	if _, err := reconciler.Reconcile(ctx, request); err != nil {
+		t.Fatalf("unexpected reconcile error: %v", err)
+	}
+	if diff := gocmp.Diff(ptr.To(0.2), tasFlavorCache.CompactionThreshold()); diff != "" {
+		t.Errorf("unexpected compaction threshold after the update (-want,+got): %s", diff)
+	}
+
+	relabeled := updated.DeepCopy()
+	relabeled.Labels = map[string]string{"team": "a"}
+	if reconciler.Update(event.UpdateEvent{ObjectOld: updated, ObjectNew: relabeled}) {
+		t.Errorf("expected the update not changing the topology nor the compaction threshold to be filtered out")
+	}
+}
+
+func TestRfReconcilerFlavorCacheOptions(t *testing.T) {
+	const (
+		flavorName     = "tas-flavor"
+		zoneLabel      = "cloud.com/zone"
+		poolAnnotation = "cloud.com/pool"
+		gpuCliqueLabel = "nvidia.com/gpu.clique"
+
+		gpuResourceName corev1.ResourceName = "nvidia.com/gpu"
+	)
+	cases := map[string]struct {
+		levels      []string
+		annotations map[string]string
+		nodes       []*corev1.Node
+		wantDomains []cache.DomainCapacity
+	}{
+		"the cordoned nodes are excluded by default": {
+			nodes: []*corev1.Node{
+				utiltesting.MakeNode("x1").
+					Label(tasBlockLabel, "b1").
+					Label(tasRackLabel, "r1").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("4"),
+					}).
+					Obj(),
+				utiltesting.MakeNode("x2").
+					Label(tasBlockLabel, "b1").
+					Label(tasRackLabel, "r2").
+					Unschedulable().
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("4"),
+					}).
+					Obj(),
+			},
+			wantDomains: []cache.DomainCapacity{
+				{Values: []string{"b1", "r1"}, Total: resources.Requests{corev1.ResourceCPU: 4000}},
+			},
+		},
+		"the cordoned nodes are included": {
+			annotations: map[string]string{
+				kueuealpha.ResourceFlavorIncludeUnschedulableNodesAnnotation: "true",
+			},
+			nodes: []*corev1.Node{
+				utiltesting.MakeNode("x1").
+					Label(tasBlockLabel, "b1").
+					Label(tasRackLabel, "r1").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("4"),
+					}).
+					Obj(),
+				utiltesting.MakeNode("x2").
+					Label(tasBlockLabel, "b1").
+					Label(tasRackLabel, "r2").
+					Unschedulable().
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("4"),
+					}).
+					Obj(),
+			},
+			wantDomains: []cache.DomainCapacity{
+				{Values: []string{"b1", "r1"}, Total: resources.Requests{corev1.ResourceCPU: 4000}},
+				{Values: []string{"b1", "r2"}, Total: resources.Requests{corev1.ResourceCPU: 4000}},
+			},
+		},
+		"a fraction of the node capacity is reserved": {
+			annotations: map[string]string{
+				kueuealpha.ResourceFlavorReservationFractionAnnotation: "0.25",
+			},
+			nodes: []*corev1.Node{
+				utiltesting.MakeNode("x1").
+					Label(tasBlockLabel, "b1").
+					Label(tasRackLabel, "r1").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("4"),
+					}).
+					Obj(),
+			},
+			wantDomains: []cache.DomainCapacity{
+				{Values: []string{"b1", "r1"}, Total: resources.Requests{corev1.ResourceCPU: 3000}},
+			},
+		},
+		"only the nodes matching the node selector are used": {
+			annotations: map[string]string{
+				kueuealpha.ResourceFlavorNodeSelectorAnnotation: zoneLabel + " in (zone-a)",
+			},
+			nodes: []*corev1.Node{
+				utiltesting.MakeNode("x1").
+					Label(tasBlockLabel, "b1").
+					Label(tasRackLabel, "r1").
+					Label(zoneLabel, "zone-a").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("4"),
+					}).
+					Obj(),
+				utiltesting.MakeNode("x2").
+					Label(tasBlockLabel, "b1").
+					Label(tasRackLabel, "r2").
+					Label(zoneLabel, "zone-b").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("4"),
+					}).
+					Obj(),
+			},
+			wantDomains: []cache.DomainCapacity{
+				{Values: []string{"b1", "r1"}, Total: resources.Requests{corev1.ResourceCPU: 4000}},
+			},
+		},
+		"only the nodes with the annotations are used": {
+			annotations: map[string]string{
+				kueuealpha.ResourceFlavorNodeAnnotationSelectorAnnotation: poolAnnotation + "=canary",
+			},
+			nodes: []*corev1.Node{
+				utiltesting.MakeNode("x1").
+					Label(tasBlockLabel, "b1").
+					Label(tasRackLabel, "r1").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("4"),
+					}).
+					Obj(),
+				utiltesting.MakeNode("x2").
+					Label(tasBlockLabel, "b1").
+					Label(tasRackLabel, "r2").
+					Annotation(poolAnnotation, "canary").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("4"),
+					}).
+					Obj(),
+			},
+			wantDomains: []cache.DomainCapacity{
+				{Values: []string{"b1", "r2"}, Total: resources.Requests{corev1.ResourceCPU: 4000}},
+			},
+		},
+		"the level values are normalized": {
+			annotations: map[string]string{
+				kueuealpha.ResourceFlavorNormalizeLevelValuesAnnotation: "true",
+			},
+			nodes: []*corev1.Node{
+				utiltesting.MakeNode("x1").
+					Label(tasBlockLabel, "b1").
+					Label(tasRackLabel, "R1").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("4"),
+					}).
+					Obj(),
+				utiltesting.MakeNode("x2").
+					Label(tasBlockLabel, "b1").
+					Label(tasRackLabel, "r1 ").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("4"),
+					}).
+					Obj(),
+			},
+			wantDomains: []cache.DomainCapacity{
+				{Values: []string{"b1", "r1"}, Total: resources.Requests{corev1.ResourceCPU: 8000}},
+			},
+		},
+		"the NVLink groups of the node form the sub-host level": {
+			levels: []string{corev1.LabelHostname, gpuCliqueLabel},
+			annotations: map[string]string{
+				kueuealpha.ResourceFlavorSubHostLevelAnnotation: gpuCliqueLabel,
+			},
+			nodes: []*corev1.Node{
+				utiltesting.MakeNode("x1").
+					Label(corev1.LabelHostname, "x1").
+					Annotation(kueuealpha.NodeNVLinkGroupsAnnotation, "4,4").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("8"),
+						gpuResourceName:    resource.MustParse("8"),
+					}).
+					Obj(),
+			},
+			wantDomains: []cache.DomainCapacity{
+				{Values: []string{"x1", "0"}, Total: resources.Requests{corev1.ResourceCPU: 4000, gpuResourceName: 4}},
+				{Values: []string{"x1", "1"}, Total: resources.Requests{corev1.ResourceCPU: 4000, gpuResourceName: 4}},
+			},
+		},
+		"the invalid annotations are ignored": {
+			annotations: map[string]string{
+				kueuealpha.ResourceFlavorIncludeUnschedulableNodesAnnotation: "yes",
+				kueuealpha.ResourceFlavorReservationFractionAnnotation:       "1.5",
+				kueuealpha.ResourceFlavorNodeSelectorAnnotation:              zoneLabel + " in (",
+			},
+			nodes: []*corev1.Node{
+				utiltesting.MakeNode("x1").
+					Label(tasBlockLabel, "b1").
+					Label(tasRackLabel, "r1").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("4"),
+					}).
+					Obj(),
+				utiltesting.MakeNode("x2").
+					Label(tasBlockLabel, "b1").
+					Label(tasRackLabel, "r2").
+					Unschedulable().
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("4"),
+					}).
+					Obj(),
+			},
+			wantDomains: []cache.DomainCapacity{
+				{Values: []string{"b1", "r1"}, Total: resources.Requests{corev1.ResourceCPU: 4000}},
+			},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			levels := defaultTestLevels
+			if tc.levels != nil {
+				levels = tc.levels
+			}
+			topology := utiltesting.MakeTopology("default").Levels(levels).Obj()
+			flavor := utiltesting.MakeResourceFlavor(flavorName).TopologyName("default").Obj()
+			flavor.Annotations = tc.annotations
+			objects := []client.Object{topology, flavor}
+			for _, node := range tc.nodes {
+				objects = append(objects, node)
+			}
+
+			ctx, _ := utiltesting.ContextWithLog(t)
+			kClient := utiltesting.NewClientBuilder().WithObjects(objects...).Build()
+			cqCache := cache.New(kClient)
+			reconciler := newRfReconciler(kClient, queue.NewManager(kClient, cqCache), cqCache, nil)
+			request := reconcile.Request{NamespacedName: client.ObjectKeyFromObject(flavor)}
+			if _, err := reconciler.Reconcile(ctx, request); err != nil {
+				t.Fatalf("unexpected reconcile error: %v", err)
+			}
+			tasFlavorCache := reconciler.tasCache.Get(flavorName)
+			if tasFlavorCache == nil {
+				t.Fatalf("the TAS flavor cache is not created")
+			}
+			capacityPerLevel := tasFlavorCache.CapacityPerLevel(ctx)
+			gotDomains := capacityPerLevel[len(capacityPerLevel)-1]
+			if diff := gocmp.Diff(tc.wantDomains, gotDomains, cmpopts.IgnoreFields(cache.DomainCapacity{}, "Free"), cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("unexpected lowest level domains (-want,+got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestRfReconcilerFlavorCacheOptionsUpdate(t *testing.T) {
+	const flavorName = "tas-flavor"
+	topology := utiltesting.MakeTopology("default").Levels(defaultTestLevels).Obj()
+	flavor := utiltesting.MakeResourceFlavor(flavorName).TopologyName("default").Obj()
+	node := utiltesting.MakeNode("x1").
+		Label(tasBlockLabel, "b1").
+		Label(tasRackLabel, "r1").
+		StatusAllocatable(corev1.ResourceList{
+			corev1.ResourceCPU: resource.MustParse("4"),
+		}).
+		Obj()
+
+	ctx, _ := utiltesting.ContextWithLog(t)
+	kClient := utiltesting.NewClientBuilder().WithObjects(topology, flavor, node).Build()
+	cqCache := cache.New(kClient)
+	reconciler := newRfReconciler(kClient, queue.NewManager(kClient, cqCache), cqCache, nil)
+	request := reconcile.Request{NamespacedName: client.ObjectKeyFromObject(flavor)}
+	if _, err := reconciler.Reconcile(ctx, request); err != nil {
+		t.Fatalf("unexpected reconcile error: %v", err)
+	}
+
+	updated := flavor.DeepCopy()
+	updated.Annotations = map[string]string{
+		kueuealpha.ResourceFlavorReservationFractionAnnotation: "0.5",
+	}
+	if err := kClient.Update(ctx, updated); err != nil {
+		t.Fatalf("unexpected update error: %v", err)
+	}
+	if !reconciler.Update(event.UpdateEvent{ObjectOld: flavor, ObjectNew: updated}) {
+		t.Fatalf("expected the update of the reservation fraction annotation to be reconciled")
+	}
+	if _, err := reconciler.Reconcile(ctx, request); err != nil {
+		t.Fatalf("unexpected reconcile error: %v", err)
+	}
+	tasFlavorCache := reconciler.tasCache.Get(flavorName)
+	if tasFlavorCache == nil {
+		t.Fatalf("the TAS flavor cache is not recreated")
+	}
+	capacityPerLevel := tasFlavorCache.CapacityPerLevel(ctx)
+	wantDomains := []cache.DomainCapacity{
+		{Values: []string{"b1", "r1"}, Total: resources.Requests{corev1.ResourceCPU: 2000}},
+	}
+	if diff := gocmp.Diff(wantDomains, capacityPerLevel[len(capacityPerLevel)-1], cmpopts.IgnoreFields(cache.DomainCapacity{}, "Free")); diff != "" {
+		t.Errorf("unexpected lowest level domains after the update (-want,+got): %s", diff)
+	}
+}
@@ -19,12 +19,15 @@ package tas
 import (
 	"context"
 	"errors"
+	"fmt"
+	"maps"
 	"slices"
 	"time"
 
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
 	"k8s.io/utils/ptr"
@@ -38,6 +41,7 @@ import (
 	configapi "sigs.k8s.io/kueue/apis/config/v1beta1"
 	kueuealpha "sigs.k8s.io/kueue/apis/kueue/v1alpha1"
 	kueue "sigs.k8s.io/kueue/apis/kueue/v1beta1"
+	"sigs.k8s.io/kueue/pkg/cache"
 	"sigs.k8s.io/kueue/pkg/controller/core"
 	utilclient "sigs.k8s.io/kueue/pkg/util/client"
 	"sigs.k8s.io/kueue/pkg/util/expectations"
@@ -53,11 +57,13 @@ const (
 )
 
 var (
-	errPendingUngateOps = errors.New("pending ungate operations")
+	errPendingUngateOps   = errors.New("pending ungate operations")
+	errTASFlavorNotCached = errors.New("TAS flavor not found in the cache")
 )
 
 type topologyUngater struct {
 	client            client.Client
+	tasCache          *cache.TASCache
 	expectationsStore *expectations.Store
 }
 
@@ -72,9 +78,10 @@ var _ reconcile.Reconciler = (*topologyUngater)(nil)
 // +kubebuilder:rbac:groups=kueue.x-k8s.io,resources=workloads,verbs=get;list;watch
 // +kubebuilder:rbac:groups=kueue.x-k8s.io,resources=workloads/status,verbs=get
 
-func newTopologyUngater(c client.Client) *topologyUngater {
+func newTopologyUngater(c client.Client, tasCache *cache.TASCache) *topologyUngater {
 	return &topologyUngater{
 		client:            c,
+		tasCache:          tasCache,
 		expectationsStore: expectations.NewStore(TASTopologyUngater),
 	}
 }
@@ -249,14 +256,13 @@ func isAdmittedByTAS(w *kueue.Workload) bool {
 }
 
 func (r *topologyUngater) podsetPodsToUngate(ctx context.Context, log logr.Logger, wl *kueue.Workload, psa *kueue.PodSetAssignment) ([]podWithUngateInfo, error) {
-	levelKeys := psa.TopologyAssignment.Levels
-	domainIDToLabelValues := make(map[utiltas.TopologyDomainID][]string)
-	domainIDToExpectedCount := make(map[utiltas.TopologyDomainID]int32)
-	for _, psaDomain := range psa.TopologyAssignment.Domains {
-		domainID := utiltas.DomainID(psaDomain.Values)
-		domainIDToExpectedCount[domainID] = psaDomain.Count
-		domainIDToLabelValues[domainID] = psaDomain.Values
+	annotationLevels, err := r.annotationLevels(psa)
+	if err != nil {
+		return nil, err
 	}
+	topologyAssignment := withoutLevels(psa.TopologyAssignment, annotationLevels)
+	levelKeys := topologyAssignment.Levels
+	placements := utiltas.DomainPlacements(topologyAssignment)
 	pods, err := r.podsForDomain(ctx, wl.Namespace, wl.Name, psa.Name)
 	if err != nil {
 		return nil, err
@@ -278,16 +284,14 @@ func (r *topologyUngater) podsetPodsToUngate(ctx context.Context, log logr.Logge
 		"podSetName", psa.Name,
 		"podSetCount", psa.Count,
 		"domainIDToUngatedCount", domainIDToUngatedCnt,
-		"domainIDToLabelValues", domainIDToLabelValues,
+		"domains", topologyAssignment.Domains,
 		"levelKeys", levelKeys)
 	toUngate := make([]podWithUngateInfo, 0)
-	for domainID, expectedInDomainCnt := range domainIDToExpectedCount {
+	for _, placement := range placements {
+		domainID := utiltas.DomainID(utiltas.LevelValues(levelKeys, placement.NodeSelector))
 		ungatedInDomainCnt := domainIDToUngatedCnt[domainID]
-		remainingUngatedInDomain := max(expectedInDomainCnt-ungatedInDomainCnt, 0)
+		remainingUngatedInDomain := max(placement.Count-ungatedInDomainCnt, 0)
 		if remainingUngatedInDomain > 0 {
-			domainValues := domainIDToLabelValues[domainID]
-
-			nodeLabels := utiltas.NodeLabelsFromKeysAndValues(levelKeys, domainValues)
 			remainingGatedCnt := int32(max(len(gatedPods)-len(toUngate), 0))
 			toUngateCnt := min(remainingUngatedInDomain, remainingGatedCnt)
 			if toUngateCnt > 0 {
@@ -295,7 +299,7 @@ func (r *topologyUngater) podsetPodsToUngate(ctx context.Context, log logr.Logge
 				for i := range podsToUngateInDomain {
 					toUngate = append(toUngate, podWithUngateInfo{
 						pod:        podsToUngateInDomain[i],
-						nodeLabels: nodeLabels,
+						nodeLabels: placement.NodeSelector,
 					})
 				}
 			}
@@ -304,6 +308,54 @@ func (r *topologyUngater) podsetPodsToUngate(ctx context.Context, log logr.Logge
 	return toUngate, nil
 }
 
+// annotationLevels returns the levels of the topology of the flavor assigned
+// to the PodSet whose values are read from the node annotations, so they
+// cannot be injected into the node selector of the pods. The levels are read
+// from the TAS cache, and the error is returned to requeue the workload if
+// none of the flavors is cached yet, for example as its Topology is missing.
+func (r *topologyUngater) annotationLevels(psa *kueue.PodSetAssignment) (sets.Set[string], error) {
+	for _, flavorName := range psa.Flavors {
+		if tasFlavorCache := r.tasCache.Get(flavorName); tasFlavorCache != nil {
+			return sets.New(tasFlavorCache.AnnotationLevels()...), nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %v", errTASFlavorNotCached, slices.Sorted(maps.Values(psa.Flavors)))
+}
+
+// withoutLevels returns the topology assignment with the given levels
+// removed, merging the domains which only differ at these levels.
+func withoutLevels(ta *kueue.TopologyAssignment, levelKeys sets.Set[string]) *kueue.TopologyAssignment {
+	if levelKeys.Len() == 0 {
+		return ta
+	}
+	result := &kueue.TopologyAssignment{}
+	for _, levelKey := range ta.Levels {
+		if !levelKeys.Has(levelKey) {
+			result.Levels = append(result.Levels, levelKey)
+		}
+	}
+	domainIdx := make(map[utiltas.TopologyDomainID]int, len(ta.Domains))
+	for _, domain := range ta.Domains {
+		values := make([]string, 0, len(result.Levels))
+		for levelIdx, levelKey := range ta.Levels {
+			if !levelKeys.Has(levelKey) {
+				values = append(values, domain.Values[levelIdx])
+			}
+		}
+		domainID := utiltas.DomainID(values)
+		if i, found := domainIdx[domainID]; found {
+			result.Domains[i].Count += domain.Count
+			continue
+		}
+		domainIdx[domainID] = len(result.Domains)
+		result.Domains = append(result.Domains, kueue.TopologyDomainAssignment{
+			Values: values,
+			Count:  domain.Count,
+		})
+	}
+	return result
+}
+
 func (r *topologyUngater) podsForDomain(ctx context.Context, ns, wlName, psName string) ([]*corev1.Pod, error) {
 	var pods corev1.PodList
 	if err := r.client.List(ctx, &pods, client.InNamespace(ns), client.MatchingLabels{
@@ -32,6 +32,7 @@ import (
 
 	kueuealpha "sigs.k8s.io/kueue/apis/kueue/v1alpha1"
 	kueue "sigs.k8s.io/kueue/apis/kueue/v1beta1"
+	"sigs.k8s.io/kueue/pkg/cache"
 	utilpod "sigs.k8s.io/kueue/pkg/util/pod"
 	utiltesting "sigs.k8s.io/kueue/pkg/util/testing"
 	testingpod "sigs.k8s.io/kueue/pkg/util/testingjobs/pod"
@@ -43,6 +44,8 @@ import (
 const (
 	tasBlockLabel = "cloud.com/topology-block"
 	tasRackLabel  = "cloud.com/topology-rack"
+
+	tasChassisAnnotation = "vendor.com/chassis"
 )
 
 var (
@@ -102,6 +105,7 @@ func TestReconcile(t *testing.T) {
 
 	testCases := map[string]struct {
 		expectUIDs []types.UID
+		objects    []client.Object
 		workloads  []kueue.Workload
 		pods       []corev1.Pod
 		wantPods   []corev1.Pod
@@ -869,6 +873,130 @@ func TestReconcile(t *testing.T) {
 				},
 			},
 		},
+		"ungate pods without the annotation level in the node selector": {
+			objects: []client.Object{
+				utiltesting.MakeResourceFlavor("tas-flavor").TopologyName("default").Obj(),
+				utiltesting.MakeTopology("default").
+					Levels([]string{tasBlockLabel, tasChassisAnnotation, corev1.LabelHostname}).
+					FromAnnotation(tasChassisAnnotation).
+					Obj(),
+			},
+			workloads: []kueue.Workload{
+				*utiltesting.MakeWorkload("unit-test", "ns").Finalizers(kueue.ResourceInUseFinalizerName).
+					PodSets(*utiltesting.MakePodSet(kueue.DefaultPodSetName, 2).Request(corev1.ResourceCPU, "1").Obj()).
+					ReserveQuota(
+						utiltesting.MakeAdmission("cq").
+							Assignment(corev1.ResourceCPU, "tas-flavor", "1").
+							AssignmentPodCount(2).
+							TopologyAssignment(&kueue.TopologyAssignment{
+								Levels: []string{tasBlockLabel, tasChassisAnnotation, corev1.LabelHostname},
+								Domains: []kueue.TopologyDomainAssignment{
+									{
+										Count: 1,
+										Values: []string{
+											"b1",
+											"c1",
+											"x1",
+										},
+									},
+									{
+										Count: 1,
+										Values: []string{
+											"b1",
+											"c2",
+											"x2",
+										},
+									},
+								},
+							}).
+							Obj(),
+					).
+					Admitted(true).
+					Obj(),
+			},
+			pods: []corev1.Pod{
+				*testingpod.MakePod("pod1", "ns").
+					Annotation(kueuealpha.WorkloadAnnotation, "unit-test").
+					Label(kueuealpha.PodSetLabel, kueue.DefaultPodSetName).
+					TopologySchedulingGate().
+					Obj(),
+				*testingpod.MakePod("pod2", "ns").
+					Annotation(kueuealpha.WorkloadAnnotation, "unit-test").
+					Label(kueuealpha.PodSetLabel, kueue.DefaultPodSetName).
+					TopologySchedulingGate().
+					Obj(),
+			},
+			wantPods: []corev1.Pod{
+				*testingpod.MakePod("pod1", "ns").
+					Annotation(kueuealpha.WorkloadAnnotation, "unit-test").
+					Label(kueuealpha.PodSetLabel, kueue.DefaultPodSetName).
+					Obj(),
+				*testingpod.MakePod("pod2", "ns").
+					Annotation(kueuealpha.WorkloadAnnotation, "unit-test").
+					Label(kueuealpha.PodSetLabel, kueue.DefaultPodSetName).
+					Obj(),
+			},
+			wantCounts: []counts{
+				{
+					NodeSelector: map[string]string{
+						tasBlockLabel:        "b1",
+						corev1.LabelHostname: "x1",
+					},
+					Count: 1,
+				},
+				{
+					NodeSelector: map[string]string{
+						tasBlockLabel:        "b1",
+						corev1.LabelHostname: "x2",
+					},
+					Count: 1,
+				},
+			},
+		},
+		"pods remain gated when the topology of the flavor is missing": {
+			objects: []client.Object{
+				utiltesting.MakeResourceFlavor("tas-flavor").TopologyName("default").Obj(),
+			},
+			workloads: []kueue.Workload{
+				*utiltesting.MakeWorkload("unit-test", "ns").Finalizers(kueue.ResourceInUseFinalizerName).
+					PodSets(*utiltesting.MakePodSet(kueue.DefaultPodSetName, 1).Request(corev1.ResourceCPU, "1").Obj()).
+					ReserveQuota(
+						utiltesting.MakeAdmission("cq").
+							Assignment(corev1.ResourceCPU, "tas-flavor", "1").
+							AssignmentPodCount(1).
+							TopologyAssignment(&kueue.TopologyAssignment{
+								Levels: defaultTestLevels,
+								Domains: []kueue.TopologyDomainAssignment{
+									{
+										Count: 1,
+										Values: []string{
+											"b1",
+											"r1",
+										},
+									},
+								},
+							}).
+							Obj(),
+					).
+					Admitted(true).
+					Obj(),
+			},
+			pods: []corev1.Pod{
+				*testingpod.MakePod("pod1", "ns").
+					Annotation(kueuealpha.WorkloadAnnotation, "unit-test").
+					Label(kueuealpha.PodSetLabel, kueue.DefaultPodSetName).
+					TopologySchedulingGate().
+					Obj(),
+			},
+			wantPods: []corev1.Pod{
+				*testingpod.MakePod("pod1", "ns").
+					Annotation(kueuealpha.WorkloadAnnotation, "unit-test").
+					Label(kueuealpha.PodSetLabel, kueue.DefaultPodSetName).
+					TopologySchedulingGate().
+					Obj(),
+			},
+			wantErr: errTASFlavorNotCached,
+		},
 	}
 
 	for name, tc := range testCases {
@@ -879,7 +1007,7 @@ func TestReconcile(t *testing.T) {
 				t.Fatalf("Could not setup indexes: %v", err)
 			}
 
-			kcBuilder := clientBuilder.WithObjects()
+			kcBuilder := clientBuilder.WithObjects(tc.objects...)
 			for i := range tc.pods {
 				kcBuilder = kcBuilder.WithObjects(&tc.pods[i])
 			}
@@ -894,7 +1022,30 @@ func TestReconcile(t *testing.T) {
 					t.Fatalf("Could not create workload: %v", err)
 				}
 			}
-			topologyUngater := newTopologyUngater(kClient)
+			tasCache := cache.NewTASCache(kClient)
+			tasCache.Set("unit-test-flavor", tasCache.NewTASFlavorCache(defaultTestLevels, nil))
+			topologies := make(map[string]*kueuealpha.Topology)
+			for _, obj := range tc.objects {
+				if topology, ok := obj.(*kueuealpha.Topology); ok {
+					topologies[topology.Name] = topology
+				}
+			}
+			for _, obj := range tc.objects {
+				flavor, ok := obj.(*kueue.ResourceFlavor)
+				if !ok || flavor.Spec.TopologyName == nil {
+					continue
+				}
+				if topology, found := topologies[*flavor.Spec.TopologyName]; found {
+					levels := make([]string, len(topology.Spec.Levels))
+					for i, level := range topology.Spec.Levels {
+						levels[i] = level.NodeLabel
+					}
+					tasFlavorCache := tasCache.NewTASFlavorCache(levels, flavor.Spec.NodeLabels)
+					tasFlavorCache.SetAnnotationLevels(annotationLevels(topology)...)
+					tasCache.Set(kueue.ResourceFlavorReference(flavor.Name), tasFlavorCache)
+				}
+			}
+			topologyUngater := newTopologyUngater(kClient, &tasCache)
 			key := client.ObjectKeyFromObject(&tc.workloads[0])
 			request := reconcile.Request{NamespacedName: key}
 			if len(tc.expectUIDs) > 0 {
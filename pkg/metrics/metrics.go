@@ -34,6 +34,9 @@ const (
 	AdmissionResultSuccess      AdmissionResult = "success"
 	AdmissionResultInadmissible AdmissionResult = "inadmissible"
 
+	TopologyAssignmentSuccess = "success"
+	TopologyAssignmentFailure = "failure"
+
 	PendingStatusActive       = "active"
 	PendingStatusInadmissible = "inadmissible"
 
@@ -89,6 +92,28 @@ The label 'result' can have the following values:
 		}, []string{"cluster_queue"},
 	)
 
+	TopologyAssignmentsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: constants.KueueName,
+			Name:      "tas_assignment_total",
+			Help: `The total number of attempts to find a topology assignment, per requested 'level'.
+The label 'result' can have the following values:
+- 'success' means that the pods fit within the topology.
+- 'failure' means that no assignment was found.`,
+		}, []string{"level", "result"},
+	)
+
+	topologyAssignmentDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: constants.KueueName,
+			Name:      "tas_assignment_duration_seconds",
+			Help: `The latency of an attempt to find a topology assignment.
+The label 'result' can have the following values:
+- 'success' means that the pods fit within the topology.
+- 'failure' means that no assignment was found.`,
+		}, []string{"result"},
+	)
+
 	// Metrics tied to the queue system.
 
 	PendingWorkloads = prometheus.NewGaugeVec(
@@ -264,6 +289,11 @@ func AdmissionAttempt(result AdmissionResult, duration time.Duration) {
 	admissionAttemptDuration.WithLabelValues(string(result)).Observe(duration.Seconds())
 }
 
+func TopologyAssignment(level, result string, duration time.Duration) {
+	TopologyAssignmentsTotal.WithLabelValues(level, result).Inc()
+	topologyAssignmentDuration.WithLabelValues(result).Observe(duration.Seconds())
+}
+
 func QuotaReservedWorkload(cqName kueue.ClusterQueueReference, waitTime time.Duration) {
 	QuotaReservedWorkloadsTotal.WithLabelValues(string(cqName)).Inc()
 	quotaReservedWaitTime.WithLabelValues(string(cqName)).Observe(waitTime.Seconds())
@@ -404,6 +434,8 @@ func Register() {
 		AdmissionAttemptsTotal,
 		admissionAttemptDuration,
 		AdmissionCyclePreemptionSkips,
+		TopologyAssignmentsTotal,
+		topologyAssignmentDuration,
 		PendingWorkloads,
 		ReservingActiveWorkloads,
 		AdmittedActiveWorkloads,
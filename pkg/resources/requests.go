@@ -22,7 +22,6 @@ import (
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
-	"k8s.io/utils/ptr"
 )
 
 // The following resources calculations are inspired on
@@ -93,16 +92,18 @@ func ResourceQuantity(name corev1.ResourceName, v int64) resource.Quantity {
 }
 
 func (req Requests) CountIn(capacity Requests) int32 {
-	var result *int32
+	var result int32
+	first := true
 	for rName, rValue := range req {
 		capacity, found := capacity[rName]
 		if !found {
 			return 0
 		}
 		count := int32(capacity / rValue)
-		if result == nil || count < *result {
-			result = ptr.To(count)
+		if first || count < result {
+			result = count
+			first = false
 		}
 	}
-	return ptr.Deref(result, 0)
+	return result
 }
@@ -17,8 +17,10 @@ limitations under the License.
 package flavorassigner
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"slices"
 	"sort"
 	"strings"
 
@@ -284,6 +286,21 @@ type FlavorAssigner struct {
 	resourceFlavors   map[kueue.ResourceFlavorReference]*kueue.ResourceFlavor
 	enableFairSharing bool
 	oracle            preemptionOracle
+
+	// tasFlavorsInUse is the set of flavors already used by the TAS PodSets
+	// of the workload.
+	tasFlavorsInUse sets.Set[kueue.ResourceFlavorReference]
+
+	// stickyTASAssignments holds the topology assignments found when probing
+	// the sticky flavors, keyed by the PodSet index.
+	stickyTASAssignments map[int]stickyTASAssignment
+}
+
+// stickyTASAssignment is the topology assignment of a PodSet within the
+// sticky flavor.
+type stickyTASAssignment struct {
+	flavor     kueue.ResourceFlavorReference
+	assignment *kueue.TopologyAssignment
 }
 
 func New(wl *workload.Info, cq *cache.ClusterQueueSnapshot, resourceFlavors map[kueue.ResourceFlavorReference]*kueue.ResourceFlavor, enableFairSharing bool, oracle preemptionOracle) *FlavorAssigner {
@@ -303,8 +320,9 @@ func lastAssignmentOutdated(wl *workload.Info, cq *cache.ClusterQueueSnapshot) b
 // Assign assigns a flavor to each of the resources requested in each pod set.
 // The result for each pod set is accompanied with reasons why the flavor can't
 // be assigned immediately. Each assigned flavor is accompanied with a
-// FlavorAssignmentMode.
-func (a *FlavorAssigner) Assign(log logr.Logger, counts []int32) Assignment {
+// FlavorAssignmentMode. The context is the one of the scheduling cycle, it is
+// passed down to the topology assignments.
+func (a *FlavorAssigner) Assign(ctx context.Context, log logr.Logger, counts []int32) Assignment {
 	if a.wl.LastAssignment != nil && lastAssignmentOutdated(a.wl, a.cq) {
 		if logV := log.V(6); logV.Enabled() {
 			keysValues := []any{
@@ -315,10 +333,10 @@ func (a *FlavorAssigner) Assign(log logr.Logger, counts []int32) Assignment {
 		}
 		a.wl.LastAssignment = nil
 	}
-	return a.assignFlavors(log, counts)
+	return a.assignFlavors(ctx, log, counts)
 }
 
-func (a *FlavorAssigner) assignFlavors(log logr.Logger, counts []int32) Assignment {
+func (a *FlavorAssigner) assignFlavors(ctx context.Context, log logr.Logger, counts []int32) Assignment {
 	var requests []workload.PodSetResources
 	if len(counts) == 0 {
 		requests = a.wl.TotalRequests
@@ -328,6 +346,8 @@ func (a *FlavorAssigner) assignFlavors(log logr.Logger, counts []int32) Assignme
 			requests[i] = *a.wl.TotalRequests[i].ScaledTo(counts[i])
 		}
 	}
+	a.tasFlavorsInUse = a.admittedTASFlavors()
+	a.stickyTASAssignments = make(map[int]stickyTASAssignment)
 	assignment := Assignment{
 		PodSets: make([]PodSetAssignment, 0, len(requests)),
 		Usage:   make(resources.FlavorResourceQuantities),
@@ -355,7 +375,7 @@ func (a *FlavorAssigner) assignFlavors(log logr.Logger, counts []int32) Assignme
 				// No need to compute again.
 				continue
 			}
-			flavors, status := a.findFlavorForPodSetResource(log, i, podSet.Requests, resName, assignment.Usage)
+			flavors, status := a.findFlavorForPodSetResource(ctx, log, i, podSet.Count, podSet.Requests, resName, assignment.Usage)
 			if status.IsError() || len(flavors) == 0 {
 				psAssignment.Flavors = nil
 				psAssignment.Status = status
@@ -365,7 +385,16 @@ func (a *FlavorAssigner) assignFlavors(log logr.Logger, counts []int32) Assignme
 		}
 		if features.Enabled(features.TopologyAwareScheduling) {
 			if a.wl.Obj.Spec.PodSets[i].TopologyRequest != nil {
-				assignTopology(log, &psAssignment, a.cq, a.wl.TotalRequests[i], &a.wl.Obj.Spec.PodSets[i])
+				if topologyAssignment := a.stickyTopologyAssignment(i, &psAssignment); topologyAssignment != nil {
+					psAssignment.TopologyAssignment = topologyAssignment
+				} else {
+					assignTopology(ctx, log, &psAssignment, a.cq, a.wl.TotalRequests[i], &a.wl.Obj.Spec.PodSets[i], a.resourceFlavors)
+				}
+				if psAssignment.TopologyAssignment != nil {
+					for _, flvAssignment := range psAssignment.Flavors {
+						a.tasFlavorsInUse.Insert(flvAssignment.Name)
+					}
+				}
 			}
 		}
 
@@ -377,6 +406,24 @@ func (a *FlavorAssigner) assignFlavors(log logr.Logger, counts []int32) Assignme
 	return assignment
 }
 
+// admittedTASFlavors returns the flavors used by the TAS PodSets in the prior
+// admission of the workload.
+func (a *FlavorAssigner) admittedTASFlavors() sets.Set[kueue.ResourceFlavorReference] {
+	result := sets.New[kueue.ResourceFlavorReference]()
+	if a.wl.Obj.Status.Admission == nil {
+		return result
+	}
+	for _, psAssignment := range a.wl.Obj.Status.Admission.PodSetAssignments {
+		if psAssignment.TopologyAssignment == nil {
+			continue
+		}
+		for _, flavor := range psAssignment.Flavors {
+			result.Insert(flavor)
+		}
+	}
+	return result
+}
+
 func (psa *PodSetAssignment) append(flavors ResourceAssignment, status *Status) {
 	for resource, assignment := range flavors {
 		psa.Flavors[resource] = assignment
@@ -408,8 +455,10 @@ func (a *Assignment) append(requests resources.Requests, psAssignment *PodSetAss
 // If the flavor cannot be immediately assigned, it returns a status with
 // reasons or failure.
 func (a *FlavorAssigner) findFlavorForPodSetResource(
+	ctx context.Context,
 	log logr.Logger,
 	psID int,
+	podCount int32,
 	requests resources.Requests,
 	resName corev1.ResourceName,
 	assignmentUsage resources.FlavorResourceQuantities,
@@ -432,57 +481,36 @@ func (a *FlavorAssigner) findFlavorForPodSetResource(
 	selector := flavorSelector(podSpec, resourceGroup.LabelKeys)
 	attemptedFlavorIdx := -1
 	idx := a.wl.LastAssignment.NextFlavorToTryForPodSetResource(psID, resName)
+	if preferredIdx := a.stickyTASFlavorIdx(psID, resourceGroup, idx); preferredIdx != -1 {
+		// Prefer the flavor already used by the TAS PodSets of the workload, to
+		// keep the workload coherent, as long as it fits without borrowing.
+		preferredFlavor := resourceGroup.Flavors[preferredIdx]
+		assignments, mode, needsBorrowing, err := a.checkFlavor(log, preferredFlavor, podSpec, selector, requests, assignmentUsage, &Status{})
+		if err == nil && mode == fit && !needsBorrowing {
+			if topologyAssignment := a.findTopologyAssignment(ctx, psID, preferredFlavor, podCount); topologyAssignment != nil {
+				a.stickyTASAssignments[psID] = stickyTASAssignment{flavor: preferredFlavor, assignment: topologyAssignment}
+				if features.Enabled(features.FlavorFungibility) {
+					for _, assignment := range assignments {
+						assignment.TriedFlavorIdx = preferredIdx
+						if preferredIdx == len(resourceGroup.Flavors)-1 {
+							assignment.TriedFlavorIdx = -1
+						}
+					}
+				}
+				return assignments, nil
+			}
+		}
+	}
 	for ; idx < len(resourceGroup.Flavors); idx++ {
 		attemptedFlavorIdx = idx
-		fName := resourceGroup.Flavors[idx]
-		flavor, exist := a.resourceFlavors[fName]
-		if !exist {
-			log.Error(nil, "Flavor not found", "Flavor", fName)
-			status.append(fmt.Sprintf("flavor %s not found", fName))
-			continue
+		assignments, representativeMode, needsBorrowing, err := a.checkFlavor(log, resourceGroup.Flavors[idx], podSpec, selector, requests, assignmentUsage, status)
+		if err != nil {
+			status.err = err
+			return nil, status
 		}
-		taint, untolerated := corev1helpers.FindMatchingUntoleratedTaint(flavor.Spec.NodeTaints, podSpec.Tolerations, func(t *corev1.Taint) bool {
-			return t.Effect == corev1.TaintEffectNoSchedule || t.Effect == corev1.TaintEffectNoExecute
-		})
-		if untolerated {
-			status.append(fmt.Sprintf("untolerated taint %s in flavor %s", taint, fName))
+		if representativeMode == noFit {
 			continue
 		}
-		if match, err := selector.Match(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Labels: flavor.Spec.NodeLabels}}); !match || err != nil {
-			if err != nil {
-				status.err = err
-				return nil, status
-			}
-			status.append(fmt.Sprintf("flavor %s doesn't match node affinity", fName))
-			continue
-		}
-		needsBorrowing := false
-		assignments := make(ResourceAssignment, len(requests))
-		// Calculate representativeMode for this assignment as the worst mode among all requests.
-		representativeMode := fit
-		for rName, val := range requests {
-			resQuota := a.cq.QuotaFor(resources.FlavorResource{Flavor: fName, Resource: rName})
-			// Check considering the flavor usage by previous pod sets.
-			fr := resources.FlavorResource{Flavor: fName, Resource: rName}
-			mode, borrow, s := a.fitsResourceQuota(log, fr, val+assignmentUsage[fr], resQuota)
-			if s != nil {
-				status.reasons = append(status.reasons, s.reasons...)
-			}
-			if mode < representativeMode {
-				representativeMode = mode
-			}
-			needsBorrowing = needsBorrowing || borrow
-			if representativeMode == noFit {
-				// The flavor doesn't fit, no need to check other resources.
-				break
-			}
-
-			assignments[rName] = &FlavorAssignment{
-				Name:   fName,
-				Mode:   mode.flavorAssignmentMode(),
-				borrow: borrow,
-			}
-		}
 
 		if features.Enabled(features.FlavorFungibility) {
 			if !shouldTryNextFlavor(representativeMode, a.cq.FlavorFungibility, needsBorrowing) {
@@ -520,6 +548,119 @@ func (a *FlavorAssigner) findFlavorForPodSetResource(
 	return bestAssignment, status
 }
 
+// checkFlavor checks if the flavor can satisfy the podSet requests for the
+// resources. It returns the assignments of the resources to the flavor, the
+// representative mode of the assignment and whether borrowing is needed. The
+// reasons why the flavor doesn't fit are appended to the status.
+func (a *FlavorAssigner) checkFlavor(
+	log logr.Logger,
+	fName kueue.ResourceFlavorReference,
+	podSpec *corev1.PodSpec,
+	selector nodeaffinity.RequiredNodeAffinity,
+	requests resources.Requests,
+	assignmentUsage resources.FlavorResourceQuantities,
+	status *Status,
+) (ResourceAssignment, granularMode, bool, error) {
+	flavor, exist := a.resourceFlavors[fName]
+	if !exist {
+		log.Error(nil, "Flavor not found", "Flavor", fName)
+		status.append(fmt.Sprintf("flavor %s not found", fName))
+		return nil, noFit, false, nil
+	}
+	taint, untolerated := corev1helpers.FindMatchingUntoleratedTaint(flavor.Spec.NodeTaints, podSpec.Tolerations, func(t *corev1.Taint) bool {
+		return t.Effect == corev1.TaintEffectNoSchedule || t.Effect == corev1.TaintEffectNoExecute
+	})
+	if untolerated {
+		status.append(fmt.Sprintf("untolerated taint %s in flavor %s", taint, fName))
+		return nil, noFit, false, nil
+	}
+	if match, err := selector.Match(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Labels: flavor.Spec.NodeLabels}}); !match || err != nil {
+		if err != nil {
+			return nil, noFit, false, err
+		}
+		status.append(fmt.Sprintf("flavor %s doesn't match node affinity", fName))
+		return nil, noFit, false, nil
+	}
+	needsBorrowing := false
+	assignments := make(ResourceAssignment, len(requests))
+	// Calculate representativeMode for this assignment as the worst mode among all requests.
+	representativeMode := fit
+	for rName, val := range requests {
+		resQuota := a.cq.QuotaFor(resources.FlavorResource{Flavor: fName, Resource: rName})
+		// Check considering the flavor usage by previous pod sets.
+		fr := resources.FlavorResource{Flavor: fName, Resource: rName}
+		mode, borrow, s := a.fitsResourceQuota(log, fr, val+assignmentUsage[fr], resQuota)
+		if s != nil {
+			status.reasons = append(status.reasons, s.reasons...)
+		}
+		if mode < representativeMode {
+			representativeMode = mode
+		}
+		needsBorrowing = needsBorrowing || borrow
+		if representativeMode == noFit {
+			// The flavor doesn't fit, no need to check other resources.
+			break
+		}
+
+		assignments[rName] = &FlavorAssignment{
+			Name:   fName,
+			Mode:   mode.flavorAssignmentMode(),
+			borrow: borrow,
+		}
+	}
+	return assignments, representativeMode, needsBorrowing, nil
+}
+
+// stickyTASFlavorIdx returns the index of the flavor, in the resource group,
+// which is already used by the TAS PodSets of the workload, either in its
+// prior admission or by the PodSets assigned earlier. Only the flavors from
+// nextIdx on are considered, so that the flavors already tried in the previous
+// scheduling cycles are not retried. It returns -1 if the PodSet doesn't use
+// TAS or there is no such flavor.
+func (a *FlavorAssigner) stickyTASFlavorIdx(psID int, resourceGroup *cache.ResourceGroup, nextIdx int) int {
+	if !features.Enabled(features.TopologyAwareScheduling) || a.wl.Obj.Spec.PodSets[psID].TopologyRequest == nil || a.tasFlavorsInUse.Len() == 0 {
+		return -1
+	}
+	idx := slices.IndexFunc(resourceGroup.Flavors[nextIdx:], a.tasFlavorsInUse.Has)
+	if idx == -1 {
+		return -1
+	}
+	return nextIdx + idx
+}
+
+// findTopologyAssignment returns the topology assignment of the pods of the
+// PodSet within the TAS flavor, or nil if they don't fit.
+func (a *FlavorAssigner) findTopologyAssignment(ctx context.Context, psID int, fName kueue.ResourceFlavorReference, podCount int32) *kueue.TopologyAssignment {
+	snapshot := a.cq.TASFlavors[fName]
+	if snapshot == nil {
+		return nil
+	}
+	psResources := a.wl.TotalRequests[psID]
+	singlePodRequests := psResources.Requests.Clone()
+	singlePodRequests.Divide(int64(psResources.Count))
+	podSet := &a.wl.Obj.Spec.PodSets[psID]
+	topologyAssignment, err := snapshot.FindTopologyAssignment(podSet.TopologyRequest, singlePodRequests, podCount, cache.WithContext(ctx), cache.WithTolerations(tasTolerations(podSet, a.resourceFlavors[fName])...))
+	if err != nil {
+		return nil
+	}
+	return topologyAssignment
+}
+
+// stickyTopologyAssignment returns the topology assignment found when probing
+// the sticky flavor, if the PodSet got assigned that flavor for all the
+// resources. It returns nil otherwise.
+func (a *FlavorAssigner) stickyTopologyAssignment(psID int, psAssignment *PodSetAssignment) *kueue.TopologyAssignment {
+	sticky, found := a.stickyTASAssignments[psID]
+	if !found || psAssignment.Status.IsError() {
+		return nil
+	}
+	flavor, err := onlyFlavor(psAssignment.Flavors)
+	if err != nil || *flavor != sticky.flavor {
+		return nil
+	}
+	return sticky.assignment
+}
+
 func shouldTryNextFlavor(representativeMode granularMode, flavorFungibility kueue.FlavorFungibility, needsBorrowing bool) bool {
 	policyPreempt := flavorFungibility.WhenCanPreempt
 	policyBorrow := flavorFungibility.WhenCanBorrow
@@ -28,6 +28,8 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	kueue "sigs.k8s.io/kueue/apis/kueue/v1beta1"
 	"sigs.k8s.io/kueue/pkg/cache"
@@ -1964,7 +1966,7 @@ func TestAssignFlavors(t *testing.T) {
 			clusterQueue.ResourceNode.Usage = tc.clusterQueueUsage
 
 			flvAssigner := New(wlInfo, clusterQueue, resourceFlavors, tc.enableFairSharing, &testOracle{})
-			assignment := flvAssigner.Assign(log, nil)
+			assignment := flvAssigner.Assign(ctx, log, nil)
 			if repMode := assignment.RepresentativeMode(); repMode != tc.wantRepMode {
 				t.Errorf("e.assignFlavors(_).RepresentativeMode()=%s, want %s", repMode, tc.wantRepMode)
 			}
@@ -2114,7 +2116,7 @@ func TestReclaimBeforePriorityPreemption(t *testing.T) {
 
 			flvAssigner := New(wlInfo, testClusterQueue, resourceFlavors, false, &testOracle{})
 			log := testr.NewWithOptions(t, testr.Options{Verbosity: 2})
-			assignment := flvAssigner.Assign(log, nil)
+			assignment := flvAssigner.Assign(ctx, log, nil)
 			if gotRepMode := assignment.RepresentativeMode(); gotRepMode != tc.wantMode {
 				t.Errorf("Unexpected RepresentativeMode. got %s, want %s", gotRepMode, tc.wantMode)
 			}
@@ -2241,7 +2243,7 @@ func TestDeletedFlavors(t *testing.T) {
 
 			flvAssigner := New(wlInfo, clusterQueue, flavorMap, false, &testOracle{})
 
-			assignment := flvAssigner.Assign(log, nil)
+			assignment := flvAssigner.Assign(ctx, log, nil)
 			if repMode := assignment.RepresentativeMode(); repMode != tc.wantRepMode {
 				t.Errorf("e.assignFlavors(_).RepresentativeMode()=%s, want %s", repMode, tc.wantRepMode)
 			}
@@ -2300,3 +2302,473 @@ func TestLastAssignmentOutdated(t *testing.T) {
 		})
 	}
 }
+
+func TestTASFlavorStickiness(t *testing.T) {
+	const (
+		rackLabel = "cloud.com/topology-rack"
+		typeLabel = "type"
+	)
+	resourceFlavors := map[kueue.ResourceFlavorReference]*kueue.ResourceFlavor{
+		"tas-one": utiltesting.MakeResourceFlavor("tas-one").NodeLabel(typeLabel, "one").TopologyName("default").Obj(),
+		"tas-two": utiltesting.MakeResourceFlavor("tas-two").NodeLabel(typeLabel, "two").TopologyName("default").Obj(),
+	}
+	priorAdmission := &kueue.Admission{
+		ClusterQueue: "tas-clusterqueue",
+		PodSetAssignments: []kueue.PodSetAssignment{
+			{
+				Name: "workers",
+				Flavors: map[corev1.ResourceName]kueue.ResourceFlavorReference{
+					corev1.ResourceCPU: "tas-two",
+				},
+				ResourceUsage: corev1.ResourceList{
+					corev1.ResourceCPU: resource.MustParse("2"),
+				},
+				Count: ptr.To[int32](2),
+				TopologyAssignment: &kueue.TopologyAssignment{
+					Levels: []string{rackLabel},
+					Domains: []kueue.TopologyDomainAssignment{
+						{Values: []string{"r1"}, Count: 2},
+					},
+				},
+			},
+		},
+	}
+	cases := map[string]struct {
+		nodes          []*corev1.Node
+		wlPods         []kueue.PodSet
+		admission      *kueue.Admission
+		lastAssignment *workload.AssignmentClusterQueueState
+		wantFlavors    map[string]kueue.ResourceFlavorReference
+	}{
+		"first flavor is used without prior TAS assignments": {
+			nodes: []*corev1.Node{
+				utiltesting.MakeNode("one-x1").
+					Label(typeLabel, "one").
+					Label(rackLabel, "r1").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("4"),
+					}).
+					Obj(),
+				utiltesting.MakeNode("two-x1").
+					Label(typeLabel, "two").
+					Label(rackLabel, "r1").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("4"),
+					}).
+					Obj(),
+			},
+			wlPods: []kueue.PodSet{
+				*utiltesting.MakePodSet("workers", 2).
+					Request(corev1.ResourceCPU, "1").
+					RequiredTopologyRequest(rackLabel).
+					Obj(),
+			},
+			wantFlavors: map[string]kueue.ResourceFlavorReference{
+				"workers": "tas-one",
+			},
+		},
+		"subsequent PodSet prefers the flavor used by the earlier PodSet": {
+			nodes: []*corev1.Node{
+				utiltesting.MakeNode("one-x1").
+					Label(typeLabel, "one").
+					Label(rackLabel, "r1").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("4"),
+					}).
+					Obj(),
+				utiltesting.MakeNode("two-x1").
+					Label(typeLabel, "two").
+					Label(rackLabel, "r1").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("4"),
+					}).
+					Obj(),
+			},
+			wlPods: []kueue.PodSet{
+				*utiltesting.MakePodSet("launcher", 1).
+					Request(corev1.ResourceCPU, "1").
+					NodeSelector(map[string]string{typeLabel: "two"}).
+					RequiredTopologyRequest(rackLabel).
+					Obj(),
+				*utiltesting.MakePodSet("workers", 2).
+					Request(corev1.ResourceCPU, "1").
+					RequiredTopologyRequest(rackLabel).
+					Obj(),
+			},
+			wantFlavors: map[string]kueue.ResourceFlavorReference{
+				"launcher": "tas-two",
+				"workers":  "tas-two",
+			},
+		},
+		"flavor of the prior admission is preferred when it still fits": {
+			nodes: []*corev1.Node{
+				utiltesting.MakeNode("one-x1").
+					Label(typeLabel, "one").
+					Label(rackLabel, "r1").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("4"),
+					}).
+					Obj(),
+				utiltesting.MakeNode("two-x1").
+					Label(typeLabel, "two").
+					Label(rackLabel, "r1").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("4"),
+					}).
+					Obj(),
+			},
+			wlPods: []kueue.PodSet{
+				*utiltesting.MakePodSet("workers", 2).
+					Request(corev1.ResourceCPU, "1").
+					RequiredTopologyRequest(rackLabel).
+					Obj(),
+			},
+			admission: priorAdmission,
+			wantFlavors: map[string]kueue.ResourceFlavorReference{
+				"workers": "tas-two",
+			},
+		},
+		"flavor of the prior admission is skipped when it was tried in the previous cycle": {
+			nodes: []*corev1.Node{
+				utiltesting.MakeNode("one-x1").
+					Label(typeLabel, "one").
+					Label(rackLabel, "r1").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("4"),
+					}).
+					Obj(),
+				utiltesting.MakeNode("two-x1").
+					Label(typeLabel, "two").
+					Label(rackLabel, "r1").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("4"),
+					}).
+					Obj(),
+			},
+			wlPods: []kueue.PodSet{
+				*utiltesting.MakePodSet("workers", 2).
+					Request(corev1.ResourceCPU, "1").
+					RequiredTopologyRequest(rackLabel).
+					Obj(),
+			},
+			admission: utiltesting.MakeAdmission("tas-clusterqueue").
+				PodSets(kueue.PodSetAssignment{
+					Name: "workers",
+					Flavors: map[corev1.ResourceName]kueue.ResourceFlavorReference{
+						corev1.ResourceCPU: "tas-one",
+					},
+					ResourceUsage: corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("2"),
+					},
+					Count: ptr.To[int32](2),
+					TopologyAssignment: &kueue.TopologyAssignment{
+						Levels: []string{rackLabel},
+						Domains: []kueue.TopologyDomainAssignment{
+							{Values: []string{"r1"}, Count: 2},
+						},
+					},
+				}).
+				Obj(),
+			lastAssignment: &workload.AssignmentClusterQueueState{
+				LastTriedFlavorIdx: []map[corev1.ResourceName]int{
+					{corev1.ResourceCPU: 0},
+				},
+			},
+			wantFlavors: map[string]kueue.ResourceFlavorReference{
+				"workers": "tas-two",
+			},
+		},
+		"flavor of the prior admission is skipped when the topology doesn't fit": {
+			nodes: []*corev1.Node{
+				utiltesting.MakeNode("one-x1").
+					Label(typeLabel, "one").
+					Label(rackLabel, "r1").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("4"),
+					}).
+					Obj(),
+				utiltesting.MakeNode("two-x1").
+					Label(typeLabel, "two").
+					Label(rackLabel, "r1").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("1"),
+					}).
+					Obj(),
+			},
+			wlPods: []kueue.PodSet{
+				*utiltesting.MakePodSet("workers", 2).
+					Request(corev1.ResourceCPU, "1").
+					RequiredTopologyRequest(rackLabel).
+					Obj(),
+			},
+			admission: priorAdmission,
+			wantFlavors: map[string]kueue.ResourceFlavorReference{
+				"workers": "tas-one",
+			},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			features.SetFeatureGateDuringTest(t, features.TopologyAwareScheduling, true)
+			ctx, _ := utiltesting.ContextWithLog(t)
+			log := testr.NewWithOptions(t, testr.Options{
+				Verbosity: 2,
+			})
+			wlInfo := workload.NewInfo(&kueue.Workload{
+				Spec: kueue.WorkloadSpec{
+					PodSets: tc.wlPods,
+				},
+				Status: kueue.WorkloadStatus{
+					Admission: tc.admission,
+				},
+			})
+
+			initialObjects := make([]client.Object, 0, len(tc.nodes))
+			for _, node := range tc.nodes {
+				initialObjects = append(initialObjects, node)
+			}
+			cqCache := cache.New(utiltesting.NewFakeClient(initialObjects...))
+			for name, rf := range resourceFlavors {
+				cqCache.AddOrUpdateResourceFlavor(rf)
+				tasCache := cqCache.TASCache()
+				tasCache.Set(name, tasCache.NewTASFlavorCache([]string{rackLabel}, rf.Spec.NodeLabels))
+			}
+			clusterQueue := utiltesting.MakeClusterQueue("tas-clusterqueue").
+				ResourceGroup(
+					*utiltesting.MakeFlavorQuotas("tas-one").Resource(corev1.ResourceCPU, "10").Obj(),
+					*utiltesting.MakeFlavorQuotas("tas-two").Resource(corev1.ResourceCPU, "10").Obj(),
+				).Obj()
+			if err := cqCache.AddClusterQueue(ctx, clusterQueue); err != nil {
+				t.Fatalf("Failed to add CQ to cache: %v", err)
+			}
+			cqSnapshot := cqCache.Snapshot(ctx).ClusterQueues[clusterQueue.Name]
+			if cqSnapshot == nil {
+				t.Fatalf("Failed to create CQ snapshot")
+			}
+			if tc.lastAssignment != nil {
+				wlInfo.LastAssignment = tc.lastAssignment
+				wlInfo.LastAssignment.ClusterQueueGeneration = cqSnapshot.AllocatableResourceGeneration
+			}
+
+			flvAssigner := New(wlInfo, cqSnapshot, resourceFlavors, false, &testOracle{})
+			assignment := flvAssigner.Assign(ctx, log, nil)
+			gotFlavors := make(map[string]kueue.ResourceFlavorReference, len(assignment.PodSets))
+			for _, psAssignment := range assignment.PodSets {
+				if flvAssignment, found := psAssignment.Flavors[corev1.ResourceCPU]; found {
+					gotFlavors[psAssignment.Name] = flvAssignment.Name
+					if psAssignment.TopologyAssignment == nil {
+						t.Errorf("Missing topology assignment of the PodSet %q", psAssignment.Name)
+					}
+				}
+			}
+			if diff := cmp.Diff(tc.wantFlavors, gotFlavors); diff != "" {
+				t.Errorf("Unexpected flavors (-want,+got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestTASNodeTaints(t *testing.T) {
+	const rackLabel = "cloud.com/topology-rack"
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "x1",
+			Labels: map[string]string{
+				rackLabel: "r1",
+			},
+		},
+		Spec: corev1.NodeSpec{
+			Taints: []corev1.Taint{
+				{
+					Key:    "dedicated",
+					Value:  "training",
+					Effect: corev1.TaintEffectNoSchedule,
+				},
+			},
+		},
+		Status: corev1.NodeStatus{
+			Allocatable: corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("4"),
+			},
+		},
+	}
+	toleration := corev1.Toleration{
+		Key:      "dedicated",
+		Operator: corev1.TolerationOpEqual,
+		Value:    "training",
+		Effect:   corev1.TaintEffectNoSchedule,
+	}
+	cases := map[string]struct {
+		flavor          *kueue.ResourceFlavor
+		podSet          *kueue.PodSet
+		wantTopologyFit bool
+	}{
+		"the tainted node is not used without the toleration": {
+			flavor: utiltesting.MakeResourceFlavor("tas").TopologyName("default").Obj(),
+			podSet: utiltesting.MakePodSet("workers", 2).
+				Request(corev1.ResourceCPU, "1").
+				RequiredTopologyRequest(rackLabel).
+				Obj(),
+		},
+		"the tainted node is used with the toleration of the PodSet": {
+			flavor: utiltesting.MakeResourceFlavor("tas").TopologyName("default").Obj(),
+			podSet: utiltesting.MakePodSet("workers", 2).
+				Request(corev1.ResourceCPU, "1").
+				RequiredTopologyRequest(rackLabel).
+				Toleration(toleration).
+				Obj(),
+			wantTopologyFit: true,
+		},
+		"the tainted node is used with the toleration of the flavor": {
+			flavor: utiltesting.MakeResourceFlavor("tas").TopologyName("default").Toleration(toleration).Obj(),
+			podSet: utiltesting.MakePodSet("workers", 2).
+				Request(corev1.ResourceCPU, "1").
+				RequiredTopologyRequest(rackLabel).
+				Obj(),
+			wantTopologyFit: true,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			features.SetFeatureGateDuringTest(t, features.TopologyAwareScheduling, true)
+			ctx, _ := utiltesting.ContextWithLog(t)
+			log := testr.NewWithOptions(t, testr.Options{
+				Verbosity: 2,
+			})
+			wlInfo := workload.NewInfo(&kueue.Workload{
+				Spec: kueue.WorkloadSpec{
+					PodSets: []kueue.PodSet{*tc.podSet},
+				},
+			})
+			resourceFlavors := map[kueue.ResourceFlavorReference]*kueue.ResourceFlavor{
+				"tas": tc.flavor,
+			}
+			cqCache := cache.New(utiltesting.NewFakeClient(node.DeepCopy()))
+			cqCache.AddOrUpdateResourceFlavor(tc.flavor)
+			tasCache := cqCache.TASCache()
+			tasCache.Set("tas", tasCache.NewTASFlavorCache([]string{rackLabel}, nil))
+			clusterQueue := utiltesting.MakeClusterQueue("tas-clusterqueue").
+				ResourceGroup(
+					*utiltesting.MakeFlavorQuotas("tas").Resource(corev1.ResourceCPU, "10").Obj(),
+				).Obj()
+			if err := cqCache.AddClusterQueue(ctx, clusterQueue); err != nil {
+				t.Fatalf("Failed to add CQ to cache: %v", err)
+			}
+			cqSnapshot := cqCache.Snapshot(ctx).ClusterQueues[clusterQueue.Name]
+			if cqSnapshot == nil {
+				t.Fatalf("Failed to create CQ snapshot")
+			}
+
+			flvAssigner := New(wlInfo, cqSnapshot, resourceFlavors, false, &testOracle{})
+			assignment := flvAssigner.Assign(ctx, log, nil)
+			gotTopologyFit := assignment.PodSets[0].TopologyAssignment != nil
+			if gotTopologyFit != tc.wantTopologyFit {
+				t.Errorf("Unexpected topology fit, want=%v, got=%v", tc.wantTopologyFit, gotTopologyFit)
+			}
+		})
+	}
+}
+
+func TestTASFailureMessage(t *testing.T) {
+	const rackLabel = "cloud.com/topology-rack"
+	cases := map[string]struct {
+		node        *corev1.Node
+		podSet      *kueue.PodSet
+		wantMessage string
+	}{
+		"insufficient capacity": {
+			node: utiltesting.MakeNode("x1").
+				Label(rackLabel, "r1").
+				StatusAllocatable(corev1.ResourceList{
+					corev1.ResourceCPU: resource.MustParse("4"),
+				}).
+				Obj(),
+			podSet: utiltesting.MakePodSet("workers", 5).
+				Request(corev1.ResourceCPU, "1").
+				RequiredTopologyRequest(rackLabel).
+				Obj(),
+			wantMessage: "Workload cannot fit within the TAS ResourceFlavor, insufficient cpu",
+		},
+		"insufficient capacity on the nodes available to the workload": {
+			node: utiltesting.MakeNode("x1").
+				Label(rackLabel, "r1").
+				StatusAllocatable(corev1.ResourceList{
+					corev1.ResourceCPU: resource.MustParse("4"),
+				}).
+				Taints(corev1.Taint{
+					Key:    "example.com/gpu",
+					Effect: corev1.TaintEffectNoSchedule,
+				}).
+				Obj(),
+			podSet: utiltesting.MakePodSet("workers", 1).
+				Request(corev1.ResourceCPU, "1").
+				RequiredTopologyRequest(rackLabel).
+				Obj(),
+			wantMessage: "Workload cannot fit within the TAS ResourceFlavor, insufficient capacity on the nodes available to the workload",
+		},
+		"no nodes with the topology level": {
+			node: utiltesting.MakeNode("x1").
+				StatusAllocatable(corev1.ResourceList{
+					corev1.ResourceCPU: resource.MustParse("4"),
+				}).
+				Obj(),
+			podSet: utiltesting.MakePodSet("workers", 1).
+				Request(corev1.ResourceCPU, "1").
+				RequiredTopologyRequest(rackLabel).
+				Obj(),
+			wantMessage: "Workload requires Topology, but there are no nodes available for the TAS ResourceFlavor",
+		},
+		"invalid topology level": {
+			node: utiltesting.MakeNode("x1").
+				Label(rackLabel, "r1").
+				StatusAllocatable(corev1.ResourceList{
+					corev1.ResourceCPU: resource.MustParse("4"),
+				}).
+				Obj(),
+			podSet: utiltesting.MakePodSet("workers", 1).
+				Request(corev1.ResourceCPU, "1").
+				RequiredTopologyRequest("cloud.com/topology-block").
+				Obj(),
+			wantMessage: `Workload requests an invalid topology level: topology level "cloud.com/topology-block" is not defined for the flavor, the levels are: [cloud.com/topology-rack]`,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			features.SetFeatureGateDuringTest(t, features.TopologyAwareScheduling, true)
+			ctx, _ := utiltesting.ContextWithLog(t)
+			log := testr.NewWithOptions(t, testr.Options{
+				Verbosity: 2,
+			})
+			wlInfo := workload.NewInfo(&kueue.Workload{
+				Spec: kueue.WorkloadSpec{
+					PodSets: []kueue.PodSet{*tc.podSet},
+				},
+			})
+			flavor := utiltesting.MakeResourceFlavor("tas").TopologyName("default").Obj()
+			resourceFlavors := map[kueue.ResourceFlavorReference]*kueue.ResourceFlavor{
+				"tas": flavor,
+			}
+			cqCache := cache.New(utiltesting.NewFakeClient(tc.node))
+			cqCache.AddOrUpdateResourceFlavor(flavor)
+			tasCache := cqCache.TASCache()
+			tasCache.Set("tas", tasCache.NewTASFlavorCache([]string{rackLabel}, nil))
+			clusterQueue := utiltesting.MakeClusterQueue("tas-clusterqueue").
+				ResourceGroup(
+					*utiltesting.MakeFlavorQuotas("tas").Resource(corev1.ResourceCPU, "10").Obj(),
+				).Obj()
+			if err := cqCache.AddClusterQueue(ctx, clusterQueue); err != nil {
+				t.Fatalf("Failed to add CQ to cache: %v", err)
+			}
+			cqSnapshot := cqCache.Snapshot(ctx).ClusterQueues[clusterQueue.Name]
+			if cqSnapshot == nil {
+				t.Fatalf("Failed to create CQ snapshot")
+			}
+
+			flvAssigner := New(wlInfo, cqSnapshot, resourceFlavors, false, &testOracle{})
+			assignment := flvAssigner.Assign(ctx, log, nil)
+			if gotMessage := assignment.PodSets[0].Status.Message(); gotMessage != tc.wantMessage {
+				t.Errorf("Unexpected status message, want=%q, got=%q", tc.wantMessage, gotMessage)
+			}
+		})
+	}
+}
@@ -17,21 +17,26 @@ limitations under the License.
 package flavorassigner
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"slices"
 
 	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
 
 	kueue "sigs.k8s.io/kueue/apis/kueue/v1beta1"
 	"sigs.k8s.io/kueue/pkg/cache"
 	"sigs.k8s.io/kueue/pkg/workload"
 )
 
-func assignTopology(log logr.Logger,
+func assignTopology(ctx context.Context,
+	log logr.Logger,
 	psAssignment *PodSetAssignment,
 	cq *cache.ClusterQueueSnapshot,
 	psResources workload.PodSetResources,
-	podSet *kueue.PodSet) {
+	podSet *kueue.PodSet,
+	resourceFlavors map[kueue.ResourceFlavorReference]*kueue.ResourceFlavor) {
 	switch {
 	case psAssignment.Status.IsError():
 		log.V(2).Info("There is no resource quota assignment for the workload. No need to check TAS.", "message", psAssignment.Status.Message())
@@ -63,13 +68,27 @@ func assignTopology(log logr.Logger,
 			psAssignment.Flavors = nil
 			return
 		}
-		psAssignment.TopologyAssignment = snapshot.FindTopologyAssignment(podSet.TopologyRequest,
-			singlePodRequests, podCount)
-		if psAssignment.TopologyAssignment == nil {
+		var assignmentErr *cache.TopologyAssignmentError
+		psAssignment.TopologyAssignment, err = snapshot.FindTopologyAssignment(podSet.TopologyRequest,
+			singlePodRequests, podCount, cache.WithContext(ctx), cache.WithTolerations(tasTolerations(podSet, resourceFlavors[*tasFlvr])...))
+		if err != nil {
 			if psAssignment.Status == nil {
 				psAssignment.Status = &Status{}
 			}
-			psAssignment.Status.append("Workload cannot fit within the TAS ResourceFlavor")
+			switch {
+			case !errors.As(err, &assignmentErr):
+				psAssignment.Status.append("Workload cannot fit within the TAS ResourceFlavor")
+			case assignmentErr.Reason == cache.InvalidTopologyLevel:
+				psAssignment.Status.append(fmt.Sprintf("Workload requests an invalid topology level: %s", err))
+			case assignmentErr.Reason == cache.InsufficientClusterCapacity:
+				psAssignment.Status.append(fmt.Sprintf("Workload cannot fit within the TAS ResourceFlavor, insufficient %s", assignmentErr.Resource))
+			case assignmentErr.Reason == cache.InsufficientCapacity:
+				psAssignment.Status.append("Workload cannot fit within the TAS ResourceFlavor, insufficient capacity on the nodes available to the workload")
+			case assignmentErr.Reason == cache.NoMatchingNodes:
+				psAssignment.Status.append("Workload requires Topology, but there are no nodes available for the TAS ResourceFlavor")
+			default:
+				psAssignment.Status.append("Workload cannot fit within the TAS ResourceFlavor")
+			}
 			psAssignment.Flavors = nil
 		}
 		log.Info("TAS PodSet assignment", "tasAssignment", psAssignment.TopologyAssignment)
@@ -90,3 +109,13 @@ func onlyFlavor(ra ResourceAssignment) (*kueue.ResourceFlavorReference, error) {
 	}
 	return nil, errors.New("no flavor assigned")
 }
+
+// tasTolerations returns the tolerations of the pods of the PodSet once
+// admitted to the flavor, which include the tolerations of the flavor.
+func tasTolerations(podSet *kueue.PodSet, flavor *kueue.ResourceFlavor) []corev1.Toleration {
+	tolerations := slices.Clone(podSet.Template.Spec.Tolerations)
+	if flavor != nil {
+		tolerations = append(tolerations, flavor.Spec.Tolerations...)
+	}
+	return tolerations
+}
@@ -18,6 +18,10 @@ package tas
 
 import (
 	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	kueue "sigs.k8s.io/kueue/apis/kueue/v1beta1"
 )
 
 type TopologyDomainID string
@@ -41,3 +45,44 @@ func LevelValues(levelKeys []string, objectLabels map[string]string) []string {
 	}
 	return levelValues
 }
+
+// DomainPlacement holds the node constraints for the group of pods assigned
+// to a single topology domain.
+type DomainPlacement struct {
+	// Count is the number of pods assigned to the domain.
+	Count int32
+
+	// NodeSelector selects the nodes of the domain.
+	NodeSelector map[string]string
+
+	// NodeSelectorTerm is the node affinity term equivalent to NodeSelector.
+	NodeSelectorTerm corev1.NodeSelectorTerm
+}
+
+// DomainPlacements returns the node constraints for each group of pods of
+// the topology assignment, in the order of the assignment domains. It is
+// used to consistently patch pods when they are ungated.
+func DomainPlacements(ta *kueue.TopologyAssignment) []DomainPlacement {
+	if ta == nil {
+		return nil
+	}
+	result := make([]DomainPlacement, 0, len(ta.Domains))
+	for _, domain := range ta.Domains {
+		term := corev1.NodeSelectorTerm{
+			MatchExpressions: make([]corev1.NodeSelectorRequirement, len(ta.Levels)),
+		}
+		for levelIdx, levelKey := range ta.Levels {
+			term.MatchExpressions[levelIdx] = corev1.NodeSelectorRequirement{
+				Key:      levelKey,
+				Operator: corev1.NodeSelectorOpIn,
+				Values:   []string{domain.Values[levelIdx]},
+			}
+		}
+		result = append(result, DomainPlacement{
+			Count:            domain.Count,
+			NodeSelector:     NodeLabelsFromKeysAndValues(ta.Levels, domain.Values),
+			NodeSelectorTerm: term,
+		})
+	}
+	return result
+}
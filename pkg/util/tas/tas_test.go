@@ -0,0 +1,106 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tas
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+
+	kueue "sigs.k8s.io/kueue/apis/kueue/v1beta1"
+)
+
+func TestDomainPlacements(t *testing.T) {
+	const (
+		tasBlockLabel = "cloud.com/topology-block"
+		tasRackLabel  = "cloud.com/topology-rack"
+	)
+	cases := map[string]struct {
+		assignment *kueue.TopologyAssignment
+		want       []DomainPlacement
+	}{
+		"no assignment": {},
+		"multiple domains": {
+			assignment: &kueue.TopologyAssignment{
+				Levels: []string{tasBlockLabel, tasRackLabel},
+				Domains: []kueue.TopologyDomainAssignment{
+					{
+						Values: []string{"b1", "r1"},
+						Count:  2,
+					},
+					{
+						Values: []string{"b1", "r2"},
+						Count:  1,
+					},
+				},
+			},
+			want: []DomainPlacement{
+				{
+					Count: 2,
+					NodeSelector: map[string]string{
+						tasBlockLabel: "b1",
+						tasRackLabel:  "r1",
+					},
+					NodeSelectorTerm: corev1.NodeSelectorTerm{
+						MatchExpressions: []corev1.NodeSelectorRequirement{
+							{
+								Key:      tasBlockLabel,
+								Operator: corev1.NodeSelectorOpIn,
+								Values:   []string{"b1"},
+							},
+							{
+								Key:      tasRackLabel,
+								Operator: corev1.NodeSelectorOpIn,
+								Values:   []string{"r1"},
+							},
+						},
+					},
+				},
+				{
+					Count: 1,
+					NodeSelector: map[string]string{
+						tasBlockLabel: "b1",
+						tasRackLabel:  "r2",
+					},
+					NodeSelectorTerm: corev1.NodeSelectorTerm{
+						MatchExpressions: []corev1.NodeSelectorRequirement{
+							{
+								Key:      tasBlockLabel,
+								Operator: corev1.NodeSelectorOpIn,
+								Values:   []string{"b1"},
+							},
+							{
+								Key:      tasRackLabel,
+								Operator: corev1.NodeSelectorOpIn,
+								Values:   []string{"r2"},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := DomainPlacements(tc.assignment)
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("unexpected placements (-want,+got): %s", diff)
+			}
+		})
+	}
+}
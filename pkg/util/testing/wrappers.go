@@ -426,6 +426,11 @@ func (p *PodSetWrapper) InitContainers(containers ...corev1.Container) *PodSetWr
 	return p
 }
 
+func (p *PodSetWrapper) RequiredTopologyRequest(level string) *PodSetWrapper {
+	p.TopologyRequest = &kueue.PodSetTopologyRequest{Required: &level}
+	return p
+}
+
 func (p *PodSetWrapper) NodeSelector(kv map[string]string) *PodSetWrapper {
 	p.Template.Spec.NodeSelector = kv
 	return p
@@ -930,10 +935,80 @@ func (t *TopologyWrapper) Levels(levels []string) *TopologyWrapper {
 	return t
 }
 
+// FromAnnotation marks the level of a Topology to be read from the node
+// annotation.
+func (t *TopologyWrapper) FromAnnotation(level string) *TopologyWrapper {
+	for i := range t.Spec.Levels {
+		if t.Spec.Levels[i].NodeLabel == level {
+			t.Spec.Levels[i].FromAnnotation = true
+		}
+	}
+	return t
+}
+
 func (t *TopologyWrapper) Obj() *kueuealpha.Topology {
 	return &t.Topology
 }
 
+// NodeWrapper wraps a Node.
+type NodeWrapper struct{ corev1.Node }
+
+// MakeNode creates a wrapper for a Node.
+func MakeNode(name string) *NodeWrapper {
+	return &NodeWrapper{corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+	}}
+}
+
+// Label sets the label of the Node.
+func (n *NodeWrapper) Label(k, v string) *NodeWrapper {
+	if n.Labels == nil {
+		n.Labels = make(map[string]string)
+	}
+	n.Labels[k] = v
+	return n
+}
+
+// Annotation sets the annotation of the Node.
+func (n *NodeWrapper) Annotation(k, v string) *NodeWrapper {
+	if n.Annotations == nil {
+		n.Annotations = make(map[string]string)
+	}
+	n.Annotations[k] = v
+	return n
+}
+
+// Unschedulable cordons the Node.
+func (n *NodeWrapper) Unschedulable() *NodeWrapper {
+	n.Spec.Unschedulable = true
+	return n
+}
+
+// Taints appends the taints to the spec of the Node.
+func (n *NodeWrapper) Taints(taints ...corev1.Taint) *NodeWrapper {
+	n.Spec.Taints = append(n.Spec.Taints, taints...)
+	return n
+}
+
+// StatusAllocatable sets the allocatable resources of the Node.
+func (n *NodeWrapper) StatusAllocatable(resources corev1.ResourceList) *NodeWrapper {
+	n.Status.Allocatable = resources
+	return n
+}
+
+// StatusConditions appends the conditions to the status of the Node.
+func (n *NodeWrapper) StatusConditions(conditions ...corev1.NodeCondition) *NodeWrapper {
+	n.Status.Conditions = append(n.Status.Conditions, conditions...)
+	return n
+}
+
+// Obj returns the inner Node.
+func (n *NodeWrapper) Obj() *corev1.Node {
+	return &n.Node
+}
+
 // RuntimeClassWrapper wraps a RuntimeClass.
 type RuntimeClassWrapper struct{ nodev1.RuntimeClass }
 
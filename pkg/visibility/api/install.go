@@ -26,6 +26,7 @@ import (
 
 	visibilityv1alpha1 "sigs.k8s.io/kueue/apis/visibility/v1alpha1"
 	visibilityv1beta1 "sigs.k8s.io/kueue/apis/visibility/v1beta1"
+	"sigs.k8s.io/kueue/pkg/cache"
 	"sigs.k8s.io/kueue/pkg/queue"
 	apiv1alpha1 "sigs.k8s.io/kueue/pkg/visibility/api/v1alpha1"
 	apiv1beta1 "sigs.k8s.io/kueue/pkg/visibility/api/v1beta1"
@@ -45,9 +46,9 @@ func init() {
 }
 
 // Install installs API scheme and registers storages
-func Install(server *genericapiserver.GenericAPIServer, kueueMgr *queue.Manager) error {
+func Install(server *genericapiserver.GenericAPIServer, kueueMgr *queue.Manager, cache *cache.Cache) error {
 	apiGroupInfo := genericapiserver.NewDefaultAPIGroupInfo(visibilityv1beta1.GroupVersion.Group, Scheme, ParameterCodec, Codecs)
 	apiGroupInfo.VersionedResourcesStorageMap[visibilityv1alpha1.GroupVersion.Version] = apiv1alpha1.NewStorage(kueueMgr)
-	apiGroupInfo.VersionedResourcesStorageMap[visibilityv1beta1.GroupVersion.Version] = apiv1beta1.NewStorage(kueueMgr)
+	apiGroupInfo.VersionedResourcesStorageMap[visibilityv1beta1.GroupVersion.Version] = apiv1beta1.NewStorage(kueueMgr, cache)
 	return server.InstallAPIGroups(&apiGroupInfo)
 }
@@ -0,0 +1,55 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apiserver/pkg/registry/rest"
+
+	visibility "sigs.k8s.io/kueue/apis/visibility/v1beta1"
+)
+
+// RfREST type is used only to install resourceflavors/ resource, so we can install resourceflavors/topologycapacity subresource.
+// It implements the necessary interfaces for genericapiserver but does not provide any actual functionalities.
+type RfREST struct{}
+
+// Those interfaces are necessary for genericapiserver to work properly
+var _ rest.Storage = &RfREST{}
+var _ rest.Scoper = &RfREST{}
+var _ rest.SingularNameProvider = &RfREST{}
+
+func NewRfREST() *RfREST {
+	return &RfREST{}
+}
+
+// New implements rest.Storage interface
+func (m *RfREST) New() runtime.Object {
+	return &visibility.TopologyCapacity{}
+}
+
+// Destroy implements rest.Storage interface
+func (m *RfREST) Destroy() {}
+
+// NamespaceScoped implements rest.Scoper interface
+func (m *RfREST) NamespaceScoped() bool {
+	return false
+}
+
+// GetSingularName implements rest.SingularNameProvider interface
+func (m *RfREST) GetSingularName() string {
+	return "resourceflavor"
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apiserver/pkg/registry/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	kueue "sigs.k8s.io/kueue/apis/kueue/v1beta1"
+	visibility "sigs.k8s.io/kueue/apis/visibility/v1beta1"
+	"sigs.k8s.io/kueue/pkg/cache"
+)
+
+type topologyCapacityInRfREST struct {
+	cache *cache.Cache
+	log   logr.Logger
+}
+
+var _ rest.Storage = &topologyCapacityInRfREST{}
+var _ rest.Getter = &topologyCapacityInRfREST{}
+var _ rest.Scoper = &topologyCapacityInRfREST{}
+
+func NewTopologyCapacityInRfREST(cache *cache.Cache) *topologyCapacityInRfREST {
+	return &topologyCapacityInRfREST{
+		cache: cache,
+		log:   ctrl.Log.WithName("topology-capacity-in-rf"),
+	}
+}
+
+// New implements rest.Storage interface
+func (m *topologyCapacityInRfREST) New() runtime.Object {
+	return &visibility.TopologyCapacity{}
+}
+
+// Destroy implements rest.Storage interface
+func (m *topologyCapacityInRfREST) Destroy() {}
+
+// Get implements rest.Getter interface
+// It fetches the total and free capacity of the topology domains at each level of the flavor
+func (m *topologyCapacityInRfREST) Get(ctx context.Context, name string, _ *metav1.GetOptions) (runtime.Object, error) {
+	tasFlavorCache := m.cache.TASCache().Get(kueue.ResourceFlavorReference(name))
+	if tasFlavorCache == nil {
+		return nil, errors.NewNotFound(visibility.Resource("resourceflavor"), name)
+	}
+
+	capacityPerLevel := tasFlavorCache.CapacityPerLevel(ctx)
+	levels := make([]visibility.TopologyLevelCapacity, 0, len(capacityPerLevel))
+	for levelIdx, domains := range capacityPerLevel {
+		level := visibility.TopologyLevelCapacity{
+			Name:    tasFlavorCache.Levels[levelIdx],
+			Domains: make([]visibility.TopologyDomainCapacity, 0, len(domains)),
+		}
+		for _, domain := range domains {
+			level.Domains = append(level.Domains, visibility.TopologyDomainCapacity{
+				Values: domain.Values,
+				Total:  domain.Total.ToResourceList(),
+				Free:   domain.Free.ToResourceList(),
+			})
+		}
+		levels = append(levels, level)
+	}
+	return &visibility.TopologyCapacity{Levels: levels}, nil
+}
+
+// NamespaceScoped implements rest.Scoper interface
+func (m *topologyCapacityInRfREST) NamespaceScoped() bool {
+	return false
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	visibility "sigs.k8s.io/kueue/apis/visibility/v1beta1"
+	"sigs.k8s.io/kueue/pkg/cache"
+	utiltesting "sigs.k8s.io/kueue/pkg/util/testing"
+)
+
+func TestTopologyCapacityInRf(t *testing.T) {
+	const (
+		tasFlavorName = "tas-flavor"
+		tasBlockLabel = "cloud.com/topology-block"
+		tasRackLabel  = "cloud.com/topology-rack"
+	)
+
+	cases := map[string]struct {
+		nodes        []corev1.Node
+		flavorName   string
+		wantCapacity *visibility.TopologyCapacity
+		wantErrMatch func(error) bool
+	}{
+		"capacity aggregated per level": {
+			nodes: []corev1.Node{
+				*utiltesting.MakeNode("b1-r1-x1").
+					Label("tas-node", "true").
+					Label(tasBlockLabel, "b1").
+					Label(tasRackLabel, "r1").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("1"),
+					}).
+					Obj(),
+				*utiltesting.MakeNode("b1-r1-x2").
+					Label("tas-node", "true").
+					Label(tasBlockLabel, "b1").
+					Label(tasRackLabel, "r1").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("2"),
+					}).
+					Obj(),
+				*utiltesting.MakeNode("b1-r2-x3").
+					Label("tas-node", "true").
+					Label(tasBlockLabel, "b1").
+					Label(tasRackLabel, "r2").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("1"),
+					}).
+					Obj(),
+				*utiltesting.MakeNode("b2-r1-x4").
+					Label("tas-node", "true").
+					Label(tasBlockLabel, "b2").
+					Label(tasRackLabel, "r1").
+					StatusAllocatable(corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("4"),
+					}).
+					Obj(),
+			},
+			flavorName: tasFlavorName,
+			wantCapacity: &visibility.TopologyCapacity{
+				Levels: []visibility.TopologyLevelCapacity{
+					{
+						Name: tasBlockLabel,
+						Domains: []visibility.TopologyDomainCapacity{
+							{
+								Values: []string{"b1"},
+								Total:  corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("4")},
+								Free:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("4")},
+							},
+							{
+								Values: []string{"b2"},
+								Total:  corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("4")},
+								Free:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("4")},
+							},
+						},
+					},
+					{
+						Name: tasRackLabel,
+						Domains: []visibility.TopologyDomainCapacity{
+							{
+								Values: []string{"b1", "r1"},
+								Total:  corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("3")},
+								Free:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("3")},
+							},
+							{
+								Values: []string{"b1", "r2"},
+								Total:  corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")},
+								Free:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")},
+							},
+							{
+								Values: []string{"b2", "r1"},
+								Total:  corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("4")},
+								Free:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("4")},
+							},
+						},
+					},
+				},
+			},
+		},
+		"unknown resource flavor": {
+			flavorName:   "unknown",
+			wantErrMatch: errors.IsNotFound,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			initialObjects := make([]client.Object, 0, len(tc.nodes))
+			for i := range tc.nodes {
+				initialObjects = append(initialObjects, &tc.nodes[i])
+			}
+			cCache := cache.New(utiltesting.NewFakeClient(initialObjects...))
+			tasCache := cCache.TASCache()
+			tasCache.Set(tasFlavorName, tasCache.NewTASFlavorCache(
+				[]string{tasBlockLabel, tasRackLabel},
+				map[string]string{"tas-node": "true"},
+			))
+			topologyCapacityInRfRest := NewTopologyCapacityInRfREST(cCache)
+
+			info, err := topologyCapacityInRfRest.Get(ctx, tc.flavorName, &metav1.GetOptions{})
+			switch {
+			case tc.wantErrMatch != nil:
+				if !tc.wantErrMatch(err) {
+					t.Errorf("Unexpected error: %v", err)
+				}
+			case err != nil:
+				t.Error(err)
+			default:
+				if diff := cmp.Diff(tc.wantCapacity, info.(*visibility.TopologyCapacity)); diff != "" {
+					t.Errorf("Topology capacity differs: (-want,+got):\n%s", diff)
+				}
+			}
+		})
+	}
+}
@@ -60,6 +60,18 @@ func TestValidateWorkload(t *testing.T) {
 				},
 			).Obj(),
 		},
+		"valid with both the required and the preferred topology levels": {
+			workload: testingutil.MakeWorkload(testWorkloadName, testWorkloadNamespace).PodSets(
+				kueue.PodSet{
+					Name:  "workers",
+					Count: 4,
+					TopologyRequest: &kueue.PodSetTopologyRequest{
+						Required:  ptr.To("cloud.com/topology-block"),
+						Preferred: ptr.To("cloud.com/topology-rack"),
+					},
+				},
+			).Obj(),
+		},
 		"should have a valid podSet name in status assignment": {
 			workload: testingutil.MakeWorkload(testWorkloadName, testWorkloadNamespace).
 				ReserveQuota(testingutil.MakeAdmission("cluster-queue", "@invalid").Obj()).